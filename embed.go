@@ -0,0 +1,19 @@
+//go:build embed
+// +build embed
+
+package main
+
+import (
+	"embed"
+	"github.com/rredpoppy/gosite/pkg/config"
+)
+
+// Site files compiled into the binary by building with "-tags embed". The
+// all: prefix keeps the _index.md files and the hidden _sections
+//
+//go:embed config.json all:content template static
+var embeddedFiles embed.FS
+
+func init() {
+	config.Embedded = embeddedFiles
+}
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"github.com/rredpoppy/gosite/pkg/cli"
+	"os"
+)
+
+func main() {
+	if err := cli.Run(os.Args[1:]); err != nil {
+		panic(err.Error())
+	}
+}
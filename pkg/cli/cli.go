@@ -0,0 +1,232 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"github.com/rredpoppy/gosite/pkg/deploy"
+	"github.com/rredpoppy/gosite/pkg/export"
+	"github.com/rredpoppy/gosite/pkg/render"
+	"github.com/rredpoppy/gosite/pkg/server"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/**
+ * Runs the gosite command with the given arguments, without the program name.
+ * The first argument names the command: "serve", the default, serves the site
+ * until the server fails, "build" exports it as static files, "deploy"
+ * exports it and publishes the export to the target named next, e.g.
+ * "gosite deploy s3", "check" reports the mistakes of the site before it is
+ * deployed, "lint-templates" reports the mistakes of the theme alone, and
+ * "init" writes a config file and the starter site to the working folder
+ */
+func Run(args []string) error {
+	command := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	if command == "init" {
+		return initSite()
+	}
+	if command != "serve" && command != "build" && command != "deploy" && command != "check" &&
+		command != "lint-templates" {
+		return errors.New("Unknown command " + command)
+	}
+	target := ""
+	if command == "deploy" {
+		if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+			return errors.New("Missing deploy target, e.g. gosite deploy s3")
+		}
+		target, args = args[0], args[1:]
+	}
+	flags := flag.NewFlagSet("gosite "+command, flag.ContinueOnError)
+	flags.BoolVar(&config.PreferDisk, "prefer-disk", false,
+		"Serve files found on disk over the ones embedded in the binary")
+	out, force := "public", false
+	if command == "build" || command == "deploy" {
+		flags.StringVar(&out, "out", out, "Folder the site is exported to")
+		flags.BoolVar(&force, "force", false, "Export all the pages, even those left unchanged")
+	}
+	if command == "serve" {
+		flags.BoolVar(&config.Profile, "profile", false,
+			"Serve the pprof profiles of the server to the administrators under /debug/pprof/")
+	}
+	execute := false
+	if command == "lint-templates" {
+		flags.BoolVar(&execute, "execute", false, "Render the page templates with sample contexts as well")
+	}
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	conf, err := config.Load()
+	if err != nil {
+		return err
+	}
+	if conf.Quickstart {
+		log.Print("gosite found no config.json and runs on the defaults; run gosite init to write one")
+	}
+	render.RegisterBuiltinFilters(&conf)
+	render.RegisterBuiltinShortcodes(&conf)
+	render.RegisterDiagrams(&conf)
+	render.RegisterEmoji(&conf)
+	render.RegisterLazyImages(&conf)
+	if err = content.StartIndex(&conf); err != nil {
+		return err
+	}
+	if command == "lint-templates" {
+		return lintTemplates(&conf, execute)
+	}
+	handler, err := server.New(&conf)
+	if err != nil {
+		return err
+	}
+	if command == "check" {
+		return checkSite(handler, &conf)
+	}
+	if command == "build" || command == "deploy" {
+		log.Printf("gosite exporting to %s", out)
+		if len(conf.Site.BaseURL) == 0 {
+			log.Print("Site.BaseURL is not set: feeds, sitemaps, canonical and social links of the export point to http://localhost")
+		}
+		changed, err := export.BuildChanged(handler, &conf, out, force)
+		if err != nil {
+			return err
+		}
+		// Search engines are only told about pages once they are live
+		if command == "build" {
+			return nil
+		}
+		log.Printf("gosite deploying %s to %s", out, target)
+		result, err := deploy.Run(target, out, &conf)
+		if err != nil {
+			return err
+		}
+		log.Printf("%d files uploaded, %d deleted", len(result.Uploaded), len(result.Deleted))
+		root := content.GetSiteURL(&conf)
+		for i, page := range changed {
+			changed[i] = root + page
+		}
+		if err = server.PingSearchEngines(changed, &conf); err != nil {
+			log.Print(err)
+		}
+		return nil
+	}
+	listener, err := server.Listen(&conf)
+	if err != nil {
+		return err
+	}
+	log.Printf("gosite serving on %s", conf.ServerIp)
+	if conf.Warmup.Enabled {
+		// Visitors are served while the caches warm up
+		go func() {
+			rendered, err := server.WarmUp(handler, &conf)
+			if err != nil {
+				log.Print(err)
+				return
+			}
+			log.Printf("gosite warmed %d pages up", rendered)
+		}()
+	}
+	return http.Serve(listener, handler)
+}
+
+/**
+ * Validates the config, compiles the templates of the theme, reads the front
+ * matter of every article and renders every page of the site once, logging
+ * each mistake found. Returns an error when any was found, so the command
+ * exits with a non-zero status
+ */
+func checkSite(handler http.Handler, conf *config.Config) error {
+	var problems []string
+	report := func(prefix string, found map[string]error) {
+		var lines []string
+		for name, err := range found {
+			lines = append(lines, prefix+name+": "+err.Error())
+		}
+		sort.Strings(lines)
+		problems = append(problems, lines...)
+	}
+	for _, err := range config.Check(conf) {
+		problems = append(problems, "config.json: "+err.Error())
+	}
+	report("template ", render.CheckTemplates(conf))
+	articles, err := content.CheckArticles(conf)
+	if err != nil {
+		return err
+	}
+	report("content ", articles)
+	rendered, pages, err := export.Check(handler, conf)
+	if err != nil {
+		return err
+	}
+	report("page ", pages)
+	for _, problem := range problems {
+		log.Print(problem)
+	}
+	log.Printf("gosite rendered %d pages, %d problems found", rendered, len(problems))
+	if len(problems) > 0 {
+		return errors.New("The site has " + strconv.Itoa(len(problems)) + " problems, see the report above")
+	}
+	return nil
+}
+
+/**
+ * Lints the templates of the theme, optionally rendering the page templates
+ * with sample contexts, and logs each mistake found. Returns an error when any
+ * was found, so the command exits with a non-zero status
+ */
+func lintTemplates(conf *config.Config, execute bool) error {
+	problems := render.LintTemplates(conf, execute)
+	for _, problem := range problems {
+		log.Print(problem)
+	}
+	log.Printf("gosite linted the templates, %d problems found", len(problems))
+	if len(problems) > 0 {
+		return errors.New("The theme has " + strconv.Itoa(len(problems)) + " problems, see the report above")
+	}
+	return nil
+}
+
+/**
+ * Writes config.json with the default configuration and the files of the
+ * starter site to the working folder, leaving the files already there alone
+ */
+func initSite() error {
+	bs, err := json.MarshalIndent(config.Default(), "", "    ")
+	if err != nil {
+		return err
+	}
+	files := map[string][]byte{"config.json": append(bs, '\n')}
+	for name, f := range content.Starter {
+		files[name] = f.Data
+	}
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		file := filepath.FromSlash(name)
+		if _, err := os.Stat(file); !os.IsNotExist(err) {
+			log.Printf("%s already exists, skipped", file)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(file, files[name], 0644); err != nil {
+			return err
+		}
+		log.Printf("%s written", file)
+	}
+	return nil
+}
@@ -0,0 +1,706 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Struct representing the site-wide metadata exposed to templates
+type SiteConfig struct {
+	Title         string
+	Description   string
+	BaseURL       string
+	Author        string
+	TwitterHandle string
+	Language      string
+	Social        []SocialLink
+}
+
+// Struct representing a link to a social network profile
+type SocialLink struct {
+	Name, Link string
+}
+
+// Struct representing the configuration
+type Config struct {
+	Site            SiteConfig
+	ContentFolder   string
+	TemplateFolder  string
+	ReadMoreText    string
+	ArticlesPerPage int
+	ServerIp        string
+	HiddenSections  []string
+	SectionTitles   map[string]string
+	MenuEntries     []MenuItem
+	HomeSection     string
+	Permalink       string
+	TemplateEngine  string
+	Assets          map[string]AssetBundle
+	Comments        CommentsConfig
+	Admin           AdminConfig
+	Smtp            SmtpConfig
+	Contact         ContactConfig
+	Newsletter      NewsletterConfig
+	Environment     string
+	Analytics       AnalyticsConfig
+	Views           ViewsConfig
+	NotFound        NotFoundConfig
+	Feed            FeedConfig
+	Images          ImagesConfig
+	Math            MathConfig
+	Mermaid         MermaidConfig
+	Emoji           EmojiConfig
+	Ping            PingConfig
+	Git             GitConfig
+	Storage         StorageConfig
+	Index           IndexConfig
+	Middleware      []MiddlewareConfig
+	Security        SecurityConfig
+	Session         SessionConfig
+	Authors         AuthorsConfig
+	Revisions       RevisionsConfig
+	Trash           TrashConfig
+	Uploads         UploadsConfig
+	Amp             AmpConfig
+	Pwa             PwaConfig
+	Search          SearchConfig
+	TagCloud        TagCloudConfig
+	Sitemap         SitemapConfig
+	CacheControl    CacheControlConfig
+	Deploy          DeployConfig
+	Export          ExportConfig
+	Redirects       []RedirectConfig
+	SocketMode      string
+	Proxy           ProxyConfig
+	PathPrefix      string
+	ContentFolders  []string
+	Ignore          []string
+	SectionOrder    map[string]string
+	Sections        map[string]SectionConfig
+	Warmup          WarmupConfig
+	// Set at runtime by the preview environment, which shows the drafts and
+	// keeps the links under /preview
+	Drafts bool `json:"-"`
+	// Set when no config file was found and the site runs on the defaults,
+	// serving the starter content and theme when its folders are missing
+	Quickstart bool `json:"-"`
+}
+
+// Struct representing the credentials protecting the administration pages
+// and the content API. User, Password and ApiToken are those of the site
+// administrator, Users those of the other people. PreviewSecret signs the
+// draft preview links, valid for PreviewHours
+type AdminConfig struct {
+	User, Password, ApiToken string
+	Users                    []UserConfig
+	PreviewSecret            string
+	PreviewHours             int
+	OAuth                    OAuthConfig
+}
+
+// Struct representing a person allowed into the admin area and the content
+// API, with their Role: admin, editor or author. People log in with their
+// Name and Password or with OAuth through their Email, scripts send their
+// Token
+type UserConfig struct {
+	Name, Password, Email, Token string
+	Role                         string
+}
+
+// Struct representing the OAuth login of the admin area. Provider is github,
+// google or oidc, the latter reading its endpoints from the Issuer. People
+// may log in when one of their verified email addresses is listed in Emails,
+// where "@example.com" stands for a whole domain, or when they are members of
+// one of the GitHub Organizations. They get the Role, admin by default,
+// unless their address is the Email of one of the Admin.Users
+type OAuthConfig struct {
+	Provider               string
+	ClientId, ClientSecret string
+	Issuer                 string
+	Emails                 []string
+	Organizations          []string
+	Role                   string
+}
+
+// Struct representing a named set of stylesheets and scripts that pages can
+// request through their front matter
+type AssetBundle struct {
+	Css, Js []string
+}
+
+// Struct representing a menu item. Items without a section are custom entries
+// defined in the configuration
+type MenuItem struct {
+	Title, Link, Section string
+	Weight               int
+	External             bool
+}
+
+// Site files embedded in the binary when built with the embed tag, nil
+// otherwise. They hold config.json and the content, template and static
+// folders
+var Embedded fs.FS
+
+// Set by the -prefer-disk flag: files found on disk then take precedence over
+// the embedded ones
+var PreferDisk bool
+
+// Set by the -profile flag: the pprof profiles of the running server are then
+// served to the administrators under /debug/pprof/
+var Profile bool
+
+// Path of the config file, config.json next to the binary when empty.
+// Programs embedding gosite can point it elsewhere before loading the config
+var File string
+
+/**
+ * Returns the configuration of sites without a config file: the content and
+ * template folders of the working folder, served on port 8080 with the
+ * defaults of the sample config.json
+ */
+func Default() Config {
+	return Config{
+		Site:            SiteConfig{Title: "My gosite", Language: "en"},
+		ContentFolder:   "content",
+		TemplateFolder:  "template",
+		TemplateEngine:  "pongo",
+		ReadMoreText:    "Read more",
+		ArticlesPerPage: 5,
+		ServerIp:        ":8080",
+		Permalink:       "/:section/:slug",
+		Comments:        CommentsConfig{Folder: "comments", MaxLinks: 2},
+		Smtp:            SmtpConfig{Host: "localhost", Port: 25, From: "gosite@localhost"},
+		Newsletter:      NewsletterConfig{File: "subscribers.txt", Subject: "Please confirm your subscription"},
+		Environment:     "production",
+		Analytics:       AnalyticsConfig{Environments: []string{"production"}},
+		Views:           ViewsConfig{File: "views.log", PopularCount: 5, PopularDays: 30},
+		NotFound:        NotFoundConfig{File: "notfound.log"},
+		Feed:            FeedConfig{Items: 20},
+		Images:          ImagesConfig{ThumbnailWidth: 300, Quality: 85, CacheFolder: "thumbs", LazyLoading: true},
+		Math: MathConfig{Css: "https://cdn.jsdelivr.net/npm/katex@0.16.11/dist/katex.min.css",
+			Js: "https://cdn.jsdelivr.net/npm/katex@0.16.11/dist/katex.min.js"},
+		Mermaid: MermaidConfig{Js: "https://cdn.jsdelivr.net/npm/mermaid@11/dist/mermaid.esm.min.mjs",
+			CacheFolder: "diagrams"},
+		Ping:       PingConfig{IndexNowEndpoint: "https://api.indexnow.org/indexnow"},
+		Git:        GitConfig{Remote: "origin"},
+		Storage:    StorageConfig{Provider: "file"},
+		Index:      IndexConfig{File: "index.db", Interval: 5},
+		Middleware: []MiddlewareConfig{{Name: "recovery"}, {Name: "gzip"}},
+		Session:    SessionConfig{Hours: 168},
+		Authors:    AuthorsConfig{File: "authors.json", Folder: "authors"},
+		Revisions:  RevisionsConfig{Folder: "revisions", Keep: 50},
+		Trash:      TrashConfig{Folder: "trash", Days: 30},
+		Uploads: UploadsConfig{Folder: "images", MaxSize: 5120, StripMetadata: true,
+			Types: []string{".jpg", ".jpeg", ".png", ".gif"}},
+		Search:       SearchConfig{Fuzzy: true, TitleWeight: 3, TagWeight: 2, BodyWeight: 1, Results: 20, ClientIndex: true},
+		TagCloud:     TagCloudConfig{Max: 30, Buckets: 5},
+		Sitemap:      SitemapConfig{Enabled: true, MaxUrls: 50000},
+		CacheControl: CacheControlConfig{Articles: 300, Listings: 300, Feeds: 1800, Static: 86400},
+		Deploy: DeployConfig{S3: DeployS3Config{Delete: true},
+			Ssh: DeploySshConfig{Port: 22}},
+		Admin:      AdminConfig{User: "admin", PreviewHours: 72, OAuth: OAuthConfig{Role: "admin"}},
+		SocketMode: "0660",
+		Quickstart: true,
+	}
+}
+
+/**
+ * Returns the content of the config file. Binaries embedding the site use
+ * their embedded config file, unless run with -prefer-disk and a config file
+ * is found next to them. Without any config file, a not exist error is
+ * returned
+ */
+func readFile() ([]byte, error) {
+	file := File
+	if len(file) == 0 {
+		dir, err := filepath.Abs(filepath.Dir(os.Args[0]))
+		if err != nil {
+			return nil, err
+		}
+		file = dir + "/config.json"
+	}
+	var bs []byte
+	var err error
+	if Embedded == nil || PreferDisk {
+		bs, err = ioutil.ReadFile(file)
+		// Without a config file next to the binary, as with go run or a
+		// binary on the PATH, the one of the working folder is used
+		if os.IsNotExist(err) && len(File) == 0 {
+			bs, err = ioutil.ReadFile("config.json")
+		}
+	}
+	if Embedded != nil && (!PreferDisk || err != nil) {
+		bs, err = fs.ReadFile(Embedded, "config.json")
+	}
+	return bs, err
+}
+
+/**
+ * Returns a Config struct filled in with values from the config file. Without
+ * any config file, the site runs on the defaults
+ */
+func Load() (Config, error) {
+	configEntry := new(Config)
+	bs, err := readFile()
+	if Embedded == nil && os.IsNotExist(err) && len(File) == 0 {
+		return Default(), nil
+	}
+	if err != nil {
+		return *configEntry, err
+	}
+	err = json.Unmarshal(bs, configEntry)
+	if err != nil {
+		return *configEntry, err
+	}
+	return *configEntry, nil
+}
+
+/**
+ * Returns the mistakes found in the config file, which Load leaves alone:
+ * unknown entries, usually misspelled ones, and values outside of the ones an
+ * entry accepts
+ */
+func Check(conf *Config) []error {
+	var problems []error
+	if bs, err := readFile(); err == nil {
+		decoder := json.NewDecoder(bytes.NewReader(bs))
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(new(Config)); err != nil {
+			problems = append(problems, err)
+		}
+	}
+	oneOf := func(entry string, value string, values ...string) {
+		for _, v := range values {
+			if value == v {
+				return
+			}
+		}
+		problems = append(problems, errors.New(entry+" is "+strconv.Quote(value)+", expected one of "+
+			strings.Join(values[1:], ", ")))
+	}
+	oneOf("TemplateEngine", conf.TemplateEngine, "", "pongo", "html")
+	for name, order := range conf.SectionOrder {
+		oneOf("SectionOrder."+name, order, "", "date", "weight")
+	}
+	for name, section := range conf.Sections {
+		oneOf("Sections."+name+".Order", section.Order, "", "date", "weight")
+	}
+	for _, r := range conf.Redirects {
+		if !strings.HasPrefix(r.From, "/") || len(r.To) == 0 {
+			problems = append(problems, errors.New("Redirect from "+strconv.Quote(r.From)+
+				" needs a From path starting with / and a To link"))
+		}
+	}
+	return problems
+}
+
+// Struct representing the Storage config entry, selecting where the content
+// is read from
+type StorageConfig struct {
+	Provider  string
+	Bucket    string
+	Region    string
+	Endpoint  string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+}
+
+// Struct representing the Index config entry. When enabled, articles are
+// indexed in a SQLite database refreshed every Interval seconds, and listings
+// are read from it instead of scanning the content on every request
+type IndexConfig struct {
+	Enabled  bool
+	File     string
+	Interval int
+}
+
+// Struct representing the analytics configuration. Provider is one of
+// "google", "plausible", "matomo" or "custom", the latter injecting Snippet
+// as is. Snippets are only injected in the listed Environments
+type AnalyticsConfig struct {
+	Provider     string
+	Id           string
+	Url          string
+	Snippet      string
+	Environments []string
+}
+
+/**
+ * Returns the environment the site runs in. The GOSITE_ENV variable overrides
+ * the Environment config entry, and "production" is the default
+ */
+func GetEnvironment(conf *Config) string {
+	if env := os.Getenv("GOSITE_ENV"); len(env) > 0 {
+		return env
+	}
+	if len(conf.Environment) > 0 {
+		return conf.Environment
+	}
+	return "production"
+}
+
+// Struct representing the configuration of the comments. Provider is one of
+// "native" for the built-in comments, or "disqus", "utterances" and "giscus"
+// for third party embeds; an empty provider disables comments
+type CommentsConfig struct {
+	Folder          string
+	MaxLinks        int
+	BlockedWords    []string
+	Provider        string
+	DisqusShortname string
+	Repo            string
+	RepoId          string
+	Category        string
+	CategoryId      string
+	Mapping         string
+	Theme           string
+}
+
+// Struct representing the SMTP server used to send mail
+type SmtpConfig struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	From     string
+}
+
+// Struct representing the configuration of the contact form
+type ContactConfig struct {
+	To             []string
+	Subject        string
+	Fields         []ContactField
+	SuccessMessage string
+	ErrorMessage   string
+}
+
+// Struct representing a field of the contact form. Type is an HTML input type,
+// or "textarea"
+type ContactField struct {
+	Name, Label, Type string
+	Required          bool
+}
+
+// Struct representing the configuration of the newsletter subscriptions.
+// Provider is "file" to store confirmed addresses in File, or "buttondown"
+// and "mailchimp" to forward them to the provider API
+type NewsletterConfig struct {
+	Provider       string
+	File           string
+	ApiKey         string
+	ListId         string
+	Secret         string
+	Subject        string
+	SuccessMessage string
+	ConfirmMessage string
+}
+
+// Struct representing the configuration of the page view counter. The
+// popular articles are the PopularCount most viewed over the last
+// PopularDays, or ever when zero
+type ViewsConfig struct {
+	Enabled      bool
+	File         string
+	PopularCount int
+	PopularDays  int
+}
+
+// Struct representing the tracking of the requests answered with a 404,
+// logged to File
+type NotFoundConfig struct {
+	Enabled bool
+	File    string
+}
+
+// Struct representing the configuration of the RSS feeds. Image, Categories,
+// Explicit and Email describe the podcast channel to iTunes compatible apps
+type FeedConfig struct {
+	Items      int
+	Image      string
+	Categories []string
+	Explicit   bool
+	Email      string
+}
+
+// Struct representing the configuration of the image pipeline. Thumbnails are
+// ThumbnailWidth pixels wide, encoded with the JPEG Quality and cached in the
+// CacheFolder. WebpCommand and AvifCommand convert images to WebP and AVIF,
+// with {in} and {out} standing for the files converted. LazyLoading adds
+// lazy loading and the size of the images to the img tags of articles
+type ImagesConfig struct {
+	ThumbnailWidth int
+	Quality        int
+	CacheFolder    string
+	WebpCommand    string
+	AvifCommand    string
+	LazyLoading    bool
+}
+
+// Struct representing the KaTeX stylesheet and script loaded by the pages
+// with math
+type MathConfig struct {
+	Css, Js string
+}
+
+// Struct representing the rendering of the mermaid diagrams. Js is the
+// Mermaid module rendering them in the browser, unless a Command renders them
+// to SVG, cached in the CacheFolder
+type MermaidConfig struct {
+	Js          string
+	Command     string
+	CacheFolder string
+}
+
+// Struct representing the replacement of the emoji shortcodes. Images is the
+// link of the emoji images, with {code} standing for the code points of the
+// emoji, Unicode emoji being used when it is empty
+type EmojiConfig struct {
+	Enabled bool
+	Images  string
+}
+
+// Struct representing the search engine pings sent once content changes.
+// Endpoints are links requested with {sitemap} standing for the Sitemap link,
+// and changed URLs are submitted to the IndexNowEndpoint when an IndexNowKey
+// is set
+type PingConfig struct {
+	Enabled          bool
+	Sitemap          string
+	Endpoints        []string
+	IndexNowKey      string
+	IndexNowEndpoint string
+}
+
+// Struct representing the Git config entry. When enabled, changes made through
+// the admin area and the API are committed to the Git repository holding the
+// content folder, and pushed to Remote when Push is set. Content read from
+// other storages is never committed
+type GitConfig struct {
+	Enabled     bool
+	Push        bool
+	Remote      string
+	Branch      string
+	AuthorEmail string
+}
+
+// Struct representing the security headers sent with every response. Headers
+// left empty get a default value, and headers set to "off" are not sent
+type SecurityConfig struct {
+	ContentSecurityPolicy   string
+	StrictTransportSecurity string
+	ContentTypeOptions      string
+	ReferrerPolicy          string
+	FrameOptions            string
+}
+
+// Struct representing the signed cookie sessions of the admin login, the
+// preview links and the unlocked articles. Sessions last Hours after they
+// were last changed, and are signed with the Secret
+type SessionConfig struct {
+	Secret string
+	Hours  int
+}
+
+// Struct representing the author profiles, read from the File JSON object
+// keyed by author and from the JSON files of the Folder, one per author
+type AuthorsConfig struct {
+	File   string
+	Folder string
+}
+
+// Struct representing the revisions kept when articles are saved through the
+// admin area and the API, in the Folder. Only the Keep latest revisions of an
+// article are kept, all of them when zero
+type RevisionsConfig struct {
+	Enabled bool
+	Folder  string
+	Keep    int
+}
+
+// Struct representing the trash holding the deleted articles, in the Folder.
+// Articles are deleted for good after Days, never when zero
+type TrashConfig struct {
+	Folder string
+	Days   int
+}
+
+// Struct representing the images uploaded through the admin area and the
+// API, stored in the Folder of their section. Uploads are limited to MaxSize
+// kilobytes and to the image Types listed, as extensions. StripMetadata
+// re-encodes them without their EXIF data, except the KeepMetadata extensions
+type UploadsConfig struct {
+	Folder        string
+	MaxSize       int
+	Types         []string
+	StripMetadata bool
+	KeepMetadata  []string
+}
+
+// Struct representing the AMP version of the articles, served under /amp
+// after their permalink when Enabled, with the Stylesheets of the static
+// folder inlined
+type AmpConfig struct {
+	Enabled     bool
+	Stylesheets []string
+}
+
+// Struct representing the progressive web app: the web app manifest, named
+// after the site unless Name and ShortName are set, and the service worker
+// caching the Precache files and the RecentArticles newest articles so they
+// can be read offline
+type PwaConfig struct {
+	Enabled         bool
+	Name, ShortName string
+	ThemeColor      string
+	BackgroundColor string
+	Icons           []PwaIcon
+	Precache        []string
+	RecentArticles  int
+}
+
+// Struct representing an icon of the web app manifest, its Sizes being like
+// 192x192
+type PwaIcon struct {
+	Src, Sizes, Type string
+}
+
+// Struct representing the search of the articles. Matches in the title, the
+// tags and the body weigh TitleWeight, TagWeight and BodyWeight, Fuzzy lets
+// words with a typo match, and searches show the Results most relevant
+// articles. ClientIndex serves the words of the articles for searches made
+// in the browser
+type SearchConfig struct {
+	Fuzzy       bool
+	TitleWeight float64
+	TagWeight   float64
+	BodyWeight  float64
+	Results     int
+	ClientIndex bool
+}
+
+// Struct representing the tag cloud of the templates, holding the Max most
+// used tags, all of them when zero, weighted from 1 to Buckets
+type TagCloudConfig struct {
+	Max     int
+	Buckets int
+}
+
+// Struct representing the sitemap of the site. Sites with more than MaxUrls
+// links, 50000 at most, get a sitemap index pointing to several sitemaps
+type SitemapConfig struct {
+	Enabled bool
+	MaxUrls int
+}
+
+// Struct representing how long browsers and shared caches may keep the
+// articles, the section listings, the feeds and the static assets, in
+// seconds. Zero sends no caching hints
+type CacheControlConfig struct {
+	Articles int
+	Listings int
+	Feeds    int
+	Static   int
+}
+
+// Struct representing where gosite deploy publishes the static export
+type DeployConfig struct {
+	S3  DeployS3Config
+	Ssh DeploySshConfig
+}
+
+// Struct representing the S3 bucket, or bucket of a compatible service, a
+// static export is deployed to. Files gone from the export are deleted from
+// the bucket with Delete, and changed files are invalidated in the CloudFront
+// Distribution when set
+type DeployS3Config struct {
+	Bucket       string
+	Region       string
+	Endpoint     string
+	Prefix       string
+	AccessKey    string
+	SecretKey    string
+	Delete       bool
+	Distribution string
+}
+
+// Struct representing the static exports. Links to the site in exported pages
+// are made relative to the page with RelativeUrls, or put under the BasePath
+// of a site not hosted at the root of its domain, like the GitHub Pages of a
+// project. Cname is the custom domain written to the CNAME file of GitHub
+// Pages, and Netlify writes the _redirects and _headers files of Netlify
+type ExportConfig struct {
+	BasePath     string
+	RelativeUrls bool
+	Cname        string
+	Netlify      bool
+}
+
+// Struct representing a redirect of the site from a path to another path or
+// to a link, permanent unless Status says otherwise
+type RedirectConfig struct {
+	From   string
+	To     string
+	Status int
+}
+
+// Struct representing the web host a static export is deployed to with rsync
+// or SFTP, logging in over SSH as User with the private Key file, or the
+// default SSH keys when empty. The export is copied to the Path folder, which
+// must be set, and files gone from the export are deleted from it with Delete
+type DeploySshConfig struct {
+	Host   string
+	Port   int
+	User   string
+	Key    string
+	Path   string
+	Delete bool
+}
+
+// Struct representing an entry of the Middleware config list. Routes are
+// regular expressions matched against the request path; a middleware without
+// routes applies to every request. Origins, Methods and Headers configure the
+// cors middleware
+type MiddlewareConfig struct {
+	Name     string
+	Routes   []string
+	MaxAge   int
+	User     string
+	Password string
+	Origins  []string
+	Methods  []string
+	Headers  []string
+}
+
+// Struct representing the Proxy config entry. Requests coming from the
+// Trusted proxies, IP addresses or CIDR ranges, are taken to be sent by the
+// client named in their X-Forwarded-For header, with the scheme and host of
+// X-Forwarded-Proto and X-Forwarded-Host
+type ProxyConfig struct {
+	Trusted []string
+}
+
+// Struct representing an entry of the Sections config map, overriding the
+// site-wide listing settings for a section: the ArticlesPerPage, the Order of
+// the articles, "date" or "weight", and the number of SummaryLines of markdown
+// shown for each article
+type SectionConfig struct {
+	ArticlesPerPage int
+	Order           string
+	SummaryLines    int
+}
+
+// Struct representing the Warmup config entry. When Enabled, the homepage,
+// the first page of the section listings and the Articles newest articles
+// are rendered at startup
+type WarmupConfig struct {
+	Enabled  bool
+	Articles int
+}
@@ -0,0 +1,85 @@
+package content
+
+import (
+	"encoding/json"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"path/filepath"
+	"strings"
+)
+
+// Struct representing the profile of an author, named by the author front
+// matter key of their articles. Bio is markdown, Avatar the link of their
+// picture
+type Author struct {
+	Key, Name, Bio, Avatar string
+	Links                  []config.SocialLink
+	// Link of the author archive, which the preview environment does not
+	// mirror
+	Link string `json:"-"`
+}
+
+/**
+ * Returns the key of an author in links and profiles, e.g. "jane-doe" for
+ * "Jane Doe"
+ */
+func GetAuthorKey(name string) string {
+	return Slugify(name)
+}
+
+/**
+ * Returns the author profiles by key. They are read from the Authors.File
+ * JSON object, keyed by author, and from the JSON files of the
+ * Authors.Folder, named after their key, which take precedence
+ */
+func GetAuthors(conf *config.Config) map[string]Author {
+	file, folder := conf.Authors.File, conf.Authors.Folder
+	if len(file) == 0 {
+		file = "authors.json"
+	}
+	if len(folder) == 0 {
+		folder = "authors"
+	}
+	authors := make(map[string]Author)
+	var listed map[string]Author
+	bs, err := OpenDataStore(filepath.Dir(file)).ReadFile(filepath.Base(file))
+	if err == nil && json.Unmarshal(bs, &listed) == nil {
+		for key, a := range listed {
+			a.Key = GetAuthorKey(key)
+			authors[a.Key] = a
+		}
+	}
+	store := OpenDataStore(folder)
+	files, _ := store.ReadDir("")
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		var a Author
+		bs, err := store.ReadFile(fi.Name())
+		if err != nil || json.Unmarshal(bs, &a) != nil {
+			continue
+		}
+		a.Key = GetAuthorKey(strings.TrimSuffix(fi.Name(), ".json"))
+		authors[a.Key] = a
+	}
+	for key, a := range authors {
+		if len(a.Name) == 0 {
+			a.Name = key
+		}
+		a.Link = "/authors/" + key
+		authors[key] = a
+	}
+	return authors
+}
+
+/**
+ * Returns the profile of the author named by an author front matter value.
+ * Authors without a profile get one holding only their name
+ */
+func GetAuthor(name string, conf *config.Config) Author {
+	key := GetAuthorKey(name)
+	if a, ok := GetAuthors(conf)[key]; ok {
+		return a
+	}
+	return Author{Key: key, Name: name, Link: "/authors/" + key}
+}
@@ -0,0 +1,150 @@
+package content
+
+import (
+	"github.com/rredpoppy/gosite/pkg/config"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Matches the names of articles starting with their date, as in
+// 2024-01-31-hello-world.md, capturing the date and the rest of the name
+var datePrefixPattern = regexp.MustCompile(`^([0-9]{4}-[0-9]{2}-[0-9]{2})-(.+\.md)$`)
+
+// Description of a file of a store under another name
+type renamedFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi renamedFileInfo) Name() string { return fi.name }
+
+// Content store showing the articles of another store named after their date,
+// as Jekyll names its posts, under the rest of their name: the article of
+// 2024-01-31-hello-world.md is hello-world, published on the date of its file
+// name unless its front matter sets another one. Articles named without a
+// date take precedence over dated ones of the same name
+type DatePrefixStore struct {
+	Store Store
+}
+
+/**
+ * Returns the name of a file in the underlying store, with the date of its
+ * name if it has one
+ */
+func (s DatePrefixStore) resolve(name string) (string, string) {
+	if _, err := s.Store.Stat(name); err == nil {
+		return name, ""
+	}
+	return s.findDated(name)
+}
+
+/**
+ * Returns the name of the dated file shown under a name, with its date, or
+ * the name itself when no such file exists
+ */
+func (s DatePrefixStore) findDated(name string) (string, string) {
+	folder, base := path.Split(strings.TrimPrefix(path.Clean("/"+name), "/"))
+	entries, err := s.Store.ReadDir(strings.TrimSuffix(folder, "/"))
+	if err != nil {
+		return name, ""
+	}
+	for _, fi := range entries {
+		if m := datePrefixPattern.FindStringSubmatch(fi.Name()); m != nil && m[2] == base && !fi.IsDir() {
+			return folder + fi.Name(), m[1]
+		}
+	}
+	return name, ""
+}
+
+/**
+ * Adds a date to the front matter of an article, unless it sets one already
+ */
+func addFrontMatterDate(bs []byte, date string) []byte {
+	text := strings.Replace(string(bs), "\r\n", "\n", -1)
+	if meta, _ := ParseFrontMatter(text); meta.Has("date") {
+		return bs
+	}
+	if strings.HasPrefix(text, "---\n") && strings.Contains(text[4:], "\n---") {
+		return []byte("---\ndate: " + date + "\n" + text[4:])
+	}
+	return []byte("---\ndate: " + date + "\n---\n" + text)
+}
+
+func (s DatePrefixStore) Open(name string) (fs.File, error) {
+	return openStoreFile(s, name)
+}
+
+func (s DatePrefixStore) ReadFile(name string) ([]byte, error) {
+	// Files are read as they are named first, sparing remote stores a request
+	if bs, err := s.Store.ReadFile(name); err == nil {
+		return bs, nil
+	}
+	file, date := s.findDated(name)
+	bs, err := s.Store.ReadFile(file)
+	if err != nil || len(date) == 0 {
+		return bs, err
+	}
+	return addFrontMatterDate(bs, date), nil
+}
+
+func (s DatePrefixStore) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := s.Store.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	var infos []os.FileInfo
+	seen := make(map[string]bool)
+	for _, fi := range entries {
+		if fi.IsDir() || !datePrefixPattern.MatchString(fi.Name()) {
+			seen[fi.Name()] = true
+			infos = append(infos, fi)
+		}
+	}
+	for _, fi := range entries {
+		m := datePrefixPattern.FindStringSubmatch(fi.Name())
+		if m == nil || fi.IsDir() || seen[m[2]] {
+			continue
+		}
+		seen[m[2]] = true
+		infos = append(infos, renamedFileInfo{FileInfo: fi, name: m[2]})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+func (s DatePrefixStore) Stat(name string) (os.FileInfo, error) {
+	file, date := s.resolve(name)
+	fi, err := s.Store.Stat(file)
+	if err != nil || len(date) == 0 {
+		return fi, err
+	}
+	return renamedFileInfo{FileInfo: fi, name: path.Base(name)}, nil
+}
+
+func (s DatePrefixStore) WriteFile(name string, data []byte) error {
+	file, _ := s.resolve(name)
+	return s.Store.WriteFile(file, data)
+}
+
+func (s DatePrefixStore) AppendFile(name string, data []byte) error {
+	file, _ := s.resolve(name)
+	return AppendFile(s.Store, file, data)
+}
+
+func (s DatePrefixStore) Remove(name string) error {
+	file, _ := s.resolve(name)
+	return s.Store.Remove(file)
+}
+
+/**
+ * Returns the name a content file has in its folder, with the date its name
+ * starts with, for tools working on the files themselves like git
+ */
+func GetStoredName(conf *config.Config, name string) string {
+	file, _ := DatePrefixStore{Store: getContentStore(conf)}.resolve(name)
+	return file
+}
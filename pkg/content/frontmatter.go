@@ -0,0 +1,132 @@
+package content
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Map of metadata values read from the front matter block of a markdown file
+type FrontMatter map[string]string
+
+// Returns the value stored for the given key, or an empty string
+func (f FrontMatter) Get(key string) string {
+	return f[strings.ToLower(key)]
+}
+
+// Returns true if the key is present in the front matter
+func (f FrontMatter) Has(key string) bool {
+	_, ok := f[strings.ToLower(key)]
+	return ok
+}
+
+// Returns true if the value stored for the given key is a truthy word
+func (f FrontMatter) Bool(key string) bool {
+	switch strings.ToLower(f.Get(key)) {
+	case "true", "yes", "on", "1":
+		return true
+	}
+	return false
+}
+
+// Returns the comma separated values stored for the given key. Lists may be
+// wrapped in square brackets, as in "tags: [go, web]"
+func (f FrontMatter) List(key string) []string {
+	var values []string
+	value := strings.TrimSuffix(strings.TrimPrefix(f.Get(key), "["), "]")
+	for _, v := range strings.Split(value, ",") {
+		v = strings.Trim(strings.TrimSpace(v), "\"'")
+		if len(v) > 0 {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+/**
+ * Splits a markdown file into its front matter and body. The front matter is
+ * an optional block of "key: value" lines delimited by "---" lines at the very
+ * top of the file.
+ */
+func ParseFrontMatter(content string) (FrontMatter, string) {
+	meta := make(FrontMatter)
+	content = strings.Replace(content, "\r\n", "\n", -1)
+	if !strings.HasPrefix(content, "---\n") {
+		return meta, content
+	}
+	end := strings.Index(content[4:], "\n---")
+	if end < 0 {
+		return meta, content
+	}
+	block := content[4 : 4+end]
+	body := strings.TrimPrefix(content[4+end+4:], "\n")
+	for _, line := range strings.Split(block, "\n") {
+		if len(strings.TrimSpace(line)) == 0 || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		meta[key] = strings.Trim(strings.TrimSpace(parts[1]), "\"'")
+	}
+	return meta, body
+}
+
+/**
+ * Returns the first mistake of the front matter of a markdown file, which
+ * ParseFrontMatter leaves alone: a block that is never closed, lines other
+ * than "key: value" pairs, and dates or weights that cannot be read
+ */
+func CheckFrontMatter(content string) error {
+	content = strings.Replace(content, "\r\n", "\n", -1)
+	if !strings.HasPrefix(content, "---\n") {
+		return nil
+	}
+	end := strings.Index(content[4:], "\n---")
+	if end < 0 {
+		return errors.New("Front matter is not closed by a --- line")
+	}
+	for i, line := range strings.Split(content[4:4+end], "\n") {
+		line = strings.TrimSpace(line)
+		if len(line) > 0 && !strings.HasPrefix(line, "#") && !strings.Contains(line, ":") {
+			return errors.New("Front matter line " + strconv.Itoa(i+2) + " is not a key: value pair")
+		}
+	}
+	meta, _ := ParseFrontMatter(content)
+	for _, key := range []string{"date", "updated"} {
+		if meta.Has(key) {
+			if _, err := ParseDate(meta.Get(key)); err != nil {
+				return errors.New("Front matter " + key + ": " + err.Error())
+			}
+		}
+	}
+	if meta.Has("weight") {
+		if _, err := strconv.Atoi(strings.TrimSpace(meta.Get("weight"))); err != nil {
+			return errors.New("Front matter weight is not a whole number: " + meta.Get("weight"))
+		}
+	}
+	return nil
+}
+
+/**
+ * Returns the front matter block, with keys sorted, ready to be written at the
+ * top of a markdown file. An empty front matter gives an empty string
+ */
+func (f FrontMatter) String() string {
+	if len(f) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(f))
+	for key := range f {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	lines := []string{"---"}
+	for _, key := range keys {
+		lines = append(lines, key+": "+f[key])
+	}
+	return strings.Join(append(lines, "---"), "\n") + "\n"
+}
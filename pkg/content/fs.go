@@ -0,0 +1,68 @@
+package content
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+)
+
+// File of a store opened through the io/fs interface, read from memory
+type storeFile struct {
+	*bytes.Reader
+	info fs.FileInfo
+}
+
+func (f *storeFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *storeFile) Close() error               { return nil }
+
+// Folder of a store opened through the io/fs interface
+type storeDir struct {
+	info    fs.FileInfo
+	entries []fs.FileInfo
+	offset  int
+}
+
+func (d *storeDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *storeDir) Close() error               { return nil }
+
+func (d *storeDir) Read(b []byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: fs.ErrInvalid}
+}
+
+func (d *storeDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	var entries []fs.DirEntry
+	for d.offset < len(d.entries) && (count <= 0 || len(entries) < count) {
+		entries = append(entries, fs.FileInfoToDirEntry(d.entries[d.offset]))
+		d.offset++
+	}
+	if count > 0 && len(entries) == 0 {
+		return nil, io.EOF
+	}
+	return entries, nil
+}
+
+/**
+ * Opens a file or folder of a store from its ReadFile, ReadDir and Stat
+ * methods, for the stores not backed by a file system
+ */
+func openStoreFile(s Store, name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	info, err := s.Stat(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	if info.IsDir() {
+		entries, err := s.ReadDir(name)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+		return &storeDir{info: info, entries: entries}, nil
+	}
+	bs, err := s.ReadFile(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &storeFile{Reader: bytes.NewReader(bs), info: info}, nil
+}
@@ -0,0 +1,113 @@
+package content
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Number of file checksums remembered at most
+const fileHashesMax = 10000
+
+// Checksum of a file, with the size and modification time it was read at
+type fileHash struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// Checksums of the files of the stores, keyed by the store and the name of
+// the file
+var fileHashes = make(map[string]fileHash)
+var fileHashesMu sync.Mutex
+
+// Checksum of the whole content as last indexed, empty until the content
+// index is started
+var contentVersion atomic.Value
+
+/**
+ * Returns the hex encoded SHA-256 checksum of a list of sources. It is the
+ * notion of "this input changed" shared by the content index and the content
+ * version the cache middleware follows, the ETags, the thumbnails and
+ * diagrams, the asset fingerprints and incremental exports. Sources are
+ * separated so their boundaries count, and a single source gets the plain
+ * checksum of its bytes
+ */
+func Hash(sources ...[]byte) string {
+	h := sha256.New()
+	for i, source := range sources {
+		if i > 0 {
+			h.Write([]byte{0})
+		}
+		h.Write(source)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+/**
+ * Returns the checksum of a file of a store. Checksums are remembered until
+ * the size or the modification time of the file changes, so unchanged files
+ * are only read once. Stores are told apart by their settings, like their
+ * folder, since they are opened anew for each use, and fileHashesMax
+ * checksums are remembered at most
+ */
+func HashFile(store Store, name string) (string, error) {
+	fi, err := store.Stat(name)
+	if err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("%#v", store) + "\x00" + name
+	fileHashesMu.Lock()
+	cached, ok := fileHashes[key]
+	fileHashesMu.Unlock()
+	if ok && cached.size == fi.Size() && cached.modTime.Equal(fi.ModTime()) {
+		return cached.sum, nil
+	}
+	bs, err := store.ReadFile(name)
+	if err != nil {
+		return "", err
+	}
+	sum := Hash(bs)
+	fileHashesMu.Lock()
+	// Maps are iterated in a random order
+	for k := range fileHashes {
+		if len(fileHashes) < fileHashesMax {
+			break
+		}
+		delete(fileHashes, k)
+	}
+	fileHashes[key] = fileHash{size: fi.Size(), modTime: fi.ModTime(), sum: sum}
+	fileHashesMu.Unlock()
+	return sum, nil
+}
+
+/**
+ * Returns the checksum of the whole content as last indexed, which changes
+ * with every article created, edited or deleted. Empty while the content
+ * index is not started
+ */
+func GetContentVersion() string {
+	version, _ := contentVersion.Load().(string)
+	return version
+}
+
+/**
+ * Sets the content version from the checksums of the indexed articles, keyed
+ * by their file name
+ */
+func setContentVersion(sums map[string]string) {
+	var names []string
+	for name := range sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sources [][]byte
+	for _, name := range names {
+		sources = append(sources, []byte(name), []byte(sums[name]))
+	}
+	contentVersion.Store(Hash(sources...))
+}
@@ -0,0 +1,57 @@
+package content
+
+// Hook called when an article is read for the public site. It can change the
+// front matter and the markdown body, to expand custom shortcodes for instance.
+// The section of pages outside any section is empty
+type ContentLoadedHook func(section string, slug string, p *Page)
+
+// Hook called once an article is created, updated, published or deleted
+// through the admin area or the API. The action is one of "Create", "Update",
+// "Publish" or "Delete"
+type ContentPublishedHook func(section string, slug string, action string)
+
+var contentLoadedHooks []ContentLoadedHook
+
+var contentPublishedHooks []ContentPublishedHook
+
+/**
+ * Registers a hook called when articles are loaded. Hooks must be registered
+ * before the server starts, and are called in registration order
+ */
+func OnContentLoaded(hook ContentLoadedHook) {
+	contentLoadedHooks = append(contentLoadedHooks, hook)
+}
+
+/**
+ * Registers a hook called when content is changed
+ */
+func OnContentPublished(hook ContentPublishedHook) {
+	contentPublishedHooks = append(contentPublishedHooks, hook)
+}
+
+/**
+ * Passes a page through the content loaded hooks
+ */
+func RunContentLoaded(section string, slug string, p Page) Page {
+	if len(contentLoadedHooks) > 0 {
+		// Hooks get their own copy of the front matter, which may be shared
+		meta := make(FrontMatter, len(p.Meta))
+		for key, value := range p.Meta {
+			meta[key] = value
+		}
+		p.Meta = meta
+	}
+	for _, hook := range contentLoadedHooks {
+		hook(section, slug, &p)
+	}
+	return p
+}
+
+/**
+ * Calls the content published hooks
+ */
+func RunContentPublished(section string, slug string, action string) {
+	for _, hook := range contentPublishedHooks {
+		hook(section, slug, action)
+	}
+}
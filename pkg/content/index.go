@@ -0,0 +1,285 @@
+package content
+
+import (
+	"database/sql"
+	"encoding/json"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	// SQLite driver, written in pure Go so binaries can be cross compiled
+	_ "modernc.org/sqlite"
+)
+
+// Interface implemented by the indexes of the content store, which listings
+// and menus are served from instead of scanning the content on every request
+type Index interface {
+	// Brings the index up to date with the content store
+	Refresh(conf *config.Config) error
+	// Returns the names of the sections, hidden ones included
+	Sections() ([]string, error)
+	// Returns the introduction of a section, read from its _index.md file
+	Intro(section string) (Page, error)
+	// Returns the published articles of a section as listing items, newest
+	// first, with their whole body as summary
+	Articles(section string) ([]ListingItem, error)
+	// Returns all the published articles, newest first
+	Published() ([]IndexedArticle, error)
+}
+
+// SQLite index of the articles of the content store
+type SQLiteIndex struct {
+	db *sql.DB
+	mu sync.Mutex
+}
+
+// Struct representing an indexed article
+type IndexedArticle struct {
+	Section, Slug, Title string
+	Date, Modified       time.Time
+	Tags                 []string
+	Draft                bool
+	Meta                 FrontMatter
+	Summary, Body        string
+	// Checksum of the file of the article
+	Checksum string
+}
+
+// The content index, nil until StartIndex is called
+var SiteIndex Index
+
+const indexSchema = `CREATE TABLE IF NOT EXISTS articles (
+	section TEXT NOT NULL,
+	slug TEXT NOT NULL,
+	path TEXT NOT NULL,
+	title TEXT NOT NULL,
+	date INTEGER NOT NULL,
+	modified INTEGER NOT NULL,
+	tags TEXT NOT NULL,
+	draft INTEGER NOT NULL,
+	meta TEXT NOT NULL,
+	summary TEXT NOT NULL,
+	body TEXT NOT NULL,
+	checksum TEXT NOT NULL,
+	PRIMARY KEY (section, slug)
+);
+CREATE INDEX IF NOT EXISTS articles_listing ON articles (section, draft, modified);
+CREATE TABLE IF NOT EXISTS sections (
+	name TEXT NOT NULL PRIMARY KEY
+);`
+
+/**
+ * Opens the content index database, creating it if needed
+ */
+func OpenSQLiteIndex(conf *config.Config) (*SQLiteIndex, error) {
+	file := conf.Index.File
+	if len(file) == 0 {
+		file = "index.db"
+	}
+	db, err := sql.Open("sqlite", file)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = db.Exec(indexSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SQLiteIndex{db: db}, nil
+}
+
+/**
+ * Brings the index up to date with the content store. Articles whose
+ * checksum did not change are skipped, the others are parsed and stored
+ * along with the checksum of their file, and deleted files are removed
+ */
+func (ix *SQLiteIndex) Refresh(conf *config.Config) error {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	known := make(map[string]string)
+	rows, err := ix.db.Query("SELECT section, slug, checksum FROM articles")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var section, slug, checksum string
+		if err = rows.Scan(&section, &slug, &checksum); err == nil {
+			known[section+"/"+slug] = checksum
+		}
+	}
+	rows.Close()
+
+	sections, err := GetSections(conf)
+	if err != nil {
+		return err
+	}
+	tx, err := ix.db.Begin()
+	if err != nil {
+		return err
+	}
+	if _, err = tx.Exec("DELETE FROM sections"); err != nil {
+		tx.Rollback()
+		return err
+	}
+	store := GetContentStore(conf)
+	sums := make(map[string]string)
+	for _, s := range sections {
+		if _, err = tx.Exec("INSERT INTO sections (name) VALUES (?)", s.Name); err != nil {
+			tx.Rollback()
+			return err
+		}
+		for _, a := range s.Articles {
+			key := s.Name + "/" + a.Slug
+			checksum, ok := known[key]
+			delete(known, key)
+			sum, err := HashFile(store, GetArticleName(s.Name, a.Slug))
+			if err != nil {
+				continue
+			}
+			sums[GetArticleName(s.Name, a.Slug)] = sum
+			if ok && checksum == sum {
+				continue
+			}
+			bs, err := store.ReadFile(GetArticleName(s.Name, a.Slug))
+			if err != nil {
+				continue
+			}
+			meta, body := ParseFrontMatter(string(bs))
+			p := Page{Meta: meta, Body: body}
+			date := a.Modified
+			if d, err := ParseDate(meta.Get("date")); err == nil {
+				date = d
+			}
+			metaJson, _ := json.Marshal(meta)
+			_, err = tx.Exec(`INSERT OR REPLACE INTO articles (section, slug, path, title, date,
+				modified, tags, draft, meta, summary, body, checksum)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				s.Name, a.Slug, GetArticleName(s.Name, a.Slug), GetPageTitle(p), date.UnixNano(),
+				a.Modified.UnixNano(), strings.Join(meta.List("tags"), ","), p.IsDraft(),
+				string(metaJson), GetSummary(body), body, Hash(bs))
+			if err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	for key := range known {
+		parts := strings.SplitN(key, "/", 2)
+		if _, err = tx.Exec("DELETE FROM articles WHERE section = ? AND slug = ?", parts[0], parts[1]); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	setContentVersion(sums)
+	return nil
+}
+
+/**
+ * Returns the indexed articles matching a SQL condition, newest first
+ */
+func (ix *SQLiteIndex) query(where string, args ...interface{}) ([]IndexedArticle, error) {
+	var articles []IndexedArticle
+	rows, err := ix.db.Query(`SELECT section, slug, title, date, modified, tags, draft,
+		meta, summary, body, checksum FROM articles WHERE `+where+` ORDER BY date DESC`, args...)
+	if err != nil {
+		return articles, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a IndexedArticle
+		var date, modified int64
+		var tags, meta string
+		err = rows.Scan(&a.Section, &a.Slug, &a.Title, &date, &modified, &tags, &a.Draft,
+			&meta, &a.Summary, &a.Body, &a.Checksum)
+		if err != nil {
+			return articles, err
+		}
+		a.Date, a.Modified = time.Unix(0, date), time.Unix(0, modified)
+		if len(tags) > 0 {
+			a.Tags = strings.Split(tags, ",")
+		}
+		json.Unmarshal([]byte(meta), &a.Meta)
+		articles = append(articles, a)
+	}
+	return articles, rows.Err()
+}
+
+func (ix *SQLiteIndex) Articles(section string) ([]ListingItem, error) {
+	var items []ListingItem
+	articles, err := ix.query("section = ? AND draft = 0 AND slug != '_index' AND date <= ?", section,
+		time.Now().UnixNano())
+	for _, a := range articles {
+		items = append(items, ListingItem{Slug: a.Slug, Title: a.Title,
+			Meta: a.Meta, Summary: a.Body, Date: a.Date})
+	}
+	return items, err
+}
+
+func (ix *SQLiteIndex) Published() ([]IndexedArticle, error) {
+	return ix.query("draft = 0 AND substr(slug, 1, 1) != '_' AND date <= ?", time.Now().UnixNano())
+}
+
+func (ix *SQLiteIndex) Sections() ([]string, error) {
+	var sections []string
+	rows, err := ix.db.Query("SELECT name FROM sections ORDER BY name")
+	if err != nil {
+		return sections, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return sections, err
+		}
+		sections = append(sections, name)
+	}
+	return sections, rows.Err()
+}
+
+func (ix *SQLiteIndex) Intro(section string) (Page, error) {
+	articles, err := ix.query("section = ? AND slug = '_index'", section)
+	if err != nil {
+		return Page{}, err
+	}
+	if len(articles) == 0 {
+		return Page{}, os.ErrNotExist
+	}
+	return Page{Meta: articles[0].Meta, Body: articles[0].Body}, nil
+}
+
+/**
+ * Builds the content index, in memory or in the SQLite database when
+ * Index.Enabled is set, then watches the content store for changes, refreshing
+ * the index every Index.Interval seconds: every 5 seconds in memory and every
+ * minute with SQLite by default
+ */
+func StartIndex(conf *config.Config) error {
+	var ix Index = NewMemoryIndex()
+	interval := 5 * time.Second
+	if conf.Index.Enabled {
+		sqlite, err := OpenSQLiteIndex(conf)
+		if err != nil {
+			return err
+		}
+		ix, interval = sqlite, time.Minute
+	}
+	if err := ix.Refresh(conf); err != nil {
+		return err
+	}
+	SiteIndex = ix
+	if conf.Index.Interval > 0 {
+		interval = time.Duration(conf.Index.Interval) * time.Second
+	}
+	go func() {
+		for range time.Tick(interval) {
+			if current, err := config.Load(); err == nil {
+				ix.Refresh(&current)
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,153 @@
+package content
+
+import (
+	"github.com/rredpoppy/gosite/pkg/config"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// In-memory index of the sections and articles of the content store, the
+// default one. Articles are kept with their front matter and body, drafts and
+// section introductions included
+type MemoryIndex struct {
+	mu       sync.RWMutex
+	refresh  sync.Mutex
+	sections []string
+	articles map[string][]IndexedArticle
+}
+
+/**
+ * Returns an empty in-memory index, filled by Refresh
+ */
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{articles: make(map[string][]IndexedArticle)}
+}
+
+/**
+ * Returns the indexed form of an article
+ */
+func getIndexedArticle(section string, slug string, p Page, modified time.Time) IndexedArticle {
+	return IndexedArticle{Section: section, Slug: slug, Title: GetPageTitle(p),
+		Date: GetArticleDate(p, modified), Modified: modified, Tags: p.Meta.List("tags"), Draft: p.IsDraft(),
+		Meta: p.Meta, Summary: GetSummary(p.Body), Body: p.Body}
+}
+
+/**
+ * Brings the index up to date with the content store. Only the articles whose
+ * content changed are parsed again
+ */
+func (ix *MemoryIndex) Refresh(conf *config.Config) error {
+	ix.refresh.Lock()
+	defer ix.refresh.Unlock()
+	store := GetContentStore(conf)
+	dirs, err := store.ReadDir("")
+	if err != nil {
+		return err
+	}
+	ix.mu.RLock()
+	previous := ix.articles
+	ix.mu.RUnlock()
+
+	var sections []string
+	articles := make(map[string][]IndexedArticle)
+	sums := make(map[string]string)
+	for _, dir := range dirs {
+		if !dir.IsDir() || strings.HasPrefix(dir.Name(), ".") {
+			continue
+		}
+		section := dir.Name()
+		sections = append(sections, section)
+		known := make(map[string]IndexedArticle)
+		for _, a := range previous[section] {
+			known[a.Slug] = a
+		}
+		files, err := store.ReadDir(section)
+		if err != nil {
+			continue
+		}
+		for _, fi := range files {
+			if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".md") {
+				continue
+			}
+			slug := strings.TrimSuffix(fi.Name(), ".md")
+			sum, err := HashFile(store, GetArticleName(section, slug))
+			if err != nil {
+				continue
+			}
+			a, ok := known[slug]
+			if !ok || a.Checksum != sum {
+				p, err := GetPage(section, slug, conf)
+				if err != nil {
+					continue
+				}
+				a = getIndexedArticle(section, slug, p, fi.ModTime())
+				a.Checksum = sum
+			}
+			sums[GetArticleName(section, slug)] = sum
+			articles[section] = append(articles[section], a)
+		}
+		sort.SliceStable(articles[section], func(i, j int) bool {
+			return articles[section][i].Date.After(articles[section][j].Date)
+		})
+	}
+	sort.Strings(sections)
+
+	ix.mu.Lock()
+	ix.sections, ix.articles = sections, articles
+	ix.mu.Unlock()
+	setContentVersion(sums)
+	return nil
+}
+
+func (ix *MemoryIndex) Sections() ([]string, error) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return append([]string(nil), ix.sections...), nil
+}
+
+func (ix *MemoryIndex) Intro(section string) (Page, error) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	for _, a := range ix.articles[section] {
+		if a.Slug == "_index" {
+			return Page{Meta: a.Meta, Body: a.Body}, nil
+		}
+	}
+	return Page{}, os.ErrNotExist
+}
+
+func (ix *MemoryIndex) Articles(section string) ([]ListingItem, error) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	var items []ListingItem
+	now := time.Now()
+	for _, a := range ix.articles[section] {
+		if a.Draft || a.Slug == "_index" || (Page{Meta: a.Meta}).IsScheduled(now) {
+			continue
+		}
+		items = append(items, ListingItem{Slug: a.Slug, Title: a.Title,
+			Meta: a.Meta, Summary: a.Body, Date: a.Date})
+	}
+	return items, nil
+}
+
+func (ix *MemoryIndex) Published() ([]IndexedArticle, error) {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	var published []IndexedArticle
+	now := time.Now()
+	for _, section := range ix.sections {
+		for _, a := range ix.articles[section] {
+			if !a.Draft && !strings.HasPrefix(a.Slug, "_") && !(Page{Meta: a.Meta}).IsScheduled(now) {
+				published = append(published, a)
+			}
+		}
+	}
+	sort.SliceStable(published, func(i, j int) bool {
+		return published[i].Date.After(published[j].Date)
+	})
+	return published, nil
+}
@@ -0,0 +1,221 @@
+package content
+
+import (
+	"errors"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/russross/blackfriday"
+	"html"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Struct representing a content page, split into front matter and markdown body
+type Page struct {
+	Meta FrontMatter
+	Body string
+}
+
+// Returns true if the page is a draft, hidden from the public site
+func (p Page) IsDraft() bool {
+	return p.Meta.Bool("draft")
+}
+
+// Returns true if the page is dated after the given time by its "date" front
+// matter key, scheduled to show on the public site from that date on
+func (p Page) IsScheduled(now time.Time) bool {
+	d, err := ParseDate(p.Meta.Get("date"))
+	return err == nil && d.After(now)
+}
+
+// Returns the robots directives of the page, set by its "noindex" and
+// "nofollow" front matter flags, e.g. "noindex, nofollow", empty when none
+func (p Page) Robots() string {
+	var directives []string
+	for _, flag := range []string{"noindex", "nofollow"} {
+		if p.Meta.Bool(flag) {
+			directives = append(directives, flag)
+		}
+	}
+	return strings.Join(directives, ", ")
+}
+
+// Returns true if the page is kept out of search engines by its "noindex"
+// front matter flag, and so out of the sitemap and the feeds
+func (p Page) IsNoIndex() bool {
+	return p.Meta.Bool("noindex")
+}
+
+// Returns true if the page is only shown to visitors knowing the password set
+// by its "password" front matter key
+func (p Page) IsProtected() bool {
+	return len(p.Meta.Get("password")) > 0
+}
+
+/**
+ * Returns a copy of the page without its password, to show it to the public
+ */
+func HidePassword(p Page) Page {
+	meta := make(FrontMatter)
+	for key, value := range p.Meta {
+		if key != "password" {
+			meta[key] = value
+		}
+	}
+	return Page{Meta: meta, Body: p.Body}
+}
+
+/**
+ * Returns the public form of a password protected page, which keeps its
+ * title only. Other pages are returned as they are
+ */
+func MaskProtected(p Page) Page {
+	if !p.IsProtected() {
+		return p
+	}
+	masked := HidePassword(p)
+	masked.Body = "# " + GetPageTitle(p) + "\n\n*This article is protected by a password.*\n"
+	return masked
+}
+
+/**
+ * Returns the content of a page. Pages outside any section, like the homepage,
+ * are read with an empty section
+ */
+func GetPage(section string, page string, conf *config.Config) (Page, error) {
+	pageContent, err := GetContentStore(conf).ReadFile(GetArticleName(section, page))
+	if err != nil {
+		return Page{}, err
+	}
+	meta, body := ParseFrontMatter(string(pageContent))
+	return Page{Meta: meta, Body: body}, nil
+}
+
+/**
+ * Returns the summary of a markdown body, made of its first lines
+ */
+func GetSummary(body string) string {
+	return getFirstLines(body, 3)
+}
+
+/**
+ * Returns the summary of a markdown body in the listings of a section, as
+ * long as set in the Sections config entry
+ */
+func GetSectionSummary(section string, body string, conf *config.Config) string {
+	if lines := conf.Sections[section].SummaryLines; lines > 0 {
+		return getFirstLines(body, lines)
+	}
+	return GetSummary(body)
+}
+
+/**
+ * Returns the first lines of a text
+ */
+func getFirstLines(text string, count int) string {
+	lines := strings.SplitN(text, "\n", count+1)
+	if len(lines) > count {
+		lines = lines[0:count]
+	}
+	return strings.Join(lines, "\n")
+}
+
+/**
+ * Returns the description of a page, taken from the front matter or from the
+ * plain text of its summary, cut to a length suited for meta tags
+ */
+func GetPageDescription(p Page) string {
+	if description := p.Meta.Get("description"); len(description) > 0 {
+		return description
+	}
+	var text []string
+	for _, line := range strings.Split(GetSummary(p.Body), "\n") {
+		// Headings usually repeat the title, so they are left out
+		if !strings.HasPrefix(line, "#") {
+			text = append(text, line)
+		}
+	}
+	rendered := string(blackfriday.MarkdownCommon([]byte(strings.Join(text, "\n"))))
+	plain := strings.Join(strings.Fields(html.UnescapeString(
+		regexp.MustCompile("<[^>]*>").ReplaceAllString(rendered, " "))), " ")
+	// Descriptions are cut to 160 characters, at a space when there is one
+	if runes := []rune(plain); len(runes) > 160 {
+		head := string(runes[:160])
+		if cut := strings.LastIndex(head, " "); cut >= 0 {
+			head = head[:cut]
+		}
+		plain = head + "..."
+	}
+	return plain
+}
+
+/**
+ * Returns the title of a page, taken from the front matter or from the first
+ * markdown heading
+ */
+func GetPageTitle(p Page) string {
+	if title := p.Meta.Get("title"); len(title) > 0 {
+		return title
+	}
+	for _, line := range strings.Split(p.Body, "\n") {
+		if strings.HasPrefix(line, "#") {
+			return strings.TrimSpace(strings.TrimLeft(line, "#"))
+		}
+	}
+	return ""
+}
+
+/**
+ * Returns the content of a page that is visible to the public, failing for
+ * drafts and scheduled articles outside of the preview environment
+ */
+func GetPublishedPage(section string, page string, conf *config.Config) (Page, error) {
+	p, err := GetPage(section, page, conf)
+	if err != nil {
+		return p, err
+	}
+	if (p.IsDraft() || p.IsScheduled(time.Now())) && !conf.Drafts {
+		return Page{}, os.ErrNotExist
+	}
+	return RunContentLoaded(section, page, p), nil
+}
+
+// Layouts tried, in order, when a date filter receives a string
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+/**
+ * Parses a date written in one of the supported layouts
+ */
+func ParseDate(value string) (time.Time, error) {
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, strings.TrimSpace(value)); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.New("Unknown date format: " + value)
+}
+
+/**
+ * Returns the name of the markdown file of an article within the content
+ * store. Pages outside any section have an empty section
+ */
+func GetArticleName(section string, page string) string {
+	if len(section) == 0 {
+		return page + ".md"
+	}
+	return section + "/" + page + ".md"
+}
+
+/**
+ * Writes an article to the content folder
+ */
+func SavePage(section string, page string, p Page, conf *config.Config) error {
+	return GetContentStore(conf).WriteFile(GetArticleName(section, page),
+		[]byte(p.Meta.String()+p.Body))
+}
@@ -0,0 +1,18 @@
+package content
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestPageDescriptionCutsCharacters(t *testing.T) {
+	description := GetPageDescription(Page{Body: strings.Repeat("日本語の文章", 40)})
+	if !utf8.ValidString(description) || utf8.RuneCountInString(description) != 163 {
+		t.Errorf("description %q is not cut to 160 characters", description)
+	}
+	description = GetPageDescription(Page{Body: strings.Repeat("word ", 40)})
+	if !strings.HasSuffix(description, "word...") || len(description) > 163 {
+		t.Errorf("description %q is not cut at a space", description)
+	}
+}
@@ -0,0 +1,38 @@
+package content
+
+import (
+	"sync"
+)
+
+// Number of goroutines reading and summarizing articles at once
+const workers = 8
+
+/**
+ * Calls fn for every index below count from a bounded pool of goroutines,
+ * returning once all the calls are done. Calls must only write to their own
+ * index of shared slices
+ */
+func parallel(count int, fn func(i int)) {
+	if count <= 1 {
+		for i := 0; i < count; i++ {
+			fn(i)
+		}
+		return
+	}
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < count; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < count; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
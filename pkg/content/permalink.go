@@ -0,0 +1,252 @@
+package content
+
+import (
+	"errors"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Default permalink of the articles, the section folder followed by the
+// article name
+const DefaultPermalink = "/:section/:slug"
+
+// Patterns matched by the placeholders of a permalink
+var permalinkTokens = map[string]string{
+	":section": `[\pL\pN_-]+`,
+	":slug":    `\pL[\pL\pN-]*`,
+	":year":    "[0-9]{4}",
+	":month":   "[0-9]{2}",
+	":day":     "[0-9]{2}",
+}
+
+/**
+ * Returns the path the links of the site start with, /preview in the preview
+ * environment and empty otherwise
+ */
+func GetLinkPrefix(conf *config.Config) string {
+	if conf.Drafts {
+		return "/preview"
+	}
+	return ""
+}
+
+/**
+ * Returns the PathPrefix config entry the whole site is served under, e.g.
+ * /blog, without a trailing slash. Empty for sites served at the root
+ */
+func GetPathPrefix(conf *config.Config) string {
+	prefix := strings.Trim(conf.PathPrefix, "/")
+	if len(prefix) == 0 {
+		return ""
+	}
+	return "/" + prefix
+}
+
+/**
+ * Returns the absolute URL of the site from the BaseURL site config entry,
+ * without a trailing slash, ending with the PathPrefix whether BaseURL holds
+ * it or not. Empty when no BaseURL is set
+ */
+func GetSiteURL(conf *config.Config) string {
+	root := strings.TrimSuffix(conf.Site.BaseURL, "/")
+	if len(root) == 0 {
+		return ""
+	}
+	if prefix := GetPathPrefix(conf); !strings.HasSuffix(root, prefix) {
+		root += prefix
+	}
+	return root
+}
+
+/**
+ * Returns the permalink pattern of the site
+ */
+func getPermalink(conf *config.Config) string {
+	if len(conf.Permalink) == 0 {
+		return DefaultPermalink
+	}
+	return conf.Permalink
+}
+
+/**
+ * Returns the segments of a slash separated path
+ */
+func getSegments(link string) []string {
+	return strings.Split(strings.Trim(link, "/"), "/")
+}
+
+/**
+ * Returns whether the permalink pattern of the site depends on the date of
+ * the articles
+ */
+func permalinkHasDate(conf *config.Config) bool {
+	for _, segment := range getSegments(getPermalink(conf)) {
+		if segment == ":year" || segment == ":month" || segment == ":day" {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Checks the permalink pattern of the site. Placeholders take whole path
+ * segments, and the article name must be part of the pattern
+ */
+func CheckPermalink(conf *config.Config) error {
+	pattern := getPermalink(conf)
+	if !strings.HasPrefix(pattern, "/") {
+		return errors.New("Permalink must start with a slash")
+	}
+	slug := false
+	for _, segment := range getSegments(pattern) {
+		if _, ok := permalinkTokens[segment]; ok {
+			slug = slug || segment == ":slug"
+		} else if len(segment) == 0 || strings.Contains(segment, ":") {
+			return errors.New("Invalid permalink segment \"" + segment + "\"")
+		}
+	}
+	if !slug {
+		return errors.New("Permalink must contain :slug")
+	}
+	return nil
+}
+
+/**
+ * Returns the route regexp matching the permalinks of the site, capturing
+ * the whole path
+ */
+func GetPermalinkRoute(conf *config.Config) string {
+	var route []string
+	for _, segment := range getSegments(getPermalink(conf)) {
+		if pattern, ok := permalinkTokens[segment]; ok {
+			route = append(route, pattern)
+		} else {
+			route = append(route, regexp.QuoteMeta(segment))
+		}
+	}
+	return "(/" + strings.Join(route, "/") + ")"
+}
+
+/**
+ * Returns the date an article is published at, taken from the front matter or
+ * from the modification time of its file
+ */
+func GetArticleDate(p Page, modified time.Time) time.Time {
+	if d, err := ParseDate(p.Meta.Get("date")); err == nil {
+		return d
+	}
+	return modified
+}
+
+/**
+ * Returns the time an article was last updated, taken from the "updated"
+ * front matter key, distinct from its publication date. Articles never
+ * updated return the zero time
+ */
+func GetArticleUpdated(p Page) time.Time {
+	if d, err := ParseDate(p.Meta.Get("updated")); err == nil {
+		return d
+	}
+	return time.Time{}
+}
+
+/**
+ * Returns the permalink of an article published at the given date
+ */
+func GetPermalink(section string, slug string, date time.Time, conf *config.Config) string {
+	replacer := strings.NewReplacer(":section", GetSectionSlug(section), ":slug", slug,
+		":year", date.Format("2006"), ":month", date.Format("01"), ":day", date.Format("02"))
+	var link []string
+	for _, segment := range getSegments(getPermalink(conf)) {
+		link = append(link, replacer.Replace(segment))
+	}
+	return GetLinkPrefix(conf) + "/" + strings.Join(link, "/")
+}
+
+/**
+ * Returns the permalink of an article, reading its date from the content
+ * store when the permalink pattern needs it
+ */
+func GetArticleLink(section string, slug string, conf *config.Config) string {
+	var date time.Time
+	if permalinkHasDate(conf) {
+		if fi, err := GetContentStore(conf).Stat(GetArticleName(section, slug)); err == nil {
+			date = fi.ModTime()
+		}
+		if p, err := GetPage(section, slug, conf); err == nil {
+			date = GetArticleDate(p, date)
+		}
+	}
+	return GetPermalink(section, slug, date, conf)
+}
+
+/**
+ * Returns the sections holding a published article with the given name
+ */
+func findArticleSections(slug string, conf *config.Config) []string {
+	var sections []string
+	if SiteIndex != nil {
+		published, _ := SiteIndex.Published()
+		for _, a := range published {
+			if a.Slug == slug {
+				sections = append(sections, a.Section)
+			}
+		}
+		return sections
+	}
+	names, _ := getSectionNames(conf)
+	for _, name := range names {
+		if _, err := GetPublishedPage(name, slug, conf); err == nil {
+			sections = append(sections, name)
+		}
+	}
+	return sections
+}
+
+/**
+ * Returns the section and name of the article a permalink points to. When the
+ * pattern leaves the section out, the article is looked up in every section.
+ * Links with an outdated date still resolve to their article, whose actual
+ * permalink can be compared to the path to redirect visitors
+ */
+func ResolvePermalink(link string, conf *config.Config) (string, string, error) {
+	pattern, segments := getSegments(getPermalink(conf)), getSegments(link)
+	if len(pattern) != len(segments) {
+		return "", "", os.ErrNotExist
+	}
+	var section, slug string
+	for i, segment := range pattern {
+		switch segment {
+		case ":section":
+			section = segments[i]
+		case ":slug":
+			slug = segments[i]
+		case ":year", ":month", ":day":
+		default:
+			if segment != segments[i] {
+				return "", "", os.ErrNotExist
+			}
+		}
+	}
+	sections := []string{FindSection(section, conf)}
+	if len(section) == 0 {
+		sections = findArticleSections(slug, conf)
+	}
+	var found []string
+	for _, s := range sections {
+		if _, err := GetPublishedPage(s, slug, conf); err != nil {
+			continue
+		}
+		if GetArticleLink(s, slug, conf) == GetLinkPrefix(conf)+"/"+strings.Join(segments, "/") {
+			return s, slug, nil
+		}
+		found = append(found, s)
+	}
+	if len(found) == 0 {
+		return "", "", os.ErrNotExist
+	}
+	return found[0], slug, nil
+}
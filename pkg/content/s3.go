@@ -0,0 +1,351 @@
+package content
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Content store backed by a bucket of Amazon S3 or of a compatible service,
+// like MinIO or Google Cloud Storage through its interoperability API.
+// Requests are signed with AWS signature version 4
+type S3Store struct {
+	Bucket    string
+	Region    string
+	Endpoint  string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+}
+
+// Struct representing an object of a bucket, named relative to the prefix of
+// the store. The ETag of objects uploaded in a single request is the hex
+// encoded MD5 checksum of their content
+type S3Object struct {
+	Name string
+	Size int64
+	ETag string
+}
+
+// Struct representing a page of a ListObjectsV2 response
+type s3ListResult struct {
+	Contents []struct {
+		Key          string
+		LastModified time.Time
+		Size         int64
+		ETag         string
+	}
+	CommonPrefixes []struct {
+		Prefix string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+/**
+ * Returns the S3 store described by the storage config. Credentials default to
+ * the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables
+ */
+func GetS3Store(conf config.StorageConfig) S3Store {
+	store := S3Store{Bucket: conf.Bucket, Region: conf.Region, Endpoint: conf.Endpoint,
+		Prefix: strings.Trim(conf.Prefix, "/"), AccessKey: conf.AccessKey, SecretKey: conf.SecretKey}
+	if len(store.Region) == 0 {
+		store.Region = "us-east-1"
+	}
+	if len(store.AccessKey) == 0 {
+		store.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if len(store.SecretKey) == 0 {
+		store.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+	return store
+}
+
+/**
+ * Returns the object key of a content file
+ */
+func (s S3Store) key(name string) string {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+	if len(s.Prefix) == 0 {
+		return name
+	}
+	if len(name) == 0 {
+		return s.Prefix
+	}
+	return s.Prefix + "/" + name
+}
+
+/**
+ * Escapes a string as required by the canonical requests of signature v4
+ */
+func s3Escape(s string, keepSlash bool) string {
+	var buf bytes.Buffer
+	for _, b := range []byte(s) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') ||
+			b == '-' || b == '_' || b == '.' || b == '~' || (keepSlash && b == '/') {
+			buf.WriteByte(b)
+		} else {
+			buf.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{b})))
+		}
+	}
+	return buf.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+/**
+ * Signs a request with AWS signature version 4, for a service of a region.
+ * The path and query of the request must already be escaped the canonical
+ * way, and headers set afterwards are left out of the signature
+ */
+func SignAwsRequest(req *http.Request, body []byte, service string, region string, accessKey string, secretKey string) {
+	now := time.Now().UTC()
+	amzDate, day := now.Format("20060102T150405Z"), now.Format("20060102")
+	sum := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(sum[:])
+	canonicalRequest := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery,
+		"host:" + req.URL.Host, "x-amz-content-sha256:" + payloadHash, "x-amz-date:" + amzDate, "",
+		"host;x-amz-content-sha256;x-amz-date", payloadHash}, "\n")
+	scope := day + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+	signingKey := hmacSHA256(hmacSHA256(hmacSHA256(hmacSHA256(
+		[]byte("AWS4"+secretKey), day), region), service), "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKey+"/"+scope+
+		", SignedHeaders=host;x-amz-content-sha256;x-amz-date, Signature="+signature)
+}
+
+/**
+ * Sends a signed request for an object key, or for the bucket when the key is
+ * empty, with extra headers. Buckets are addressed by virtual host on AWS, and
+ * by path on custom endpoints
+ */
+func (s S3Store) request(method string, key string, query url.Values, body []byte, header http.Header) (*http.Response, error) {
+	host, uri := s.Bucket+".s3."+s.Region+".amazonaws.com", "/"+key
+	scheme := "https"
+	if len(s.Endpoint) > 0 {
+		endpoint, err := url.Parse(s.Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		scheme, host, uri = endpoint.Scheme, endpoint.Host, "/"+s.Bucket+"/"+key
+	}
+	uri = s3Escape(uri, true)
+
+	var params []string
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, s3Escape(name, false)+"="+s3Escape(value, false))
+		}
+	}
+	sort.Strings(params)
+	canonicalQuery := strings.Join(params, "&")
+
+	target := scheme + "://" + host + uri
+	if len(canonicalQuery) > 0 {
+		target += "?" + canonicalQuery
+	}
+	req, err := http.NewRequest(method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	SignAwsRequest(req, body, "s3", s.Region, s.AccessKey, s.SecretKey)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	return http.DefaultClient.Do(req)
+}
+
+/**
+ * Returns an error for failed responses, os.ErrNotExist for missing objects
+ */
+func s3Error(resp *http.Response) error {
+	if resp.StatusCode == 404 {
+		return os.ErrNotExist
+	}
+	if resp.StatusCode >= 300 {
+		return errors.New("S3 request failed: " + resp.Status)
+	}
+	return nil
+}
+
+func (s S3Store) Open(name string) (fs.File, error) {
+	return openStoreFile(s, name)
+}
+
+func (s S3Store) ReadFile(name string) ([]byte, error) {
+	resp, err := s.request("GET", s.key(name), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if err = s3Error(resp); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+/**
+ * Lists the objects and common prefixes found under a folder. At most limit
+ * entries are returned when limit is positive
+ */
+func (s S3Store) list(name string, limit int) ([]os.FileInfo, error) {
+	var infos []os.FileInfo
+	prefix := s.key(name)
+	if len(prefix) > 0 {
+		prefix += "/"
+	}
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}, "delimiter": {"/"}}
+	if limit > 0 {
+		query.Set("max-keys", strconv.Itoa(limit))
+	}
+	for {
+		resp, err := s.request("GET", "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		if err = s3Error(resp); err == nil {
+			err = xml.NewDecoder(resp.Body).Decode(&result)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range result.CommonPrefixes {
+			infos = append(infos, storeFileInfo{name: path.Base(p.Prefix), dir: true})
+		}
+		for _, c := range result.Contents {
+			if c.Key != prefix {
+				infos = append(infos, storeFileInfo{name: path.Base(c.Key), size: c.Size, modTime: c.LastModified})
+			}
+		}
+		if !result.IsTruncated || limit > 0 {
+			return infos, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+func (s S3Store) ReadDir(name string) ([]os.FileInfo, error) {
+	infos, err := s.list(name, 0)
+	if err == nil && len(infos) == 0 && len(strings.Trim(name, "/")) > 0 {
+		return nil, os.ErrNotExist
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, err
+}
+
+func (s S3Store) Stat(name string) (os.FileInfo, error) {
+	if len(s.key(name)) == 0 {
+		return storeFileInfo{name: ".", dir: true}, nil
+	}
+	resp, err := s.request("HEAD", s.key(name), nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == 404 {
+		// Folders only exist as prefixes of object keys
+		if infos, err := s.list(name, 1); err == nil && len(infos) > 0 {
+			return storeFileInfo{name: path.Base(name), dir: true}, nil
+		}
+	}
+	if err = s3Error(resp); err != nil {
+		return nil, err
+	}
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return storeFileInfo{name: path.Base(name), size: resp.ContentLength, modTime: modTime}, nil
+}
+
+func (s S3Store) WriteFile(name string, data []byte) error {
+	resp, err := s.request("PUT", s.key(name), nil, data, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return s3Error(resp)
+}
+
+func (s S3Store) Remove(name string) error {
+	// Deleting a missing object succeeds on S3, unlike on a filesystem
+	if _, err := s.Stat(name); err != nil {
+		return err
+	}
+	resp, err := s.request("DELETE", s.key(name), nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return s3Error(resp)
+}
+
+/**
+ * Returns all the objects of the store, in every folder
+ */
+func (s S3Store) ListObjects() ([]S3Object, error) {
+	var objects []S3Object
+	prefix := s.key("")
+	if len(prefix) > 0 {
+		prefix += "/"
+	}
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		resp, err := s.request("GET", "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		var result s3ListResult
+		if err = s3Error(resp); err == nil {
+			err = xml.NewDecoder(resp.Body).Decode(&result)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range result.Contents {
+			if c.Key != prefix {
+				objects = append(objects, S3Object{Name: strings.TrimPrefix(c.Key, prefix),
+					Size: c.Size, ETag: strings.Trim(c.ETag, "\"")})
+			}
+		}
+		if !result.IsTruncated {
+			return objects, nil
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+}
+
+/**
+ * Writes a file with headers stored along with the object and sent back when
+ * it is served, like its Content-Type and Cache-Control
+ */
+func (s S3Store) PutObject(name string, data []byte, header http.Header) error {
+	resp, err := s.request("PUT", s.key(name), nil, data, header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return s3Error(resp)
+}
@@ -0,0 +1,575 @@
+package content
+
+import (
+	"errors"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/language"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Class representing a menu. Implements the sortable interface
+type Menu []*config.MenuItem
+
+// Return the length of the menu
+func (m Menu) Len() int {
+	return len(m)
+}
+
+// Comparison function used in sorting. Orders by weight, then alphabetically
+// by section slug and title
+func (m Menu) Less(i, j int) bool {
+	if m[i].Weight != m[j].Weight {
+		return m[i].Weight < m[j].Weight
+	}
+	if m[i].Section != m[j].Section {
+		return m[i].Section < m[j].Section
+	}
+	return m[i].Title < m[j].Title
+}
+
+// Function used for swapping menu items, used in sorting
+func (m Menu) Swap(i, j int) {
+	m[i], m[j] = m[j], m[i]
+}
+
+// Returns a copy of the menu item that matches the given section
+func (m Menu) GetCurrent(s string, conf *config.Config) config.MenuItem {
+	sort.Sort(m)
+	for i, item := range m {
+		if len(item.Section) == 0 || s != item.Section {
+			continue
+		}
+		return *m[i]
+	}
+	// Hidden sections are not part of the menu, so build an item on the fly
+	return config.MenuItem{Title: GetSectionTitle(s, conf), Section: s, Link: GetLinkPrefix(conf) + "/" + GetSectionSlug(s)}
+}
+
+// Struct representing an article of a section listing. The summary is the
+// markdown shown in the listing
+type ListingItem struct {
+	Slug, Title, Link string
+	Meta              FrontMatter
+	Summary           string
+	Date              time.Time
+}
+
+// Struct representing a page of a section listing
+type Listing struct {
+	Section            string
+	Prefix             string
+	Intro              string
+	ShowListing        bool
+	Items              []ListingItem
+	Page, Pages, Total int
+}
+
+// Returns the link to a page of the listing
+func (l Listing) GetPageLink(page int) string {
+	if page <= 1 {
+		return l.Prefix + "/" + GetSectionSlug(l.Section)
+	}
+	return l.Prefix + "/" + GetSectionSlug(l.Section) + "/" + strconv.Itoa(page)
+}
+
+// Error type representing a pagination error
+type PaginationError struct {
+	message string
+}
+
+// Error function for pagination, returns the error message
+func (e PaginationError) Error() string {
+	return e.message
+}
+
+// Sortable list of files in a folder
+type SortableFileList struct {
+	FileList []os.FileInfo
+}
+
+// Copies an external FileInfo list into the internal one
+func (l SortableFileList) setList(list []os.FileInfo) {
+	l.FileList = make([]os.FileInfo, len(list))
+	copy(l.FileList, list)
+}
+
+// Returns the sorted list of files
+func (l SortableFileList) getList() []os.FileInfo {
+	sort.Sort(l)
+	return l.FileList
+}
+
+// Comparison function used in sorting. Orders descending by modifictaion date
+func (l SortableFileList) Less(i, j int) bool {
+	return l.FileList[i].ModTime().After(l.FileList[j].ModTime())
+}
+
+// Function used for swapping menu items, used in sorting
+func (l SortableFileList) Swap(i, j int) {
+	l.FileList[i], l.FileList[j] = l.FileList[j], l.FileList[i]
+}
+
+// Returns the length of the list
+func (l SortableFileList) Len() int {
+	return len(l.FileList)
+}
+
+/**
+ * Returns the text with the first letter of every word in upper case,
+ * following the casing rules of the language of the site, e.g. "istanbul"
+ * becomes "İstanbul" in Turkish
+ */
+func TitleCase(s string, conf *config.Config) string {
+	tag, err := language.Parse(conf.Site.Language)
+	if err != nil {
+		tag = language.Und
+	}
+	return cases.Title(tag, cases.NoLower).String(s)
+}
+
+/**
+ * Returns the display title of a section: its entry in the SectionTitles
+ * config entry, or a title built from its folder name
+ */
+func GetSectionTitle(name string, conf *config.Config) string {
+	if title, ok := conf.SectionTitles[name]; ok {
+		return title
+	}
+	re := regexp.MustCompile("^[0-9]+-")
+	name = strings.TrimPrefix(name, "_")
+	return TitleCase(
+		strings.Replace(
+			strings.TrimPrefix(name, re.FindString(name)),
+			"-", " ", -1), conf)
+}
+
+/**
+ * Returns true if the section is routable but must not appear in the menu.
+ * Sections are hidden by prefixing the folder name with "_" or by listing
+ * them in the HiddenSections config entry
+ */
+func IsHiddenSection(name string, conf *config.Config) bool {
+	if strings.HasPrefix(name, "_") {
+		return true
+	}
+	for _, hidden := range conf.HiddenSections {
+		if hidden == name {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Returns the ordering of the articles of a section, "weight" or "date",
+ * which is the default: the Order of its entry in the Sections config entry,
+ * or else its entry in the SectionOrder config entry
+ */
+func GetSectionOrder(name string, conf *config.Config) string {
+	order := conf.Sections[name].Order
+	if len(order) == 0 {
+		order = conf.SectionOrder[name]
+	}
+	if order == "weight" {
+		return order
+	}
+	return "date"
+}
+
+/**
+ * Returns the number of articles on a page of the listing of a section: the
+ * ArticlesPerPage of its entry in the Sections config entry, or else the
+ * ArticlesPerPage config entry
+ */
+func GetArticlesPerPage(name string, conf *config.Config) int {
+	if perPage := conf.Sections[name].ArticlesPerPage; perPage > 0 {
+		return perPage
+	}
+	return conf.ArticlesPerPage
+}
+
+/**
+ * Orders listing items by the "weight" key of their front matter, lightest
+ * first. Items without a weight follow the weighted ones, and items of equal
+ * weight keep their order
+ */
+func sortByWeight(items []ListingItem) {
+	weight := func(item ListingItem) (int, bool) {
+		w, err := strconv.Atoi(strings.TrimSpace(item.Meta.Get("weight")))
+		return w, err == nil
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		wi, oki := weight(items[i])
+		wj, okj := weight(items[j])
+		if oki != okj {
+			return oki
+		}
+		return oki && wi < wj
+	})
+}
+
+/**
+ * Returns true if the homepage is defined by an index.md file placed directly
+ * in the content folder
+ */
+func HasHomePage(conf *config.Config) bool {
+	fi, err := GetContentStore(conf).Stat("index.md")
+	return err == nil && !fi.IsDir()
+}
+
+/**
+ * Returns the section listed on the homepage. The HomeSection config entry
+ * takes precedence, then content/index.md, in which case no section is
+ * returned. Otherwise the first section of the menu is used
+ */
+func GetHomeSection(menu Menu, conf *config.Config) string {
+	if len(conf.HomeSection) > 0 {
+		return conf.HomeSection
+	}
+	if HasHomePage(conf) {
+		return ""
+	}
+	for _, item := range menu {
+		if len(item.Section) > 0 {
+			return item.Section
+		}
+	}
+	return ""
+}
+
+/**
+ * Returns the names of the sections, from the content index when started,
+ * otherwise from the content store
+ */
+func getSectionNames(conf *config.Config) ([]string, error) {
+	if SiteIndex != nil {
+		return SiteIndex.Sections()
+	}
+	var names []string
+	fileInfos, err := GetContentStore(conf).ReadDir("")
+	if err != nil {
+		return names, err
+	}
+	for _, fi := range fileInfos {
+		if fi.IsDir() && !strings.HasPrefix(fi.Name(), ".") {
+			names = append(names, fi.Name())
+		}
+	}
+	return names, nil
+}
+
+/**
+ * Returns a slice with menu items
+ */
+func GetMenu(conf *config.Config) (Menu, error) {
+	var menu Menu
+	sections, err := getSectionNames(conf)
+	if err != nil {
+		return menu, err
+	}
+	var link string
+	for _, name := range sections {
+		if IsHiddenSection(name, conf) {
+			continue
+		}
+		link = GetLinkPrefix(conf) + "/" + GetSectionSlug(name)
+		menu = append(menu,
+			&config.MenuItem{Title: GetSectionTitle(name, conf),
+				Section: name,
+				Link:    link})
+	}
+	for _, entry := range conf.MenuEntries {
+		item := entry
+		item.Section = ""
+		item.External = strings.Contains(item.Link, "://")
+		menu = append(menu, &item)
+	}
+
+	sort.Sort(menu)
+	home := GetHomeSection(menu, conf)
+	for _, item := range menu {
+		if len(home) > 0 && item.Section == home {
+			item.Link = GetLinkPrefix(conf) + "/"
+		}
+	}
+
+	return menu, nil
+}
+
+/**
+ * Returns a page of the listing of a section: its introduction, read from the
+ * optional _index.md file, and the articles of the page, in the order of the
+ * section
+ */
+func GetListing(section string, pageNum int, conf *config.Config) (Listing, error) {
+	listing := Listing{Section: section, Page: pageNum, ShowListing: true, Prefix: GetLinkPrefix(conf)}
+	if listing.Page < 1 {
+		listing.Page = 1
+	}
+	articles, err := GetSectionArticles(section, conf)
+	if err != nil {
+		return listing, err
+	}
+
+	perPage := GetArticlesPerPage(section, conf)
+	listing.Total = len(articles)
+	listing.Pages = int(math.Ceil(float64(listing.Total) / float64(perPage)))
+	// The optional _index.md file introduces the section on its first page,
+	// and replaces the listing altogether when it sets "listing: false"
+	if intro, err := getIntro(section, conf); err == nil {
+		intro = RunContentLoaded(section, "_index", intro)
+		listing.ShowListing = !(intro.Meta.Has("listing") && !intro.Meta.Bool("listing"))
+		if listing.Page == 1 {
+			listing.Intro = intro.Body
+			if !listing.ShowListing || listing.Total == 0 {
+				return listing, nil
+			}
+		}
+	}
+	start := perPage * (listing.Page - 1)
+	end := start + perPage
+	if end > listing.Total {
+		end = listing.Total
+	}
+	if start >= listing.Total || !listing.ShowListing {
+		e := PaginationError{message: "No such page"}
+		return listing, e
+	}
+	for _, item := range articles[start:end] {
+		// A section holding a single article shows it in full
+		if listing.Total > 1 {
+			item.Summary = GetSectionSummary(section, item.Summary, conf)
+		}
+		listing.Items = append(listing.Items, item)
+	}
+	return listing, nil
+}
+
+/**
+ * Returns the introduction of a section, from the content index when started,
+ * otherwise from the content store
+ */
+func getIntro(section string, conf *config.Config) (Page, error) {
+	if SiteIndex != nil {
+		return SiteIndex.Intro(section)
+	}
+	return GetPage(section, "_index", conf)
+}
+
+/**
+ * Returns the published articles of a section, newest first or by weight
+ * following the SectionOrder config entry, with their whole body as summary.
+ * They come from the content index when started, otherwise from the content
+ * store
+ */
+func GetSectionArticles(section string, conf *config.Config) ([]ListingItem, error) {
+	var items []ListingItem
+	var err error
+	// The index leaves drafts and scheduled articles out, so previews read
+	// the content store
+	if SiteIndex != nil && !conf.Drafts {
+		items, err = SiteIndex.Articles(section)
+	} else {
+		items, err = scanSectionArticles(section, conf)
+	}
+	parallel(len(items), func(i int) {
+		p := MaskProtected(RunContentLoaded(section, items[i].Slug, Page{Meta: items[i].Meta, Body: items[i].Summary}))
+		items[i].Title, items[i].Meta, items[i].Summary = GetPageTitle(p), p.Meta, p.Body
+		items[i].Link = GetPermalink(section, items[i].Slug, items[i].Date, conf)
+	})
+	if GetSectionOrder(section, conf) == "weight" {
+		sortByWeight(items)
+	}
+	return items, err
+}
+
+/**
+ * Reads the published articles of a section from the content store, newest
+ * first, with their whole body as summary
+ */
+func scanSectionArticles(section string, conf *config.Config) ([]ListingItem, error) {
+	var items []ListingItem
+	fileInfos, err := GetContentStore(conf).ReadDir(section)
+	if err != nil {
+		return items, err
+	}
+	var files []os.FileInfo
+	for _, fi := range fileInfos {
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), ".md") && fi.Name() != "_index.md" {
+			files = append(files, fi)
+		}
+	}
+	read := make([]*Page, len(files))
+	parallel(len(files), func(i int) {
+		if p, err := GetPage(section, strings.TrimSuffix(files[i].Name(), ".md"), conf); err == nil {
+			read[i] = &p
+		}
+	})
+	var articles []os.FileInfo
+	pages := make(map[string]Page)
+	now := time.Now()
+	for i, fi := range files {
+		// Drafts and scheduled articles are left out before paginating, so
+		// pages stay full
+		if read[i] == nil || ((read[i].IsDraft() || read[i].IsScheduled(now)) && !conf.Drafts) {
+			continue
+		}
+		pages[fi.Name()] = *read[i]
+		articles = append(articles, fi)
+	}
+	sortedFiles := SortableFileList{FileList: articles}
+	for _, fi := range sortedFiles.getList() {
+		page, p := strings.TrimSuffix(fi.Name(), ".md"), pages[fi.Name()]
+		items = append(items, ListingItem{Slug: page, Title: GetPageTitle(p),
+			Meta: p.Meta, Summary: p.Body, Date: GetArticleDate(p, fi.ModTime())})
+	}
+	// Articles dated in their front matter or file name are ordered by date
+	sort.SliceStable(items, func(i, j int) bool { return items[i].Date.After(items[j].Date) })
+	return items, nil
+}
+
+/**
+ * Returns a string containing the abstracts of the articles on the page
+ */
+func GetAbstracts(section string, pageNum int, conf *config.Config) (string, error) {
+	listing, err := GetListing(section, pageNum, conf)
+	if err != nil {
+		return "", err
+	}
+	content := make([]string, 1)
+	if len(listing.Intro) > 0 {
+		content = append(content, listing.Intro)
+	}
+	for _, item := range listing.Items {
+		content = append(content, item.Summary)
+		if listing.Total > 1 {
+			content = append(content, "["+conf.ReadMoreText+"]("+item.Link+")")
+		}
+	}
+
+	if listing.Pages > 1 && len(listing.Items) > 0 {
+		pagination := make([]string, 1)
+		pagination = append(pagination, "<ul class=\"pagination\">")
+		for i := 1; i <= listing.Pages; i++ {
+			l := listing.GetPageLink(i)
+			if i != listing.Page {
+				pagination = append(
+					pagination,
+					"<li><a href=\""+l+"\">"+strconv.Itoa(i)+"</a></li>")
+			} else {
+				pagination = append(
+					pagination,
+					"<li class=\"active\"><a href=\""+l+"\">"+strconv.Itoa(i)+"</a></li>")
+			}
+		}
+		pagination = append(pagination, "</ul>")
+		content = append(content, strings.Join(pagination, " "))
+	}
+
+	return strings.Join(content, "\n\n"), nil
+}
+
+// Struct representing an article in the admin file browser
+type ArticleInfo struct {
+	Section, Slug, Title string
+	Draft                bool
+	Modified             time.Time
+}
+
+// Struct representing a section in the admin file browser
+type SectionInfo struct {
+	Name     string
+	Articles []ArticleInfo
+}
+
+/**
+ * Returns the sections of the content folder with all their articles,
+ * drafts included
+ */
+func GetSections(conf *config.Config) ([]SectionInfo, error) {
+	var sections []SectionInfo
+	store := GetContentStore(conf)
+	dirs, err := store.ReadDir("")
+	if err != nil {
+		return sections, err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() || strings.HasPrefix(dir.Name(), ".") {
+			continue
+		}
+		section := SectionInfo{Name: dir.Name()}
+		files, _ := store.ReadDir(dir.Name())
+		for _, fi := range files {
+			slug := strings.TrimSuffix(fi.Name(), ".md")
+			if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".md") || !SlugPattern.MatchString(slug) {
+				continue
+			}
+			p, err := GetPage(dir.Name(), slug, conf)
+			if err != nil {
+				continue
+			}
+			section.Articles = append(section.Articles, ArticleInfo{Section: dir.Name(),
+				Slug: slug, Title: GetPageTitle(p), Draft: p.IsDraft(), Modified: fi.ModTime()})
+		}
+		sort.Slice(section.Articles, func(i, j int) bool {
+			return section.Articles[i].Modified.After(section.Articles[j].Modified)
+		})
+		sections = append(sections, section)
+	}
+	return sections, nil
+}
+
+/**
+ * Returns the mistakes found in the markdown files of the content folder, by
+ * file name: front matter that cannot be read, and files ignored by the site
+ * since their name is not a valid slug
+ */
+func CheckArticles(conf *config.Config) (map[string]error, error) {
+	problems := make(map[string]error)
+	store := GetContentStore(conf)
+	check := func(name string) {
+		bs, err := store.ReadFile(name)
+		if err == nil {
+			err = CheckFrontMatter(string(bs))
+		}
+		if err != nil {
+			problems[name] = err
+		}
+	}
+	if HasHomePage(conf) {
+		check("index.md")
+	}
+	dirs, err := store.ReadDir("")
+	if err != nil {
+		return problems, err
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() || strings.HasPrefix(dir.Name(), ".") {
+			continue
+		}
+		files, err := store.ReadDir(dir.Name())
+		if err != nil {
+			problems[dir.Name()] = err
+			continue
+		}
+		for _, fi := range files {
+			if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".md") {
+				continue
+			}
+			name := dir.Name() + "/" + fi.Name()
+			if !SlugPattern.MatchString(strings.TrimSuffix(fi.Name(), ".md")) {
+				problems[name] = errors.New("File name is not a valid slug, the file is left out of the site")
+				continue
+			}
+			check(name)
+		}
+	}
+	return problems, nil
+}
@@ -0,0 +1,85 @@
+package content
+
+import (
+	"github.com/rredpoppy/gosite/pkg/config"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// Valid article slugs, as accepted by the page routes. Letters of any script
+// are allowed
+var SlugPattern = regexp.MustCompile(`^_?\pL[\pL\pN-]*$`)
+
+// Section names used as they are in links, for compatibility with the links
+// of existing sites
+var sectionLinkPattern = regexp.MustCompile("^[a-zA-Z0-9_-]+$")
+
+// Latin letters without a decomposed form, spelled out in ASCII
+var transliterations = strings.NewReplacer(
+	"ß", "ss", "æ", "ae", "Æ", "ae", "œ", "oe", "Œ", "oe", "ø", "o", "Ø", "o",
+	"đ", "d", "Đ", "d", "ð", "d", "Ð", "d", "ł", "l", "Ł", "l", "þ", "th", "Þ", "th",
+	"ı", "i", "ħ", "h", "Ħ", "h")
+
+/**
+ * Returns a lower case slug made of the letters and digits of the text,
+ * separated by dashes. Accented latin letters are spelled without their
+ * diacritics, e.g. "Știri și noutăți" becomes "stiri-si-noutati", while
+ * letters of other scripts are kept
+ */
+func Slugify(s string) string {
+	t := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	if plain, _, err := transform.String(t, transliterations.Replace(s)); err == nil {
+		s = plain
+	}
+	var slug strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && slug.Len() > 0 {
+				slug.WriteRune('-')
+			}
+			slug.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return slug.String()
+}
+
+/**
+ * Returns the name of a section in links. Folder names with spaces,
+ * punctuation or accented letters are turned into slugs
+ */
+func GetSectionSlug(name string) string {
+	if sectionLinkPattern.MatchString(name) {
+		return name
+	}
+	return Slugify(name)
+}
+
+/**
+ * Returns the section folder a link name points to, the name itself when no
+ * section matches
+ */
+func FindSection(link string, conf *config.Config) string {
+	names, err := getSectionNames(conf)
+	if err != nil || len(link) == 0 {
+		return link
+	}
+	for _, name := range names {
+		if name == link {
+			return name
+		}
+	}
+	for _, name := range names {
+		if GetSectionSlug(name) == link {
+			return name
+		}
+	}
+	return link
+}
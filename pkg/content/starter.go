@@ -0,0 +1,85 @@
+package content
+
+import (
+	"github.com/rredpoppy/gosite/pkg/config"
+	"os"
+	"testing/fstest"
+)
+
+// Starter site served by sites without a config file while their content and
+// template folders are missing, and written to disk by gosite init: a welcome
+// page, a blog section with a first article and a single page theme
+var Starter = fstest.MapFS{
+	"content/1-home/welcome.md": {Mode: 0644, Data: []byte(`---
+title: Welcome
+---
+# Welcome to gosite
+
+This site runs on the built-in defaults. Every folder of the *content* folder
+is a section of the menu, and every markdown file in it an article.
+
+Run ` + "`gosite init`" + ` to write a *config.json*, this content and the starter
+theme next to you, then edit them to make the site your own.
+`)},
+	"content/2-blog/hello-world.md": {Mode: 0644, Data: []byte(`---
+title: Hello world
+tags: gosite, markdown
+---
+# Hello world
+
+This is the first article of the blog. Articles are written in **markdown**,
+with an optional front matter block for their title, date, tags and author.
+
+- Add articles by dropping markdown files in *content/2-blog*
+- Add sections by creating folders in *content*
+- Change the layout in *template/template.html*
+`)},
+	"template/template.html": {Mode: 0644, Data: []byte(`<!DOCTYPE html>
+<html lang="{{ site.Language }}">
+  <head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta name="description" content="{% if description %}{{ description }}{% else %}{{ site.Description }}{% endif %}">
+    {% if robots %}<meta name="robots" content="{{ robots }}">{% endif %}
+    {% if canonical %}<link rel="canonical" href="{{ canonical }}">{% endif %}
+    {% if feedLinks %}{{ feedLinks | unsafe }}{% endif %}
+    <title>{{ site.Title }} - {{ currentMenu.Title }}</title>
+    <style>
+      body { max-width: 42em; margin: 0 auto; padding: 1em; font: 18px/1.6 sans-serif; color: #222; }
+      nav a { margin-right: 1em; }
+      nav a.active { font-weight: bold; }
+      a { color: #1d5fa8; }
+      pre { overflow: auto; background: #f4f4f4; padding: 1em; }
+      footer { margin-top: 3em; color: #777; font-size: 0.8em; }
+    </style>
+  </head>
+  <body>
+    <header>
+      <h1>{{ site.Title }}</h1>
+      <nav>
+        {% for m in menu %}<a href="{{ m.Link }}"{% if currentMenu == m %} class="active"{% endif %}>{{ m.Title }}</a>{% endfor %}
+      </nav>
+    </header>
+    <main>
+      {{ content | unsafe }}
+    </main>
+    <footer>Powered by gosite</footer>
+    {% if analytics %}{{ analytics | unsafe }}{% endif %}
+  </body>
+</html>
+`)},
+}
+
+/**
+ * Returns the store of a folder of the starter site when the site runs on the
+ * default configuration and the folder is missing from disk
+ */
+func getStarterStore(conf *config.Config, folder string, root string) (Store, bool) {
+	if !conf.Quickstart || config.Embedded != nil {
+		return nil, false
+	}
+	if _, err := os.Stat(folder); !os.IsNotExist(err) {
+		return nil, false
+	}
+	return EmbedStore{FS: Starter, Root: root}, true
+}
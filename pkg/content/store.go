@@ -0,0 +1,477 @@
+package content
+
+import (
+	"errors"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Interface implemented by the storages able to hold the content of a site.
+// Names are slash separated paths relative to the root of the content, the
+// empty name being the root itself. Stores are io/fs file systems as well, so
+// they can be handed to fs.WalkDir, html/template or http.FS, and any fs.FS
+// can back a store through EmbedStore
+type Store interface {
+	fs.FS
+	// Returns the content of a file
+	ReadFile(name string) ([]byte, error)
+	// Returns the files and folders found in a folder
+	ReadDir(name string) ([]os.FileInfo, error)
+	// Returns the description of a file or folder
+	Stat(name string) (os.FileInfo, error)
+	// Creates or replaces a file
+	WriteFile(name string, data []byte) error
+	// Deletes a file
+	Remove(name string) error
+}
+
+// Function opening the store of a Storage config entry
+type StoreFactory func(conf config.StorageConfig) Store
+
+// Stores available to the Storage config entry, by provider name
+var storeProviders = map[string]StoreFactory{
+	"s3": func(conf config.StorageConfig) Store { return GetS3Store(conf) },
+}
+
+// Opens the store holding the files the site writes itself, like comments and
+// the views log, rooted at a folder. Programs embedding gosite can replace it
+// to keep these files elsewhere, in memory for tests for instance
+var OpenDataStore = func(folder string) Store {
+	return FileStore{Folder: folder}
+}
+
+// Interface implemented by the stores able to append to a file without
+// rewriting it
+type Appender interface {
+	AppendFile(name string, data []byte) error
+}
+
+// Content store backed by a folder of the local filesystem, the default one
+type FileStore struct {
+	Folder string
+}
+
+// Struct describing a file or folder of a content store without a filesystem
+type storeFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+}
+
+func (fi storeFileInfo) Name() string       { return fi.name }
+func (fi storeFileInfo) Size() int64        { return fi.size }
+func (fi storeFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi storeFileInfo) IsDir() bool        { return fi.dir }
+func (fi storeFileInfo) Sys() interface{}   { return nil }
+
+func (fi storeFileInfo) Mode() os.FileMode {
+	if fi.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+/**
+ * Returns the local path of a content file. Names cannot point outside of the
+ * content folder
+ */
+func (s FileStore) path(name string) string {
+	return filepath.Join(s.Folder, filepath.FromSlash(path.Clean("/"+name)))
+}
+
+func (s FileStore) Open(name string) (fs.File, error) {
+	return os.DirFS(s.Folder).Open(name)
+}
+
+func (s FileStore) ReadFile(name string) ([]byte, error) {
+	return ioutil.ReadFile(s.path(name))
+}
+
+func (s FileStore) ReadDir(name string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(s.path(name))
+}
+
+func (s FileStore) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(s.path(name))
+}
+
+// Writes a file, creating its folder if needed
+func (s FileStore) WriteFile(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(s.path(name)), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(s.path(name), data, 0644)
+}
+
+// Appends to a file, creating it with owner-only permissions if needed, as
+// appended files tend to be logs and lists of subscribers
+func (s FileStore) AppendFile(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(s.path(name)), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(s.path(name), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	return err
+}
+
+func (s FileStore) Remove(name string) error {
+	return os.Remove(s.path(name))
+}
+
+// Read-only content store backed by a folder of a file system, like the files
+// embedded in the binary or an fstest.MapFS
+type EmbedStore struct {
+	FS   fs.FS
+	Root string
+}
+
+/**
+ * Returns the path of a file within the file system
+ */
+func (s EmbedStore) path(name string) string {
+	p := path.Join(s.Root, strings.TrimPrefix(path.Clean("/"+name), "/"))
+	if len(p) == 0 {
+		return "."
+	}
+	return p
+}
+
+func (s EmbedStore) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	return s.FS.Open(s.path(name))
+}
+
+func (s EmbedStore) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(s.FS, s.path(name))
+}
+
+func (s EmbedStore) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := fs.ReadDir(s.FS, s.path(name))
+	if err != nil {
+		return nil, err
+	}
+	var infos []os.FileInfo
+	for _, entry := range entries {
+		if fi, err := entry.Info(); err == nil {
+			infos = append(infos, fi)
+		}
+	}
+	return infos, nil
+}
+
+func (s EmbedStore) Stat(name string) (os.FileInfo, error) {
+	return fs.Stat(s.FS, s.path(name))
+}
+
+func (s EmbedStore) WriteFile(name string, data []byte) error {
+	return errors.New("Embedded content is read-only")
+}
+
+func (s EmbedStore) Remove(name string) error {
+	return errors.New("Embedded content is read-only")
+}
+
+// Content store stacking other stores: files are read from the first store
+// holding them and folders list the files of all the stores. Files are
+// written to and removed from the first store holding them, new files and
+// files of read-only embedded stores go to the first store
+type OverlayStore struct {
+	Stores []Store
+}
+
+func (s OverlayStore) Open(name string) (fs.File, error) {
+	return openStoreFile(s, name)
+}
+
+func (s OverlayStore) ReadFile(name string) ([]byte, error) {
+	for _, store := range s.Stores {
+		if bs, err := store.ReadFile(name); err == nil {
+			return bs, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+func (s OverlayStore) ReadDir(name string) ([]os.FileInfo, error) {
+	var infos []os.FileInfo
+	seen := make(map[string]bool)
+	found := false
+	for _, store := range s.Stores {
+		entries, err := store.ReadDir(name)
+		if err != nil {
+			continue
+		}
+		found = true
+		for _, fi := range entries {
+			if !seen[fi.Name()] {
+				seen[fi.Name()] = true
+				infos = append(infos, fi)
+			}
+		}
+	}
+	if !found {
+		return nil, os.ErrNotExist
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+func (s OverlayStore) Stat(name string) (os.FileInfo, error) {
+	for _, store := range s.Stores {
+		if fi, err := store.Stat(name); err == nil {
+			return fi, nil
+		}
+	}
+	return nil, os.ErrNotExist
+}
+
+/**
+ * Returns the store a file is written to or removed from
+ */
+func (s OverlayStore) getWritableStore(name string) Store {
+	for _, store := range s.Stores {
+		if _, err := store.Stat(name); err == nil {
+			if _, ok := store.(EmbedStore); !ok {
+				return store
+			}
+			break
+		}
+	}
+	return s.Stores[0]
+}
+
+func (s OverlayStore) WriteFile(name string, data []byte) error {
+	return s.getWritableStore(name).WriteFile(name, data)
+}
+
+func (s OverlayStore) Remove(name string) error {
+	return s.getWritableStore(name).Remove(name)
+}
+
+// Content store hiding the files and folders of another store whose name
+// matches one of the glob Patterns, like the working files kept next to the
+// content. Patterns holding a slash are matched against the whole name of a
+// file, others against every part of it, so the files of an ignored folder are
+// ignored too. Hidden files may still be written
+type IgnoreStore struct {
+	Store    Store
+	Patterns []string
+}
+
+/**
+ * Returns true if a file is hidden by one of the patterns
+ */
+func (s IgnoreStore) ignored(name string) bool {
+	name = strings.Trim(path.Clean("/"+name), "/")
+	if len(name) == 0 {
+		return false
+	}
+	parts := strings.Split(name, "/")
+	for _, pattern := range s.Patterns {
+		if strings.Contains(pattern, "/") {
+			if ok, _ := path.Match(strings.Trim(pattern, "/"), name); ok {
+				return true
+			}
+			continue
+		}
+		for _, part := range parts {
+			if ok, _ := path.Match(pattern, part); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s IgnoreStore) Open(name string) (fs.File, error) {
+	return openStoreFile(s, name)
+}
+
+func (s IgnoreStore) ReadFile(name string) ([]byte, error) {
+	if s.ignored(name) {
+		return nil, os.ErrNotExist
+	}
+	return s.Store.ReadFile(name)
+}
+
+func (s IgnoreStore) ReadDir(name string) ([]os.FileInfo, error) {
+	if s.ignored(name) {
+		return nil, os.ErrNotExist
+	}
+	entries, err := s.Store.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	var infos []os.FileInfo
+	for _, fi := range entries {
+		if !s.ignored(path.Join(name, fi.Name())) {
+			infos = append(infos, fi)
+		}
+	}
+	return infos, nil
+}
+
+func (s IgnoreStore) Stat(name string) (os.FileInfo, error) {
+	if s.ignored(name) {
+		return nil, os.ErrNotExist
+	}
+	return s.Store.Stat(name)
+}
+
+func (s IgnoreStore) WriteFile(name string, data []byte) error {
+	return s.Store.WriteFile(name, data)
+}
+
+func (s IgnoreStore) AppendFile(name string, data []byte) error {
+	return AppendFile(s.Store, name, data)
+}
+
+func (s IgnoreStore) Remove(name string) error {
+	return s.Store.Remove(name)
+}
+
+/**
+ * Registers a store under the given provider name, so it can be selected by
+ * the Storage config entry. Stores must be registered before the server starts
+ */
+func RegisterStore(provider string, factory StoreFactory) {
+	storeProviders[provider] = factory
+}
+
+/**
+ * Appends data to a file of a store, creating the file if needed. Stores
+ * unable to append have the whole file rewritten
+ */
+func AppendFile(s Store, name string, data []byte) error {
+	if a, ok := s.(Appender); ok {
+		return a.AppendFile(name, data)
+	}
+	bs, err := s.ReadFile(name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return s.WriteFile(name, append(bs, data...))
+}
+
+/**
+ * Returns the store of a site folder: the folder embedded in the binary when
+ * there is one, overlaid by the folder on disk with -prefer-disk, or the folder
+ * on disk alone
+ */
+func GetSiteStore(folder string, embedded string) Store {
+	disk := FileStore{Folder: folder}
+	if config.Embedded == nil {
+		return disk
+	}
+	embed := EmbedStore{FS: config.Embedded, Root: embedded}
+	if config.PreferDisk {
+		return OverlayStore{Stores: []Store{disk, embed}}
+	}
+	return embed
+}
+
+/**
+ * Returns the content store selected by the Storage config entry, either the
+ * content folder (the default), an S3 compatible bucket or a store added with
+ * RegisterStore. Several ContentFolders are stacked in an OverlayStore, and
+ * sites running on the defaults without a content folder get the starter
+ * content. Files matching the Ignore config entry are hidden, and articles
+ * named after their date are served under the rest of their name
+ */
+func GetContentStore(conf *config.Config) Store {
+	store := getContentStore(conf)
+	if len(conf.Ignore) > 0 {
+		store = IgnoreStore{Store: store, Patterns: conf.Ignore}
+	}
+	return DatePrefixStore{Store: store}
+}
+
+/**
+ * Returns the content store of the site, as its files are named and without
+ * the ignored files hidden
+ */
+func getContentStore(conf *config.Config) Store {
+	if factory, ok := storeProviders[conf.Storage.Provider]; ok {
+		return factory(conf.Storage)
+	}
+	if store, ok := getStarterStore(conf, conf.ContentFolder, "content"); ok {
+		return store
+	}
+	// Binaries embedding the site only serve their embedded content unless
+	// run with -prefer-disk
+	folders := GetContentFolders(conf)
+	if len(folders) > 1 && (config.Embedded == nil || config.PreferDisk) {
+		var stores []Store
+		for _, folder := range folders {
+			stores = append(stores, FileStore{Folder: folder})
+		}
+		if config.Embedded != nil {
+			stores = append(stores, EmbedStore{FS: config.Embedded, Root: "content"})
+		}
+		return OverlayStore{Stores: stores}
+	}
+	return GetSiteStore(folders[0], "content")
+}
+
+/**
+ * Returns the content folders of the site: the ContentFolders config entry,
+ * merged into one site with the first folders taking precedence, or the
+ * ContentFolder alone
+ */
+func GetContentFolders(conf *config.Config) []string {
+	if len(conf.ContentFolders) > 0 {
+		return conf.ContentFolders
+	}
+	return []string{conf.ContentFolder}
+}
+
+/**
+ * Returns the content folder holding a file, named relative to the content,
+ * or the folder new files are written to when no folder holds it. Empty when
+ * the content is not kept in folders of the local filesystem
+ */
+func GetContentFolder(conf *config.Config, name string) string {
+	switch s := getContentStore(conf).(type) {
+	case FileStore:
+		return s.Folder
+	case OverlayStore:
+		var folders []string
+		for _, store := range s.Stores {
+			fs, ok := store.(FileStore)
+			if !ok {
+				return ""
+			}
+			if _, err := fs.Stat(name); err == nil {
+				return fs.Folder
+			}
+			folders = append(folders, fs.Folder)
+		}
+		return folders[0]
+	}
+	return ""
+}
+
+/**
+ * Returns the store holding the templates of the theme, the starter theme
+ * for sites running on the defaults without a template folder
+ */
+func GetTemplateStore(conf *config.Config) Store {
+	if store, ok := getStarterStore(conf, conf.TemplateFolder, "template"); ok {
+		return store
+	}
+	return GetSiteStore(conf.TemplateFolder, "template")
+}
@@ -0,0 +1,120 @@
+package deploy
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/export"
+	"io/fs"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Struct representing the outcome of a deployment: the files uploaded and
+// deleted, named relative to the export folder
+type Result struct {
+	Uploaded, Deleted []string
+}
+
+// Function publishing the static export of a folder
+type Target func(out string, conf *config.Config) (Result, error)
+
+// Targets of gosite deploy, by name
+var targets = map[string]Target{
+	"s3":    S3,
+	"rsync": Rsync,
+	"sftp":  Sftp,
+}
+
+/**
+ * Registers a deploy target under the given name, so gosite deploy can
+ * publish to it
+ */
+func RegisterTarget(name string, target Target) {
+	targets[name] = target
+}
+
+/**
+ * Publishes the static export of a folder to the named target
+ */
+func Run(name string, out string, conf *config.Config) (Result, error) {
+	target, ok := targets[name]
+	if !ok {
+		return Result{}, errors.New("Unknown deploy target " + name)
+	}
+	return target(out, conf)
+}
+
+// Struct representing a file of a static export, named with slashes relative
+// to the export folder, with the hex encoded MD5 checksum of its content
+type localFile struct {
+	Name, File, Sum string
+}
+
+/**
+ * Returns the files of a static export, but for the manifest of the export
+ */
+func getLocalFiles(out string) ([]localFile, error) {
+	var files []localFile
+	err := filepath.WalkDir(out, func(file string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		name, err := filepath.Rel(out, file)
+		if err != nil || name == export.ManifestFile {
+			return err
+		}
+		bs, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		sum := md5.Sum(bs)
+		files = append(files, localFile{Name: filepath.ToSlash(name), File: file, Sum: hex.EncodeToString(sum[:])})
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files, err
+}
+
+/**
+ * Returns the MIME type of a file of the export, from its extension or else
+ * its content
+ */
+func getContentType(name string, data []byte) string {
+	if t := mime.TypeByExtension(path.Ext(name)); len(t) > 0 {
+		return t
+	}
+	return http.DetectContentType(data)
+}
+
+/**
+ * Returns the Cache-Control header of a file of the export, following the
+ * CacheControl config entry: pages are kept as long as articles, feeds as
+ * long as feeds, the files listing the site as long as listings, and other
+ * files as long as static assets. The service worker is always checked for a
+ * new version
+ */
+func getCacheControl(name string, conf *config.Config) string {
+	seconds := conf.CacheControl.Static
+	switch {
+	case name == "sw.js":
+		return "no-cache"
+	case path.Ext(name) == ".html":
+		seconds = conf.CacheControl.Articles
+	case path.Base(name) == "feed.xml":
+		seconds = conf.CacheControl.Feeds
+	case (strings.HasPrefix(name, "sitemap") && path.Ext(name) == ".xml") || name == "search-index.json" || name == "manifest.webmanifest":
+		seconds = conf.CacheControl.Listings
+	}
+	if seconds <= 0 {
+		return ""
+	}
+	return "public, max-age=" + strconv.Itoa(seconds)
+}
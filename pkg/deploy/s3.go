@@ -0,0 +1,158 @@
+package deploy
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Most paths invalidated one by one; larger deployments invalidate the whole
+// distribution
+const maxInvalidationPaths = 100
+
+// Struct representing a CloudFront invalidation request
+type invalidationBatch struct {
+	XMLName         xml.Name `xml:"http://cloudfront.amazonaws.com/doc/2020-05-31/ InvalidationBatch"`
+	Quantity        int      `xml:"Paths>Quantity"`
+	Paths           []string `xml:"Paths>Items>Path"`
+	CallerReference string   `xml:"CallerReference"`
+}
+
+/**
+ * Returns the bucket of the Deploy.S3 config entry. Credentials default to
+ * the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables
+ */
+func getS3Bucket(conf *config.Config) content.S3Store {
+	s := conf.Deploy.S3
+	return content.GetS3Store(config.StorageConfig{Provider: "s3", Bucket: s.Bucket, Region: s.Region,
+		Endpoint: s.Endpoint, Prefix: s.Prefix, AccessKey: s.AccessKey, SecretKey: s.SecretKey})
+}
+
+/**
+ * Deploys the static export of a folder to the Deploy.S3 bucket. Only the
+ * files missing from the bucket or whose content changed are uploaded, with
+ * their Content-Type and the Cache-Control of the CacheControl config entry.
+ * With Deploy.S3.Delete, files gone from the export are deleted from the
+ * bucket, and with a Distribution, the changed files are invalidated in
+ * CloudFront
+ */
+func S3(out string, conf *config.Config) (Result, error) {
+	var result Result
+	if len(conf.Deploy.S3.Bucket) == 0 {
+		return result, errors.New("No bucket set in the Deploy.S3 config entry")
+	}
+	files, err := getLocalFiles(out)
+	if err != nil {
+		return result, err
+	}
+	bucket := getS3Bucket(conf)
+	objects, err := bucket.ListObjects()
+	if err != nil {
+		return result, err
+	}
+	remote := make(map[string]string)
+	for _, o := range objects {
+		remote[o.Name] = o.ETag
+	}
+	local := make(map[string]bool)
+	for _, f := range files {
+		local[f.Name] = true
+		if etag, ok := remote[f.Name]; ok && etag == f.Sum {
+			continue
+		}
+		bs, err := ioutil.ReadFile(f.File)
+		if err != nil {
+			return result, err
+		}
+		header := http.Header{"Content-Type": {getContentType(f.Name, bs)}}
+		if cacheControl := getCacheControl(f.Name, conf); len(cacheControl) > 0 {
+			header.Set("Cache-Control", cacheControl)
+		}
+		if err = bucket.PutObject(f.Name, bs, header); err != nil {
+			return result, errors.New("Could not upload " + f.Name + ": " + err.Error())
+		}
+		result.Uploaded = append(result.Uploaded, f.Name)
+	}
+	if conf.Deploy.S3.Delete {
+		for _, o := range objects {
+			if local[o.Name] {
+				continue
+			}
+			if err = bucket.Remove(o.Name); err != nil {
+				return result, errors.New("Could not delete " + o.Name + ": " + err.Error())
+			}
+			result.Deleted = append(result.Deleted, o.Name)
+		}
+	}
+	if len(conf.Deploy.S3.Distribution) > 0 {
+		paths := getInvalidationPaths(append(append([]string{}, result.Uploaded...), result.Deleted...))
+		if err = invalidateCloudFront(conf.Deploy.S3.Distribution, paths, bucket); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+/**
+ * Returns the CloudFront paths to invalidate for changed files. Pages are
+ * also requested by the link of their folder, with and without a trailing
+ * slash
+ */
+func getInvalidationPaths(names []string) []string {
+	var paths []string
+	for _, name := range names {
+		link := (&url.URL{Path: "/" + name}).EscapedPath()
+		paths = append(paths, link)
+		if path.Base(name) == "index.html" {
+			folder := strings.TrimSuffix(link, "index.html")
+			paths = append(paths, folder)
+			if folder != "/" {
+				paths = append(paths, strings.TrimSuffix(folder, "/"))
+			}
+		}
+	}
+	if len(paths) > maxInvalidationPaths {
+		return []string{"/*"}
+	}
+	return paths
+}
+
+/**
+ * Asks CloudFront to drop paths of a distribution from its caches, signing
+ * the request with the credentials of the bucket
+ */
+func invalidateCloudFront(distribution string, paths []string, bucket content.S3Store) error {
+	if len(paths) == 0 {
+		return nil
+	}
+	body, err := xml.Marshal(invalidationBatch{Quantity: len(paths), Paths: paths,
+		CallerReference: "gosite-" + strconv.FormatInt(time.Now().UnixNano(), 10)})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", "https://cloudfront.amazonaws.com/2020-05-31/distribution/"+
+		url.PathEscape(distribution)+"/invalidation", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	content.SignAwsRequest(req, body, "cloudfront", "us-east-1", bucket.AccessKey, bucket.SecretKey)
+	req.Header.Set("Content-Type", "text/xml")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return errors.New("CloudFront invalidation failed: " + resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package deploy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/export"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Name of the file listing the checksums of the files deployed with SFTP,
+// kept in the folder of the web host
+const sftpManifestFile = ".gosite-deploy.json"
+
+/**
+ * Runs a command, returning its output, or its error output as the error
+ * when it fails
+ */
+func runCommand(stdin string, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); len(msg) > 0 {
+			return "", errors.New(name + ": " + msg)
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+/**
+ * Returns the SSH login of the Deploy.Ssh config entry, user@host or the host
+ * alone
+ */
+func getSshLogin(conf *config.Config) (string, error) {
+	if len(conf.Deploy.Ssh.Host) == 0 {
+		return "", errors.New("No host set in the Deploy.Ssh config entry")
+	}
+	if len(conf.Deploy.Ssh.User) == 0 {
+		return conf.Deploy.Ssh.Host, nil
+	}
+	return conf.Deploy.Ssh.User + "@" + conf.Deploy.Ssh.Host, nil
+}
+
+/**
+ * Returns the folder of the Deploy.Ssh web host the export is copied to,
+ * without a trailing slash. A folder must be set, so deployments never write
+ * to, or delete from, the home folder of the user
+ */
+func getSshPath(conf *config.Config) (string, error) {
+	root := strings.TrimSuffix(strings.TrimSpace(conf.Deploy.Ssh.Path), "/")
+	if len(root) == 0 {
+		return "", errors.New("No path set in the Deploy.Ssh config entry")
+	}
+	return root, nil
+}
+
+/**
+ * Returns the options of ssh and sftp selecting the port and the key of the
+ * Deploy.Ssh config entry. The port flag differs between the two commands
+ */
+func getSshOptions(portFlag string, conf *config.Config) []string {
+	var options []string
+	if conf.Deploy.Ssh.Port > 0 {
+		options = append(options, portFlag, strconv.Itoa(conf.Deploy.Ssh.Port))
+	}
+	if len(conf.Deploy.Ssh.Key) > 0 {
+		options = append(options, "-i", conf.Deploy.Ssh.Key)
+	}
+	return options
+}
+
+/**
+ * Deploys the static export of a folder to the Deploy.Ssh web host with
+ * rsync, which only transfers the changed files. With Deploy.Ssh.Delete,
+ * files gone from the export are deleted from the host
+ */
+func Rsync(out string, conf *config.Config) (Result, error) {
+	var result Result
+	login, err := getSshLogin(conf)
+	if err != nil {
+		return result, err
+	}
+	root, err := getSshPath(conf)
+	if err != nil {
+		return result, err
+	}
+	shell := "ssh"
+	for _, option := range getSshOptions("-p", conf) {
+		shell += " '" + strings.Replace(option, "'", "'\\''", -1) + "'"
+	}
+	args := []string{"-az", "-e", shell, "--exclude", "/" + export.ManifestFile, "--out-format", "%o %n"}
+	if conf.Deploy.Ssh.Delete {
+		args = append(args, "--delete")
+	}
+	args = append(args, strings.TrimSuffix(out, string(filepath.Separator))+string(filepath.Separator), login+":"+root+"/")
+	output, err := runCommand("", "rsync", args...)
+	if err != nil {
+		return result, err
+	}
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) < 2 || strings.HasSuffix(fields[1], "/") {
+			continue
+		}
+		switch fields[0] {
+		case "send":
+			result.Uploaded = append(result.Uploaded, fields[1])
+		case "del.":
+			result.Deleted = append(result.Deleted, fields[1])
+		}
+	}
+	return result, nil
+}
+
+/**
+ * Quotes an argument of an sftp batch command
+ */
+func sftpQuote(s string) string {
+	return "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(s) + "\""
+}
+
+/**
+ * Deploys the static export of a folder to the Deploy.Ssh web host with
+ * SFTP, for hosts without rsync. The checksums of the deployed files are kept
+ * on the host, so later deployments only upload the changed files. With
+ * Deploy.Ssh.Delete, files gone from the export are deleted from the host
+ */
+func Sftp(out string, conf *config.Config) (Result, error) {
+	var result Result
+	login, err := getSshLogin(conf)
+	if err != nil {
+		return result, err
+	}
+	root, err := getSshPath(conf)
+	if err != nil {
+		return result, err
+	}
+	files, err := getLocalFiles(out)
+	if err != nil {
+		return result, err
+	}
+	tmp, err := ioutil.TempDir("", "gosite-deploy")
+	if err != nil {
+		return result, err
+	}
+	defer os.RemoveAll(tmp)
+	args := append(append([]string{"-b", "-"}, getSshOptions("-P", conf)...), login)
+	remote := func(name string) string {
+		return root + "/" + name
+	}
+
+	// Hosts deployed to for the first time have no checksums yet
+	manifest := filepath.Join(tmp, sftpManifestFile)
+	deployed := make(map[string]string)
+	if _, err = runCommand("-get "+sftpQuote(remote(sftpManifestFile))+" "+sftpQuote(manifest)+"\n", "sftp", args...); err != nil {
+		return result, err
+	}
+	if bs, err := ioutil.ReadFile(manifest); err == nil {
+		json.Unmarshal(bs, &deployed)
+	}
+
+	var batch []string
+	folders := make(map[string]bool)
+	current := make(map[string]string)
+	for _, f := range files {
+		current[f.Name] = f.Sum
+		if deployed[f.Name] == f.Sum {
+			continue
+		}
+		for folder := path.Dir(f.Name); folder != "."; folder = path.Dir(folder) {
+			folders[folder] = true
+		}
+		batch = append(batch, "put "+sftpQuote(f.File)+" "+sftpQuote(remote(f.Name)))
+		result.Uploaded = append(result.Uploaded, f.Name)
+	}
+	// Folders are created before the files they hold, parents first
+	var mkdirs []string
+	for folder := range folders {
+		mkdirs = append(mkdirs, folder)
+	}
+	sort.Strings(mkdirs)
+	for i, folder := range mkdirs {
+		mkdirs[i] = "-mkdir " + sftpQuote(remote(folder))
+	}
+	mkdirs = append([]string{"-mkdir " + sftpQuote(root)}, mkdirs...)
+	batch = append(mkdirs, batch...)
+	if conf.Deploy.Ssh.Delete {
+		var gone []string
+		for name := range deployed {
+			if _, ok := current[name]; !ok {
+				gone = append(gone, name)
+			}
+		}
+		sort.Strings(gone)
+		for _, name := range gone {
+			batch = append(batch, "-rm "+sftpQuote(remote(name)))
+			result.Deleted = append(result.Deleted, name)
+		}
+	} else {
+		for name, sum := range deployed {
+			if _, ok := current[name]; !ok {
+				current[name] = sum
+			}
+		}
+	}
+	if len(result.Uploaded)+len(result.Deleted) == 0 {
+		return result, nil
+	}
+	bs, err := json.MarshalIndent(current, "", "    ")
+	if err != nil {
+		return result, err
+	}
+	if err = ioutil.WriteFile(manifest, bs, 0644); err != nil {
+		return result, err
+	}
+	batch = append(batch, "put "+sftpQuote(manifest)+" "+sftpQuote(remote(sftpManifestFile)))
+	_, err = runCommand(strings.Join(batch, "\n")+"\n", "sftp", args...)
+	return result, err
+}
@@ -0,0 +1,24 @@
+package deploy
+
+import (
+	"github.com/rredpoppy/gosite/pkg/config"
+	"testing"
+)
+
+func TestSshDeploysNeedPath(t *testing.T) {
+	conf := config.Default()
+	conf.Deploy.Ssh.Host = "example.com"
+	conf.Deploy.Ssh.Delete = true
+	for _, path := range []string{"", " ", "/"} {
+		conf.Deploy.Ssh.Path = path
+		if _, err := Rsync(t.TempDir(), &conf); err == nil {
+			t.Errorf("rsync deployed to the path %q", path)
+		}
+		if _, err := Sftp(t.TempDir(), &conf); err == nil {
+			t.Errorf("sftp deployed to the path %q", path)
+		}
+	}
+	if config.Default().Deploy.Ssh.Delete {
+		t.Error("deleting files from the host is on by default")
+	}
+}
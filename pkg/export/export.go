@@ -0,0 +1,535 @@
+package export
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"github.com/rredpoppy/gosite/pkg/render"
+	"io/fs"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// User agent of the export requests. It reads as a bot so exported pages are
+// not counted as views
+const userAgent = "gosite-export (bot)"
+
+// Error listing the pages that could not be exported, by path
+type BuildError struct {
+	Pages map[string]error
+}
+
+// Returns one line per failed page
+func (e BuildError) Error() string {
+	var lines []string
+	for page, err := range e.Pages {
+		lines = append(lines, page+": "+err.Error())
+	}
+	sort.Strings(lines)
+	return "Could not export " + strconv.Itoa(len(lines)) + " pages:\n" + strings.Join(lines, "\n")
+}
+
+// Response writer keeping the page rendered by the site handler in memory
+type pageWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *pageWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *pageWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *pageWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = 200
+	}
+	return w.body.Write(b)
+}
+
+// Error of a page answered with another status than 200
+type statusError int
+
+func (e statusError) Error() string {
+	return "HTTP status " + strconv.Itoa(int(e))
+}
+
+// Name of the file listing the pages of the last export with the checksum of
+// their sources, kept in the output folder
+const ManifestFile = ".gosite-build.json"
+
+// Struct representing the manifest of an export
+type manifest struct {
+	Pages map[string]string
+}
+
+// Page of the site to export, with the checksum of the sources it is
+// rendered from
+type sitePage struct {
+	Path, Checksum string
+}
+
+/**
+ * Returns the checksum of the sources shared by all the pages: the config, the
+ * templates of the theme, the author profiles, the list of sections making up
+ * the menu and the tags of the published articles, which make up the tag
+ * cloud
+ */
+func getSiteChecksum(conf *config.Config, sections []content.SectionInfo) (string, error) {
+	sources := [][]byte{}
+	bs, err := json.Marshal(conf)
+	if err != nil {
+		return "", err
+	}
+	sources = append(sources, bs)
+	templates := content.GetTemplateStore(conf)
+	err = fs.WalkDir(templates, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		sum, err := content.HashFile(templates, name)
+		sources = append(sources, []byte(name), []byte(sum))
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	bs, err = json.Marshal(content.GetAuthors(conf))
+	if err != nil {
+		return "", err
+	}
+	sources = append(sources, bs)
+	store := content.GetContentStore(conf)
+	for _, section := range sections {
+		sources = append(sources, []byte(section.Name))
+		for _, article := range section.Articles {
+			if article.Draft || strings.HasPrefix(article.Slug, "_") {
+				continue
+			}
+			bs, err := store.ReadFile(content.GetArticleName(section.Name, article.Slug))
+			if err != nil {
+				return "", err
+			}
+			meta, _ := content.ParseFrontMatter(string(bs))
+			if !(content.Page{Meta: meta}).IsScheduled(time.Now()) {
+				sources = append(sources, []byte(strings.Join(meta.List("tags"), "\n")))
+			}
+		}
+	}
+	return content.Hash(sources...), nil
+}
+
+/**
+ * Returns the pages of the site: the homepage, every page of the section
+ * listings and every published article. Articles depend on their own file,
+ * listings on all the files of their section, and every page on the sources
+ * shared by the whole site
+ */
+func getPages(conf *config.Config) ([]sitePage, error) {
+	sections, err := content.GetSections(conf)
+	if err != nil {
+		return nil, err
+	}
+	site, err := getSiteChecksum(conf, sections)
+	if err != nil {
+		return nil, err
+	}
+	store := content.GetContentStore(conf)
+	var pages []sitePage
+	sectionSums := make(map[string]string)
+	authorSources := make(map[string][][]byte)
+	var authors []string
+	for _, section := range sections {
+		sources := [][]byte{[]byte(site)}
+		var articles []sitePage
+		for _, article := range section.Articles {
+			bs, err := store.ReadFile(content.GetArticleName(section.Name, article.Slug))
+			if err != nil {
+				return nil, err
+			}
+			// Password protected articles need the server to be unlocked, and
+			// scheduled ones are left out until their date, which changes the
+			// sources of their listing
+			meta, _ := content.ParseFrontMatter(string(bs))
+			protected := content.Page{Meta: meta}.IsProtected()
+			scheduled := content.Page{Meta: meta}.IsScheduled(time.Now())
+			sources = append(sources, []byte(article.Slug), bs, []byte(strconv.FormatBool(scheduled)))
+			if !article.Draft && !protected && !scheduled && !strings.HasPrefix(article.Slug, "_") {
+				articles = append(articles, sitePage{Path: content.GetArticleLink(section.Name, article.Slug, conf),
+					Checksum: content.Hash([]byte(site), bs)})
+				if conf.Amp.Enabled {
+					articles = append(articles, sitePage{Path: content.GetArticleLink(section.Name, article.Slug, conf) + "/amp",
+						Checksum: content.Hash([]byte(site), bs)})
+				}
+				if key := content.GetAuthorKey(meta.Get("author")); len(key) > 0 {
+					if _, ok := authorSources[key]; !ok {
+						authors = append(authors, key)
+						authorSources[key] = [][]byte{[]byte(site)}
+					}
+					authorSources[key] = append(authorSources[key], []byte(section.Name+"/"+article.Slug), bs)
+				}
+			}
+		}
+		sum := content.Hash(sources...)
+		sectionSums[section.Name] = sum
+		// Sections without articles nor introduction have no listing
+		listing, err := content.GetListing(section.Name, 1, conf)
+		if err == nil {
+			pages = append(pages, sitePage{Path: listing.GetPageLink(1), Checksum: sum})
+		}
+		for page := 2; page <= listing.Pages && listing.ShowListing; page++ {
+			pages = append(pages, sitePage{Path: listing.GetPageLink(page), Checksum: sum})
+		}
+		pages = append(pages, articles...)
+	}
+	// Author archives list the published articles of their author
+	for _, key := range authors {
+		pages = append(pages, sitePage{Path: "/authors/" + key,
+			Checksum: content.Hash(authorSources[key]...)})
+	}
+
+	// The homepage shows content/index.md or the listing of the home section
+	home := sitePage{Path: "/", Checksum: site}
+	if content.HasHomePage(conf) {
+		bs, err := store.ReadFile("index.md")
+		if err != nil {
+			return nil, err
+		}
+		home.Checksum = content.Hash([]byte(site), bs)
+	} else if menu, err := content.GetMenu(conf); err == nil {
+		home.Checksum = sectionSums[content.GetHomeSection(menu, conf)]
+	}
+	return append([]sitePage{home}, pages...), nil
+}
+
+/**
+ * Returns the files of the site exported under their own name rather than as
+ * pages: the feed of the site and those of the sections of the menu, the
+ * sitemap, the client-side search index, the files of the progressive web app
+ * and the configuration files of Netlify
+ */
+func getSiteFiles(conf *config.Config) []string {
+	// Every page links to the feeds in its head
+	files := []string{"/feed.xml"}
+	if menu, err := content.GetMenu(conf); err == nil {
+		for _, item := range menu {
+			if len(item.Section) > 0 {
+				files = append(files, "/"+content.GetSectionSlug(item.Section)+"/feed.xml")
+			}
+		}
+	}
+	if conf.Sitemap.Enabled {
+		files = append(files, "/sitemap.xml")
+	}
+	if conf.Search.ClientIndex {
+		files = append(files, "/search-index.json")
+	}
+	if conf.Pwa.Enabled {
+		files = append(files, "/manifest.webmanifest", "/sw.js")
+	}
+	if conf.Export.Netlify {
+		files = append(files, "/_redirects", "/_headers")
+	}
+	return files
+}
+
+// Struct representing the links of a sitemap index
+type sitemapIndex struct {
+	XMLName  xml.Name
+	Sitemaps []string `xml:"sitemap>loc"`
+}
+
+/**
+ * Returns the paths of the sitemaps listed by a sitemap index, none for a
+ * plain sitemap
+ */
+func getSitemapParts(bs []byte, root string) []string {
+	var index sitemapIndex
+	if err := xml.Unmarshal(bs, &index); err != nil || index.XMLName.Local != "sitemapindex" {
+		return nil
+	}
+	var parts []string
+	for _, loc := range index.Sitemaps {
+		parts = append(parts, strings.TrimPrefix(loc, root))
+	}
+	return parts
+}
+
+/**
+ * Reads the manifest of the previous export to a folder, if any
+ */
+func readManifest(out string) manifest {
+	m := manifest{Pages: make(map[string]string)}
+	if bs, err := ioutil.ReadFile(filepath.Join(out, ManifestFile)); err == nil {
+		json.Unmarshal(bs, &m)
+	}
+	return m
+}
+
+/**
+ * Returns the file a page is exported to
+ */
+func getPageFile(out string, page string) string {
+	return filepath.Join(out, filepath.FromSlash(page), "index.html")
+}
+
+/**
+ * Writes a file unless it already holds the same data, creating its folder
+ * if needed
+ */
+func writeFile(file string, data []byte) error {
+	if bs, err := ioutil.ReadFile(file); err == nil && bytes.Equal(bs, data) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, data, 0644)
+}
+
+/**
+ * Returns the root pages are requested from, the host of the site under the
+ * PathPrefix, and the root of the links written in the pages. A folder of the
+ * BaseURL site config entry is only part of the latter
+ */
+func getRoots(conf *config.Config) (string, string) {
+	prefix := content.GetPathPrefix(conf)
+	if base, err := url.Parse(conf.Site.BaseURL); err == nil && len(base.Host) > 0 {
+		return base.Scheme + "://" + base.Host + prefix, content.GetSiteURL(conf)
+	}
+	return "http://localhost" + prefix, "http://localhost" + prefix
+}
+
+/**
+ * Renders a page of the site with the handler and writes it to the output
+ * folder, as the index.html file of a folder named after its path
+ */
+func exportPage(handler http.Handler, root string, page string, out string, conf *config.Config) error {
+	bs, err := renderPath(handler, root, page)
+	if err != nil {
+		return err
+	}
+	return writeFile(getPageFile(out, page), rewriteLinks(bs, page, conf))
+}
+
+/**
+ * Renders a path of the site with the handler
+ */
+func renderPath(handler http.Handler, root string, page string) ([]byte, error) {
+	req, err := http.NewRequest("GET", root+page, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", userAgent)
+	w := &pageWriter{header: make(http.Header)}
+	handler.ServeHTTP(w, req)
+	if w.status != 200 {
+		return nil, statusError(w.status)
+	}
+	return w.body.Bytes(), nil
+}
+
+/**
+ * Copies the files of a store to a folder
+ */
+func copyStore(store content.Store, out string) error {
+	return fs.WalkDir(store, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		bs, err := fs.ReadFile(store, name)
+		if err != nil {
+			return err
+		}
+		return writeFile(filepath.Join(out, filepath.FromSlash(name)), bs)
+	})
+}
+
+/**
+ * Copies the images and videos of the content store to the media folder,
+ * along with the thumbnails of the images of the default width, as served by
+ * the site
+ */
+func exportMedia(conf *config.Config, out string) error {
+	store, width := content.GetContentStore(conf), render.GetThumbnailWidth(conf)
+	return fs.WalkDir(store, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || render.GetImageType(name)+render.GetVideoType(name) == "" {
+			return err
+		}
+		bs, err := fs.ReadFile(store, name)
+		if err != nil {
+			return err
+		}
+		if err = writeFile(filepath.Join(out, "media", filepath.FromSlash(name)), bs); err != nil {
+			return err
+		}
+		if render.GetImageType(name) == "" {
+			return nil
+		}
+		thumbnail, _, err := render.GetThumbnail(name, width, conf)
+		if err != nil {
+			return err
+		}
+		return writeFile(filepath.Join(out, "thumbs", strconv.Itoa(width), filepath.FromSlash(name)), thumbnail)
+	})
+}
+
+/**
+ * Exports the site served by the handler as static files to the out folder,
+ * along with the static folder, the media of the content folder, the feeds,
+ * the sitemap, the search index and the files of the progressive web app.
+ * Pages are rendered concurrently, one worker per CPU core; pages failing to
+ * render do not stop the export, and are reported together in a BuildError.
+ * Exports are incremental: unless forced, pages whose sources did not change
+ * since the previous export to the folder are left as they are, and pages
+ * gone from the site are removed
+ */
+func Build(handler http.Handler, conf *config.Config, out string, force bool) error {
+	_, err := BuildChanged(handler, conf, out, force)
+	return err
+}
+
+/**
+ * Exports the site like Build, returning the paths of the pages written or
+ * removed by the export
+ */
+func BuildChanged(handler http.Handler, conf *config.Config, out string, force bool) ([]string, error) {
+	pages, err := getPages(conf)
+	if err != nil {
+		return nil, err
+	}
+	previous := readManifest(out)
+	current := manifest{Pages: make(map[string]string)}
+	root, siteRoot := getRoots(conf)
+
+	failed := make(map[string]error)
+	var changed []string
+	var mu sync.Mutex
+	jobs := make(chan sitePage)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for page := range jobs {
+				err := exportPage(handler, root, page.Path, out, conf)
+				mu.Lock()
+				if err != nil {
+					failed[page.Path] = err
+				} else {
+					current.Pages[page.Path] = page.Checksum
+					changed = append(changed, page.Path)
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, page := range pages {
+		if _, err := os.Stat(getPageFile(out, page.Path)); err == nil && !force && previous.Pages[page.Path] == page.Checksum {
+			current.Pages[page.Path] = page.Checksum
+			continue
+		}
+		jobs <- page
+	}
+	close(jobs)
+	wg.Wait()
+
+	for page := range previous.Pages {
+		if _, ok := current.Pages[page]; !ok && failed[page] == nil {
+			os.Remove(getPageFile(out, page))
+			// The folder is only removed when nothing else is left in it
+			os.Remove(filepath.Dir(getPageFile(out, page)))
+			changed = append(changed, page)
+		}
+	}
+	bs, err := json.MarshalIndent(current, "", "    ")
+	if err == nil {
+		err = ioutil.WriteFile(filepath.Join(out, ManifestFile), bs, 0644)
+	}
+	if err != nil {
+		failed[ManifestFile] = err
+	}
+
+	if err = copyStore(content.GetSiteStore("static", "static"), out); err != nil && !os.IsNotExist(err) {
+		failed["static"] = err
+	}
+	if err = exportMedia(conf, out); err != nil {
+		failed["media"] = err
+	}
+	if len(conf.Export.Cname) > 0 {
+		if err = writeFile(filepath.Join(out, "CNAME"), []byte(conf.Export.Cname+"\n")); err != nil {
+			failed["CNAME"] = err
+		}
+	}
+	if key := conf.Ping.IndexNowKey; len(key) > 0 {
+		if err = writeFile(filepath.Join(out, key+".txt"), []byte(key)); err != nil {
+			failed[key+".txt"] = err
+		}
+	}
+	// Files other than pages keep their name. The sitemaps of a sitemap
+	// index are exported along with it
+	files := getSiteFiles(conf)
+	for i := 0; i < len(files); i++ {
+		file := files[i]
+		bs, err := renderPath(handler, root, file)
+		if err == nil && file == "/sitemap.xml" {
+			files = append(files, getSitemapParts(bs, siteRoot)...)
+		}
+		if err == nil {
+			err = writeFile(filepath.Join(out, filepath.FromSlash(file)), bs)
+		}
+		if err != nil {
+			failed[file] = err
+		}
+	}
+	sort.Strings(changed)
+	if len(failed) > 0 {
+		return changed, BuildError{Pages: failed}
+	}
+	return changed, nil
+}
+
+/**
+ * Renders every page and file an export of the site would hold, without
+ * writing them, and returns the ones failing by path along with the number of
+ * paths rendered
+ */
+func Check(handler http.Handler, conf *config.Config) (int, map[string]error, error) {
+	pages, err := getPages(conf)
+	if err != nil {
+		return 0, nil, err
+	}
+	root, siteRoot := getRoots(conf)
+	paths := getSiteFiles(conf)
+	for _, page := range pages {
+		paths = append(paths, page.Path)
+	}
+	failed := make(map[string]error)
+	for i := 0; i < len(paths); i++ {
+		bs, err := renderPath(handler, root, paths[i])
+		if err != nil {
+			failed[paths[i]] = err
+		} else if paths[i] == "/sitemap.xml" {
+			paths = append(paths, getSitemapParts(bs, siteRoot)...)
+		}
+	}
+	return len(paths), failed, nil
+}
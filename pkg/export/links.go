@@ -0,0 +1,39 @@
+package export
+
+import (
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/render"
+	"strings"
+)
+
+/**
+ * Returns the link a path of the site has in an exported page: relative to
+ * the page with Export.RelativeUrls, under Export.BasePath otherwise
+ */
+func getExportLink(link string, page string, conf *config.Config) string {
+	if conf.Export.RelativeUrls {
+		// Pages are exported as the index.html file of a folder named after
+		// their path
+		depth := len(strings.FieldsFunc(page, func(r rune) bool { return r == '/' }))
+		relative := strings.Repeat("../", depth) + strings.TrimPrefix(link, "/")
+		if len(relative) == 0 {
+			return "./"
+		}
+		return relative
+	}
+	return strings.TrimSuffix(conf.Export.BasePath, "/") + link
+}
+
+/**
+ * Rewrites the links to the site in the HTML of an exported page for sites
+ * not hosted at the root of their domain, following the Export config entry.
+ * Pages are left as they are when neither RelativeUrls nor a BasePath is set
+ */
+func rewriteLinks(html []byte, page string, conf *config.Config) []byte {
+	if !conf.Export.RelativeUrls && len(strings.Trim(conf.Export.BasePath, "/")) == 0 {
+		return html
+	}
+	return render.RewriteLinks(html, func(link string) string {
+		return getExportLink(link, page, conf)
+	})
+}
@@ -0,0 +1,114 @@
+package render
+
+import (
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Largest stylesheet an AMP page may inline, in bytes
+const maxAmpCss = 75000
+
+// Markup AMP pages may not hold, and the tags turned into AMP components
+var (
+	ampScriptPattern    = regexp.MustCompile(`(?is)<script\b([^>]*)>.*?</script>`)
+	ampRemovedPattern   = regexp.MustCompile(`(?is)<style\b[^>]*>.*?</style>|<form\b.*?</form>|<noscript\b.*?</noscript>`)
+	ampAttributePattern = regexp.MustCompile(`(?i)\s(?:style|on[a-z]+|loading|decoding)="[^"]*"`)
+	ampImgPattern       = regexp.MustCompile(`(?i)<img\b([^>]*?)\s*/?>`)
+	ampIframePattern    = regexp.MustCompile(`(?is)<iframe\b([^>]*)>.*?</iframe>`)
+	ampVideoPattern     = regexp.MustCompile(`(?is)<video\b([^>]*)>(.*?)</video>`)
+	ampImportantPattern = regexp.MustCompile(`(?i)\s*!important`)
+)
+
+/**
+ * Returns the layout attributes of an AMP component: responsive when the
+ * component has a size, and otherwise the given size, scaled to the width of
+ * the page
+ */
+func getAmpLayout(attributes string, width int, height int) string {
+	if sizeAttributes.MatchString(attributes) {
+		return " layout=\"responsive\""
+	}
+	return " width=\"" + strconv.Itoa(width) + "\" height=\"" + strconv.Itoa(height) + "\" layout=\"responsive\""
+}
+
+/**
+ * Turns rendered HTML into AMP markup: images, frames and videos become their
+ * AMP components, while scripts, stylesheets, forms and style and event
+ * attributes are dropped. Returns the markup along with the names of the
+ * components needing their script, sorted
+ */
+func AmpHtml(rendered string, conf *config.Config) (string, []string) {
+	rendered = ampScriptPattern.ReplaceAllStringFunc(rendered, func(s string) string {
+		// Structured data is the only script AMP pages keep
+		if strings.Contains(ampScriptPattern.FindStringSubmatch(s)[1], "application/ld+json") {
+			return s
+		}
+		return ""
+	})
+	rendered = ampRemovedPattern.ReplaceAllString(rendered, "")
+	rendered = ampAttributePattern.ReplaceAllString(rendered, "")
+	rendered = ampImgPattern.ReplaceAllStringFunc(rendered, func(s string) string {
+		attributes := ampImgPattern.FindStringSubmatch(s)[1]
+		if !sizeAttributes.MatchString(attributes) {
+			if m := imgSrcPattern.FindStringSubmatch(attributes); m != nil {
+				if w, h, err := getLinkedImageSize(m[1], conf); err == nil {
+					attributes += " width=\"" + strconv.Itoa(w) + "\" height=\"" + strconv.Itoa(h) + "\""
+				}
+			}
+		}
+		if !sizeAttributes.MatchString(attributes) {
+			// Images of unknown size get a fixed height
+			return "<amp-img" + attributes + " height=\"300\" layout=\"fixed-height\"></amp-img>"
+		}
+		return "<amp-img" + attributes + " layout=\"responsive\"></amp-img>"
+	})
+	components := make(map[string]bool)
+	rendered = ampIframePattern.ReplaceAllStringFunc(rendered, func(s string) string {
+		components["amp-iframe"] = true
+		attributes := ampIframePattern.FindStringSubmatch(s)[1]
+		if !strings.Contains(attributes, " sandbox=") {
+			attributes += " sandbox=\"allow-scripts allow-same-origin allow-popups\""
+		}
+		return "<amp-iframe" + attributes + getAmpLayout(attributes, 16, 9) + "></amp-iframe>"
+	})
+	rendered = ampVideoPattern.ReplaceAllStringFunc(rendered, func(s string) string {
+		components["amp-video"] = true
+		m := ampVideoPattern.FindStringSubmatch(s)
+		return "<amp-video" + m[1] + getAmpLayout(m[1], 16, 9) + ">" + m[2] + "</amp-video>"
+	})
+	var names []string
+	for name := range components {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return rendered, names
+}
+
+/**
+ * Returns the stylesheet inlined in AMP pages: the Amp.Stylesheets of the
+ * static folder, without the !important rules AMP forbids. Stylesheets which
+ * would take it over the AMP limit are left out
+ */
+func GetAmpCss(conf *config.Config) string {
+	var css strings.Builder
+	store := content.GetSiteStore("static", "static")
+	for _, file := range conf.Amp.Stylesheets {
+		name, err := CleanContentName(file)
+		if err != nil {
+			continue
+		}
+		bs, err := store.ReadFile(name)
+		if err != nil {
+			continue
+		}
+		sheet := ampImportantPattern.ReplaceAllString(string(bs), "")
+		if css.Len()+len(sheet) <= maxAmpCss {
+			css.WriteString(sheet + "\n")
+		}
+	}
+	return css.String()
+}
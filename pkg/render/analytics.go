@@ -0,0 +1,51 @@
+package render
+
+import (
+	"encoding/json"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"html"
+	"strings"
+)
+
+/**
+ * Returns the analytics snippet to place at the end of every page, or an empty
+ * string when analytics are disabled for the current environment
+ */
+func GetAnalyticsSnippet(conf *config.Config) string {
+	a := conf.Analytics
+	environments := a.Environments
+	if len(environments) == 0 {
+		environments = []string{"production"}
+	}
+	enabled := false
+	for _, env := range environments {
+		if env == config.GetEnvironment(conf) {
+			enabled = true
+		}
+	}
+	if !enabled {
+		return ""
+	}
+	id, _ := json.Marshal(a.Id)
+	switch a.Provider {
+	case "google":
+		return "<script async src=\"https://www.googletagmanager.com/gtag/js?id=" + html.EscapeString(a.Id) + "\"></script>\n" +
+			"<script>window.dataLayer = window.dataLayer || []; function gtag(){dataLayer.push(arguments);} " +
+			"gtag('js', new Date()); gtag('config', " + string(id) + ");</script>"
+	case "plausible":
+		host := strings.TrimSuffix(a.Url, "/")
+		if len(host) == 0 {
+			host = "https://plausible.io"
+		}
+		return "<script defer data-domain=\"" + html.EscapeString(a.Id) + "\" src=\"" +
+			html.EscapeString(host) + "/js/script.js\"></script>"
+	case "matomo":
+		host, _ := json.Marshal(strings.TrimSuffix(a.Url, "/") + "/")
+		return "<script>var _paq = window._paq = window._paq || []; _paq.push(['trackPageView']); _paq.push(['enableLinkTracking']);\n" +
+			"(function() { var u = " + string(host) + "; _paq.push(['setTrackerUrl', u + 'matomo.php']); _paq.push(['setSiteId', " + string(id) + "]);\n" +
+			"var d = document, g = d.createElement('script'), s = d.getElementsByTagName('script')[0]; g.async = true; g.src = u + 'matomo.js'; s.parentNode.insertBefore(g, s); })();</script>"
+	case "custom":
+		return a.Snippet
+	}
+	return ""
+}
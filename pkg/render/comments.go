@@ -0,0 +1,61 @@
+package render
+
+import (
+	"encoding/json"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"html"
+)
+
+/**
+ * Returns a quoted, HTML-escaped attribute, or nothing if the value is empty
+ */
+func htmlAttribute(name string, value string) string {
+	if len(value) == 0 {
+		return ""
+	}
+	return " " + name + "=\"" + html.EscapeString(value) + "\""
+}
+
+/**
+ * Returns the comments embed markup for an article, or an empty string when
+ * comments are disabled in the config or by "comments: false" in the front
+ * matter
+ */
+func GetCommentsEmbed(p content.Page, link string, identifier string, conf *config.Config) string {
+	c := conf.Comments
+	if p.Meta.Has("comments") && !p.Meta.Bool("comments") {
+		return ""
+	}
+	mapping := c.Mapping
+	if len(mapping) == 0 {
+		mapping = "pathname"
+	}
+	switch c.Provider {
+	case "disqus":
+		url, _ := json.Marshal(link)
+		id, _ := json.Marshal(identifier)
+		src, _ := json.Marshal("https://" + c.DisqusShortname + ".disqus.com/embed.js")
+		return "<div id=\"disqus_thread\"></div>\n" +
+			"<script>var disqus_config = function () { this.page.url = " + string(url) +
+			"; this.page.identifier = " + string(id) + "; };\n" +
+			"(function () { var d = document, s = d.createElement('script'); s.src = " + string(src) +
+			"; s.setAttribute('data-timestamp', +new Date()); (d.head || d.body).appendChild(s); })();</script>"
+	case "utterances":
+		return "<script src=\"https://utteranc.es/client.js\"" +
+			htmlAttribute("repo", c.Repo) +
+			htmlAttribute("issue-term", mapping) +
+			htmlAttribute("theme", c.Theme) +
+			" crossorigin=\"anonymous\" async></script>"
+	case "giscus":
+		return "<script src=\"https://giscus.app/client.js\"" +
+			htmlAttribute("data-repo", c.Repo) +
+			htmlAttribute("data-repo-id", c.RepoId) +
+			htmlAttribute("data-category", c.Category) +
+			htmlAttribute("data-category-id", c.CategoryId) +
+			htmlAttribute("data-mapping", mapping) +
+			htmlAttribute("data-theme", c.Theme) +
+			" crossorigin=\"anonymous\" async></script>"
+	}
+	return ""
+}
@@ -0,0 +1,130 @@
+package render
+
+import (
+	"errors"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MIME types of the formats images are converted to, most compact first
+var derivativeTypes = []string{"image/avif", "image/webp"}
+
+/**
+ * Returns the command converting images to a format, empty when there is none
+ */
+func getDerivativeCommand(mime string, conf *config.Config) string {
+	switch mime {
+	case "image/avif":
+		return conf.Images.AvifCommand
+	case "image/webp":
+		return conf.Images.WebpCommand
+	}
+	return ""
+}
+
+/**
+ * Returns true if an image of the content store may be served converted to
+ * another format. Only JPEG and PNG images are, GIF images being animated
+ */
+func CanConvertImage(name string, conf *config.Config) bool {
+	if t := GetImageType(name); t != "image/jpeg" && t != "image/png" {
+		return false
+	}
+	return len(conf.Images.AvifCommand) > 0 || len(conf.Images.WebpCommand) > 0
+}
+
+/**
+ * Returns the MIME type of the most compact format a browser accepts, given
+ * its Accept header, among the ones images are converted to. Returns an empty
+ * string when it accepts none
+ */
+func GetDerivativeType(accept string, conf *config.Config) string {
+	for _, mime := range derivativeTypes {
+		if len(getDerivativeCommand(mime, conf)) > 0 && strings.Contains(accept, mime) {
+			return mime
+		}
+	}
+	return ""
+}
+
+/**
+ * Runs a conversion command on an image, replacing {in} and {out} in its
+ * arguments with the files converted
+ */
+func convertImage(command string, bs []byte, ext string) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "gosite-image")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	in, out := filepath.Join(dir, "image"+ext), filepath.Join(dir, "converted")
+	if err = ioutil.WriteFile(in, bs, 0644); err != nil {
+		return nil, err
+	}
+	args := strings.Fields(command)
+	for i, arg := range args {
+		args[i] = strings.NewReplacer("{in}", in, "{out}", out).Replace(arg)
+	}
+	if err = exec.Command(args[0], args[1:]...).Run(); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(out)
+}
+
+/**
+ * Returns an image of the content store converted to the given format, or
+ * its thumbnail of the given width when it is not zero. Conversions are
+ * cached in the Images.CacheFolder by checksum of the image. Fails when the
+ * conversion is not smaller than the image, which is then better served as it
+ * is
+ */
+func GetDerivative(name string, width int, mime string, conf *config.Config) ([]byte, error) {
+	command := getDerivativeCommand(mime, conf)
+	if !CanConvertImage(name, conf) || len(command) == 0 {
+		return nil, errors.New("Invalid conversion")
+	}
+	store := content.GetContentStore(conf)
+	sum, err := content.HashFile(store, name)
+	if err != nil {
+		return nil, err
+	}
+	cache := getImageCache(conf)
+	cached := strings.TrimPrefix(mime, "image/") + "/" + strconv.Itoa(width) + "/" + sum
+	// An empty file records a conversion not worth serving
+	if bs, err := cache.ReadFile(cached); err == nil && len(bs) > 0 {
+		return bs, nil
+	} else if err == nil {
+		return nil, errors.New("Conversion larger than the image")
+	}
+
+	var bs []byte
+	ext := ".png"
+	if GetImageType(name) == "image/jpeg" {
+		ext = ".jpg"
+	}
+	if width > 0 {
+		bs, _, err = GetThumbnail(name, width, conf)
+	} else {
+		bs, err = store.ReadFile(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	converted, err := convertImage(command, bs, ext)
+	if err != nil {
+		return nil, err
+	}
+	if len(converted) == 0 || len(converted) >= len(bs) {
+		cache.WriteFile(cached, []byte{})
+		return nil, errors.New("Conversion larger than the image")
+	}
+	// A conversion that cannot be cached is made again next time
+	cache.WriteFile(cached, converted)
+	return converted, nil
+}
@@ -0,0 +1,179 @@
+package render
+
+import (
+	"fmt"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"html"
+	"regexp"
+	"strings"
+)
+
+// Emoji shortcodes as in GitHub flavored markdown, with their Unicode emoji
+var emojis = map[string]string{
+	"+1": "👍", "-1": "👎", "100": "💯", "1st_place_medal": "🥇", "2nd_place_medal": "🥈",
+	"3rd_place_medal": "🥉", "8ball": "🎱", "airplane": "✈️", "alarm_clock": "⏰",
+	"alien": "👽", "anchor": "⚓", "anger": "💢", "angry": "😠", "apple": "🍎",
+	"arrow_down": "⬇️", "arrow_left": "⬅️", "arrow_right": "➡️", "arrow_up": "⬆️",
+	"arrows_counterclockwise": "🔄", "art": "🎨", "astonished": "😲", "atom_symbol": "⚛️",
+	"avocado": "🥑", "baby": "👶", "baby_chick": "🐤", "back": "🔙", "bacon": "🥓",
+	"balloon": "🎈", "ballot_box_with_check": "☑️", "banana": "🍌", "bangbang": "‼️",
+	"baseball": "⚾", "basketball": "🏀", "battery": "🔋", "beach_umbrella": "⛱️", "bear": "🐻",
+	"bee": "🐝", "beer": "🍺", "beers": "🍻", "bell": "🔔", "bike": "🚲", "bird": "🐦",
+	"birthday": "🎂", "black_circle": "⚫️", "black_flag": "🏴", "black_heart": "🖤",
+	"blue_heart": "💙", "blush": "😊", "boat": "⛵", "book": "📖", "bookmark": "🔖",
+	"books": "📚", "boom": "💥", "bouquet": "💐", "bow": "🙇", "brain": "🧠", "bread": "🍞",
+	"briefcase": "💼", "broken_heart": "💔", "bug": "🐛", "bulb": "💡", "burrito": "🌯",
+	"bus": "🚌", "butterfly": "🦋", "cactus": "🌵", "cake": "🍰", "calendar": "📆",
+	"call_me_hand": "🤙", "camel": "🐫", "camera": "📷", "camera_flash": "📸", "candle": "🕯️",
+	"candy": "🍬", "car": "🚗", "carrot": "🥕", "castle": "🏰", "cat": "🐱", "cd": "💿",
+	"chains": "⛓️", "champagne": "🍾", "checkered_flag": "🏁", "cheese": "🧀", "cherries": "🍒",
+	"cherry_blossom": "🌸", "chess_pawn": "♟️", "chicken": "🐔", "chocolate_bar": "🍫",
+	"christmas_tree": "🎄", "city_sunset": "🌆", "clap": "👏", "clapper": "🎬",
+	"clipboard": "📋", "cloud": "☁️", "cloud_with_rain": "🌧️", "clown_face": "🤡",
+	"cocktail": "🍸", "coffee": "☕", "cold_face": "🥶", "cold_sweat": "😰", "collision": "💥",
+	"computer": "💻", "confetti_ball": "🎊", "confounded": "😖", "confused": "😕",
+	"construction": "🚧", "cookie": "🍪", "cool": "🆒", "cop": "👮", "copyright": "©️",
+	"corn": "🌽", "cow": "🐮", "cowboy_hat_face": "🤠", "crab": "🦀", "credit_card": "💳",
+	"crescent_moon": "🌙", "croissant": "🥐", "crossed_fingers": "🤞", "cry": "😢",
+	"cupid": "💘", "cursing_face": "🤬", "dancer": "💃", "dart": "🎯", "dash": "💨", "date": "📅",
+	"deciduous_tree": "🌳", "desert_island": "🏝️", "desktop_computer": "🖥️",
+	"disappointed": "😞", "dizzy": "💫", "dizzy_face": "😵", "dna": "🧬", "dog": "🐶",
+	"dollar": "💵", "dolphin": "🐬", "doughnut": "🍩", "dragon": "🐉", "drooling_face": "🤤",
+	"droplet": "💧", "drum": "🥁", "duck": "🦆", "dvd": "📀", "eagle": "🦅", "earth_africa": "🌍",
+	"earth_americas": "🌎", "earth_asia": "🌏", "egg": "🥚", "eggplant": "🍆",
+	"electric_plug": "🔌", "elephant": "🐘", "email": "✉", "envelope": "✉️", "euro": "💶",
+	"evergreen_tree": "🌲", "exclamation": "❗", "exploding_head": "🤯", "expressionless": "😑",
+	"eye": "👁️", "eyes": "👀", "face_with_thermometer": "🤒", "facepalm": "🤦",
+	"facepunch": "👊", "fallen_leaf": "🍂", "fearful": "😨", "file_folder": "📁", "fire": "🔥",
+	"fireworks": "🎆", "fish": "🐟", "fist": "✊", "flashlight": "🔦", "floppy_disk": "💾",
+	"flushed": "😳", "football": "🏈", "footprints": "👣", "four_leaf_clover": "🍀",
+	"fox_face": "🦊", "free": "🆓", "fries": "🍟", "frog": "🐸", "full_moon": "🌕",
+	"game_die": "🎲", "gear": "⚙️", "gem": "💎", "ghost": "👻", "gift": "🎁", "gift_heart": "💝",
+	"giraffe": "🦒", "globe_with_meridians": "🌐", "grapes": "🍇", "green_apple": "🍏",
+	"green_circle": "🟢", "green_heart": "💚", "grey_exclamation": "❕", "grey_question": "❔",
+	"grimacing": "😬", "grin": "😁", "grinning": "😀", "guitar": "🎸", "hamburger": "🍔",
+	"hammer": "🔨", "hamster": "🐹", "hand": "✋", "handshake": "🤝", "hankey": "💩",
+	"headphones": "🎧", "hear_no_evil": "🙉", "heart": "❤️", "heart_eyes": "😍",
+	"heart_eyes_cat": "😻", "heartbeat": "💓", "heartpulse": "💗", "heavy_check_mark": "✔️",
+	"heavy_exclamation_mark": "❗", "heavy_minus_sign": "➖", "heavy_multiplication_x": "✖️",
+	"heavy_plus_sign": "➕", "helicopter": "🚁", "herb": "🌿", "honeybee": "🐝", "horse": "🐴",
+	"hospital": "🏥", "hot_face": "🥵", "hot_pepper": "🌶️", "hotdog": "🌭", "hourglass": "⌛",
+	"house": "🏠", "hugs": "🤗", "hushed": "😯", "icecream": "🍦", "imp": "👿",
+	"incoming_envelope": "📨", "infinity": "♾️", "information_source": "ℹ️", "innocent": "😇",
+	"interrobang": "⁉️", "iphone": "📱", "jack_o_lantern": "🎃", "jigsaw": "🧩", "joy": "😂",
+	"key": "🔑", "keyboard": "⌨️", "kiss": "💋", "kissing": "😗", "kissing_heart": "😘",
+	"koala": "🐨", "label": "🏷️", "large_blue_circle": "🔵", "laughing": "😆", "leaves": "🍃",
+	"lemon": "🍋", "link": "🔗", "lion": "🦁", "lips": "👄", "lock": "🔒", "loudspeaker": "📢",
+	"lying_face": "🤥", "mag": "🔍", "mag_right": "🔎", "magnet": "🧲", "mailbox": "📫",
+	"man": "👨", "maple_leaf": "🍁", "mask": "😷", "medal_sports": "🏅", "mega": "📣",
+	"memo": "📝", "metal": "🤘", "microphone": "🎤", "microscope": "🔬",
+	"money_mouth_face": "🤑", "moneybag": "💰", "monkey_face": "🐵", "mountain": "⛰️",
+	"mouse": "🐭", "movie_camera": "🎥", "muscle": "💪", "mushroom": "🍄",
+	"musical_keyboard": "🎹", "musical_note": "🎵", "nauseated_face": "🤢", "nerd_face": "🤓",
+	"neutral_face": "😐", "new": "🆕", "new_moon": "🌑", "newspaper": "📰", "ninja": "🥷",
+	"no_bell": "🔕", "no_entry": "⛔", "no_entry_sign": "🚫", "no_mouth": "😶", "notebook": "📓",
+	"notes": "🎶", "o": "⭕", "ocean": "🌊", "octopus": "🐙", "office": "🏢", "ok": "🆗",
+	"ok_hand": "👌", "older_man": "👴", "older_woman": "👵", "open_book": "📖",
+	"open_file_folder": "📂", "open_hands": "👐", "open_mouth": "😮", "orange_heart": "🧡",
+	"owl": "🦉", "package": "📦", "page_facing_up": "📄", "palm_tree": "🌴", "pancakes": "🥞",
+	"panda_face": "🐼", "paperclip": "📎", "partly_sunny": "⛅", "partying_face": "🥳",
+	"peace_symbol": "☮️", "peach": "🍑", "pear": "🍐", "pencil": "📝", "pencil2": "✏️",
+	"penguin": "🐧", "pensive": "😔", "performing_arts": "🎭", "persevere": "😣", "phone": "☎️",
+	"pig": "🐷", "pill": "💊", "pinching_hand": "🤏", "pineapple": "🍍", "pizza": "🍕",
+	"pleading_face": "🥺", "point_down": "👇", "point_left": "👈", "point_right": "👉",
+	"point_up": "☝️", "point_up_2": "👆", "poop": "💩", "popcorn": "🍿", "pray": "🙏",
+	"printer": "🖨️", "punch": "👊", "purple_heart": "💜", "pushpin": "📌", "question": "❓",
+	"rabbit": "🐰", "rage": "😡", "rainbow": "🌈", "raised_back_of_hand": "🤚",
+	"raised_eyebrow": "🤨", "raised_hand": "✋", "raised_hands": "🙌", "ramen": "🍜",
+	"recycle": "♻️", "red_car": "🚗", "red_circle": "🔴", "registered": "®️", "relieved": "😌",
+	"repeat": "🔁", "revolving_hearts": "💞", "ribbon": "🎀", "robot": "🤖", "rocket": "🚀",
+	"rofl": "🤣", "roll_eyes": "🙄", "rose": "🌹", "round_pushpin": "📍", "runner": "🏃",
+	"running": "🏃", "sailboat": "⛵", "santa": "🎅", "satellite": "📡", "satisfied": "😆",
+	"school": "🏫", "scissors": "✂️", "scream": "😱", "see_no_evil": "🙈", "seedling": "🌱",
+	"shark": "🦈", "ship": "🚢", "shrug": "🤷", "skull": "💀", "sleeping": "😴", "sleepy": "😪",
+	"slightly_frowning_face": "🙁", "slightly_smiling_face": "🙂", "sloth": "🦥", "smile": "😄",
+	"smiley": "😃", "smiley_cat": "😺", "smiling_imp": "😈", "smirk": "😏", "snail": "🐌",
+	"snake": "🐍", "sneezing_face": "🤧", "snowflake": "❄️", "snowman": "⛄", "sob": "😭",
+	"soccer": "⚽", "sos": "🆘", "spaghetti": "🍝", "sparkles": "✨", "sparkling_heart": "💖",
+	"speak_no_evil": "🙊", "speech_balloon": "💬", "star": "⭐", "star2": "🌟",
+	"star_struck": "🤩", "stars": "🌠", "statue_of_liberty": "🗽", "steam_locomotive": "🚂",
+	"stopwatch": "⏱️", "straight_ruler": "📏", "strawberry": "🍓", "stuck_out_tongue": "😛",
+	"stuck_out_tongue_winking_eye": "😜", "sun_with_face": "🌞", "sunflower": "🌻",
+	"sunglasses": "😎", "sunny": "☀️", "sushi": "🍣", "sweat": "😓", "sweat_drops": "💦",
+	"sweat_smile": "😅", "syringe": "💉", "taco": "🌮", "tada": "🎉", "tangerine": "🍊",
+	"taxi": "🚕", "tea": "🍵", "telephone": "☎️", "telescope": "🔭", "tennis": "🎾",
+	"tent": "⛺", "test_tube": "🧪", "thinking": "🤔", "thought_balloon": "💭",
+	"thumbsdown": "👎", "thumbsup": "👍", "tiger": "🐯", "tired_face": "😫", "tm": "™️",
+	"tomato": "🍅", "tongue": "👅", "toolbox": "🧰", "top": "🔝", "train": "🚋",
+	"triangular_flag_on_post": "🚩", "triumph": "😤", "trophy": "🏆", "tropical_fish": "🐠",
+	"truck": "🚚", "trumpet": "🎺", "tulip": "🌷", "turtle": "🐢", "tv": "📺", "two_hearts": "💕",
+	"umbrella": "☔", "unamused": "😒", "unicorn": "🦄", "unlock": "🔓", "up": "🆙",
+	"upside_down_face": "🙃", "v": "✌️", "vertical_traffic_light": "🚦", "video_camera": "📹",
+	"video_game": "🎮", "violin": "🎻", "volcano": "🌋", "volleyball": "🏐",
+	"vulcan_salute": "🖖", "warning": "⚠️", "wastebasket": "🗑️", "watch": "⌚",
+	"watermelon": "🍉", "wave": "👋", "weary": "😩", "whale": "🐳", "white_check_mark": "✅",
+	"white_circle": "⚪️", "white_flag": "🏳️", "wine_glass": "🍷", "wink": "😉", "wolf": "🐺",
+	"woman": "👩", "world_map": "🗺️", "worried": "😟", "wrench": "🔧", "writing_hand": "✍️",
+	"x": "❌", "yawning_face": "🥱", "yellow_circle": "🟡", "yellow_heart": "💛",
+	"yin_yang": "☯️", "yum": "😋", "zany_face": "🤪", "zap": "⚡", "zipper_mouth_face": "🤐",
+	"zzz": "💤",
+}
+
+// Code, tags and emoji shortcodes of rendered HTML. Only the shortcodes of
+// the text outside of code and tags are replaced
+var emojiPattern = regexp.MustCompile(`(?s)<pre.*?</pre>|<code.*?</code>|<[^>]*>|:([a-z0-9_+-]+):`)
+
+/**
+ * Returns the code points of an emoji in hex, joined by dashes, as in the
+ * names of the emoji image sets. Variation selectors are left out of emojis
+ * made of a single character
+ */
+func getEmojiCode(emoji string) string {
+	var code []string
+	zwj := strings.ContainsRune(emoji, '\u200d')
+	for _, r := range emoji {
+		if r != '\ufe0f' || zwj {
+			code = append(code, fmt.Sprintf("%x", r))
+		}
+	}
+	return strings.Join(code, "-")
+}
+
+/**
+ * Replaces the emoji shortcodes of rendered HTML, like :tada:, with their
+ * Unicode emoji, or with images when Emoji.Images is set. The {code} of the
+ * image link is replaced with the code points of the emoji
+ */
+func replaceEmoji(rendered string, conf *config.Config) string {
+	if !strings.Contains(rendered, ":") {
+		return rendered
+	}
+	return emojiPattern.ReplaceAllStringFunc(rendered, func(s string) string {
+		if s[0] != ':' {
+			return s
+		}
+		emoji, ok := emojis[s[1:len(s)-1]]
+		if !ok {
+			return s
+		}
+		if len(conf.Emoji.Images) == 0 {
+			return emoji
+		}
+		src := strings.Replace(conf.Emoji.Images, "{code}", getEmojiCode(emoji), -1)
+		return "<img class=\"emoji\" src=\"" + html.EscapeString(src) + "\" alt=\"" + emoji + "\" title=\"" + s +
+			"\" style=\"height: 1.2em; width: auto; vertical-align: -0.2em;\">"
+	})
+}
+
+/**
+ * Registers the replacement of the emoji shortcodes, when Emoji.Enabled is
+ * set
+ */
+func RegisterEmoji(conf *config.Config) {
+	if !conf.Emoji.Enabled {
+		return
+	}
+	OnMarkdownRendered(func(section string, slug string, html string) string {
+		return replaceEmoji(html, conf)
+	})
+}
@@ -0,0 +1,209 @@
+package render
+
+import (
+	"bytes"
+	"github.com/flosch/pongo"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"html/template"
+	"path"
+	"strings"
+)
+
+// Interface implemented by the template engines able to render a theme
+type Engine interface {
+	// Renders the named template of the theme with the given context
+	Render(name string, context map[string]interface{}) (string, error)
+}
+
+// Interface implemented by the template engines able to compile a template
+// without rendering it, to report the mistakes of a theme up front
+type Compiler interface {
+	// Parses the named template of the theme and the ones it uses
+	Compile(name string) error
+}
+
+// Template engine backed by pongo, the default one. Templates given to
+// {% extends %} and {% include %} are looked up within the template folder
+type PongoEngine struct {
+	Store content.Store
+}
+
+/**
+ * Returns the content of a template of the theme. Names are resolved relative
+ * to the template store and cannot point outside of it
+ */
+func (e PongoEngine) locate(name *string) (*string, error) {
+	bs, err := e.Store.ReadFile(*name)
+	if err != nil {
+		return nil, err
+	}
+	content := string(bs)
+	return &content, nil
+}
+
+// Parses a pongo template from the template folder, with the templates it
+// extends and includes
+func (e PongoEngine) parse(name string) (*pongo.Template, error) {
+	content, err := e.locate(&name)
+	if err != nil {
+		return nil, err
+	}
+	return pongo.FromString(name, content, e.locate)
+}
+
+// Compiles a pongo template without rendering it
+func (e PongoEngine) Compile(name string) error {
+	_, err := e.parse(name)
+	return err
+}
+
+// Renders a pongo template from the template folder
+func (e PongoEngine) Render(name string, context map[string]interface{}) (string, error) {
+	tpl, err := e.parse(name)
+	if err != nil {
+		return "", err
+	}
+	tplContext := pongo.Context(context)
+	response, err := tpl.Execute(&tplContext)
+	if err != nil {
+		return "", err
+	}
+	return *response, nil
+}
+
+// Template engine backed by the standard library html/template package. Page
+// templates are rendered inside layouts/base.html when the theme has one, and
+// can use any template found in the partials folder
+type HtmlEngine struct {
+	Store   content.Store
+	BaseURL string
+}
+
+/**
+ * Returns the functions available to html/template themes, mirroring the
+ * built-in pongo filters
+ */
+func (e HtmlEngine) funcs() template.FuncMap {
+	return template.FuncMap{
+		"safe": func(s string) template.HTML {
+			return template.HTML(s)
+		},
+		"dateformat": func(layout string, value interface{}) string {
+			s, _ := filterDateFormat(value, []interface{}{layout}, nil)
+			return s.(string)
+		},
+		"truncatewords": func(count int, value interface{}) string {
+			s, _ := filterTruncateWords(value, []interface{}{count}, nil)
+			return s.(string)
+		},
+		"slugify": content.Slugify,
+		"absurl": func(link string) string {
+			if strings.Contains(link, "://") {
+				return link
+			}
+			return strings.TrimSuffix(e.BaseURL, "/") + "/" + strings.TrimPrefix(link, "/")
+		},
+		"fingerprint": GetAssetURL,
+	}
+}
+
+// Parses an html/template page template with its layout and partials.
+// Returns the template with the name of the one to execute
+func (e HtmlEngine) parse(name string) (*template.Template, string, error) {
+	var files []string
+	layout := "layouts/base.html"
+	if _, err := e.Store.Stat(layout); err != nil {
+		layout = ""
+	} else {
+		files = append(files, layout)
+	}
+	partials, _ := e.Store.ReadDir("partials")
+	for _, fi := range partials {
+		if !fi.IsDir() && strings.HasSuffix(fi.Name(), ".html") {
+			files = append(files, "partials/"+fi.Name())
+		}
+	}
+	// The page is parsed last so its definitions override the layout blocks
+	files = append(files, name)
+
+	// Templates are named after their file
+	tpl, err := template.New(name).Funcs(e.funcs()).ParseFS(e.Store, files...)
+	if err != nil {
+		return nil, "", err
+	}
+	entry := name
+	if len(layout) > 0 {
+		entry = path.Base(layout)
+	}
+	return tpl, entry, nil
+}
+
+// Compiles an html/template page template without rendering it
+func (e HtmlEngine) Compile(name string) error {
+	_, _, err := e.parse(name)
+	return err
+}
+
+// Renders an html/template page template, with its layout and partials
+func (e HtmlEngine) Render(name string, context map[string]interface{}) (string, error) {
+	tpl, entry, err := e.parse(name)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err = tpl.ExecuteTemplate(&out, entry, context); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+/**
+ * Returns the template engine selected by the TemplateEngine config entry,
+ * either "pongo" (the default) or "html"
+ */
+func GetEngine(conf *config.Config) Engine {
+	if conf.TemplateEngine == "html" {
+		return HtmlEngine{Store: content.GetTemplateStore(conf), BaseURL: content.GetSiteURL(conf)}
+	}
+	return PongoEngine{Store: content.GetTemplateStore(conf)}
+}
+
+/**
+ * Returns the templates of a folder of a template store and its subfolders
+ */
+func getTemplateNames(store content.Store, dir string) []string {
+	var names []string
+	entries, _ := store.ReadDir(dir)
+	for _, fi := range entries {
+		name := path.Join(dir, fi.Name())
+		if fi.IsDir() {
+			names = append(names, getTemplateNames(store, name)...)
+		} else if strings.HasSuffix(name, ".html") {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+/**
+ * Compiles every template of the theme with the selected engine and returns
+ * the ones failing, by name. Layouts and partials of the html engine are
+ * compiled with each page template rather than on their own
+ */
+func CheckTemplates(conf *config.Config) map[string]error {
+	problems := make(map[string]error)
+	engine, ok := GetEngine(conf).(Compiler)
+	if !ok {
+		return problems
+	}
+	for _, name := range getTemplateNames(content.GetTemplateStore(conf), "") {
+		if conf.TemplateEngine == "html" && strings.Contains(name, "/") {
+			continue
+		}
+		if err := engine.Compile(name); err != nil {
+			problems[name] = err
+		}
+	}
+	return problems
+}
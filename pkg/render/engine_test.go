@@ -0,0 +1,55 @@
+package render
+
+import (
+	"github.com/rredpoppy/gosite/pkg/content"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+/**
+ * Writes a theme to a temporary folder and returns the pongo engine reading
+ * it. Files are named relative to the template folder
+ */
+func newTestTheme(t *testing.T, files map[string]string) PongoEngine {
+	dir := t.TempDir()
+	for name, data := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return PongoEngine{Store: content.FileStore{Folder: dir}}
+}
+
+func TestPongoThemeExtendsAndIncludes(t *testing.T) {
+	engine := newTestTheme(t, map[string]string{
+		"base.html":            "<main>{% block body %}{% endblock %}</main>",
+		"template.html":        `{% extends "base.html" %}{% block body %}{% include "partials/header.html" %}{% endblock %}`,
+		"partials/header.html": `<h1>{% include "partials/title.html" %}</h1>`,
+		"partials/title.html":  "{{ title }}",
+	})
+	out, err := engine.Render("template.html", map[string]interface{}{"title": "Hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "<main><h1>Hello</h1></main>") {
+		t.Errorf("theme rendered as %q, want the nested partials inside the base template", out)
+	}
+}
+
+func TestPongoThemeMissingPartial(t *testing.T) {
+	engine := newTestTheme(t, map[string]string{
+		"template.html": `<h1>{% include "partials/missing.html" %}</h1>`,
+	})
+	if err := engine.Compile("template.html"); err == nil {
+		if _, err = engine.Render("template.html", map[string]interface{}{}); err == nil {
+			t.Error("theme including a missing partial rendered without an error")
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package render
+
+import (
+	"fmt"
+	"github.com/flosch/pongo"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+ * Registers a template filter under the given name, replacing any existing
+ * filter with the same name. Filters must be registered before templates
+ * using them are parsed
+ */
+func RegisterFilter(name string, filter pongo.FilterFunc) {
+	pongo.Filters[name] = filter
+}
+
+/**
+ * Registers the filters shipped with gosite: dateformat, truncatewords,
+ * slugify, absurl and fingerprint
+ */
+func RegisterBuiltinFilters(conf *config.Config) {
+	RegisterFilter("dateformat", filterDateFormat)
+	RegisterFilter("truncatewords", filterTruncateWords)
+	RegisterFilter("slugify", filterSlugify)
+	baseURL := content.GetSiteURL(conf)
+	RegisterFilter("absurl", func(value interface{}, args []interface{}, ctx *pongo.FilterChainContext) (interface{}, error) {
+		link := fmt.Sprint(value)
+		if strings.Contains(link, "://") {
+			return link, nil
+		}
+		return baseURL + "/" + strings.TrimPrefix(link, "/"), nil
+	})
+	RegisterFilter("fingerprint", func(value interface{}, args []interface{}, ctx *pongo.FilterChainContext) (interface{}, error) {
+		return GetAssetURL(fmt.Sprint(value)), nil
+	})
+}
+
+/**
+ * Returns the string form of the filter argument at the given position, or
+ * the fallback when it is missing
+ */
+func getStringArg(args []interface{}, pos int, fallback string) string {
+	if len(args) <= pos {
+		return fallback
+	}
+	return fmt.Sprint(args[pos])
+}
+
+/**
+ * Returns the integer form of the filter argument at the given position, or
+ * the fallback when it is missing or not a number
+ */
+func getIntArg(args []interface{}, pos int, fallback int) int {
+	if len(args) <= pos {
+		return fallback
+	}
+	switch v := args[pos].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	if v, err := strconv.Atoi(fmt.Sprint(args[pos])); err == nil {
+		return v
+	}
+	return fallback
+}
+
+/**
+ * Formats a time, or a date string, using a Go layout given as argument.
+ * Usage: {{ meta.date|dateformat:"2 Jan 2006" }}
+ */
+func filterDateFormat(value interface{}, args []interface{}, ctx *pongo.FilterChainContext) (interface{}, error) {
+	layout := getStringArg(args, 0, "January 2, 2006")
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format(layout), nil
+	case *time.Time:
+		return v.Format(layout), nil
+	}
+	t, err := content.ParseDate(fmt.Sprint(value))
+	if err != nil {
+		return value, nil
+	}
+	return t.Format(layout), nil
+}
+
+/**
+ * Keeps the given number of words of a text, appending an ellipsis when
+ * words were dropped. Usage: {{ summary|truncatewords:30 }}
+ */
+func filterTruncateWords(value interface{}, args []interface{}, ctx *pongo.FilterChainContext) (interface{}, error) {
+	count := getIntArg(args, 0, 30)
+	words := strings.Fields(fmt.Sprint(value))
+	if len(words) <= count {
+		return strings.Join(words, " "), nil
+	}
+	return strings.Join(words[:count], " ") + " ...", nil
+}
+
+/**
+ * Turns a text into a URL slug. Usage: {{ meta.title|slugify }}
+ */
+func filterSlugify(value interface{}, args []interface{}, ctx *pongo.FilterChainContext) (interface{}, error) {
+	return content.Slugify(fmt.Sprint(value)), nil
+}
@@ -0,0 +1,92 @@
+package render
+
+import (
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"html"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/**
+ * Returns the link of a file of the content store, served under /media
+ */
+func GetMediaLink(name string) string {
+	return "/media/" + escapeContentName(name)
+}
+
+/**
+ * Returns the link of the thumbnail of an image of the content store
+ */
+func GetThumbnailLink(name string, width int) string {
+	return "/thumbs/" + strconv.Itoa(width) + "/" + escapeContentName(name)
+}
+
+/**
+ * Escapes each segment of the name of a file for use in a link
+ */
+func escapeContentName(name string) string {
+	segments := strings.Split(name, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+/**
+ * Expands {{< gallery dir [width] >}} to a gallery of the images of a folder,
+ * relative to the section folder of the article, sorted by name. Each image
+ * is a figure linking to the full image, with the size of the image as data
+ * attributes so themes can open it in a lightbox, and the thumbnail as its
+ * image, Images.ThumbnailWidth wide unless a width is given
+ */
+func shortcodeGallery(section string, args []string, conf *config.Config) string {
+	if len(args) == 0 {
+		return "<div class=\"gallery gallery-error\">Missing gallery folder</div>"
+	}
+	dir, err := CleanContentName(path.Join(section, args[0]))
+	if err != nil {
+		return "<div class=\"gallery gallery-error\">Invalid gallery folder</div>"
+	}
+	width := GetThumbnailWidth(conf)
+	if len(args) > 1 {
+		if w, err := strconv.Atoi(args[1]); err == nil && w > 0 && w <= maxThumbnailWidth {
+			width = w
+		}
+	}
+	files, err := content.GetContentStore(conf).ReadDir(dir)
+	if err != nil {
+		return "<div class=\"gallery gallery-error\">Gallery folder not found</div>"
+	}
+	var names []string
+	for _, fi := range files {
+		if !fi.IsDir() && GetImageType(fi.Name()) != "" {
+			names = append(names, fi.Name())
+		}
+	}
+	sort.Strings(names)
+
+	name := html.EscapeString(args[0])
+	var out strings.Builder
+	out.WriteString("<div class=\"gallery\" data-gallery=\"" + name + "\">\n")
+	for _, file := range names {
+		image := dir + "/" + file
+		w, h, err := GetImageSize(image, conf)
+		if err != nil {
+			continue
+		}
+		tw, th := GetThumbnailSize(w, h, width)
+		caption := html.EscapeString(strings.TrimSuffix(file, path.Ext(file)))
+		out.WriteString("<figure class=\"gallery-item\">" +
+			"<a href=\"" + GetMediaLink(image) + "\" data-lightbox=\"" + name + "\"" +
+			" data-width=\"" + strconv.Itoa(w) + "\" data-height=\"" + strconv.Itoa(h) + "\">" +
+			"<img src=\"" + GetThumbnailLink(image, width) + "\" alt=\"" + caption + "\"" +
+			" width=\"" + strconv.Itoa(tw) + "\" height=\"" + strconv.Itoa(th) + "\" loading=\"lazy\">" +
+			"</a></figure>\n")
+	}
+	out.WriteString("</div>")
+	return out.String()
+}
@@ -0,0 +1,76 @@
+package render
+
+import (
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"html"
+	"strings"
+)
+
+// Script rendering the formulas of a page with KaTeX
+const mathScript = `<script type="text/javascript">
+    document.addEventListener("DOMContentLoaded", function () {
+      document.querySelectorAll(".math").forEach(function (e) {
+        katex.render(e.textContent, e, {displayMode: e.classList.contains("math-display"), throwOnError: false});
+      });
+    });
+    </script>`
+
+/**
+ * Returns the link to a file of the static folder with the checksum of its
+ * content as version, so browsers keeping static assets for long fetch them
+ * again once they change. Other links, and links already holding a query,
+ * are returned as they are
+ */
+func GetAssetURL(link string) string {
+	if !strings.HasPrefix(link, "/") || strings.HasPrefix(link, "//") || strings.ContainsAny(link, "?#") {
+		return link
+	}
+	sum, err := content.HashFile(content.GetSiteStore("static", "static"), strings.TrimPrefix(link, "/"))
+	if err != nil {
+		return link
+	}
+	return link + "?v=" + sum[:10]
+}
+
+/**
+ * Returns the extra markup a page asks to place in the head: the stylesheets
+ * and scripts of the named assets and of the css and js front matter keys,
+ * fingerprinted when they are files of the static folder, KaTeX for the pages
+ * with math, Mermaid for the pages with diagrams left to the browser,
+ * followed by the raw head front matter snippet
+ */
+func GetHeadExtra(p content.Page, conf *config.Config) string {
+	var css, js, tags []string
+	math := p.Meta.Bool("math") && len(conf.Math.Js) > 0
+	if math {
+		css = append(css, conf.Math.Css)
+		js = append(js, conf.Math.Js)
+	}
+	for _, name := range p.Meta.List("assets") {
+		if bundle, ok := conf.Assets[name]; ok {
+			css = append(css, bundle.Css...)
+			js = append(js, bundle.Js...)
+		}
+	}
+	css = append(css, p.Meta.List("css")...)
+	js = append(js, p.Meta.List("js")...)
+	for _, link := range css {
+		tags = append(tags, "<link href=\""+html.EscapeString(GetAssetURL(link))+"\" rel=\"stylesheet\">")
+	}
+	for _, link := range js {
+		tags = append(tags, "<script type=\"text/javascript\" src=\""+html.EscapeString(GetAssetURL(link))+"\" defer></script>")
+	}
+	if math {
+		// Deferred scripts run before DOMContentLoaded
+		tags = append(tags, mathScript)
+	}
+	if HasMermaid(p.Body) && len(conf.Mermaid.Command) == 0 && len(conf.Mermaid.Js) > 0 {
+		tags = append(tags, "<script type=\"module\">import mermaid from \""+html.EscapeString(conf.Mermaid.Js)+
+			"\"; mermaid.initialize({startOnLoad: true});</script>")
+	}
+	if head := p.Meta.Get("head"); len(head) > 0 {
+		tags = append(tags, head)
+	}
+	return strings.Join(tags, "\n    ")
+}
@@ -0,0 +1,191 @@
+package render
+
+import (
+	"bytes"
+	"errors"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Widest thumbnail the image pipeline generates
+const maxThumbnailWidth = 2000
+
+// MIME types of the images handled by the image pipeline, by extension
+var imageTypes = map[string]string{
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".png":  "image/png",
+	".gif":  "image/gif",
+}
+
+/**
+ * Returns the MIME type of an image file, or an empty string for the files
+ * that are not images
+ */
+func GetImageType(name string) string {
+	return imageTypes[strings.ToLower(path.Ext(name))]
+}
+
+/**
+ * Returns the clean form of the name of a file of the content store, or an
+ * error when the name points outside the content folder
+ */
+func CleanContentName(name string) (string, error) {
+	name = path.Clean("/" + name)[1:]
+	if len(name) == 0 {
+		return "", errors.New("Invalid file name")
+	}
+	return name, nil
+}
+
+/**
+ * Returns the width and height of an image of the content store
+ */
+func GetImageSize(name string, conf *config.Config) (int, int, error) {
+	bs, err := content.GetContentStore(conf).ReadFile(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	c, _, err := image.DecodeConfig(bytes.NewReader(bs))
+	return c.Width, c.Height, err
+}
+
+/**
+ * Returns the default width of the thumbnails
+ */
+func GetThumbnailWidth(conf *config.Config) int {
+	if conf.Images.ThumbnailWidth <= 0 {
+		return 300
+	}
+	return conf.Images.ThumbnailWidth
+}
+
+/**
+ * Returns the size of an image scaled down to the given width, keeping its
+ * proportions. Images narrower than the width keep their size
+ */
+func GetThumbnailSize(width int, height int, thumbnail int) (int, int) {
+	if width <= thumbnail || width == 0 {
+		return width, height
+	}
+	h := height * thumbnail / width
+	if h == 0 {
+		h = 1
+	}
+	return thumbnail, h
+}
+
+/**
+ * Returns the store caching the images generated by the pipeline, the
+ * Images.CacheFolder
+ */
+func getImageCache(conf *config.Config) content.Store {
+	folder := conf.Images.CacheFolder
+	if len(folder) == 0 {
+		folder = "thumbs"
+	}
+	return content.OpenDataStore(folder)
+}
+
+/**
+ * Returns the quality of the JPEG images the pipeline encodes, 85 by default
+ */
+func getJpegQuality(conf *config.Config) int {
+	if conf.Images.Quality <= 0 || conf.Images.Quality > 100 {
+		return 85
+	}
+	return conf.Images.Quality
+}
+
+/**
+ * Scales an image down to the given size, averaging the pixels each thumbnail
+ * pixel covers
+ */
+func resizeImage(src image.Image, width int, height int) image.Image {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0, y1 := b.Min.Y+y*b.Dy()/height, b.Min.Y+(y+1)*b.Dy()/height
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < width; x++ {
+			x0, x1 := b.Min.X+x*b.Dx()/width, b.Min.X+(x+1)*b.Dx()/width
+			if x1 == x0 {
+				x1++
+			}
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+uint64(cr), g+uint64(cg), bl+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			dst.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+	return dst
+}
+
+/**
+ * Returns the thumbnail of an image of the content store scaled down to the
+ * given width, along with its MIME type. Thumbnails are cached in the
+ * Images.CacheFolder by width and checksum of the image, so a changed image
+ * gets a new thumbnail. JPEG images give JPEG thumbnails, the other ones PNG
+ * thumbnails
+ */
+func GetThumbnail(name string, width int, conf *config.Config) ([]byte, string, error) {
+	if GetImageType(name) == "" || width <= 0 || width > maxThumbnailWidth {
+		return nil, "", errors.New("Invalid thumbnail")
+	}
+	store := content.GetContentStore(conf)
+	sum, err := content.HashFile(store, name)
+	if err != nil {
+		return nil, "", err
+	}
+	mime := "image/png"
+	if GetImageType(name) == "image/jpeg" {
+		mime = "image/jpeg"
+	}
+	cache, cached := getImageCache(conf), strconv.Itoa(width)+"/"+sum+path.Ext(name)
+	if bs, err := cache.ReadFile(cached); err == nil {
+		return bs, mime, nil
+	}
+
+	bs, err := store.ReadFile(name)
+	if err != nil {
+		return nil, "", err
+	}
+	var src image.Image
+	if GetImageType(name) == "image/gif" {
+		// Animated images are reduced to their first frame
+		src, err = gif.Decode(bytes.NewReader(bs))
+	} else {
+		src, _, err = image.Decode(bytes.NewReader(bs))
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	w, h := GetThumbnailSize(src.Bounds().Dx(), src.Bounds().Dy(), width)
+	thumbnail := resizeImage(src, w, h)
+	var out bytes.Buffer
+	if mime == "image/jpeg" {
+		err = jpeg.Encode(&out, thumbnail, &jpeg.Options{Quality: getJpegQuality(conf)})
+	} else {
+		err = png.Encode(&out, thumbnail)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	// A thumbnail that cannot be cached is generated again next time
+	cache.WriteFile(cached, out.Bytes())
+	return out.Bytes(), mime, nil
+}
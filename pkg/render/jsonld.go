@@ -0,0 +1,101 @@
+package render
+
+import (
+	"encoding/json"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+)
+
+// Struct representing a step of a breadcrumb trail
+type Breadcrumb struct {
+	Name, Url string
+}
+
+/**
+ * Returns a schema.org BreadcrumbList for the given trail
+ */
+func GetBreadcrumbList(trail []Breadcrumb) map[string]interface{} {
+	items := make([]interface{}, 0, len(trail))
+	for i, crumb := range trail {
+		items = append(items, map[string]interface{}{
+			"@type":    "ListItem",
+			"position": i + 1,
+			"name":     crumb.Name,
+			"item":     crumb.Url,
+		})
+	}
+	return map[string]interface{}{"@type": "BreadcrumbList", "itemListElement": items}
+}
+
+/**
+ * Returns a schema.org BlogPosting for an article, assembled from its front
+ * matter. Setting "schema: Article" in the front matter changes the type
+ */
+func GetArticleSchema(page content.Page, og OpenGraph, conf *config.Config) map[string]interface{} {
+	schemaType := page.Meta.Get("schema")
+	if len(schemaType) == 0 {
+		schemaType = "BlogPosting"
+	}
+	author := page.Meta.Get("author")
+	if len(author) == 0 {
+		author = conf.Site.Author
+	}
+	article := map[string]interface{}{
+		"@type":            schemaType,
+		"headline":         og.Title,
+		"url":              og.Url,
+		"mainEntityOfPage": og.Url,
+	}
+	if len(og.Description) > 0 {
+		article["description"] = og.Description
+	}
+	if len(og.Image) > 0 {
+		article["image"] = og.Image
+	}
+	if len(author) > 0 {
+		article["author"] = map[string]interface{}{"@type": "Person", "name": author}
+	}
+	if len(conf.Site.Title) > 0 {
+		article["publisher"] = map[string]interface{}{"@type": "Organization", "name": conf.Site.Title}
+	}
+	if date := page.Meta.Get("date"); len(date) > 0 {
+		article["datePublished"] = date
+		article["dateModified"] = date
+	}
+	if updated := page.Meta.Get("updated"); len(updated) > 0 {
+		article["dateModified"] = updated
+	}
+	return article
+}
+
+/**
+ * Returns a schema.org WebSite for the site
+ */
+func GetWebSiteSchema(root string, conf *config.Config) map[string]interface{} {
+	site := map[string]interface{}{
+		"@type": "WebSite",
+		"url":   root + "/",
+	}
+	if len(conf.Site.Title) > 0 {
+		site["name"] = conf.Site.Title
+	}
+	if len(conf.Site.Description) > 0 {
+		site["description"] = conf.Site.Description
+	}
+	return site
+}
+
+/**
+ * Returns a JSON-LD script tag holding the given schema.org entities, ready to
+ * be placed in the page
+ */
+func GetStructuredData(entities ...map[string]interface{}) string {
+	bs, err := json.Marshal(map[string]interface{}{
+		"@context": "https://schema.org",
+		"@graph":   entities,
+	})
+	if err != nil {
+		return ""
+	}
+	return "<script type=\"application/ld+json\">" + string(bs) + "</script>"
+}
@@ -0,0 +1,99 @@
+package render
+
+import (
+	"bytes"
+	"errors"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"html"
+	"image"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Image tags of rendered markdown, and their attributes
+var (
+	imgPattern     = regexp.MustCompile(`<img\b[^>]*>`)
+	imgSrcPattern  = regexp.MustCompile(`\ssrc="([^"]*)"`)
+	thumbsPattern  = regexp.MustCompile(`^/thumbs/([0-9]+)/(.+)$`)
+	sizeAttributes = regexp.MustCompile(`\s(width|height)=`)
+)
+
+// Error of a link which is not one of an image of the site
+var errNotSiteImage = errors.New("Not an image of the site")
+
+/**
+ * Returns the width and height of the image a link of the site points to:
+ * images of the content folder under /media, their thumbnails under /thumbs
+ * and the images of the static folder. Fails for the other links
+ */
+func getLinkedImageSize(src string, conf *config.Config) (int, int, error) {
+	link, err := url.Parse(html.UnescapeString(src))
+	if err != nil || len(link.Host) > 0 || !strings.HasPrefix(link.Path, "/") {
+		return 0, 0, errNotSiteImage
+	}
+	name, err := CleanContentName(link.Path)
+	if err != nil || GetImageType(name) == "" {
+		return 0, 0, errNotSiteImage
+	}
+	if strings.HasPrefix(name, "media/") {
+		return GetImageSize(strings.TrimPrefix(name, "media/"), conf)
+	}
+	if m := thumbsPattern.FindStringSubmatch("/" + name); m != nil {
+		width, _ := strconv.Atoi(m[1])
+		w, h, err := GetImageSize(m[2], conf)
+		if err != nil {
+			return 0, 0, err
+		}
+		w, h = GetThumbnailSize(w, h, width)
+		return w, h, nil
+	}
+	bs, err := content.GetSiteStore("static", "static").ReadFile(name)
+	if err != nil {
+		return 0, 0, err
+	}
+	c, _, err := image.DecodeConfig(bytes.NewReader(bs))
+	return c.Width, c.Height, err
+}
+
+/**
+ * Adds loading="lazy" and decoding="async" to the image tags of rendered
+ * markdown, along with their width and height when they have none and the
+ * image is one of the site, so the page does not move as images load
+ */
+func addImageAttributes(rendered string, conf *config.Config) string {
+	if !strings.Contains(rendered, "<img") {
+		return rendered
+	}
+	return imgPattern.ReplaceAllStringFunc(rendered, func(tag string) string {
+		end := strings.TrimSuffix(strings.TrimSuffix(tag[:len(tag)-1], "/"), " ")
+		var attributes string
+		if !strings.Contains(tag, " loading=") {
+			attributes += " loading=\"lazy\""
+		}
+		if !strings.Contains(tag, " decoding=") {
+			attributes += " decoding=\"async\""
+		}
+		if m := imgSrcPattern.FindStringSubmatch(tag); m != nil && !sizeAttributes.MatchString(tag) {
+			if w, h, err := getLinkedImageSize(m[1], conf); err == nil {
+				attributes += " width=\"" + strconv.Itoa(w) + "\" height=\"" + strconv.Itoa(h) + "\""
+			}
+		}
+		return end + attributes + tag[len(end):]
+	})
+}
+
+/**
+ * Registers the lazy loading of the images of rendered markdown, when
+ * Images.LazyLoading is set
+ */
+func RegisterLazyImages(conf *config.Config) {
+	if !conf.Images.LazyLoading {
+		return
+	}
+	OnMarkdownRendered(func(section string, slug string, html string) string {
+		return addImageAttributes(html, conf)
+	})
+}
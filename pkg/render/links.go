@@ -0,0 +1,36 @@
+package render
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Matches the attributes of HTML elements linking to the site by path
+var linkAttrPattern = regexp.MustCompile(`(\s(?:href|src|action|poster|data-src)=["'])(/(?:[^/"'][^"']*)?)(["'])`)
+
+// Matches the srcset attributes of HTML elements
+var srcsetPattern = regexp.MustCompile(`(\ssrcset=["'])([^"']*)(["'])`)
+
+/**
+ * Rewrites the links to paths of the site in a page, the href, src, action,
+ * poster, data-src and srcset attributes starting with a single slash, with
+ * the given function
+ */
+func RewriteLinks(html []byte, rewrite func(link string) string) []byte {
+	html = linkAttrPattern.ReplaceAllFunc(html, func(match []byte) []byte {
+		parts := linkAttrPattern.FindSubmatch(match)
+		return []byte(string(parts[1]) + rewrite(string(parts[2])) + string(parts[3]))
+	})
+	return srcsetPattern.ReplaceAllFunc(html, func(match []byte) []byte {
+		parts := srcsetPattern.FindSubmatch(match)
+		candidates := strings.Split(string(parts[2]), ",")
+		for i, candidate := range candidates {
+			fields := strings.Fields(candidate)
+			if len(fields) > 0 && strings.HasPrefix(fields[0], "/") && !strings.HasPrefix(fields[0], "//") {
+				fields[0] = rewrite(fields[0])
+			}
+			candidates[i] = strings.Join(fields, " ")
+		}
+		return []byte(string(parts[1]) + strings.Join(candidates, ", ") + string(parts[3]))
+	})
+}
@@ -0,0 +1,219 @@
+package render
+
+import (
+	"fmt"
+	"github.com/flosch/pongo"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Struct representing a mistake found in a template of the theme, at a line
+// of its source when known
+type LintError struct {
+	Template string
+	Line     int
+	Message  string
+}
+
+// Returns the mistake prefixed with its template and line
+func (e LintError) Error() string {
+	if e.Line > 0 {
+		return e.Template + ":" + strconv.Itoa(e.Line) + ": " + e.Message
+	}
+	return e.Template + ": " + e.Message
+}
+
+// Variables and tags of pongo templates
+var pongoBlockPattern = regexp.MustCompile(`(?s)\{\{.*?\}\}|\{%.*?%\}`)
+
+// String literals of pongo expressions, left out when looking for filters
+var pongoStringPattern = regexp.MustCompile(`"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'`)
+
+// Filters applied in pongo expressions
+var pongoFilterPattern = regexp.MustCompile(`\|\s*([A-Za-z_][A-Za-z0-9_]*)`)
+
+// Templates extended or included by name by pongo templates
+var pongoIncludePattern = regexp.MustCompile(`^\{%-?\s*(?:include|extends)\s+("[^"]*"|'[^']*')`)
+
+// Templates executed by name by html/template templates
+var htmlTemplatePattern = regexp.MustCompile(`\{\{-?\s*template\s+"([^"]+)"`)
+
+/**
+ * Returns the line of a template source holding the given offset
+ */
+func getLine(source string, offset int) int {
+	return strings.Count(source[:offset], "\n") + 1
+}
+
+/**
+ * Returns the filters used by a pongo template which are not registered, and
+ * the templates it extends or includes which are missing from the theme
+ */
+func lintPongo(store content.Store, name string, source string) []LintError {
+	var problems []LintError
+	for _, loc := range pongoBlockPattern.FindAllStringIndex(source, -1) {
+		block := source[loc[0]:loc[1]]
+		line := getLine(source, loc[0])
+		if m := pongoIncludePattern.FindStringSubmatch(block); m != nil {
+			included := strings.Trim(m[1], "\"'")
+			if _, err := store.Stat(included); err != nil {
+				problems = append(problems, LintError{name, line, "includes the undefined template " + included})
+			}
+		}
+		expression := pongoStringPattern.ReplaceAllString(block, `""`)
+		for _, m := range pongoFilterPattern.FindAllStringSubmatch(expression, -1) {
+			if _, ok := pongo.Filters[m[1]]; !ok {
+				problems = append(problems, LintError{name, line, "uses the unknown filter " + m[1]})
+			}
+		}
+	}
+	return problems
+}
+
+/**
+ * Returns the templates executed by an html/template page, its layout or its
+ * partials which none of them defines
+ */
+func lintHtml(e HtmlEngine, name string) []LintError {
+	tpl, _, err := e.parse(name)
+	if err != nil {
+		return []LintError{{Template: name, Message: err.Error()}}
+	}
+	var problems []LintError
+	files := append(getTemplateNames(e.Store, "layouts"), getTemplateNames(e.Store, "partials")...)
+	for _, file := range append(files, name) {
+		bs, err := e.Store.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		source := string(bs)
+		for _, loc := range htmlTemplatePattern.FindAllStringSubmatchIndex(source, -1) {
+			executed := source[loc[2]:loc[3]]
+			if tpl.Lookup(executed) == nil {
+				problems = append(problems, LintError{file, getLine(source, loc[0]),
+					"executes the undefined template " + executed})
+			}
+		}
+	}
+	return problems
+}
+
+/**
+ * Returns the contexts templates are executed with to be linted, named after
+ * the kind of page they stand for: the homepage and an article, the newest
+ * one of the site when there is one
+ */
+func getSampleContexts(conf *config.Config) map[string]map[string]interface{} {
+	menu, _ := content.GetMenu(conf)
+	home := map[string]interface{}{
+		"site":          conf.Site,
+		"menu":          menu,
+		"contactFields": conf.Contact.Fields,
+		"currentMenu":   config.MenuItem{Title: conf.Site.Title, Link: content.GetLinkPrefix(conf) + "/"},
+		"isHome":        true,
+		"canonical":     content.GetSiteURL(conf) + "/",
+		"description":   conf.Site.Description,
+		"content":       "<p>" + conf.Site.Description + "</p>",
+	}
+	article := make(map[string]interface{})
+	for key, value := range home {
+		article[key] = value
+	}
+	page := content.Page{Meta: content.FrontMatter{"title": "Sample article"}, Body: "# Sample article\n\nSample text."}
+	section, slug := "", "sample"
+	if len(menu) > 0 {
+		section = menu[0].Section
+		if items, err := content.GetSectionArticles(section, conf); err == nil && len(items) > 0 {
+			slug = items[0].Slug
+			if p, err := content.GetPublishedPage(section, slug, conf); err == nil {
+				page = p
+			}
+		}
+		article["currentMenu"] = menu.GetCurrent(section, conf)
+	}
+	article["isHome"] = false
+	article["meta"] = page.Meta
+	article["content"] = PageMarkdown(section, slug, page)
+	article["description"] = content.GetPageDescription(page)
+	article["keywords"] = strings.Join(page.Meta.List("keywords"), ", ")
+	article["updated"] = time.Now()
+	article["canonical"] = content.GetSiteURL(conf) + content.GetArticleLink(section, slug, conf)
+	return map[string]map[string]interface{}{"home": home, "article": article}
+}
+
+/**
+ * Executes a template with a sample context, turning the panics of the
+ * engine into errors
+ */
+func executeSample(engine Engine, name string, context map[string]interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panics: %v", r)
+		}
+	}()
+	_, err = engine.Render(name, context)
+	return err
+}
+
+/**
+ * Parses every template of the theme and returns its mistakes: unknown
+ * filters and undefined includes of pongo templates, templates executed but
+ * never defined by html/template ones. With execute, the page templates, the
+ * ones at the top of the template folder, are also rendered with sample
+ * contexts, catching the errors and panics which would otherwise only show
+ * when serving a page
+ */
+func LintTemplates(conf *config.Config, execute bool) []LintError {
+	var problems []LintError
+	store := content.GetTemplateStore(conf)
+	engine := GetEngine(conf)
+	seen := make(map[LintError]bool)
+	var contexts map[string]map[string]interface{}
+	var kinds []string
+	if execute {
+		contexts = getSampleContexts(conf)
+		for kind := range contexts {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+	}
+	for _, name := range getTemplateNames(store, "") {
+		page := !strings.Contains(name, "/")
+		var found []LintError
+		if html, ok := engine.(HtmlEngine); ok {
+			// Layouts and partials are parsed with each page
+			if !page {
+				continue
+			}
+			found = lintHtml(html, name)
+		} else if bs, err := store.ReadFile(name); err != nil {
+			found = []LintError{{Template: name, Message: err.Error()}}
+		} else if found = lintPongo(store, name, string(bs)); len(found) == 0 {
+			if err = engine.(PongoEngine).Compile(name); err != nil {
+				found = []LintError{{Template: name, Message: err.Error()}}
+			}
+		}
+		for _, problem := range found {
+			// Mistakes of layouts and partials show once, not with every page
+			if !seen[problem] {
+				seen[problem] = true
+				problems = append(problems, problem)
+			}
+		}
+		if !page || len(found) > 0 {
+			continue
+		}
+		for _, kind := range kinds {
+			if err := executeSample(engine, name, contexts[kind]); err != nil {
+				problems = append(problems, LintError{Template: name,
+					Message: "fails with the " + kind + " sample: " + err.Error()})
+			}
+		}
+	}
+	return problems
+}
@@ -0,0 +1,44 @@
+package render
+
+import (
+	"github.com/russross/blackfriday"
+)
+
+// Hook called once markdown is rendered, returning the HTML to use instead.
+// Listings are rendered with an empty slug
+type MarkdownRenderedHook func(section string, slug string, html string) string
+
+var markdownRenderedHooks []MarkdownRenderedHook
+
+/**
+ * Registers a hook called when markdown is rendered
+ */
+func OnMarkdownRendered(hook MarkdownRenderedHook) {
+	markdownRenderedHooks = append(markdownRenderedHooks, hook)
+}
+
+/**
+ * Renders markdown to HTML, expanding its shortcodes and passing the result
+ * through the markdown rendered hooks
+ */
+func Markdown(section string, slug string, markdown string) string {
+	markdown = expandShortcodes(section, slug, markdown)
+	return runMarkdownHooks(section, slug, renderMarkdown(markdown))
+}
+
+/**
+ * Renders markdown to HTML
+ */
+func renderMarkdown(markdown string) string {
+	return string(blackfriday.MarkdownCommon([]byte(markdown)))
+}
+
+/**
+ * Passes rendered markdown through the markdown rendered hooks
+ */
+func runMarkdownHooks(section string, slug string, html string) string {
+	for _, hook := range markdownRenderedHooks {
+		html = hook(section, slug, html)
+	}
+	return html
+}
@@ -0,0 +1,111 @@
+package render
+
+import (
+	"github.com/rredpoppy/gosite/pkg/content"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Code blocks and spans, left as they are, and the display and inline math
+// of a markdown text. Inline math starts and ends next to a non space
+// character and stays on one line, so prices like $5 and $10 are not math
+var mathPattern = regexp.MustCompile("(?s)```.*?```|~~~.*?~~~|`[^`\n]+`|\\$\\$(.+?)\\$\\$|\\$([^\\s$`](?:[^$`\n]*[^\\s$`\\\\])?)\\$")
+
+// Placeholders of the math of a text while it is rendered as markdown
+var mathPlaceholderPattern = regexp.MustCompile(`(<p>)?gositemath([0-9]+)x(</p>)?`)
+
+/**
+ * Returns the markup of a formula, rendered in the browser by KaTeX
+ */
+func getMathMarkup(tex string, display bool, block bool) string {
+	if display && block {
+		return "<div class=\"math math-display\">" + html.EscapeString(tex) + "</div>"
+	}
+	class := "math math-inline"
+	if display {
+		class = "math math-display"
+	}
+	return "<span class=\"" + class + "\">" + html.EscapeString(tex) + "</span>"
+}
+
+/**
+ * Replaces the math of a markdown text with placeholders the markdown
+ * renderer leaves alone, returning the text and the formulas. Display math is
+ * written $$...$$ and inline math $...$, outside of code
+ */
+func protectMath(markdown string) (string, []string, []bool) {
+	var formulas []string
+	var display []bool
+	var out strings.Builder
+	last, pos := 0, 0
+	for {
+		m := mathPattern.FindStringSubmatchIndex(markdown[pos:])
+		if m == nil {
+			break
+		}
+		for i := range m {
+			if m[i] >= 0 {
+				m[i] += pos
+			}
+		}
+		var tex string
+		switch {
+		case m[2] >= 0:
+			tex = markdown[m[2]:m[3]]
+		case m[4] >= 0:
+			// Inline math is not followed by a digit, as in "$5 to $10", the
+			// closing dollar then possibly opening other math
+			if m[1] < len(markdown) && markdown[m[1]] >= '0' && markdown[m[1]] <= '9' {
+				pos = m[0] + 1
+				continue
+			}
+			tex = markdown[m[4]:m[5]]
+		default:
+			pos = m[1]
+			continue
+		}
+		pos = m[1]
+		out.WriteString(markdown[last:m[0]])
+		out.WriteString("gositemath" + strconv.Itoa(len(formulas)) + "x")
+		formulas = append(formulas, strings.TrimSpace(tex))
+		display = append(display, m[2] >= 0)
+		last = m[1]
+	}
+	out.WriteString(markdown[last:])
+	return out.String(), formulas, display
+}
+
+/**
+ * Puts the formulas back in place of their placeholders in rendered HTML.
+ * Display math alone in a paragraph becomes a block of its own
+ */
+func restoreMath(html string, formulas []string, display []bool) string {
+	return mathPlaceholderPattern.ReplaceAllStringFunc(html, func(s string) string {
+		m := mathPlaceholderPattern.FindStringSubmatch(s)
+		i, err := strconv.Atoi(m[2])
+		if err != nil || i >= len(formulas) {
+			return s
+		}
+		block := len(m[1]) > 0 && len(m[3]) > 0
+		if block && display[i] {
+			return getMathMarkup(formulas[i], true, true)
+		}
+		return m[1] + getMathMarkup(formulas[i], display[i], false) + m[3]
+	})
+}
+
+/**
+ * Renders the markdown body of a page. Pages declaring math: true get their
+ * $...$ and $$...$$ formulas kept out of the markdown renderer, and output as
+ * markup KaTeX renders in the browser
+ */
+func PageMarkdown(section string, slug string, p content.Page) string {
+	if !p.Meta.Bool("math") {
+		return Markdown(section, slug, p.Body)
+	}
+	markdown, formulas, display := protectMath(p.Body)
+	markdown = expandShortcodes(section, slug, markdown)
+	return runMarkdownHooks(section, slug, restoreMath(renderMarkdown(markdown), formulas, display))
+}
@@ -0,0 +1,89 @@
+package render
+
+import (
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"html"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Mermaid fenced code blocks, as rendered by the markdown renderer
+var mermaidPattern = regexp.MustCompile(`(?s)<pre><code class="language-mermaid">(.*?)</code></pre>`)
+
+/**
+ * Returns true if a markdown text holds a mermaid diagram
+ */
+func HasMermaid(markdown string) bool {
+	return strings.Contains(markdown, "```mermaid")
+}
+
+/**
+ * Renders a diagram to SVG with the Mermaid.Command, mermaid-cli's mmdc for
+ * instance, called with the -i and -o options. Diagrams are cached in the
+ * Mermaid.CacheFolder by checksum, so each one is only rendered once
+ */
+func renderMermaidSvg(diagram string, conf *config.Config) (string, error) {
+	name := content.Hash([]byte(diagram)) + ".svg"
+	folder := conf.Mermaid.CacheFolder
+	if len(folder) == 0 {
+		folder = "diagrams"
+	}
+	cache := content.OpenDataStore(folder)
+	if bs, err := cache.ReadFile(name); err == nil {
+		return string(bs), nil
+	}
+	dir, err := ioutil.TempDir("", "gosite-mermaid")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+	in, out := filepath.Join(dir, "diagram.mmd"), filepath.Join(dir, "diagram.svg")
+	if err = ioutil.WriteFile(in, []byte(diagram), 0644); err != nil {
+		return "", err
+	}
+	if err = exec.Command(conf.Mermaid.Command, "-i", in, "-o", out).Run(); err != nil {
+		return "", err
+	}
+	bs, err := ioutil.ReadFile(out)
+	if err != nil {
+		return "", err
+	}
+	// A diagram that cannot be cached is rendered again next time
+	cache.WriteFile(name, bs)
+	return string(bs), nil
+}
+
+/**
+ * Turns the mermaid fenced code blocks of rendered markdown into diagrams:
+ * SVG images when a Mermaid.Command is set, and otherwise containers the
+ * Mermaid script renders in the browser. Diagrams failing to render on the
+ * server are left to the browser as well
+ */
+func renderMermaid(rendered string, conf *config.Config) string {
+	if !strings.Contains(rendered, "language-mermaid") {
+		return rendered
+	}
+	return mermaidPattern.ReplaceAllStringFunc(rendered, func(s string) string {
+		code := mermaidPattern.FindStringSubmatch(s)[1]
+		if len(conf.Mermaid.Command) > 0 {
+			if svg, err := renderMermaidSvg(html.UnescapeString(code), conf); err == nil {
+				return "<figure class=\"mermaid-diagram\">" + svg + "</figure>"
+			}
+		}
+		return "<pre class=\"mermaid\">" + code + "</pre>"
+	})
+}
+
+/**
+ * Registers the handling of diagrams written in mermaid fenced code blocks
+ */
+func RegisterDiagrams(conf *config.Config) {
+	OnMarkdownRendered(func(section string, slug string, html string) string {
+		return renderMermaid(html, conf)
+	})
+}
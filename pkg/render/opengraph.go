@@ -0,0 +1,86 @@
+package render
+
+import (
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"html"
+	"strings"
+)
+
+// Struct representing the Open Graph and Twitter Card data of a page
+type OpenGraph struct {
+	Title, Description, Image, Type, Url, SiteName string
+	TwitterCard, TwitterSite                       string
+}
+
+/**
+ * Returns the Open Graph data of an article, built from its front matter with
+ * the site config as fallback
+ */
+func GetArticleOpenGraph(page content.Page, link string, root string, conf *config.Config) OpenGraph {
+	og := OpenGraph{
+		Title:       content.GetPageTitle(page),
+		Description: content.GetPageDescription(page),
+		Image:       page.Meta.Get("image"),
+		Type:        "article",
+		Url:         link,
+		SiteName:    conf.Site.Title,
+		TwitterSite: conf.Site.TwitterHandle,
+	}
+	if len(og.Description) == 0 {
+		og.Description = conf.Site.Description
+	}
+	if strings.HasPrefix(og.Image, "/") {
+		og.Image = root + og.Image
+	}
+	og.TwitterCard = "summary"
+	if len(og.Image) > 0 {
+		og.TwitterCard = "summary_large_image"
+	}
+	return og
+}
+
+/**
+ * Returns the Open Graph data of a listing page
+ */
+func GetListingOpenGraph(title string, link string, conf *config.Config) OpenGraph {
+	return OpenGraph{
+		Title:       title,
+		Description: conf.Site.Description,
+		Type:        "website",
+		Url:         link,
+		SiteName:    conf.Site.Title,
+		TwitterCard: "summary",
+		TwitterSite: conf.Site.TwitterHandle,
+	}
+}
+
+/**
+ * Returns the Open Graph and Twitter Card meta tags, ready to be placed in the
+ * head of the page
+ */
+func (og OpenGraph) Html() string {
+	var tags []string
+	property := func(name, value string) {
+		if len(value) > 0 {
+			tags = append(tags, "<meta property=\""+name+"\" content=\""+html.EscapeString(value)+"\">")
+		}
+	}
+	name := func(name, value string) {
+		if len(value) > 0 {
+			tags = append(tags, "<meta name=\""+name+"\" content=\""+html.EscapeString(value)+"\">")
+		}
+	}
+	property("og:title", og.Title)
+	property("og:description", og.Description)
+	property("og:image", og.Image)
+	property("og:type", og.Type)
+	property("og:url", og.Url)
+	property("og:site_name", og.SiteName)
+	name("twitter:card", og.TwitterCard)
+	name("twitter:site", og.TwitterSite)
+	name("twitter:title", og.Title)
+	name("twitter:description", og.Description)
+	name("twitter:image", og.Image)
+	return strings.Join(tags, "\n    ")
+}
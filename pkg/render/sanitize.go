@@ -0,0 +1,160 @@
+package render
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+// Largest image re-encoded, in pixels, so a small file cannot claim a huge
+// picture and exhaust the memory
+const maxSanitizedPixels = 100000000
+
+/**
+ * Returns the EXIF orientation of a JPEG image, from 1 to 8, 1 when the image
+ * has none
+ */
+func getJpegOrientation(bs []byte) int {
+	// Segments follow the start of image marker until the image data
+	for i := 2; i+4 <= len(bs) && bs[i] == 0xFF; {
+		marker, size := bs[i+1], int(binary.BigEndian.Uint16(bs[i+2:]))
+		if marker == 0xDA || size < 2 || i+2+size > len(bs) {
+			break
+		}
+		segment := bs[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return getExifOrientation(segment[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+/**
+ * Returns the orientation tag of the first directory of EXIF data, 1 when it
+ * is missing or invalid
+ */
+func getExifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	ifd := int64(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > int64(len(tiff)) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for e := 0; e < entries; e++ {
+		entry := int(ifd) + 2 + e*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			break
+		}
+	}
+	return 1
+}
+
+/**
+ * Turns an image the way its EXIF orientation says, so it shows upright once
+ * the EXIF data is gone
+ */
+func orientImage(src image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	// Orientations from 5 on swap width and height
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			sx, sy := x, y
+			switch orientation {
+			case 2:
+				sx = w - 1 - x
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sy = h - 1 - y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			dst.Set(x, y, src.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return dst
+}
+
+/**
+ * Re-encodes an image, dropping its EXIF data, location included, comments
+ * and any other metadata. JPEG images are turned upright first and encoded
+ * with the Images.Quality; animated GIF images keep their frames
+ */
+func SanitizeImage(bs []byte, name string, conf *config.Config) ([]byte, error) {
+	c, _, err := image.DecodeConfig(bytes.NewReader(bs))
+	if err != nil {
+		return nil, err
+	}
+	if int64(c.Width)*int64(c.Height) > maxSanitizedPixels {
+		return nil, errors.New("Image too large")
+	}
+	var out bytes.Buffer
+	switch GetImageType(name) {
+	case "image/jpeg":
+		src, err := jpeg.Decode(bytes.NewReader(bs))
+		if err != nil {
+			return nil, err
+		}
+		err = jpeg.Encode(&out, orientImage(src, getJpegOrientation(bs)), &jpeg.Options{Quality: getJpegQuality(conf)})
+		if err != nil {
+			return nil, err
+		}
+	case "image/png":
+		src, err := png.Decode(bytes.NewReader(bs))
+		if err != nil {
+			return nil, err
+		}
+		if err = png.Encode(&out, src); err != nil {
+			return nil, err
+		}
+	case "image/gif":
+		src, err := gif.DecodeAll(bytes.NewReader(bs))
+		if err != nil {
+			return nil, err
+		}
+		if err = gif.EncodeAll(&out, src); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, errors.New("Unsupported image")
+	}
+	return out.Bytes(), nil
+}
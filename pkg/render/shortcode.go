@@ -0,0 +1,81 @@
+package render
+
+import (
+	"github.com/rredpoppy/gosite/pkg/config"
+	"regexp"
+	"strings"
+)
+
+// Function expanding a shortcode of an article to HTML. Arguments are the
+// space separated words following the shortcode name, double quotes keeping
+// spaces in an argument
+type Shortcode func(section string, slug string, args []string) string
+
+var shortcodes = map[string]Shortcode{}
+
+// Shortcodes in markdown, e.g. {{< gallery holiday >}}
+var shortcodePattern = regexp.MustCompile(`{{<\s*([a-zA-Z][a-zA-Z0-9_-]*)((?:\s+[^>]*?)?)\s*>}}`)
+
+// Arguments of a shortcode, either plain words or double quoted strings
+var shortcodeArgPattern = regexp.MustCompile(`"([^"]*)"|(\S+)`)
+
+/**
+ * Registers a shortcode under the given name, replacing any existing
+ * shortcode with the same name
+ */
+func RegisterShortcode(name string, shortcode Shortcode) {
+	shortcodes[name] = shortcode
+}
+
+/**
+ * Returns the arguments of a shortcode
+ */
+func getShortcodeArgs(s string) []string {
+	var args []string
+	for _, match := range shortcodeArgPattern.FindAllStringSubmatch(s, -1) {
+		if len(match[2]) > 0 {
+			args = append(args, match[2])
+		} else {
+			args = append(args, match[1])
+		}
+	}
+	return args
+}
+
+/**
+ * Expands the registered shortcodes of a markdown text. Their HTML is set
+ * apart by blank lines, so it is kept as is by the markdown renderer, while
+ * unknown shortcodes are left untouched
+ */
+func expandShortcodes(section string, slug string, markdown string) string {
+	if len(shortcodes) == 0 || !strings.Contains(markdown, "{{<") {
+		return markdown
+	}
+	return shortcodePattern.ReplaceAllStringFunc(markdown, func(s string) string {
+		match := shortcodePattern.FindStringSubmatch(s)
+		shortcode, ok := shortcodes[match[1]]
+		if !ok {
+			return s
+		}
+		return "\n\n" + shortcode(section, slug, getShortcodeArgs(match[2])) + "\n\n"
+	})
+}
+
+/**
+ * Registers the shortcodes shipped with gosite: gallery, youtube, vimeo and
+ * video
+ */
+func RegisterBuiltinShortcodes(conf *config.Config) {
+	RegisterShortcode("gallery", func(section string, slug string, args []string) string {
+		return shortcodeGallery(section, args, conf)
+	})
+	RegisterShortcode("youtube", func(section string, slug string, args []string) string {
+		return shortcodeYoutube(args)
+	})
+	RegisterShortcode("vimeo", func(section string, slug string, args []string) string {
+		return shortcodeVimeo(args)
+	})
+	RegisterShortcode("video", func(section string, slug string, args []string) string {
+		return shortcodeVideo(section, args)
+	})
+}
@@ -0,0 +1,122 @@
+package render
+
+import (
+	"html"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// MIME types of the videos served from the content folder, by extension
+var videoTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".webm": "video/webm",
+	".ogv":  "video/ogg",
+	".mov":  "video/quicktime",
+}
+
+// Ids of the YouTube videos, alone or in a watch, share or embed link
+var youtubePattern = regexp.MustCompile(`^(?:https?://(?:www\.|m\.)?(?:youtube\.com/(?:watch\?(?:.*&)?v=|embed/|shorts/)|youtu\.be/))?([A-Za-z0-9_-]{6,})`)
+
+// Ids of the Vimeo videos, alone or in a link
+var vimeoPattern = regexp.MustCompile(`^(?:https?://(?:www\.|player\.)?vimeo\.com/(?:video/)?)?([0-9]+)`)
+
+// Wrapper keeping embedded players at a 16:9 ratio whatever their width
+const videoWrapperStyle = "position: relative; padding-bottom: 56.25%; height: 0; overflow: hidden;"
+
+// Style of the players filling their wrapper
+const videoFrameStyle = "position: absolute; top: 0; left: 0; width: 100%; height: 100%; border: 0;"
+
+/**
+ * Returns the MIME type of a video file, or an empty string for the files
+ * that are not videos
+ */
+func GetVideoType(name string) string {
+	return videoTypes[strings.ToLower(path.Ext(name))]
+}
+
+/**
+ * Returns the responsive markup of an embedded player
+ */
+func getVideoFrame(provider string, src string, title string) string {
+	return "<div class=\"video video-" + provider + "\" style=\"" + videoWrapperStyle + "\">" +
+		"<iframe src=\"" + html.EscapeString(src) + "\" title=\"" + html.EscapeString(title) + "\"" +
+		" style=\"" + videoFrameStyle + "\" loading=\"lazy\" referrerpolicy=\"strict-origin-when-cross-origin\"" +
+		" allow=\"autoplay; encrypted-media; fullscreen; picture-in-picture\" allowfullscreen></iframe></div>"
+}
+
+/**
+ * Expands {{< youtube id [title] >}} to a YouTube player. The id may also be
+ * the link of the video. The player is served from youtube-nocookie.com, so
+ * no cookie is set before the visitor plays the video
+ */
+func shortcodeYoutube(args []string) string {
+	if len(args) == 0 || !youtubePattern.MatchString(args[0]) {
+		return "<div class=\"video video-error\">Invalid YouTube video</div>"
+	}
+	title := "YouTube video"
+	if len(args) > 1 {
+		title = strings.Join(args[1:], " ")
+	}
+	id := youtubePattern.FindStringSubmatch(args[0])[1]
+	return getVideoFrame("youtube", "https://www.youtube-nocookie.com/embed/"+id, title)
+}
+
+/**
+ * Expands {{< vimeo id [title] >}} to a Vimeo player. The id may also be the
+ * link of the video. The player is told not to track the visitor
+ */
+func shortcodeVimeo(args []string) string {
+	if len(args) == 0 || !vimeoPattern.MatchString(args[0]) {
+		return "<div class=\"video video-error\">Invalid Vimeo video</div>"
+	}
+	title := "Vimeo video"
+	if len(args) > 1 {
+		title = strings.Join(args[1:], " ")
+	}
+	id := vimeoPattern.FindStringSubmatch(args[0])[1]
+	return getVideoFrame("vimeo", "https://player.vimeo.com/video/"+id+"?dnt=1", title)
+}
+
+/**
+ * Returns the link of a file given to a shortcode: links and absolute paths
+ * are kept, other paths are files of the content folder, relative to the
+ * section folder of the article
+ */
+func getShortcodeFileLink(section string, file string) string {
+	if strings.Contains(file, "://") || strings.HasPrefix(file, "/") {
+		return file
+	}
+	name, err := CleanContentName(path.Join(section, file))
+	if err != nil {
+		return ""
+	}
+	return GetMediaLink(name)
+}
+
+/**
+ * Expands {{< video file [poster] >}} to a player of a video file, either a
+ * link or a file of the content folder, relative to the section folder of the
+ * article. The poster is an image shown before the video plays
+ */
+func shortcodeVideo(section string, args []string) string {
+	if len(args) == 0 {
+		return "<div class=\"video video-error\">Missing video file</div>"
+	}
+	src := getShortcodeFileLink(section, args[0])
+	if len(src) == 0 {
+		return "<div class=\"video video-error\">Invalid video file</div>"
+	}
+	poster := ""
+	if len(args) > 1 {
+		poster = " poster=\"" + html.EscapeString(getShortcodeFileLink(section, args[1])) + "\""
+	}
+	source := "<source src=\"" + html.EscapeString(src) + "\""
+	if videoType := GetVideoType(args[0]); len(videoType) > 0 {
+		source += " type=\"" + videoType + "\""
+	}
+	return "<div class=\"video video-local\">" +
+		"<video controls preload=\"metadata\" playsinline style=\"width: 100%; height: auto;\"" + poster + ">" +
+		source + "></video></div>"
+}
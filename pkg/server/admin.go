@@ -0,0 +1,496 @@
+package server
+
+import (
+	"bytes"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"github.com/rredpoppy/gosite/pkg/render"
+	"html/template"
+	"strings"
+	"time"
+)
+
+// Front matter keys edited through dedicated fields of the admin editor
+var adminMetaFields = []string{"title", "description", "date", "author", "tags", "image"}
+
+// Layout shared by the admin pages
+const adminLayout = `<!DOCTYPE html>
+<html lang="en">
+  <head>
+    <meta charset="utf-8">
+    <title>{{ .Title }} - Admin</title>
+    <link href="/css/bootstrap.css" rel="stylesheet">
+  </head>
+  <body>
+    <div class="container">
+      {{ block "nav" . }}
+      <ul class="nav nav-pills">
+        <li><a href="/admin">Content</a></li>
+        <li><a href="/admin/comments">Comments</a></li>
+        <li><a href="/admin/media">Media</a></li>
+        <li><a href="/admin/trash">Trash</a></li>
+        <li><a href="/">View site</a></li>
+        <li>
+          <form method="post" action="/admin/logout">
+            {{ csrfField }}
+            <button class="btn btn-link" type="submit">Log out</button>
+          </form>
+        </li>
+      </ul>
+      {{ end }}
+      <h1>{{ .Title }}</h1>
+      {{ template "body" . }}
+    </div>
+  </body>
+</html>`
+
+// File browser listing the sections and their articles
+const adminBrowserBody = `{{ define "body" }}
+{{ range .Sections }}
+<h2>{{ .Name }}</h2>
+<table class="table">
+  {{ range .Articles }}
+  <tr>
+    <td><a href="/admin/edit/{{ .Section }}/{{ .Slug }}">{{ .Slug }}</a></td>
+    <td>{{ .Title }}</td>
+    <td>{{ if .Draft }}<span class="label label-default">draft</span>{{ end }}</td>
+    <td>{{ .Modified.Format "2006-01-02 15:04" }}</td>
+  </tr>
+  {{ end }}
+</table>
+<form class="form-inline" method="post" action="/admin/new">
+  {{ csrfField }}
+  <input type="hidden" name="section" value="{{ .Name }}">
+  <input class="form-control" name="slug" placeholder="New article title or slug" required>
+  <button class="btn btn-default" type="submit">New article</button>
+</form>
+{{ end }}
+{{ end }}`
+
+// Login form of the admin area
+const adminLoginBody = `{{ define "nav" }}<p><a href="/">Back to the site</a></p>{{ end }}
+{{ define "body" }}
+{{ if .Failed }}<p class="alert alert-danger">Wrong user or password, please try again.</p>{{ end }}
+{{ if .OAuth }}<p><a class="btn btn-primary" href="/admin/oauth?next={{ .Next }}">Log in with {{ .OAuth }}</a></p>{{ end }}
+{{ if .Password }}
+<form method="post" action="/admin/login">
+  {{ csrfField }}
+  <input type="hidden" name="next" value="{{ .Next }}">
+  <div class="form-group">
+    <label for="admin-user">User</label>
+    <input class="form-control" id="admin-user" name="user" value="{{ .User }}" required autofocus>
+  </div>
+  <div class="form-group">
+    <label for="admin-password">Password</label>
+    <input class="form-control" id="admin-password" name="password" type="password" required>
+  </div>
+  <button class="btn btn-primary" type="submit">Log in</button>
+</form>
+{{ end }}
+{{ end }}`
+
+// Markdown and front matter editor
+const adminEditorBody = `{{ define "body" }}
+<p>{{ .Section }}/{{ .Slug }}
+  {{ if .Draft }}<span class="label label-default">draft</span>{{ else }}<a href="/{{ .Section }}/{{ .Slug }}">published</a>{{ end }}
+  {{ if .Saved }}<span class="label label-success">saved</span>{{ end }}
+  {{ if .PreviewLink }}<a href="{{ .PreviewLink }}">shareable preview</a>{{ end }}
+  {{ if .History }}<a href="/admin/history/{{ .Section }}/{{ .Slug }}">history</a>{{ end }}
+</p>
+<form method="post" action="/admin/edit/{{ .Section }}/{{ .Slug }}">
+  {{ csrfField }}
+  {{ range .Fields }}
+  <div class="form-group">
+    <label>{{ .Name }}</label>
+    <input class="form-control" name="meta_{{ .Name }}" value="{{ .Value }}">
+  </div>
+  {{ end }}
+  <div class="form-group">
+    <label>Other front matter, as "key: value" lines</label>
+    <textarea class="form-control" name="extra" rows="3">{{ .Extra }}</textarea>
+  </div>
+  <div class="form-group">
+    <label>Content</label>
+    <textarea class="form-control" id="admin-body" name="body" rows="20" style="font-family: monospace">{{ .Body }}</textarea>
+  </div>
+  <div class="form-inline form-group">
+    <label for="admin-upload">Insert an image</label>
+    <input class="form-control" id="admin-upload-alt" placeholder="Description of the image">
+    <input type="file" id="admin-upload" accept="image/*" data-action="/admin/upload/{{ .Section }}">
+    <span id="admin-upload-status"></span>
+  </div>
+  <button class="btn btn-default" name="action" value="preview">Preview</button>
+  <button class="btn btn-primary" name="action" value="save">Save</button>
+  {{ if .CanPublish }}<button class="btn btn-success" name="action" value="publish">Publish</button>{{ end }}
+</form>
+{{ if .CanDelete }}
+<form method="post" action="/admin/delete/{{ .Section }}/{{ .Slug }}">
+  {{ csrfField }}
+  <button class="btn btn-danger" type="submit">Move to trash</button>
+</form>
+{{ end }}
+{{ if .Preview }}<hr><div class="preview">{{ .Preview }}</div>{{ end }}
+<script>
+  // Uploads the chosen image and inserts its markdown at the cursor
+  document.getElementById("admin-upload").addEventListener("change", function () {
+    var input = this, status = document.getElementById("admin-upload-status");
+    var body = document.getElementById("admin-body");
+    var data = new FormData();
+    data.append("file", input.files[0]);
+    data.append("alt", document.getElementById("admin-upload-alt").value);
+    status.textContent = "Uploading...";
+    fetch(input.dataset.action, {method: "POST", body: data, credentials: "same-origin",
+      headers: {"X-CSRF-Token": document.querySelector("input[name=csrf_token]").value}})
+      .then(function (response) { return response.json(); })
+      .then(function (upload) {
+        if (upload.error) {
+          status.textContent = upload.error;
+          return;
+        }
+        var at = body.selectionStart;
+        body.value = body.value.slice(0, at) + upload.markdown + body.value.slice(body.selectionEnd);
+        status.textContent = "";
+        input.value = "";
+      });
+  });
+</script>
+{{ end }}`
+
+// Struct representing a front matter field of the admin editor
+type AdminField struct {
+	Name, Value string
+}
+
+// Struct holding the data shown by the admin editor
+type AdminEditor struct {
+	Title, Section, Slug string
+	Fields               []AdminField
+	Extra, Body          string
+	Preview              template.HTML
+	PreviewLink          string
+	Draft, Saved         bool
+	CanPublish, History  bool
+	CanDelete            bool
+}
+
+/**
+ * Renders an admin page made of the shared layout and the given body. Forms
+ * of the body emit their CSRF token field with {{ csrfField }}
+ */
+func renderAdmin(ctx *web.Context, body string, data interface{}) (string, error) {
+	tpl, err := template.New("layout").Funcs(template.FuncMap{
+		"csrfField": func() template.HTML { return template.HTML(getCsrfField(ctx)) },
+	}).Parse(adminLayout)
+	if err != nil {
+		return "", err
+	}
+	if _, err = tpl.Parse(body); err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err = tpl.Execute(&out, data); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+/**
+ * Returns the page to go to after logging in, which must be on the site
+ */
+func getLoginRedirect(next string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return "/admin"
+	}
+	return next
+}
+
+/**
+ * Login form of the admin area
+ */
+func handleAdminLogin(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	if !isAdminEnabled(&conf) {
+		ctx.Abort(403, "Administration is disabled.")
+		return ""
+	}
+	next := getLoginRedirect(ctx.Params["next"])
+	if ctx.Request.Method == "POST" && hasPasswordLogin(&conf) {
+		if !checkCsrf(ctx) {
+			ctx.Abort(403, "Invalid or missing CSRF token.")
+			return ""
+		}
+		if account, ok := findPasswordAccount(ctx.Params["user"], ctx.Params["password"], &conf); ok {
+			if err = openAccountSession(ctx, GetSession(ctx, &conf), account, &conf); err != nil {
+				ctx.Abort(500, "Could not open session")
+				return ""
+			}
+			ctx.Redirect(303, next)
+			return ""
+		}
+		ctx.WriteHeader(403)
+	}
+	response, err := renderAdmin(ctx, adminLoginBody, map[string]interface{}{
+		"Title": "Log in", "Next": next, "User": ctx.Params["user"], "Failed": ctx.Request.Method == "POST",
+		"Password": hasPasswordLogin(&conf), "OAuth": getOAuthName(&conf)})
+	if err != nil {
+		ctx.Abort(500, err.Error())
+		return ""
+	}
+	return response
+}
+
+/**
+ * Logs the account out, keeping the rest of the session
+ */
+func handleAdminLogout(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	if !checkCsrf(ctx) {
+		ctx.Abort(403, "Invalid or missing CSRF token.")
+		return ""
+	}
+	session := GetSession(ctx, &conf)
+	delete(session.Values, "admin")
+	delete(session.Values, "user")
+	delete(session.Values, "email")
+	if err = session.Save(ctx, &conf); err != nil {
+		ctx.Abort(500, "Could not close session")
+		return ""
+	}
+	ctx.Redirect(303, "/")
+	return ""
+}
+
+/**
+ * Records a change made to an article through the admin area or the API:
+ * keeps a revision of it or commits it when Git support is enabled, refreshes
+ * the content index, pings the search engines and calls the content published
+ * hooks
+ */
+func contentChanged(ctx *web.Context, conf *config.Config, section string, slug string, action string) {
+	if action != "Delete" {
+		if err := saveRevision(ctx, conf, section, slug); err != nil {
+			logRequestError(ctx, "Could not save revision:", err.Error())
+		}
+	}
+	commitArticle(ctx, conf, section, slug, action)
+	if content.SiteIndex != nil {
+		content.SiteIndex.Refresh(conf)
+	}
+	pingArticle(ctx, conf, section, slug, action)
+	content.RunContentPublished(section, slug, action)
+}
+
+/**
+ * Returns the editor data for a page, splitting its front matter between the
+ * dedicated fields and the free form ones. Only editors may publish and
+ * delete
+ */
+func getAdminEditor(section string, slug string, p content.Page, account Account) AdminEditor {
+	editor := AdminEditor{Title: "Edit " + slug, Section: section, Slug: slug,
+		Body: p.Body, Draft: p.IsDraft(), CanPublish: account.Can(RoleEditor),
+		CanDelete: account.Can(RoleEditor)}
+	extra := make(content.FrontMatter)
+	for key, value := range p.Meta {
+		extra[key] = value
+	}
+	for _, name := range adminMetaFields {
+		editor.Fields = append(editor.Fields, AdminField{Name: name, Value: p.Meta.Get(name)})
+		delete(extra, name)
+	}
+	delete(extra, "draft")
+	editor.Extra = strings.TrimSuffix(strings.TrimPrefix(extra.String(), "---\n"), "---\n")
+	return editor
+}
+
+/**
+ * Builds a page from the fields posted by the admin editor
+ */
+func getPostedPage(ctx *web.Context) content.Page {
+	meta, _ := content.ParseFrontMatter("---\n" + ctx.Params["extra"] + "\n---\n")
+	for _, name := range adminMetaFields {
+		if value := strings.TrimSpace(ctx.Params["meta_"+name]); len(value) > 0 {
+			meta[name] = value
+		}
+	}
+	return content.Page{Meta: meta, Body: strings.Replace(ctx.Params["body"], "\r\n", "\n", -1)}
+}
+
+/**
+ * Admin home, a file browser over the content tree
+ */
+func handleAdmin(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	if _, ok := checkAdminAuth(ctx, &conf, RoleAuthor); !ok {
+		return ""
+	}
+	sections, err := content.GetSections(&conf)
+	if err != nil {
+		ctx.Abort(500, "Could not read content")
+		return ""
+	}
+	response, err := renderAdmin(ctx, adminBrowserBody, map[string]interface{}{
+		"Title": "Content", "Sections": sections})
+	if err != nil {
+		ctx.Abort(500, err.Error())
+		return ""
+	}
+	return response
+}
+
+/**
+ * Creates a new draft article and opens it in the editor
+ */
+func handleAdminNew(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	account, ok := checkAdminAuth(ctx, &conf, RoleAuthor)
+	if !ok {
+		return ""
+	}
+	if !checkCsrf(ctx) {
+		ctx.Abort(403, "Invalid or missing CSRF token.")
+		return ""
+	}
+	section, title := ctx.Params["section"], strings.TrimSpace(ctx.Params["slug"])
+	// Titles are turned into slugs, so non-ASCII titles give readable links
+	slug := title
+	if !content.SlugPattern.MatchString(slug) {
+		slug = content.Slugify(title)
+	} else {
+		title = content.TitleCase(strings.Replace(slug, "-", " ", -1), &conf)
+	}
+	if len(section) == 0 || strings.ContainsAny(section, "/\\.") || !content.SlugPattern.MatchString(slug) {
+		ctx.Abort(400, "Invalid article name.")
+		return ""
+	}
+	store := content.GetContentStore(&conf)
+	if fi, err := store.Stat(section); err != nil || !fi.IsDir() {
+		ctx.Abort(404, "Section not found.")
+		return ""
+	}
+	if _, err := store.Stat(content.GetArticleName(section, slug)); err == nil {
+		ctx.Abort(409, "Article already exists.")
+		return ""
+	}
+	p := account.RestrictPage(content.Page{Meta: content.FrontMatter{"draft": "true", "date": time.Now().Format("2006-01-02")},
+		Body: "# " + title + "\n"})
+	if err = content.SavePage(section, slug, p, &conf); err != nil {
+		ctx.Abort(500, "Could not create article")
+		return ""
+	}
+	contentChanged(ctx, &conf, section, slug, "Create")
+	ctx.Redirect(303, "/admin/edit/"+section+"/"+slug)
+	return ""
+}
+
+/**
+ * Shows the editor of an article
+ */
+func handleAdminEdit(ctx *web.Context, section string, slug string) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	account, ok := checkAdminAuth(ctx, &conf, RoleAuthor)
+	if !ok {
+		return ""
+	}
+	p, err := content.GetPage(section, slug, &conf)
+	if err != nil {
+		ctx.Abort(404, "Page not found.")
+		return ""
+	}
+	if !account.CanEdit(p) {
+		ctx.Abort(403, "You are not allowed to edit this article.")
+		return ""
+	}
+	editor := getAdminEditor(section, slug, p, account)
+	editor.Saved = len(ctx.Params["saved"]) > 0
+	editor.History = hasRevisions(&conf)
+	if editor.Draft || p.IsScheduled(time.Now()) {
+		editor.PreviewLink = GetPreviewLink(section, slug, &conf)
+	}
+	response, err := renderAdmin(ctx, adminEditorBody, editor)
+	if err != nil {
+		ctx.Abort(500, err.Error())
+		return ""
+	}
+	return response
+}
+
+/**
+ * Handles the editor actions: preview renders the posted content without
+ * saving it, save keeps the publication state and publish clears the draft
+ * flag
+ */
+func handleAdminSave(ctx *web.Context, section string, slug string) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	account, ok := checkAdminAuth(ctx, &conf, RoleAuthor)
+	if !ok {
+		return ""
+	}
+	if !checkCsrf(ctx) {
+		ctx.Abort(403, "Invalid or missing CSRF token.")
+		return ""
+	}
+	current, err := content.GetPage(section, slug, &conf)
+	if err != nil {
+		ctx.Abort(404, "Page not found.")
+		return ""
+	}
+	if !account.CanEdit(current) || (ctx.Params["action"] == "publish" && !account.Can(RoleEditor)) {
+		ctx.Abort(403, "You are not allowed to edit this article.")
+		return ""
+	}
+	p := getPostedPage(ctx)
+	if current.IsDraft() {
+		p.Meta["draft"] = "true"
+	}
+	p = account.RestrictPage(p)
+	switch ctx.Params["action"] {
+	case "preview":
+		editor := getAdminEditor(section, slug, p, account)
+		preview := content.RunContentLoaded(section, slug, p)
+		editor.Preview = template.HTML(render.PageMarkdown(section, slug, preview))
+		response, err := renderAdmin(ctx, adminEditorBody, editor)
+		if err != nil {
+			ctx.Abort(500, err.Error())
+			return ""
+		}
+		return response
+	case "publish":
+		delete(p.Meta, "draft")
+	}
+	if err = content.SavePage(section, slug, p, &conf); err != nil {
+		ctx.Abort(500, "Could not save article")
+		return ""
+	}
+	if ctx.Params["action"] == "publish" {
+		contentChanged(ctx, &conf, section, slug, "Publish")
+	} else {
+		contentChanged(ctx, &conf, section, slug, "Update")
+	}
+	ctx.Redirect(303, "/admin/edit/"+section+"/"+slug+"?saved=1")
+	return ""
+}
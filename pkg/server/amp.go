@@ -0,0 +1,79 @@
+package server
+
+import (
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"github.com/rredpoppy/gosite/pkg/render"
+)
+
+/**
+ * Returns the link of the AMP version of an article, or an empty string when
+ * it has none: AMP is disabled, the article is protected or it is a preview
+ */
+func getAmpLink(ctx *web.Context, conf *config.Config, section string, page string, output content.Page, preview bool) string {
+	if !conf.Amp.Enabled || preview || len(section) == 0 || output.IsProtected() {
+		return ""
+	}
+	return getRootURL(ctx, conf) + content.GetArticleLink(section, page, conf) + "/amp"
+}
+
+/**
+ * Handles requests for the AMP version of an article, under /amp after its
+ * permalink
+ */
+func handleAmp(ctx *web.Context, path string) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	section, page, err := content.ResolvePermalink(path, &conf)
+	if err != nil {
+		return renderNotFound(ctx, &conf)
+	}
+	output, err := content.GetPublishedPage(section, page, &conf)
+	if err != nil || output.IsProtected() {
+		return renderNotFound(ctx, &conf)
+	}
+	return renderAmpArticle(ctx, &conf, section, page, output)
+}
+
+/**
+ * Renders the AMP version of an article with the amp.html template, pointing
+ * search engines at the article as its canonical version
+ */
+func renderAmpArticle(ctx *web.Context, conf *config.Config, section string, page string, output content.Page) string {
+	menu, err := content.GetMenu(conf)
+	if err != nil {
+		ctx.Abort(501, "Could not load menu")
+		return ""
+	}
+	body, components := render.AmpHtml(render.PageMarkdown(section, page, output), conf)
+	root := getRootURL(ctx, conf)
+	canonical := output.Meta.Get("canonical")
+	if len(canonical) == 0 {
+		canonical = root + content.GetArticleLink(section, page, conf)
+	}
+	robots := output.Robots()
+	if len(robots) > 0 {
+		ctx.SetHeader("X-Robots-Tag", robots, true)
+	}
+	tplContext := getTemplateContext(ctx, conf, menu)
+	tplContext["content"] = body
+	tplContext["ampComponents"] = components
+	tplContext["ampCss"] = render.GetAmpCss(conf)
+	tplContext["meta"] = output.Meta
+	tplContext["title"] = content.GetPageTitle(output)
+	tplContext["description"] = content.GetPageDescription(output)
+	tplContext["robots"] = robots
+	tplContext["canonical"] = canonical
+	og := render.GetArticleOpenGraph(output, canonical, root, conf)
+	tplContext["structuredData"] = render.GetStructuredData(render.GetArticleSchema(output, og, conf))
+	response, err := render.GetEngine(conf).Render("amp.html", tplContext)
+	if err != nil {
+		ctx.Abort(501, "")
+		return err.Error()
+	}
+	return servePage(ctx, response)
+}
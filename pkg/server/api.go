@@ -0,0 +1,489 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"github.com/rredpoppy/gosite/pkg/render"
+	"io/ioutil"
+	"math"
+	"mime"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Struct representing an article in the content API
+type ApiArticle struct {
+	Section  string              `json:"section"`
+	Slug     string              `json:"slug"`
+	Title    string              `json:"title"`
+	Draft    bool                `json:"draft"`
+	Modified time.Time           `json:"modified"`
+	Meta     content.FrontMatter `json:"meta"`
+	Markdown string              `json:"markdown,omitempty"`
+	Content  string              `json:"content,omitempty"`
+}
+
+// Struct representing a page of an article listing in the content API
+type ApiArticleList struct {
+	Articles []ApiArticle `json:"articles"`
+	Page     int          `json:"page"`
+	PerPage  int          `json:"perPage"`
+	Total    int          `json:"total"`
+	Pages    int          `json:"pages"`
+}
+
+// Struct representing a published article in the read-only articles listing,
+// with its absolute URL and rendered summary
+type ApiPublishedArticle struct {
+	Section  string              `json:"section"`
+	Slug     string              `json:"slug"`
+	Title    string              `json:"title"`
+	Url      string              `json:"url"`
+	Date     time.Time           `json:"date"`
+	Modified time.Time           `json:"modified"`
+	Author   string              `json:"author,omitempty"`
+	Tags     []string            `json:"tags"`
+	Meta     content.FrontMatter `json:"meta"`
+	Summary  string              `json:"summary"`
+}
+
+// Struct representing a page of the read-only articles listing
+type ApiPublishedArticleList struct {
+	Articles []ApiPublishedArticle `json:"articles"`
+	Page     int                   `json:"page"`
+	PerPage  int                   `json:"perPage"`
+	Total    int                   `json:"total"`
+	Pages    int                   `json:"pages"`
+}
+
+// Struct representing a section in the content API
+type ApiSection struct {
+	Name     string `json:"name"`
+	Title    string `json:"title"`
+	Hidden   bool   `json:"hidden"`
+	Articles int    `json:"articles"`
+}
+
+// Struct representing the body of article writes in the content API
+type ApiArticleInput struct {
+	Slug     string              `json:"slug"`
+	Meta     content.FrontMatter `json:"meta"`
+	Markdown string              `json:"markdown"`
+}
+
+/**
+ * Writes a JSON response with the given status
+ */
+func apiResponse(ctx *web.Context, status int, data interface{}) string {
+	bs, err := json.Marshal(data)
+	if err != nil {
+		status, bs = 500, []byte(`{"error":"Could not encode response"}`)
+	}
+	ctx.ContentType("json")
+	ctx.WriteHeader(status)
+	return string(bs)
+}
+
+/**
+ * Writes a JSON error response. Server errors carry the ID of the request as
+ * their reference
+ */
+func apiError(ctx *web.Context, status int, message string) string {
+	data := map[string]string{"error": message}
+	if id := getRequestID(ctx.Request); status >= 500 && len(id) > 0 {
+		data["reference"] = id
+	}
+	return apiResponse(ctx, status, data)
+}
+
+/**
+ * Returns true if an API request cannot have been forged by a page of another
+ * site. Browsers send the basic auth credentials they remember along with
+ * cross-site forms, so writes authenticated with basic auth must send a JSON
+ * body, which a form cannot and a script of another site only may after a
+ * preflight request, or the CSRF token. Reads and bearer tokens, which only
+ * the client itself sends, are not concerned
+ */
+func checkApiCsrf(ctx *web.Context) bool {
+	method := ctx.Request.Method
+	if method == "GET" || method == "HEAD" || method == "OPTIONS" ||
+		strings.HasPrefix(ctx.Request.Header.Get("Authorization"), "Bearer ") {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(ctx.Request.Header.Get("Content-Type"))
+	return mediaType == "application/json" || checkCsrf(ctx)
+}
+
+/**
+ * Loads the config and checks that the request credentials are those of an
+ * account having the given role, writing the error response when either
+ * fails. Scripts authenticate with their token as a bearer token, people with
+ * their name and password with HTTP basic auth, writing with a JSON body or
+ * the CSRF token
+ */
+func getApiConfig(ctx *web.Context, role string) (config.Config, Account, string, bool) {
+	conf, err := config.Load()
+	if err != nil {
+		return conf, Account{}, apiError(ctx, 500, "Configuration error"), false
+	}
+	account, ok := getCredentialsAccount(ctx, &conf)
+	if !ok {
+		ctx.SetHeader("WWW-Authenticate", "Bearer realm=\"gosite\"", true)
+		return conf, account, apiError(ctx, 401, "Unauthorized"), false
+	}
+	if !account.Can(role) {
+		return conf, account, apiError(ctx, 403, "Forbidden"), false
+	}
+	if !checkApiCsrf(ctx) {
+		return conf, account, apiError(ctx, 403, "Basic auth writes need a JSON body or the CSRF token"), false
+	}
+	return conf, account, "", true
+}
+
+/**
+ * Returns the API representation of an article
+ */
+func getApiArticle(section string, slug string, withContent bool, conf *config.Config) (ApiArticle, error) {
+	fi, err := content.GetContentStore(conf).Stat(content.GetArticleName(section, slug))
+	if err != nil {
+		return ApiArticle{}, err
+	}
+	p, err := content.GetPage(section, slug, conf)
+	if err != nil {
+		return ApiArticle{}, err
+	}
+	article := ApiArticle{Section: section, Slug: slug, Title: content.GetPageTitle(p),
+		Draft: p.IsDraft(), Modified: fi.ModTime(), Meta: p.Meta}
+	if withContent {
+		article.Markdown = p.Body
+		article.Content = render.PageMarkdown(section, slug, content.RunContentLoaded(section, slug, p))
+	}
+	return article, nil
+}
+
+/**
+ * Reads the JSON body of an article write
+ */
+func readApiArticleInput(ctx *web.Context) (ApiArticleInput, error) {
+	var input ApiArticleInput
+	bs, err := ioutil.ReadAll(ctx.Request.Body)
+	if err != nil {
+		return input, err
+	}
+	err = json.Unmarshal(bs, &input)
+	if input.Meta == nil {
+		input.Meta = make(content.FrontMatter)
+	}
+	return input, err
+}
+
+/**
+ * Lists the sections of the content folder
+ */
+func handleApiSections(ctx *web.Context) string {
+	conf, _, response, ok := getApiConfig(ctx, RoleAuthor)
+	if !ok {
+		return response
+	}
+	sections, err := content.GetSections(&conf)
+	if err != nil {
+		return apiError(ctx, 500, "Could not read content")
+	}
+	list := []ApiSection{}
+	for _, s := range sections {
+		list = append(list, ApiSection{Name: s.Name, Title: content.GetSectionTitle(s.Name, &conf),
+			Hidden: content.IsHiddenSection(s.Name, &conf), Articles: len(s.Articles)})
+	}
+	return apiResponse(ctx, 200, list)
+}
+
+// Number of articles per page of the API listings at most
+const apiMaxPerPage = 100
+
+/**
+ * Returns the page and the number of articles per page asked for by the page
+ * and perPage query parameters of an API listing, with the bounds of the page
+ * within the total articles listed. perPage defaults to the given number and
+ * is capped at apiMaxPerPage, and pages past the last one are empty. Returns
+ * false for a page below 1 or which is not a number
+ */
+func getApiPage(ctx *web.Context, fallback int, total int) (int, int, int, int, bool) {
+	page := 1
+	if value, ok := ctx.Params["page"]; ok {
+		var err error
+		if page, err = strconv.Atoi(value); err != nil || page < 1 {
+			return 0, 0, 0, 0, false
+		}
+	}
+	perPage := getIntParam(ctx, "perPage", fallback)
+	if perPage <= 0 {
+		perPage = 10
+	}
+	if perPage > apiMaxPerPage {
+		perPage = apiMaxPerPage
+	}
+	// Checked before multiplying, so huge pages cannot overflow
+	start := total
+	if page-1 < (total+perPage-1)/perPage {
+		start = (page - 1) * perPage
+	}
+	end := start + perPage
+	if end > total {
+		end = total
+	}
+	return page, perPage, start, end, true
+}
+
+/**
+ * Lists the articles of a section, drafts included, newest first. Supports
+ * the page and perPage query parameters
+ */
+func handleApiArticles(ctx *web.Context, section string) string {
+	conf, _, response, ok := getApiConfig(ctx, RoleAuthor)
+	if !ok {
+		return response
+	}
+	sections, err := content.GetSections(&conf)
+	if err != nil {
+		return apiError(ctx, 500, "Could not read content")
+	}
+	for _, s := range sections {
+		if s.Name != section {
+			continue
+		}
+		page, perPage, start, end, ok := getApiPage(ctx, content.GetArticlesPerPage(section, &conf), len(s.Articles))
+		if !ok {
+			return apiError(ctx, 400, "Invalid page")
+		}
+		list := ApiArticleList{Articles: []ApiArticle{}, Page: page, PerPage: perPage, Total: len(s.Articles)}
+		list.Pages = int(math.Ceil(float64(list.Total) / float64(perPage)))
+		for i := start; i < end; i++ {
+			article, err := getApiArticle(section, s.Articles[i].Slug, false, &conf)
+			if err == nil {
+				list.Articles = append(list.Articles, article)
+			}
+		}
+		return apiResponse(ctx, 200, list)
+	}
+	return apiError(ctx, 404, "Section not found")
+}
+
+/**
+ * Returns the date of a query parameter of the articles listing. Dates
+ * without a time of day are taken at midnight, or at the end of the day for
+ * the end of a range
+ */
+func getDateParam(ctx *web.Context, name string, end bool) (time.Time, error) {
+	value := strings.TrimSpace(ctx.Params[name])
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+	t, err := content.ParseDate(value)
+	if err == nil && end && len(value) == len("2006-01-02") {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return t, err
+}
+
+/**
+ * Lists the published articles of the site, newest first, with their
+ * metadata and rendered summary. Supports the section, tag, author, from and
+ * to filters, and the page and perPage query parameters. Read-only and open
+ * to everybody, like the rest of the published content
+ */
+func handleApiPublishedArticles(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		return apiError(ctx, 500, "Configuration error")
+	}
+	from, err := getDateParam(ctx, "from", false)
+	if err != nil {
+		return apiError(ctx, 400, "Invalid from date")
+	}
+	to, err := getDateParam(ctx, "to", true)
+	if err != nil {
+		return apiError(ctx, 400, "Invalid to date")
+	}
+	articles, err := getGqlArticles(&conf)
+	if err != nil {
+		return apiError(ctx, 500, "Could not read content")
+	}
+	filters := map[string]interface{}{}
+	for _, name := range []string{"tag", "author"} {
+		if len(ctx.Params[name]) > 0 {
+			filters[name] = ctx.Params[name]
+		}
+	}
+	if len(ctx.Params["section"]) > 0 {
+		filters["section"] = content.FindSection(ctx.Params["section"], &conf)
+	}
+	var matches []gqlArticle
+	for _, a := range filterGqlArticles(articles, filters) {
+		if (from.IsZero() || !a.Date.Before(from)) && (to.IsZero() || !a.Date.After(to)) {
+			matches = append(matches, a)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].Date.After(matches[j].Date) })
+
+	page, perPage, start, end, ok := getApiPage(ctx, conf.ArticlesPerPage, len(matches))
+	if !ok {
+		return apiError(ctx, 400, "Invalid page")
+	}
+	list := ApiPublishedArticleList{Articles: []ApiPublishedArticle{}, Page: page, PerPage: perPage, Total: len(matches)}
+	list.Pages = int(math.Ceil(float64(list.Total) / float64(perPage)))
+	root := getRootURL(ctx, &conf)
+	for i := start; i < end; i++ {
+		a := matches[i]
+		tags := a.Page.Meta.List("tags")
+		if tags == nil {
+			tags = []string{}
+		}
+		list.Articles = append(list.Articles, ApiPublishedArticle{Section: a.Section, Slug: a.Slug,
+			Title: content.GetPageTitle(a.Page), Url: root + a.Link, Date: a.Date, Modified: a.Modified,
+			Author: a.Page.Meta.Get("author"), Tags: tags, Meta: a.Page.Meta,
+			Summary: render.PageMarkdown(a.Section, a.Slug, content.Page{Meta: a.Page.Meta,
+				Body: content.GetSectionSummary(a.Section, a.Page.Body, &conf)})})
+	}
+	return apiResponse(ctx, 200, list)
+}
+
+/**
+ * Returns an article with its markdown and rendered content
+ */
+func handleApiGetArticle(ctx *web.Context, section string, slug string) string {
+	conf, _, response, ok := getApiConfig(ctx, RoleAuthor)
+	if !ok {
+		return response
+	}
+	article, err := getApiArticle(section, slug, true, &conf)
+	if err != nil {
+		return apiError(ctx, 404, "Article not found")
+	}
+	return apiResponse(ctx, 200, article)
+}
+
+/**
+ * Creates an article in a section, failing if the slug is taken
+ */
+func handleApiCreateArticle(ctx *web.Context, section string) string {
+	conf, account, response, ok := getApiConfig(ctx, RoleAuthor)
+	if !ok {
+		return response
+	}
+	input, err := readApiArticleInput(ctx)
+	if err != nil || !content.SlugPattern.MatchString(input.Slug) {
+		return apiError(ctx, 400, "Invalid article")
+	}
+	store := content.GetContentStore(&conf)
+	if fi, err := store.Stat(section); err != nil || !fi.IsDir() {
+		return apiError(ctx, 404, "Section not found")
+	}
+	if _, err = store.Stat(content.GetArticleName(section, input.Slug)); err == nil {
+		return apiError(ctx, 409, "Article already exists")
+	}
+	p := account.RestrictPage(content.Page{Meta: input.Meta, Body: input.Markdown})
+	if err = content.SavePage(section, input.Slug, p, &conf); err != nil {
+		return apiError(ctx, 500, "Could not save article")
+	}
+	contentChanged(ctx, &conf, section, input.Slug, "Create")
+	article, _ := getApiArticle(section, input.Slug, true, &conf)
+	ctx.SetHeader("Location", "/api/v1/sections/"+section+"/articles/"+input.Slug, true)
+	return apiResponse(ctx, 201, article)
+}
+
+/**
+ * Creates or replaces an article. Authors may only replace their own drafts
+ */
+func handleApiPutArticle(ctx *web.Context, section string, slug string) string {
+	conf, account, response, ok := getApiConfig(ctx, RoleAuthor)
+	if !ok {
+		return response
+	}
+	input, err := readApiArticleInput(ctx)
+	if err != nil {
+		return apiError(ctx, 400, "Invalid article")
+	}
+	if fi, err := content.GetContentStore(&conf).Stat(section); err != nil || !fi.IsDir() {
+		return apiError(ctx, 404, "Section not found")
+	}
+	if current, err := content.GetPage(section, slug, &conf); err == nil && !account.CanEdit(current) {
+		return apiError(ctx, 403, "Forbidden")
+	}
+	p := account.RestrictPage(content.Page{Meta: input.Meta, Body: input.Markdown})
+	if err = content.SavePage(section, slug, p, &conf); err != nil {
+		return apiError(ctx, 500, "Could not save article")
+	}
+	contentChanged(ctx, &conf, section, slug, "Update")
+	article, _ := getApiArticle(section, slug, true, &conf)
+	return apiResponse(ctx, 200, article)
+}
+
+/**
+ * Moves an article to the trash
+ */
+func handleApiDeleteArticle(ctx *web.Context, section string, slug string) string {
+	conf, _, response, ok := getApiConfig(ctx, RoleEditor)
+	if !ok {
+		return response
+	}
+	if err := trashArticle(ctx, &conf, section, slug); os.IsNotExist(err) {
+		return apiError(ctx, 404, "Article not found")
+	} else if err != nil {
+		return apiError(ctx, 500, "Could not delete article")
+	}
+	contentChanged(ctx, &conf, section, slug, "Delete")
+	ctx.WriteHeader(204)
+	return ""
+}
+
+/**
+ * Returns a page of the public listing of a section, as served to visitors.
+ * Supports the page query parameter
+ */
+func handleContentListing(ctx *web.Context, section string) string {
+	conf, err := config.Load()
+	if err != nil {
+		return apiError(ctx, 500, "Configuration error")
+	}
+	menu, err := content.GetMenu(&conf)
+	if err != nil {
+		return apiError(ctx, 500, "Could not load menu")
+	}
+	section = content.FindSection(section, &conf)
+	listing, err := content.GetListing(section, getIntParam(ctx, "page", 1), &conf)
+	if err != nil {
+		return apiError(ctx, 404, "Page not found")
+	}
+	return apiResponse(ctx, 200, getListingData(listing, menu.GetCurrent(section, &conf).Title))
+}
+
+/**
+ * Returns a published article with its markdown and rendered content
+ */
+func handleContentPage(ctx *web.Context, section string, page string) string {
+	conf, err := config.Load()
+	if err != nil {
+		return apiError(ctx, 500, "Configuration error")
+	}
+	section = content.FindSection(section, &conf)
+	p, err := content.GetPublishedPage(section, page, &conf)
+	if err != nil {
+		return apiError(ctx, 404, "Page not found")
+	}
+	if p.IsProtected() && !isUnlocked(ctx, &conf, section, page, p) {
+		return apiError(ctx, 403, "Password required")
+	}
+	p = content.HidePassword(p)
+	canonical := p.Meta.Get("canonical")
+	if len(canonical) == 0 {
+		canonical = getRootURL(ctx, &conf) + content.GetArticleLink(section, page, &conf)
+	}
+	return apiResponse(ctx, 200, PageData{Section: section, Slug: page,
+		Meta: p.Meta, Canonical: canonical, Markdown: p.Body,
+		Content: render.PageMarkdown(section, page, p)})
+}
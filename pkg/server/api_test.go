@@ -0,0 +1,87 @@
+package server
+
+import (
+	"github.com/rredpoppy/gosite/pkg/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+/**
+ * Returns a test site with three articles in the blog section, whose API the
+ * admin account may use with basic auth
+ */
+func newTestApiSite(t *testing.T) http.Handler {
+	return newTestSite(t, func(conf *config.Config) {
+		conf.Admin.User, conf.Admin.Password = "admin", "secret"
+		conf.Admin.ApiToken = "api-token"
+	}, map[string]string{
+		"content/blog/one.md":   "---\ntitle: One\ndate: 2024-01-01\n---\nOne.\n",
+		"content/blog/two.md":   "---\ntitle: Two\ndate: 2024-01-02\n---\nTwo.\n",
+		"content/blog/three.md": "---\ntitle: Three\ndate: 2024-01-03\n---\nThree.\n",
+	})
+}
+
+func TestApiArticlesPagination(t *testing.T) {
+	site := newTestApiSite(t)
+	var list ApiArticleList
+	path := "/api/v1/sections/blog/articles"
+	if code := getTestJSON(t, site, path+"?page=9223372036854775807&perPage=2", &list); code != 200 ||
+		len(list.Articles) != 0 || list.PerPage != 2 {
+		t.Errorf("huge page answered %d with %d articles, %d per page", code, len(list.Articles), list.PerPage)
+	}
+	if code := getTestJSON(t, site, path+"?page=2&perPage=2", &list); code != 200 || len(list.Articles) != 1 {
+		t.Errorf("last page answered %d with %d articles, want 1", code, len(list.Articles))
+	}
+	if code := getTestJSON(t, site, path+"?perPage=1000", &list); code != 200 || list.PerPage != apiMaxPerPage {
+		t.Errorf("large perPage answered %d with %d per page, want %d", code, list.PerPage, apiMaxPerPage)
+	}
+	for _, page := range []string{"0", "-1", "first"} {
+		if code := getTestJSON(t, site, path+"?page="+page, &list); code != 400 {
+			t.Errorf("page %s answered %d, want 400", page, code)
+		}
+	}
+}
+
+func TestApiPublishedArticlesPagination(t *testing.T) {
+	site := newTestApiSite(t)
+	var list ApiPublishedArticleList
+	if code := getTestJSON(t, site, "/api/v1/articles?page=9223372036854775807&perPage=2", &list); code != 200 ||
+		len(list.Articles) != 0 || list.PerPage != 2 {
+		t.Errorf("huge page answered %d with %d articles, %d per page", code, len(list.Articles), list.PerPage)
+	}
+	if code := getTestJSON(t, site, "/api/v1/articles?perPage=1000", &list); code != 200 ||
+		len(list.Articles) != 3 || list.PerPage != apiMaxPerPage {
+		t.Errorf("large perPage answered %d with %d articles, %d per page", code, len(list.Articles), list.PerPage)
+	}
+	if code := getTestJSON(t, site, "/api/v1/articles?page=0", &list); code != 400 {
+		t.Errorf("page 0 answered %d, want 400", code)
+	}
+}
+
+func TestApiBasicAuthWritesNeedJson(t *testing.T) {
+	site := newTestApiSite(t)
+	post := func(contentType string, token string) int {
+		r := httptest.NewRequest("POST", "/api/v1/sections/blog/articles",
+			strings.NewReader(`{"slug": "forged", "markdown": "Forged."}`))
+		r.Header.Set("Content-Type", contentType)
+		if len(token) > 0 {
+			r.Header.Set("Authorization", "Bearer "+token)
+		} else {
+			r.SetBasicAuth("admin", "secret")
+		}
+		w := httptest.NewRecorder()
+		site.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := post("text/plain", ""); code != 403 {
+		t.Errorf("basic auth text/plain write answered %d, want 403", code)
+	}
+	if code := post("application/json; charset=utf-8", ""); code != 201 && code != 200 {
+		t.Errorf("basic auth JSON write answered %d", code)
+	}
+	if code := post("text/plain", "api-token"); code == 403 {
+		t.Error("bearer token write refused for its content type")
+	}
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"github.com/rredpoppy/gosite/pkg/render"
+	"sort"
+	"strings"
+)
+
+// Struct representing the JSON representation of an author archive
+type AuthorData struct {
+	Key      string              `json:"key"`
+	Name     string              `json:"name"`
+	Bio      string              `json:"bio,omitempty"`
+	Avatar   string              `json:"avatar,omitempty"`
+	Links    []config.SocialLink `json:"links"`
+	Url      string              `json:"url"`
+	Articles []ListingItemData   `json:"articles"`
+}
+
+/**
+ * Adds the profile of the author of an article to a template context, as
+ * author, with its bio rendered as authorBio. authorByline tells templates to
+ * show it below the article
+ */
+func addAuthorProfile(tplContext map[string]interface{}, p content.Page, conf *config.Config) {
+	name := p.Meta.Get("author")
+	if len(content.GetAuthorKey(name)) == 0 {
+		return
+	}
+	author := content.GetAuthor(name, conf)
+	tplContext["author"] = author
+	tplContext["authorBio"] = render.Markdown("", "", author.Bio)
+	tplContext["authorByline"] = true
+}
+
+/**
+ * Handles the archive of an author: their profile and their published
+ * articles, newest first
+ */
+func handleAuthor(ctx *web.Context, key string) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	articles, err := getGqlArticles(&conf)
+	if err != nil {
+		ctx.Abort(501, "Could not load articles")
+		return ""
+	}
+	author, found := content.GetAuthors(&conf)[key]
+	var written []gqlArticle
+	for _, a := range articles {
+		if content.GetAuthorKey(a.Page.Meta.Get("author")) != key {
+			continue
+		}
+		if !found {
+			author, found = content.GetAuthor(a.Page.Meta.Get("author"), &conf), true
+		}
+		written = append(written, a)
+	}
+	if !found {
+		ctx.SetHeader("Vary", "Accept", true)
+		if negotiateFormat(ctx.Request.Header.Get("Accept")) == "json" {
+			return apiError(ctx, 404, "Page not found")
+		}
+		return renderNotFound(ctx, &conf)
+	}
+	sort.SliceStable(written, func(i, j int) bool {
+		return written[i].Date.After(written[j].Date)
+	})
+	bio := render.Markdown("", "", author.Bio)
+	ctx.SetHeader("Vary", "Accept", true)
+	if negotiateFormat(ctx.Request.Header.Get("Accept")) == "json" {
+		data := AuthorData{Key: author.Key, Name: author.Name, Bio: bio, Avatar: author.Avatar,
+			Links: author.Links, Url: author.Link, Articles: []ListingItemData{}}
+		if data.Links == nil {
+			data.Links = []config.SocialLink{}
+		}
+		for _, a := range written {
+			data.Articles = append(data.Articles, ListingItemData{Slug: a.Slug,
+				Title: content.GetPageTitle(a.Page), Url: a.Link, Meta: a.Page.Meta,
+				Summary: render.PageMarkdown(a.Section, a.Slug, content.Page{Meta: a.Page.Meta, Body: content.GetSummary(a.Page.Body)})})
+		}
+		return apiResponse(ctx, 200, data)
+	}
+	list := make([]string, len(written))
+	for i, a := range written {
+		list[i] = "- [" + content.GetPageTitle(a.Page) + "](" + a.Link + ") " + a.Date.Format("2 Jan 2006")
+	}
+	canonical := getRootURL(ctx, &conf) + author.Link
+	og := render.GetListingOpenGraph(conf.Site.Title+" - "+author.Name, canonical, &conf)
+	return renderMessage(ctx, &conf, author.Name, strings.Join(list, "\n"), map[string]interface{}{
+		"author": author, "authorBio": bio, "authorArchive": true,
+		"canonical": canonical, "description": content.GetPageDescription(content.Page{Body: author.Bio}),
+		"openGraph": og, "socialMeta": og.Html()})
+}
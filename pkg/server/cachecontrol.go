@@ -0,0 +1,81 @@
+package server
+
+import (
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+ * Lets browsers and shared caches keep a response for a lifetime in seconds,
+ * with the Cache-Control and Expires headers. Lifetimes of zero send no
+ * caching hints
+ */
+func setCacheHeaders(header http.Header, seconds int) {
+	if seconds <= 0 {
+		return
+	}
+	header.Set("Cache-Control", "public, max-age="+strconv.Itoa(seconds))
+	header.Set("Expires", time.Now().Add(time.Duration(seconds)*time.Second).UTC().Format(http.TimeFormat))
+}
+
+/**
+ * Returns true if a request asks for a static asset: a file of the static
+ * folder, or an image or video of the content folder
+ */
+func isStaticAsset(r *http.Request) bool {
+	name := path.Clean(r.URL.Path)
+	if strings.HasPrefix(name, "/media/") || strings.HasPrefix(name, "/thumbs/") {
+		return true
+	}
+	fi, err := content.GetSiteStore("static", "static").Stat(strings.TrimPrefix(name, "/"))
+	return err == nil && !fi.IsDir()
+}
+
+// Response writer keeping the caching hints of successful responses only, and
+// keeping responses setting cookies out of shared caches
+type cacheWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *cacheWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		h := w.Header()
+		if strings.HasPrefix(h.Get("Cache-Control"), "public") {
+			if status != 200 && status != 206 && status != 304 {
+				h.Del("Cache-Control")
+				h.Del("Expires")
+			} else if len(h.Get("Set-Cookie")) > 0 {
+				h.Set("Cache-Control", strings.Replace(h.Get("Cache-Control"), "public", "private", 1))
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+/**
+ * Wraps the site handler to send the caching hints of the static assets,
+ * articles, listings and feeds set in the CacheControl config entry. Handlers
+ * set the hints of their pages; errors are never cached
+ */
+func addCacheHeaders(next http.Handler, conf *config.Config) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method == "GET" || r.Method == "HEAD") && conf.CacheControl.Static > 0 && isStaticAsset(r) {
+			setCacheHeaders(w.Header(), conf.CacheControl.Static)
+		}
+		next.ServeHTTP(&cacheWriter{ResponseWriter: w}, r)
+	})
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"github.com/rredpoppy/gosite/pkg/render"
+	"strconv"
+)
+
+// Struct representing an article card of a listing page, rendered as on the
+// listing itself
+type ListingCardData struct {
+	Slug string `json:"slug"`
+	Url  string `json:"url"`
+	Html string `json:"html"`
+}
+
+// Struct representing the cards of a listing page, served to themes loading
+// further pages as visitors scroll. Next links to the cards of the next page
+type ListingCardsData struct {
+	Section string            `json:"section"`
+	Page    int               `json:"page"`
+	Pages   int               `json:"pages"`
+	Total   int               `json:"total"`
+	Cards   []ListingCardData `json:"cards"`
+	Next    string            `json:"next,omitempty"`
+}
+
+/**
+ * Returns the link to the cards of a page of a section listing
+ */
+func getCardsLink(listing content.Listing, page int) string {
+	return listing.Prefix + "/" + content.GetSectionSlug(listing.Section) + "/page/" + strconv.Itoa(page) + ".json"
+}
+
+/**
+ * Returns the cards of a listing page: the rendered summary of each article
+ * followed by its read more link, as on the listing page. Links are given
+ * under the PathPrefix, since JSON responses are not rewritten
+ */
+func getListingCards(listing content.Listing, conf *config.Config) ListingCardsData {
+	prefix := content.GetPathPrefix(conf)
+	link := func(l string) string {
+		return addPathPrefix(l, prefix)
+	}
+	data := ListingCardsData{Section: listing.Section, Page: listing.Page, Pages: listing.Pages,
+		Total: listing.Total, Cards: []ListingCardData{}}
+	for _, item := range listing.Items {
+		summary := item.Summary
+		if listing.Total > 1 {
+			summary += "\n\n[" + conf.ReadMoreText + "](" + item.Link + ")"
+		}
+		html := render.PageMarkdown(listing.Section, item.Slug, content.Page{Meta: item.Meta, Body: summary})
+		if len(prefix) > 0 {
+			html = string(render.RewriteLinks([]byte(html), link))
+		}
+		data.Cards = append(data.Cards, ListingCardData{Slug: item.Slug, Url: link(item.Link), Html: html})
+	}
+	if listing.Page < listing.Pages {
+		data.Next = link(getCardsLink(listing, listing.Page+1))
+	}
+	return data
+}
+
+/**
+ * Serves the article cards of a page of a section listing as JSON, for themes
+ * implementing a load more button or infinite scroll
+ */
+func handleListingCards(ctx *web.Context, section string, page string) string {
+	conf, err := config.Load()
+	if err != nil {
+		return apiError(ctx, 500, "Configuration error")
+	}
+	p, err := strconv.Atoi(page)
+	if err != nil {
+		return apiError(ctx, 404, "Page not found")
+	}
+	section = content.FindSection(section, &conf)
+	listing, err := content.GetListing(section, p, &conf)
+	if err != nil || !listing.ShowListing || len(listing.Items) == 0 {
+		return apiError(ctx, 404, "Page not found")
+	}
+	setCacheHeaders(ctx.Header(), conf.CacheControl.Listings)
+	return apiResponse(ctx, 200, getListingCards(listing, &conf))
+}
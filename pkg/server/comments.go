@@ -0,0 +1,275 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"html"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Struct representing a comment left through the native comments system
+type Comment struct {
+	Id       string
+	Section  string
+	Page     string
+	Author   string
+	Body     string
+	Created  time.Time
+	Approved bool
+}
+
+// Sortable list of comments, ordered by creation date
+type CommentList []Comment
+
+// Returns the length of the list
+func (l CommentList) Len() int {
+	return len(l)
+}
+
+// Comparison function used in sorting. Orders ascending by creation date
+func (l CommentList) Less(i, j int) bool {
+	return l[i].Created.Before(l[j].Created)
+}
+
+// Function used for swapping comments, used in sorting
+func (l CommentList) Swap(i, j int) {
+	l[i], l[j] = l[j], l[i]
+}
+
+/**
+ * Returns the store holding the comments, in a folder per page
+ */
+func getCommentStore(conf *config.Config) content.Store {
+	folder := conf.Comments.Folder
+	if len(folder) == 0 {
+		folder = "comments"
+	}
+	return content.OpenDataStore(folder)
+}
+
+/**
+ * Returns the comments of a page, oldest first. Pending comments are only
+ * returned when approvedOnly is false
+ */
+func getComments(section string, page string, approvedOnly bool, conf *config.Config) (CommentList, error) {
+	var comments CommentList
+	store := getCommentStore(conf)
+	files, err := store.ReadDir(section + "/" + page)
+	if err != nil && !os.IsNotExist(err) {
+		return comments, err
+	}
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		bs, err := store.ReadFile(section + "/" + page + "/" + fi.Name())
+		if err != nil {
+			continue
+		}
+		var c Comment
+		if err = json.Unmarshal(bs, &c); err != nil {
+			continue
+		}
+		if approvedOnly && !c.Approved {
+			continue
+		}
+		comments = append(comments, c)
+	}
+	sort.Sort(comments)
+	return comments, nil
+}
+
+/**
+ * Returns the comments of the whole site awaiting moderation, oldest first
+ */
+func getPendingComments(conf *config.Config) CommentList {
+	var pending CommentList
+	store := getCommentStore(conf)
+	sections, _ := store.ReadDir("")
+	for _, section := range sections {
+		if !section.IsDir() {
+			continue
+		}
+		pages, _ := store.ReadDir(section.Name())
+		for _, page := range pages {
+			if !page.IsDir() {
+				continue
+			}
+			comments, err := getComments(section.Name(), page.Name(), false, conf)
+			if err != nil {
+				continue
+			}
+			for _, c := range comments {
+				if !c.Approved {
+					pending = append(pending, c)
+				}
+			}
+		}
+	}
+	sort.Sort(pending)
+	return pending
+}
+
+/**
+ * Writes a comment to its page folder, replacing any previous version
+ */
+func saveComment(c Comment, conf *config.Config) error {
+	bs, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		return err
+	}
+	return getCommentStore(conf).WriteFile(c.Section+"/"+c.Page+"/"+c.Id+".json", bs)
+}
+
+/**
+ * Returns true if a submitted comment looks like spam: the hidden honeypot
+ * field was filled, the text is empty or too long, it holds too many links or
+ * one of the blocked words
+ */
+func isSpamComment(ctx *web.Context, conf *config.Config) bool {
+	author := strings.TrimSpace(ctx.Params["author"])
+	body := strings.TrimSpace(ctx.Params["body"])
+	if len(ctx.Params["website"]) > 0 || len(author) == 0 || len(body) == 0 ||
+		len(author) > 100 || len(body) > 5000 {
+		return true
+	}
+	maxLinks := conf.Comments.MaxLinks
+	if maxLinks == 0 {
+		maxLinks = 2
+	}
+	if strings.Count(strings.ToLower(body), "http") > maxLinks {
+		return true
+	}
+	lower := strings.ToLower(author + " " + body)
+	for _, word := range conf.Comments.BlockedWords {
+		if len(word) > 0 && strings.Contains(lower, strings.ToLower(word)) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Handles comment submissions. Comments are stored pending moderation and the
+ * visitor is sent back to the article
+ */
+func handlePostComment(ctx *web.Context, section string, page string) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	if conf.Comments.Provider != "native" {
+		ctx.Abort(404, "Page not found.")
+		return ""
+	}
+	section = content.FindSection(section, &conf)
+	p, err := content.GetPublishedPage(section, page, &conf)
+	if err != nil || (p.Meta.Has("comments") && !p.Meta.Bool("comments")) {
+		ctx.Abort(404, "Page not found.")
+		return ""
+	}
+	if !checkCsrf(ctx) {
+		return renderCsrfError(ctx, &conf)
+	}
+	if isSpamComment(ctx, &conf) {
+		ctx.Abort(400, "Comment rejected.")
+		return ""
+	}
+	now := time.Now()
+	c := Comment{
+		Id:      strconv.FormatInt(now.UnixNano(), 16),
+		Section: section,
+		Page:    page,
+		Author:  strings.TrimSpace(ctx.Params["author"]),
+		Body:    strings.TrimSpace(ctx.Params["body"]),
+		Created: now,
+	}
+	if err = saveComment(c, &conf); err != nil {
+		ctx.Abort(500, "Could not save comment")
+		return ""
+	}
+	ctx.Redirect(303, content.GetArticleLink(section, page, &conf)+"?comment=pending#comments")
+	return ""
+}
+
+/**
+ * Moderation queue, lists the comments awaiting approval
+ */
+func handleCommentQueue(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	if _, ok := checkAdminAuth(ctx, &conf, RoleEditor); !ok {
+		return ""
+	}
+	rows := []string{"<!DOCTYPE html><html><head><meta charset=\"utf-8\"><title>Comments awaiting moderation</title></head><body>",
+		"<h1>Comments awaiting moderation</h1>"}
+	pending := getPendingComments(&conf)
+	if len(pending) == 0 {
+		rows = append(rows, "<p>Nothing to moderate.</p>")
+	}
+	field := getCsrfField(ctx)
+	for _, c := range pending {
+		action := "/admin/comments/" + c.Section + "/" + c.Page + "/" + c.Id
+		rows = append(rows, "<div class=\"comment\"><p><strong>"+html.EscapeString(c.Author)+"</strong> on <a href=\""+
+			content.GetArticleLink(c.Section, c.Page, &conf)+"\">"+c.Section+"/"+c.Page+"</a>, "+c.Created.Format("2006-01-02 15:04")+"</p>"+
+			"<p>"+html.EscapeString(c.Body)+"</p>"+
+			"<form method=\"post\" action=\""+action+"/approve\">"+field+"<button>Approve</button></form>"+
+			"<form method=\"post\" action=\""+action+"/delete\">"+field+"<button>Delete</button></form></div>")
+	}
+	rows = append(rows, "</body></html>")
+	return strings.Join(rows, "\n")
+}
+
+/**
+ * Approves or deletes a pending comment
+ */
+func handleModerateComment(ctx *web.Context, section string, page string, id string, action string) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	if _, ok := checkAdminAuth(ctx, &conf, RoleEditor); !ok {
+		return ""
+	}
+	if !checkCsrf(ctx) {
+		ctx.Abort(403, "Invalid or missing CSRF token.")
+		return ""
+	}
+	store := getCommentStore(&conf)
+	file := section + "/" + page + "/" + id + ".json"
+	bs, err := store.ReadFile(file)
+	if err != nil {
+		ctx.Abort(404, "Comment not found.")
+		return ""
+	}
+	if action == "delete" {
+		if err = store.Remove(file); err != nil {
+			ctx.Abort(500, "Could not delete comment")
+			return ""
+		}
+	} else {
+		var c Comment
+		if err = json.Unmarshal(bs, &c); err != nil {
+			ctx.Abort(500, "Could not read comment")
+			return ""
+		}
+		c.Approved = true
+		if err = saveComment(c, &conf); err != nil {
+			ctx.Abort(500, "Could not save comment")
+			return ""
+		}
+	}
+	ctx.Redirect(303, "/admin/comments")
+	return ""
+}
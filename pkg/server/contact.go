@@ -0,0 +1,90 @@
+package server
+
+import (
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+ * Removes line breaks from a mail header value, preventing header injection
+ */
+func sanitizeHeader(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
+
+/**
+ * Sends a plain text mail through the configured SMTP server
+ */
+func sendMail(conf *config.SmtpConfig, to []string, subject string, replyTo string, body string) error {
+	var auth smtp.Auth
+	if len(conf.User) > 0 {
+		auth = smtp.PlainAuth("", conf.User, conf.Password, conf.Host)
+	}
+	headers := []string{
+		"From: " + sanitizeHeader(conf.From),
+		"To: " + sanitizeHeader(strings.Join(to, ", ")),
+		"Subject: " + sanitizeHeader(subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+	}
+	if len(replyTo) > 0 {
+		headers = append(headers, "Reply-To: "+sanitizeHeader(replyTo))
+	}
+	msg := strings.Join(headers, "\r\n") + "\r\n\r\n" + strings.Replace(body, "\n", "\r\n", -1)
+	return smtp.SendMail(conf.Host+":"+strconv.Itoa(conf.Port), auth, conf.From, to, []byte(msg))
+}
+
+/**
+ * Handles contact form submissions. The message is mailed to the configured
+ * recipients and the outcome is rendered with the site template
+ */
+func handleContact(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	if len(conf.Contact.To) == 0 {
+		ctx.Abort(404, "Page not found.")
+		return ""
+	}
+	if !checkCsrf(ctx) {
+		return renderCsrfError(ctx, &conf)
+	}
+	message := conf.Contact.SuccessMessage
+	if len(message) == 0 {
+		message = "Thank you, your message has been sent."
+	}
+	var lines []string
+	valid := len(ctx.Params["website"]) == 0
+	for _, field := range conf.Contact.Fields {
+		value := strings.TrimSpace(ctx.Params[field.Name])
+		if field.Required && len(value) == 0 {
+			valid = false
+		}
+		lines = append(lines, field.Label+":\n"+value+"\n")
+	}
+	if valid {
+		subject := conf.Contact.Subject
+		if len(subject) == 0 {
+			subject = "Contact form message"
+		}
+		err = sendMail(&conf.Smtp, conf.Contact.To, subject,
+			ctx.Params["email"], strings.Join(lines, "\n"))
+	}
+	if !valid || err != nil {
+		message = conf.Contact.ErrorMessage
+		if len(message) == 0 {
+			message = "Sorry, your message could not be sent. Please check the form and try again."
+		}
+		ctx.WriteHeader(400)
+	}
+
+	return renderMessage(ctx, &conf, "Contact", message, map[string]interface{}{
+		"contactForm": !valid || err != nil})
+}
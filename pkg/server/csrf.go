@@ -0,0 +1,94 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"html"
+	"net/http"
+)
+
+// Name of the cookie holding the CSRF token of a visitor
+const csrfCookie = "gosite_csrf"
+
+// Name of the form field holding the CSRF token
+const csrfField = "csrf_token"
+
+/**
+ * Returns the CSRF token of the visitor, setting the cookie holding it on
+ * the first visit. Forms posting to the site send the token back, which a
+ * page of another site cannot read
+ */
+func getCsrfToken(ctx *web.Context) string {
+	if cookie, err := ctx.Request.Cookie(csrfCookie); err == nil && len(cookie.Value) == 64 {
+		return cookie.Value
+	}
+	bs := make([]byte, 32)
+	if _, err := rand.Read(bs); err != nil {
+		return ""
+	}
+	cookie := &http.Cookie{
+		Name:     csrfCookie,
+		Value:    hex.EncodeToString(bs),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   isSecureRequest(ctx.Request),
+		SameSite: http.SameSiteLaxMode,
+	}
+	http.SetCookie(ctx, cookie)
+	// Later calls while serving the request get the same token
+	ctx.Request.AddCookie(cookie)
+	return cookie.Value
+}
+
+/**
+ * Returns the hidden form field holding the CSRF token of the visitor
+ */
+func getCsrfField(ctx *web.Context) string {
+	return "<input type=\"hidden\" name=\"" + csrfField + "\" value=\"" + html.EscapeString(getCsrfToken(ctx)) + "\">"
+}
+
+/**
+ * Returns true if the pages of the site may hold forms posted to it. Other
+ * sites do not set the CSRF cookie, so their pages can be cached
+ */
+func hasForms(conf *config.Config) bool {
+	return len(conf.Contact.To) > 0 || conf.Comments.Provider == "native" ||
+		(len(conf.Newsletter.Provider) > 0 && len(conf.Newsletter.Secret) > 0)
+}
+
+/**
+ * Adds the CSRF token of the visitor to a template context, alone as
+ * csrfToken and as a hidden form field as csrfField
+ */
+func addCsrfToken(ctx *web.Context, tplContext map[string]interface{}) {
+	tplContext["csrfToken"] = getCsrfToken(ctx)
+	tplContext["csrfField"] = getCsrfField(ctx)
+}
+
+/**
+ * Returns true if the request holds the CSRF token of the visitor, either as
+ * a form field or in the X-CSRF-Token header
+ */
+func checkCsrf(ctx *web.Context) bool {
+	cookie, err := ctx.Request.Cookie(csrfCookie)
+	if err != nil || len(cookie.Value) == 0 {
+		return false
+	}
+	token := ctx.Params[csrfField]
+	if len(token) == 0 {
+		token = ctx.Request.Header.Get("X-CSRF-Token")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(cookie.Value)) == 1
+}
+
+/**
+ * Renders the error of a form posted without a valid CSRF token
+ */
+func renderCsrfError(ctx *web.Context, conf *config.Config) string {
+	ctx.WriteHeader(403)
+	return renderMessage(ctx, conf, "Form expired",
+		"Sorry, this form has expired. Please go back, reload the page and try again.", nil)
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+/**
+ * Returns true if a form post holding the given cookie, form field and header
+ * passes the CSRF check
+ */
+func checkTestCsrf(cookie string, field string, header string) bool {
+	form := url.Values{}
+	if len(field) > 0 {
+		form.Set(csrfField, field)
+	}
+	r := httptest.NewRequest("POST", "/contact", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if len(cookie) > 0 {
+		r.AddCookie(&http.Cookie{Name: csrfCookie, Value: cookie})
+	}
+	if len(header) > 0 {
+		r.Header.Set("X-CSRF-Token", header)
+	}
+	ctx, _ := newTestContext(r)
+	return checkCsrf(ctx)
+}
+
+func TestCsrfToken(t *testing.T) {
+	ctx, w := newTestContext(httptest.NewRequest("GET", "/", nil))
+	token := getCsrfToken(ctx)
+	if len(token) != 64 || getCsrfToken(ctx) != token {
+		t.Fatalf("token %q is not kept while serving the request", token)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Value != token || !cookies[0].HttpOnly {
+		t.Errorf("token sent as %v, want a single HttpOnly cookie", cookies)
+	}
+	if !checkTestCsrf(token, token, "") {
+		t.Error("form holding the token rejected")
+	}
+	if !checkTestCsrf(token, "", token) {
+		t.Error("script sending the token in its header rejected")
+	}
+	other := strings.Repeat("0", 64)
+	for name, ok := range map[string]bool{
+		"without token":     checkTestCsrf(token, "", ""),
+		"with another one":  checkTestCsrf(token, other, ""),
+		"without cookie":    checkTestCsrf("", token, ""),
+		"with empty cookie": checkTestCsrf("", "", ""),
+	} {
+		if ok {
+			t.Errorf("form %s accepted", name)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"strings"
+)
+
+// Struct representing a line of a diff. Op is "=" for unchanged lines, "-"
+// for removed ones and "+" for added ones
+type DiffLine struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// Largest number of line pairs compared. Longer texts are shown as entirely
+// replaced rather than spending the memory
+const maxDiffCells = 4000000
+
+/**
+ * Returns the line-level diff turning a text into another, made from the
+ * longest common subsequence of their lines
+ */
+func diffLines(from string, to string) []DiffLine {
+	a, b := splitDiffLines(from), splitDiffLines(to)
+	diff := []DiffLine{}
+	// Common prefix and suffix are kept out of the table
+	start := 0
+	for start < len(a) && start < len(b) && a[start] == b[start] {
+		diff = append(diff, DiffLine{Op: "=", Text: a[start]})
+		start++
+	}
+	end := 0
+	for end < len(a)-start && end < len(b)-start && a[len(a)-1-end] == b[len(b)-1-end] {
+		end++
+	}
+	ma, mb := a[start:len(a)-end], b[start:len(b)-end]
+	if len(ma)*len(mb) > maxDiffCells {
+		for _, line := range ma {
+			diff = append(diff, DiffLine{Op: "-", Text: line})
+		}
+		for _, line := range mb {
+			diff = append(diff, DiffLine{Op: "+", Text: line})
+		}
+	} else {
+		// lcs[i][j] is the length of the common subsequence of ma[i:] and mb[j:]
+		lcs := make([][]int, len(ma)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(mb)+1)
+		}
+		for i := len(ma) - 1; i >= 0; i-- {
+			for j := len(mb) - 1; j >= 0; j-- {
+				if ma[i] == mb[j] {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(ma) || j < len(mb) {
+			switch {
+			case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+				diff = append(diff, DiffLine{Op: "=", Text: ma[i]})
+				i++
+				j++
+			case j == len(mb) || (i < len(ma) && lcs[i+1][j] >= lcs[i][j+1]):
+				diff = append(diff, DiffLine{Op: "-", Text: ma[i]})
+				i++
+			default:
+				diff = append(diff, DiffLine{Op: "+", Text: mb[j]})
+				j++
+			}
+		}
+	}
+	for _, line := range a[len(a)-end:] {
+		diff = append(diff, DiffLine{Op: "=", Text: line})
+	}
+	return diff
+}
+
+/**
+ * Returns the lines of a text. A final newline does not start a line
+ */
+func splitDiffLines(text string) []string {
+	text = strings.TrimSuffix(strings.Replace(text, "\r\n", "\n", -1), "\n")
+	if len(text) == 0 {
+		return []string{}
+	}
+	return strings.Split(text, "\n")
+}
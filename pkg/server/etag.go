@@ -0,0 +1,127 @@
+package server
+
+import (
+	"bytes"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"net/http"
+	"strings"
+)
+
+// Largest response held to be tagged; larger ones, like the sitemaps of big
+// sites, are streamed without an ETag
+const maxTaggedSize = 1 << 20
+
+/**
+ * Returns true if a response of the given type is held to be tagged: pages,
+ * feeds, JSON and the text assets. Images and videos are sent as they come
+ */
+func isTaggedType(contentType string) bool {
+	if len(contentType) == 0 || strings.HasPrefix(contentType, "text/") {
+		return true
+	}
+	for _, t := range []string{"json", "xml", "javascript", "webmanifest"} {
+		if strings.Contains(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Returns true if an If-None-Match header names the given ETag
+ */
+func matchesETag(header string, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Response writer holding successful textual responses, to tag them with the
+// checksum of their body once the handler is done
+type etagWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        *bytes.Buffer
+}
+
+func (w *etagWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.Header()
+	if status == 200 && len(h.Get("ETag")) == 0 && !strings.Contains(h.Get("Cache-Control"), "no-store") &&
+		isTaggedType(h.Get("Content-Type")) {
+		h.Del("Content-Length")
+		w.status, w.body = status, new(bytes.Buffer)
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+	if w.body != nil && w.body.Len()+len(b) <= maxTaggedSize {
+		return w.body.Write(b)
+	}
+	if w.body != nil {
+		held := w.body.Bytes()
+		w.body = nil
+		w.ResponseWriter.WriteHeader(w.status)
+		if _, err := w.ResponseWriter.Write(held); err != nil {
+			return 0, err
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+/**
+ * Sends the response held by the writer with its ETag, or an empty 304 when
+ * the client already has this version
+ */
+func (w *etagWriter) finish(r *http.Request) {
+	if w.body == nil {
+		return
+	}
+	body := w.body.Bytes()
+	h := w.Header()
+	if len(h.Get("Content-Type")) == 0 && len(body) > 0 {
+		h.Set("Content-Type", http.DetectContentType(body))
+	}
+	etag := "\"" + content.Hash(body)[:20] + "\""
+	h.Set("ETag", etag)
+	if matchesETag(r.Header.Get("If-None-Match"), etag) {
+		h.Del("Content-Type")
+		w.ResponseWriter.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(body)
+}
+
+/**
+ * Wraps the site handler to tag the successful responses to GET and HEAD
+ * requests with the checksum of their body, and to answer the clients
+ * revalidating a version they already have with a 304 and no body
+ */
+func addETags(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ew := &etagWriter{ResponseWriter: w}
+		next.ServeHTTP(ew, r)
+		if !ew.wroteHeader {
+			ew.WriteHeader(200)
+		}
+		ew.finish(r)
+	})
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"encoding/xml"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"github.com/rredpoppy/gosite/pkg/render"
+	"html"
+	"mime"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Namespace of the iTunes podcast extensions
+const itunesNamespace = "http://www.itunes.com/dtds/podcast-1.0.dtd"
+
+// Namespace of Atom, whose updated element dates the changes of feed items
+const atomNamespace = "http://www.w3.org/2005/Atom"
+
+// Struct representing an RSS 2.0 feed, with the iTunes podcast extensions
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Itunes  string     `xml:"xmlns:itunes,attr,omitempty"`
+	Atom    string     `xml:"xmlns:atom,attr,omitempty"`
+	Channel rssChannel `xml:"channel"`
+}
+
+// Struct representing the channel of a feed
+type rssChannel struct {
+	Title         string           `xml:"title"`
+	Link          string           `xml:"link"`
+	Description   string           `xml:"description"`
+	Language      string           `xml:"language,omitempty"`
+	LastBuildDate string           `xml:"lastBuildDate,omitempty"`
+	Image         *rssImage        `xml:"image,omitempty"`
+	Author        string           `xml:"itunes:author,omitempty"`
+	Owner         *itunesOwner     `xml:"itunes:owner,omitempty"`
+	ItunesImage   *itunesImage     `xml:"itunes:image,omitempty"`
+	Categories    []itunesCategory `xml:"itunes:category"`
+	Explicit      string           `xml:"itunes:explicit,omitempty"`
+	Items         []rssItem        `xml:"item"`
+}
+
+// Struct representing the artwork of a channel
+type rssImage struct {
+	Url   string `xml:"url"`
+	Title string `xml:"title"`
+	Link  string `xml:"link"`
+}
+
+// Struct representing the owner of a podcast, who podcast directories
+// contact
+type itunesOwner struct {
+	Name  string `xml:"itunes:name,omitempty"`
+	Email string `xml:"itunes:email"`
+}
+
+// Struct representing the artwork of a podcast or episode
+type itunesImage struct {
+	Href string `xml:"href,attr"`
+}
+
+// Struct representing a category of a podcast
+type itunesCategory struct {
+	Text string `xml:"text,attr"`
+}
+
+// Struct representing an article of a feed
+type rssItem struct {
+	Title       string        `xml:"title"`
+	Link        string        `xml:"link"`
+	Guid        string        `xml:"guid"`
+	PubDate     string        `xml:"pubDate"`
+	Updated     string        `xml:"atom:updated,omitempty"`
+	Description string        `xml:"description"`
+	Enclosure   *rssEnclosure `xml:"enclosure,omitempty"`
+	Duration    string        `xml:"itunes:duration,omitempty"`
+	Image       *itunesImage  `xml:"itunes:image,omitempty"`
+}
+
+// Struct representing the audio file of a podcast episode
+type rssEnclosure struct {
+	Url    string `xml:"url,attr"`
+	Length int64  `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+// Struct representing an article of a feed with its section
+type feedArticle struct {
+	Section string
+	Item    content.ListingItem
+}
+
+/**
+ * Returns the absolute form of a link of the site
+ */
+func getAbsoluteURL(root string, link string) string {
+	if strings.Contains(link, "://") {
+		return link
+	}
+	return root + "/" + strings.TrimPrefix(link, "/")
+}
+
+/**
+ * Returns the enclosure of an episode, from the audio, audio_type and
+ * audio_length front matter keys. The type defaults to the one of the file
+ * extension and the length to the size of the file, when it is part of the
+ * static folder
+ */
+func getEnclosure(meta content.FrontMatter, root string) *rssEnclosure {
+	file := meta.Get("audio")
+	if len(file) == 0 {
+		return nil
+	}
+	enclosure := &rssEnclosure{Url: getAbsoluteURL(root, file), Type: meta.Get("audio_type")}
+	if len(enclosure.Type) == 0 {
+		enclosure.Type = mime.TypeByExtension(path.Ext(file))
+	}
+	if length, err := strconv.ParseInt(meta.Get("audio_length"), 10, 64); err == nil {
+		enclosure.Length = length
+	} else if !strings.Contains(file, "://") {
+		if fi, err := content.GetSiteStore("static", "static").Stat(strings.TrimPrefix(file, "/")); err == nil {
+			enclosure.Length = fi.Size()
+		}
+	}
+	return enclosure
+}
+
+/**
+ * Returns the newest articles of a section, or of all the sections of the
+ * menu when no section is given, leaving out the articles kept out of search
+ * engines
+ */
+func getFeedArticles(section string, conf *config.Config) ([]feedArticle, error) {
+	var sections []string
+	if len(section) > 0 {
+		sections = append(sections, section)
+	} else {
+		menu, err := content.GetMenu(conf)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range menu {
+			if len(item.Section) > 0 {
+				sections = append(sections, item.Section)
+			}
+		}
+	}
+	var articles []feedArticle
+	for _, s := range sections {
+		items, err := content.GetSectionArticles(s, conf)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			if (content.Page{Meta: item.Meta}).IsNoIndex() {
+				continue
+			}
+			articles = append(articles, feedArticle{Section: s, Item: item})
+		}
+	}
+	sort.SliceStable(articles, func(i, j int) bool {
+		return articles[i].Item.Date.After(articles[j].Item.Date)
+	})
+	count := conf.Feed.Items
+	if count <= 0 {
+		count = 20
+	}
+	if len(articles) > count {
+		articles = articles[:count]
+	}
+	return articles, nil
+}
+
+/**
+ * Renders the RSS feed of a section, or of the whole site when no section is
+ * given. Feeds holding articles with an audio file are podcast feeds, with
+ * the iTunes extensions
+ */
+func renderFeed(ctx *web.Context, section string) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	root := getRootURL(ctx, &conf)
+	channel := rssChannel{Title: conf.Site.Title, Link: root + "/",
+		Description: conf.Site.Description, Language: conf.Site.Language}
+	if len(section) > 0 {
+		section = content.FindSection(section, &conf)
+		channel.Title += " - " + content.GetSectionTitle(section, &conf)
+		channel.Link = root + content.Listing{Section: section}.GetPageLink(1)
+	}
+	articles, err := getFeedArticles(section, &conf)
+	if err != nil {
+		return renderNotFound(ctx, &conf)
+	}
+
+	podcast, updates := false, false
+	var lastBuild time.Time
+	for _, a := range articles {
+		link := root + a.Item.Link
+		item := rssItem{Title: a.Item.Title, Link: link, Guid: link,
+			PubDate:     a.Item.Date.Format(time.RFC1123Z),
+			Description: render.Markdown(a.Section, a.Item.Slug, content.GetSectionSummary(a.Section, a.Item.Summary, &conf)),
+			Enclosure:   getEnclosure(a.Item.Meta, root)}
+		changed := a.Item.Date
+		// Updated articles keep their publication date, and tell readers
+		// when they changed
+		if updated := content.GetArticleUpdated(content.Page{Meta: a.Item.Meta}); !updated.IsZero() {
+			item.Updated, updates, changed = updated.Format(time.RFC3339), true, updated
+		}
+		if changed.After(lastBuild) {
+			lastBuild = changed
+		}
+		if item.Enclosure != nil {
+			podcast = true
+			item.Duration = a.Item.Meta.Get("audio_duration")
+			if image := a.Item.Meta.Get("image"); len(image) > 0 {
+				item.Image = &itunesImage{Href: getAbsoluteURL(root, image)}
+			}
+		}
+		channel.Items = append(channel.Items, item)
+	}
+	if len(conf.Feed.Image) > 0 {
+		channel.Image = &rssImage{Url: getAbsoluteURL(root, conf.Feed.Image),
+			Title: channel.Title, Link: channel.Link}
+	}
+	if !lastBuild.IsZero() {
+		channel.LastBuildDate = lastBuild.Format(time.RFC1123Z)
+	}
+	feed := rssFeed{Version: "2.0"}
+	if updates {
+		feed.Atom = atomNamespace
+	}
+	if podcast {
+		feed.Itunes = itunesNamespace
+		channel.Author = conf.Site.Author
+		channel.Explicit = strconv.FormatBool(conf.Feed.Explicit)
+		if len(conf.Feed.Email) > 0 {
+			channel.Owner = &itunesOwner{Name: conf.Site.Author, Email: conf.Feed.Email}
+		}
+		if channel.Image != nil {
+			channel.ItunesImage = &itunesImage{Href: channel.Image.Url}
+		}
+		for _, category := range conf.Feed.Categories {
+			channel.Categories = append(channel.Categories, itunesCategory{Text: category})
+		}
+	}
+	feed.Channel = channel
+	bs, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		ctx.Abort(500, "Could not render feed")
+		return ""
+	}
+	ctx.SetHeader("Content-Type", "application/rss+xml; charset=utf-8", true)
+	setCacheHeaders(ctx.Header(), conf.CacheControl.Feeds)
+	return xml.Header + string(bs)
+}
+
+// Struct representing a feed announced in the head of the pages
+type FeedLink struct {
+	Title, Url string
+}
+
+/**
+ * Adds the feeds of a page to a template context, as the feeds list and
+ * pre-rendered as feedLinks: the feed of the site and, for the pages of a
+ * section, the feed of the section
+ */
+func addFeedLinks(tplContext map[string]interface{}, ctx *web.Context, conf *config.Config, section string) {
+	root := getRootURL(ctx, conf)
+	feeds := []FeedLink{{Title: conf.Site.Title, Url: root + "/feed.xml"}}
+	if len(section) > 0 {
+		feeds = append(feeds, FeedLink{Title: conf.Site.Title + " - " + content.GetSectionTitle(section, conf),
+			Url: root + "/" + content.GetSectionSlug(section) + "/feed.xml"})
+	}
+	var links []string
+	for _, feed := range feeds {
+		links = append(links, "<link rel=\"alternate\" type=\"application/rss+xml\" title=\""+
+			html.EscapeString(feed.Title)+"\" href=\""+html.EscapeString(feed.Url)+"\">")
+	}
+	tplContext["feeds"] = feeds
+	tplContext["feedLinks"] = strings.Join(links, "\n")
+}
+
+/**
+ * Handles the feed of the whole site
+ */
+func handleFeed(ctx *web.Context) string {
+	return renderFeed(ctx, "")
+}
+
+/**
+ * Handles the feed of a section
+ */
+func handleSectionFeed(ctx *web.Context, section string) string {
+	return renderFeed(ctx, section)
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Locks of the content folders, by absolute path. Git refuses to change a
+// repository while another command holds its index
+var gitLocks sync.Map
+
+/**
+ * Returns the lock serializing the git commands changing a content folder
+ */
+func getGitLock(folder string) *sync.Mutex {
+	if abs, err := filepath.Abs(folder); err == nil {
+		folder = abs
+	}
+	lock, _ := gitLocks.LoadOrStore(folder, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+/**
+ * Runs a git command in a content folder, returning its output. Failures
+ * carry the error output of git
+ */
+func runGit(folder string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", folder}, args...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); len(msg) > 0 {
+			return "", errors.New(msg)
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
+
+/**
+ * Returns the author of an edit, in the "Name <email>" form expected by git.
+ * Edits are attributed to the account making them, scripts using the API
+ * token of the Admin config entry to "api"
+ */
+func getEditAuthor(ctx *web.Context, conf *config.Config) string {
+	name, email := "api", conf.Git.AuthorEmail
+	if account, ok := getRequestAccount(ctx, conf); ok {
+		name = account.Name
+		if len(email) == 0 {
+			email = account.Email
+		}
+	}
+	if len(email) == 0 {
+		email = name + "@gosite"
+	}
+	return name + " <" + email + ">"
+}
+
+/**
+ * Commits the changes of a content file, named relative to the content folder,
+ * attributed to the author of the request, then pushes them in the background
+ * if configured to. With several content folders, the repository is the one
+ * of the folder holding the file. Git commands on a folder run one at a time.
+ * Does nothing when Git support is disabled or the file is unchanged
+ */
+func commitContent(ctx *web.Context, conf *config.Config, name string, message string) error {
+	if !conf.Git.Enabled {
+		return nil
+	}
+	folder := content.GetContentFolder(conf, name)
+	if len(folder) == 0 {
+		return nil
+	}
+	rel := filepath.FromSlash(content.GetStoredName(conf, name))
+	// Concurrent edits commit one after the other
+	lock := getGitLock(folder)
+	lock.Lock()
+	defer lock.Unlock()
+	_, err := runGit(folder, "add", "-A", "--", rel)
+	if err != nil {
+		return err
+	}
+	status, err := runGit(folder, "status", "--porcelain", "--", rel)
+	if err != nil || len(strings.TrimSpace(status)) == 0 {
+		return err
+	}
+	if _, err = runGit(folder, "commit", "-m", message, "--author", getEditAuthor(ctx, conf), "--", rel); err != nil {
+		return err
+	}
+	if conf.Git.Push {
+		remote := conf.Git.Remote
+		if len(remote) == 0 {
+			remote = "origin"
+		}
+		args := []string{"push", remote}
+		if len(conf.Git.Branch) > 0 {
+			args = append(args, "HEAD:"+conf.Git.Branch)
+		}
+		go func() {
+			lock.Lock()
+			defer lock.Unlock()
+			if _, err := runGit(folder, args...); err != nil {
+				logRequestError(ctx, "Could not push content:", err.Error())
+			}
+		}()
+	}
+	return nil
+}
+
+/**
+ * Commits the changes of an article, logging failures: the edit itself is
+ * saved either way
+ */
+func commitArticle(ctx *web.Context, conf *config.Config, section string, slug string, action string) {
+	err := commitContent(ctx, conf, content.GetArticleName(section, slug), action+" "+section+"/"+slug)
+	if err != nil {
+		logRequestError(ctx, "Could not commit content:", err.Error())
+	}
+}
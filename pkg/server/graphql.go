@@ -0,0 +1,680 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"github.com/rredpoppy/gosite/pkg/render"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Object of the GraphQL schema, mapping field names to values or resolvers
+type gqlObject map[string]interface{}
+
+// Field of a GraphQL object computed on demand from the field arguments
+type gqlResolver func(args map[string]interface{}) (interface{}, error)
+
+// Reference to a query variable, resolved when the query is executed
+type gqlVariable string
+
+// Struct representing a field selected by a GraphQL query
+type gqlField struct {
+	Alias, Name string
+	Args        map[string]interface{}
+	Selection   []gqlField
+}
+
+// Object of a GraphQL response, keeping its fields in query order
+type gqlResult struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+// Struct representing the body of a GraphQL request
+type gqlRequest struct {
+	Query     string                 `json:"query"`
+	Variables map[string]interface{} `json:"variables"`
+}
+
+// Struct representing an error of a GraphQL response
+type gqlError struct {
+	Message string `json:"message"`
+}
+
+// Struct representing a published article, as exposed by the GraphQL schema.
+// The Page of password protected articles is masked, and Protected is set
+type gqlArticle struct {
+	Section, Slug string
+	Link          string
+	Page          content.Page
+	Date          time.Time
+	Modified      time.Time
+	Protected     bool
+}
+
+// Encodes the result object with its fields in query order
+func (r gqlResult) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for i, key := range r.keys {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(r.values[key])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteString(":")
+		buf.Write(v)
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
+
+// Parser of the GraphQL query language. Queries, variables, aliases and
+// arguments are supported; fragments, directives and mutations are not
+type gqlParser struct {
+	tokens []string
+	pos    int
+}
+
+/**
+ * Splits a query into tokens: punctuators, names, numbers and quoted strings.
+ * Strings keep their quotes so they can be told apart from names
+ */
+func gqlTokenize(query string) ([]string, error) {
+	var tokens []string
+	runes := []rune(query)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r) || r == ',':
+		case r == '#':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+		case strings.ContainsRune("{}()[]:$!=@", r):
+			tokens = append(tokens, string(r))
+		case r == '.':
+			if i+2 >= len(runes) || runes[i+1] != '.' || runes[i+2] != '.' {
+				return nil, errors.New("Unexpected character .")
+			}
+			tokens = append(tokens, "...")
+			i += 2
+		case r == '"':
+			start := i
+			for i++; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(runes) {
+				return nil, errors.New("Unterminated string")
+			}
+			tokens = append(tokens, string(runes[start:i+1]))
+		case r == '_' || r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r):
+			start := i
+			for i+1 < len(runes) && (runes[i+1] == '_' || runes[i+1] == '.' ||
+				unicode.IsLetter(runes[i+1]) || unicode.IsDigit(runes[i+1])) {
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i+1]))
+		default:
+			return nil, fmt.Errorf("Unexpected character %c", r)
+		}
+	}
+	return tokens, nil
+}
+
+// Returns the current token, or an empty string at the end of the query
+func (p *gqlParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+// Consumes the current token, failing if it differs from the expected one
+func (p *gqlParser) expect(token string) error {
+	if p.peek() != token {
+		return fmt.Errorf("Expected %s, found %q", token, p.peek())
+	}
+	p.pos++
+	return nil
+}
+
+// Consumes a name token
+func (p *gqlParser) name() (string, error) {
+	token := p.peek()
+	if len(token) == 0 || !(token[0] == '_' || unicode.IsLetter(rune(token[0]))) {
+		return "", fmt.Errorf("Expected a name, found %q", token)
+	}
+	p.pos++
+	return token, nil
+}
+
+/**
+ * Parses a query document, returning the fields selected at its root
+ */
+func (p *gqlParser) document() ([]gqlField, error) {
+	switch p.peek() {
+	case "mutation", "subscription":
+		return nil, errors.New("Only queries are supported")
+	case "query":
+		p.pos++
+		if p.peek() != "{" && p.peek() != "(" {
+			if _, err := p.name(); err != nil {
+				return nil, err
+			}
+		}
+		if p.peek() == "(" {
+			if err := p.variableDefinitions(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	fields, err := p.selectionSet()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, errors.New("Only one operation per document is supported")
+	}
+	return fields, nil
+}
+
+// Skips the variable definitions of an operation, values come from the request
+func (p *gqlParser) variableDefinitions() error {
+	p.pos++
+	for p.peek() != ")" {
+		if p.pos >= len(p.tokens) {
+			return errors.New("Unterminated variable definitions")
+		}
+		if p.peek() == "=" {
+			p.pos++
+			if _, err := p.value(); err != nil {
+				return err
+			}
+			continue
+		}
+		p.pos++
+	}
+	p.pos++
+	return nil
+}
+
+// Parses a brace delimited list of fields
+func (p *gqlParser) selectionSet() ([]gqlField, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var fields []gqlField
+	for p.peek() != "}" {
+		if p.peek() == "..." {
+			return nil, errors.New("Fragments are not supported")
+		}
+		field, err := p.field()
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+	p.pos++
+	return fields, nil
+}
+
+// Parses a field, with its optional alias, arguments and selection
+func (p *gqlParser) field() (gqlField, error) {
+	var field gqlField
+	name, err := p.name()
+	if err != nil {
+		return field, err
+	}
+	field.Alias, field.Name = name, name
+	if p.peek() == ":" {
+		p.pos++
+		if field.Name, err = p.name(); err != nil {
+			return field, err
+		}
+	}
+	field.Args = make(map[string]interface{})
+	if p.peek() == "(" {
+		p.pos++
+		for p.peek() != ")" {
+			arg, err := p.name()
+			if err != nil {
+				return field, err
+			}
+			if err = p.expect(":"); err != nil {
+				return field, err
+			}
+			if field.Args[arg], err = p.value(); err != nil {
+				return field, err
+			}
+		}
+		p.pos++
+	}
+	if p.peek() == "@" {
+		return field, errors.New("Directives are not supported")
+	}
+	if p.peek() == "{" {
+		field.Selection, err = p.selectionSet()
+	}
+	return field, err
+}
+
+// Parses an argument value
+func (p *gqlParser) value() (interface{}, error) {
+	token := p.peek()
+	p.pos++
+	switch {
+	case token == "$":
+		name, err := p.name()
+		return gqlVariable(name), err
+	case token == "[":
+		list := []interface{}{}
+		for p.peek() != "]" {
+			if p.pos >= len(p.tokens) {
+				return nil, errors.New("Unterminated list")
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		p.pos++
+		return list, nil
+	case strings.HasPrefix(token, "\""):
+		return strconv.Unquote(token)
+	case token == "true" || token == "false":
+		return token == "true", nil
+	case token == "null":
+		return nil, nil
+	case len(token) > 0 && (token[0] == '-' || unicode.IsDigit(rune(token[0]))):
+		if i, err := strconv.Atoi(token); err == nil {
+			return i, nil
+		}
+		return strconv.ParseFloat(token, 64)
+	case len(token) > 0 && (token[0] == '_' || unicode.IsLetter(rune(token[0]))):
+		// Enum values are handled as strings
+		return token, nil
+	}
+	return nil, fmt.Errorf("Unexpected value %q", token)
+}
+
+/**
+ * Replaces the variable references of an argument value with the values given
+ * along with the query
+ */
+func gqlResolveArg(value interface{}, variables map[string]interface{}) interface{} {
+	switch v := value.(type) {
+	case gqlVariable:
+		return variables[string(v)]
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = gqlResolveArg(item, variables)
+		}
+		return list
+	}
+	return value
+}
+
+/**
+ * Resolves the selected fields of a value. Objects give a result holding the
+ * selected fields, lists are resolved item by item and other values are
+ * returned as is
+ */
+func gqlExecute(value interface{}, fields []gqlField, variables map[string]interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case gqlObject:
+		if len(fields) == 0 {
+			return nil, errors.New("Objects need a selection of fields")
+		}
+		result := gqlResult{values: make(map[string]interface{})}
+		for _, field := range fields {
+			fieldValue, ok := v[field.Name]
+			if !ok {
+				return nil, fmt.Errorf("Unknown field %q", field.Name)
+			}
+			if resolver, ok := fieldValue.(gqlResolver); ok {
+				args := make(map[string]interface{})
+				for name, arg := range field.Args {
+					args[name] = gqlResolveArg(arg, variables)
+				}
+				var err error
+				if fieldValue, err = resolver(args); err != nil {
+					return nil, fmt.Errorf("%s: %s", field.Name, err.Error())
+				}
+			}
+			resolved, err := gqlExecute(fieldValue, field.Selection, variables)
+			if err != nil {
+				return nil, err
+			}
+			if _, seen := result.values[field.Alias]; !seen {
+				result.keys = append(result.keys, field.Alias)
+			}
+			result.values[field.Alias] = resolved
+		}
+		return result, nil
+	case []gqlObject:
+		list := make([]interface{}, 0, len(v))
+		for _, item := range v {
+			resolved, err := gqlExecute(item, fields, variables)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, resolved)
+		}
+		return list, nil
+	}
+	if len(fields) > 0 {
+		return nil, errors.New("Scalar fields cannot have a selection")
+	}
+	return value, nil
+}
+
+/**
+ * Returns the string form of an argument, or the fallback when it is missing
+ */
+func gqlString(args map[string]interface{}, name string, fallback string) string {
+	if v, ok := args[name]; ok && v != nil {
+		return fmt.Sprint(v)
+	}
+	return fallback
+}
+
+/**
+ * Returns the integer form of an argument, or the fallback when it is missing
+ * or not a number
+ */
+func gqlInt(args map[string]interface{}, name string, fallback int) int {
+	switch v := args[name].(type) {
+	case int:
+		return v
+	case float64:
+		return int(v)
+	}
+	return fallback
+}
+
+/**
+ * Returns the published articles of all the sections
+ */
+func getGqlArticles(conf *config.Config) ([]gqlArticle, error) {
+	var articles []gqlArticle
+	if content.SiteIndex != nil {
+		indexed, err := content.SiteIndex.Published()
+		for _, a := range indexed {
+			articles = append(articles, gqlArticle{Section: a.Section, Slug: a.Slug,
+				Page: content.MaskProtected(content.RunContentLoaded(a.Section, a.Slug, content.Page{Meta: a.Meta, Body: a.Body})),
+				Date: a.Date, Modified: a.Modified, Link: content.GetPermalink(a.Section, a.Slug, a.Date, conf),
+				Protected: (content.Page{Meta: a.Meta}).IsProtected()})
+		}
+		return articles, err
+	}
+	sections, err := content.GetSections(conf)
+	if err != nil {
+		return articles, err
+	}
+	for _, s := range sections {
+		for _, a := range s.Articles {
+			if a.Draft || strings.HasPrefix(a.Slug, "_") {
+				continue
+			}
+			p, err := content.GetPage(s.Name, a.Slug, conf)
+			if err != nil || p.IsScheduled(time.Now()) {
+				continue
+			}
+			date := content.GetArticleDate(p, a.Modified)
+			article := gqlArticle{Section: s.Name, Slug: a.Slug, Page: content.MaskProtected(content.RunContentLoaded(s.Name, a.Slug, p)),
+				Date: date, Modified: a.Modified, Link: content.GetPermalink(s.Name, a.Slug, date, conf), Protected: p.IsProtected()}
+			articles = append(articles, article)
+		}
+	}
+	return articles, nil
+}
+
+/**
+ * Returns the GraphQL object of an article
+ */
+func getGqlArticleObject(a gqlArticle) gqlObject {
+	return gqlObject{
+		"section":     a.Section,
+		"slug":        a.Slug,
+		"title":       content.GetPageTitle(a.Page),
+		"url":         a.Link,
+		"date":        a.Date.Format(time.RFC3339),
+		"modified":    a.Modified.Format(time.RFC3339),
+		"author":      a.Page.Meta.Get("author"),
+		"description": content.GetPageDescription(a.Page),
+		"tags":        append([]string{}, a.Page.Meta.List("tags")...),
+		"meta":        a.Page.Meta,
+		"markdown":    a.Page.Body,
+		"summary": gqlResolver(func(args map[string]interface{}) (interface{}, error) {
+			return render.PageMarkdown(a.Section, a.Slug, content.Page{Meta: a.Page.Meta, Body: content.GetSummary(a.Page.Body)}), nil
+		}),
+		"content": gqlResolver(func(args map[string]interface{}) (interface{}, error) {
+			return render.PageMarkdown(a.Section, a.Slug, a.Page), nil
+		}),
+	}
+}
+
+/**
+ * Returns the articles matching the section, tag and author arguments
+ */
+func filterGqlArticles(articles []gqlArticle, args map[string]interface{}) []gqlArticle {
+	section, tag, author := gqlString(args, "section", ""), gqlString(args, "tag", ""), gqlString(args, "author", "")
+	var matches []gqlArticle
+	for _, a := range articles {
+		if len(section) > 0 && a.Section != section {
+			continue
+		}
+		if len(author) > 0 && !strings.EqualFold(a.Page.Meta.Get("author"), author) {
+			continue
+		}
+		if len(tag) > 0 {
+			tagged := false
+			for _, t := range a.Page.Meta.List("tags") {
+				tagged = tagged || strings.EqualFold(t, tag)
+			}
+			if !tagged {
+				continue
+			}
+		}
+		matches = append(matches, a)
+	}
+	return matches
+}
+
+/**
+ * Returns a resolver listing articles. Besides the filters, it takes the
+ * orderBy (DATE, MODIFIED or TITLE), order (ASC or DESC), first and offset
+ * arguments. Defaults to the newest articles first
+ */
+func getGqlArticlesResolver(articles []gqlArticle, defaults map[string]interface{}) gqlResolver {
+	return func(args map[string]interface{}) (interface{}, error) {
+		for name, value := range defaults {
+			args[name] = value
+		}
+		matches := filterGqlArticles(articles, args)
+		orderBy := strings.ToLower(gqlString(args, "orderBy", "date"))
+		desc := strings.ToLower(gqlString(args, "order", "desc")) == "desc"
+		var less func(a, b gqlArticle) bool
+		switch orderBy {
+		case "date":
+			less = func(a, b gqlArticle) bool { return a.Date.Before(b.Date) }
+		case "modified":
+			less = func(a, b gqlArticle) bool { return a.Modified.Before(b.Modified) }
+		case "title":
+			less = func(a, b gqlArticle) bool {
+				return strings.ToLower(content.GetPageTitle(a.Page)) < strings.ToLower(content.GetPageTitle(b.Page))
+			}
+		default:
+			return nil, errors.New("Unknown ordering " + orderBy)
+		}
+		sort.SliceStable(matches, func(i, j int) bool {
+			if desc {
+				return less(matches[j], matches[i])
+			}
+			return less(matches[i], matches[j])
+		})
+		offset, first := gqlInt(args, "offset", 0), gqlInt(args, "first", len(matches))
+		list := []gqlObject{}
+		for i := offset; i >= 0 && i < len(matches) && i < offset+first; i++ {
+			list = append(list, getGqlArticleObject(matches[i]))
+		}
+		return list, nil
+	}
+}
+
+/**
+ * Returns the root object of the GraphQL schema, giving access to the
+ * articles, sections, tags and menu of the site
+ */
+func getGqlRoot(conf *config.Config) (gqlObject, error) {
+	articles, err := getGqlArticles(conf)
+	if err != nil {
+		return nil, err
+	}
+	menu, err := content.GetMenu(conf)
+	if err != nil {
+		return nil, err
+	}
+	sections, err := content.GetSections(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	var sectionList []gqlObject
+	for _, s := range sections {
+		sectionList = append(sectionList, gqlObject{
+			"name":     s.Name,
+			"title":    menu.GetCurrent(s.Name, conf).Title,
+			"url":      "/" + content.GetSectionSlug(s.Name),
+			"hidden":   content.IsHiddenSection(s.Name, conf),
+			"count":    len(filterGqlArticles(articles, map[string]interface{}{"section": s.Name})),
+			"articles": getGqlArticlesResolver(articles, map[string]interface{}{"section": s.Name}),
+		})
+	}
+
+	counts := make(map[string]int)
+	for _, a := range articles {
+		for _, t := range a.Page.Meta.List("tags") {
+			counts[t]++
+		}
+	}
+	var tagList []gqlObject
+	for name, count := range counts {
+		tagList = append(tagList, gqlObject{
+			"name":     name,
+			"slug":     content.Slugify(name),
+			"count":    count,
+			"articles": getGqlArticlesResolver(articles, map[string]interface{}{"tag": name}),
+		})
+	}
+	sort.Slice(tagList, func(i, j int) bool {
+		if tagList[i]["count"] != tagList[j]["count"] {
+			return tagList[i]["count"].(int) > tagList[j]["count"].(int)
+		}
+		return tagList[i]["name"].(string) < tagList[j]["name"].(string)
+	})
+
+	var menuList []gqlObject
+	for _, item := range menu {
+		menuList = append(menuList, gqlObject{"title": item.Title, "link": item.Link,
+			"section": item.Section, "weight": item.Weight, "external": item.External})
+	}
+
+	return gqlObject{
+		"articles": getGqlArticlesResolver(articles, nil),
+		"articleCount": gqlResolver(func(args map[string]interface{}) (interface{}, error) {
+			return len(filterGqlArticles(articles, args)), nil
+		}),
+		"article": gqlResolver(func(args map[string]interface{}) (interface{}, error) {
+			for _, a := range articles {
+				if a.Section == gqlString(args, "section", "") && a.Slug == gqlString(args, "slug", "") {
+					return getGqlArticleObject(a), nil
+				}
+			}
+			return nil, nil
+		}),
+		"sections": gqlResolver(func(args map[string]interface{}) (interface{}, error) {
+			list := []gqlObject{}
+			for _, s := range sectionList {
+				if !s["hidden"].(bool) || args["hidden"] == true {
+					list = append(list, s)
+				}
+			}
+			return list, nil
+		}),
+		"tags": gqlResolver(func(args map[string]interface{}) (interface{}, error) {
+			first := gqlInt(args, "first", len(tagList))
+			if first < len(tagList) && first >= 0 {
+				return tagList[:first], nil
+			}
+			return tagList, nil
+		}),
+		"menu": menuList,
+	}, nil
+}
+
+/**
+ * GraphQL endpoint over the published content. Queries are read from the query
+ * and variables parameters, or from a JSON body when posted
+ */
+func handleGraphql(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		return apiError(ctx, 500, "Configuration error")
+	}
+	request := gqlRequest{Query: ctx.Params["query"]}
+	if len(ctx.Params["variables"]) > 0 {
+		if err = json.Unmarshal([]byte(ctx.Params["variables"]), &request.Variables); err != nil {
+			return apiResponse(ctx, 400, map[string][]gqlError{"errors": {{"Invalid variables"}}})
+		}
+	}
+	if ctx.Request.Method == "POST" && len(request.Query) == 0 {
+		bs, err := ioutil.ReadAll(ctx.Request.Body)
+		if err == nil && strings.HasPrefix(ctx.Request.Header.Get("Content-Type"), "application/graphql") {
+			request.Query = string(bs)
+		} else if err == nil {
+			err = json.Unmarshal(bs, &request)
+		}
+		if err != nil {
+			return apiResponse(ctx, 400, map[string][]gqlError{"errors": {{"Invalid request body"}}})
+		}
+	}
+	tokens, err := gqlTokenize(request.Query)
+	var fields []gqlField
+	if err == nil {
+		parser := gqlParser{tokens: tokens}
+		fields, err = parser.document()
+	}
+	if err != nil {
+		return apiResponse(ctx, 400, map[string][]gqlError{"errors": {{err.Error()}}})
+	}
+	root, err := getGqlRoot(&conf)
+	if err != nil {
+		return apiResponse(ctx, 500, map[string][]gqlError{"errors": {{"Could not read content"}}})
+	}
+	data, err := gqlExecute(root, fields, request.Variables)
+	if err != nil {
+		return apiResponse(ctx, 200, map[string]interface{}{"data": nil, "errors": []gqlError{{err.Error()}}})
+	}
+	return apiResponse(ctx, 200, map[string]interface{}{"data": data})
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"github.com/hoisie/web"
+)
+
+// Hook called with the response of a page about to be served, returning the
+// response to send instead
+type PageServedHook func(ctx *web.Context, response string) string
+
+var pageServedHooks []PageServedHook
+
+/**
+ * Registers a hook called before pages are served
+ */
+func OnPageServed(hook PageServedHook) {
+	pageServedHooks = append(pageServedHooks, hook)
+}
+
+/**
+ * Passes a response through the page served hooks
+ */
+func servePage(ctx *web.Context, response string) string {
+	for _, hook := range pageServedHooks {
+		response = hook(ctx, response)
+	}
+	return response
+}
@@ -0,0 +1,396 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"github.com/rredpoppy/gosite/pkg/render"
+	"io/fs"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Struct representing an image or video of the content store, with the
+// articles using it
+type MediaFile struct {
+	Name     string         `json:"name"`
+	Url      string         `json:"url"`
+	Type     string         `json:"type"`
+	Size     int64          `json:"size"`
+	Modified time.Time      `json:"modified"`
+	UsedBy   []ArticleUsage `json:"usedBy"`
+}
+
+// Struct representing an article using a media file
+type ArticleUsage struct {
+	Section string `json:"section"`
+	Slug    string `json:"slug"`
+	Title   string `json:"title"`
+}
+
+// Struct representing the body of a rename in the content API
+type MediaRename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Gallery shortcodes, whose folder is relative to the section folder
+var galleryPattern = regexp.MustCompile(`\{\{<\s*gallery\s+([^\s>]+)`)
+
+// Struct representing an article of the content store, with its markdown
+type storedArticle struct {
+	ArticleUsage
+	Body string
+}
+
+/**
+ * Returns all the articles of the content store, drafts included
+ */
+func getStoredArticles(conf *config.Config) ([]storedArticle, error) {
+	var articles []storedArticle
+	sections, err := content.GetSections(conf)
+	if err != nil {
+		return articles, err
+	}
+	for _, s := range sections {
+		for _, a := range s.Articles {
+			p, err := content.GetPage(s.Name, a.Slug, conf)
+			if err == nil {
+				articles = append(articles, storedArticle{ArticleUsage: ArticleUsage{Section: s.Name, Slug: a.Slug, Title: a.Title},
+					Body: p.Meta.String() + p.Body})
+			}
+		}
+	}
+	return articles, nil
+}
+
+/**
+ * Returns true if an article uses a media file: it links to it under /media
+ * or /thumbs, names it relative to its section folder, like the video
+ * shortcode does, or shows the gallery of its folder
+ */
+func usesMedia(a storedArticle, name string) bool {
+	if strings.Contains(a.Body, "/"+name) {
+		return true
+	}
+	rel := strings.TrimPrefix(name, a.Section+"/")
+	if rel == name {
+		return false
+	}
+	if strings.Contains(a.Body, " "+rel) || strings.Contains(a.Body, "("+rel+")") {
+		return true
+	}
+	for _, m := range galleryPattern.FindAllStringSubmatch(a.Body, -1) {
+		if path.Join(a.Section, m[1]) == path.Dir(name) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Returns the images and videos of the content store, sorted by name, with
+ * the articles using them
+ */
+func getMediaFiles(conf *config.Config) ([]MediaFile, error) {
+	files := []MediaFile{}
+	articles, err := getStoredArticles(conf)
+	if err != nil {
+		return files, err
+	}
+	store := content.GetContentStore(conf)
+	err = fs.WalkDir(store, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || render.GetImageType(name)+render.GetVideoType(name) == "" {
+			return err
+		}
+		fi, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		file := MediaFile{Name: name, Url: "/media/" + name, Type: render.GetImageType(name) + render.GetVideoType(name),
+			Size: fi.Size(), Modified: fi.ModTime(), UsedBy: []ArticleUsage{}}
+		for _, a := range articles {
+			if usesMedia(a, name) {
+				file.UsedBy = append(file.UsedBy, a.ArticleUsage)
+			}
+		}
+		files = append(files, file)
+		return nil
+	})
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name < files[j].Name
+	})
+	return files, err
+}
+
+/**
+ * Returns the clean name of a media file, or an error when the name is not
+ * the one of an image or a video
+ */
+func getMediaName(name string) (string, error) {
+	name, err := render.CleanContentName(name)
+	if err != nil || render.GetImageType(name)+render.GetVideoType(name) == "" {
+		return "", os.ErrNotExist
+	}
+	return name, nil
+}
+
+/**
+ * Deletes a media file
+ */
+func deleteMedia(name string, conf *config.Config) error {
+	name, err := getMediaName(name)
+	if err != nil {
+		return err
+	}
+	store := content.GetContentStore(conf)
+	if _, err = store.Stat(name); err != nil {
+		return err
+	}
+	return store.Remove(name)
+}
+
+/**
+ * Renames a media file, keeping its type, and updates the links under /media
+ * and /thumbs of the articles using it. Returns the changed articles. Fails
+ * with os.ErrExist when the new name is taken
+ */
+func renameMedia(from string, to string, conf *config.Config) ([]ArticleUsage, error) {
+	var changed []ArticleUsage
+	from, err := getMediaName(from)
+	if err != nil {
+		return changed, err
+	}
+	to, err = getMediaName(to)
+	if err != nil || render.GetImageType(from) != render.GetImageType(to) ||
+		render.GetVideoType(from) != render.GetVideoType(to) {
+		return changed, errInvalidMediaName
+	}
+	store := content.GetContentStore(conf)
+	// Files may move to any folder of an existing section, but new folders of
+	// the content root would be new sections
+	if top := strings.SplitN(to, "/", 2); len(top) > 1 {
+		if fi, err := store.Stat(top[0]); err != nil || !fi.IsDir() {
+			return changed, errInvalidMediaName
+		}
+	}
+	if _, err = store.Stat(to); err == nil {
+		return changed, os.ErrExist
+	}
+	bs, err := store.ReadFile(from)
+	if err != nil {
+		return changed, err
+	}
+	if err = store.WriteFile(to, bs); err != nil {
+		return changed, err
+	}
+	if err = store.Remove(from); err != nil {
+		return changed, err
+	}
+	articles, err := getStoredArticles(conf)
+	if err != nil {
+		return changed, err
+	}
+	links := regexp.MustCompile(`(/media|/thumbs/[0-9]+)/` + regexp.QuoteMeta(from) + `\b`)
+	for _, a := range articles {
+		if !links.MatchString(a.Body) {
+			continue
+		}
+		p, err := content.GetPage(a.Section, a.Slug, conf)
+		if err != nil {
+			continue
+		}
+		p.Body = links.ReplaceAllString(p.Body, "${1}/"+to)
+		for key, value := range p.Meta {
+			p.Meta[key] = links.ReplaceAllString(value, "${1}/"+to)
+		}
+		if err = content.SavePage(a.Section, a.Slug, p, conf); err != nil {
+			return changed, err
+		}
+		changed = append(changed, a.ArticleUsage)
+	}
+	return changed, nil
+}
+
+// Error of a rename to a name which is not one of a media file of the same
+// type, within an existing section
+var errInvalidMediaName = errors.New("Invalid media file name")
+
+// Media library of the admin area
+const adminMediaBody = `{{ define "body" }}
+{{ if not .Files }}<p>No images nor videos yet.</p>{{ end }}
+<table class="table">
+  {{ range .Files }}
+  <tr>
+    <td>{{ if eq (slice .Type 0 5) "image" }}<img src="/thumbs/120/{{ .Name }}" width="120" alt="">{{ end }}</td>
+    <td><a href="{{ .Url }}">{{ .Name }}</a><br><small>{{ .Type }}, {{ .Size }} bytes, {{ .Modified.Format "2006-01-02 15:04" }}</small></td>
+    <td>
+      {{ range .UsedBy }}<a href="/admin/edit/{{ .Section }}/{{ .Slug }}">{{ .Section }}/{{ .Slug }}</a><br>{{ else }}<em>unused</em>{{ end }}
+    </td>
+    {{ if $.CanEdit }}
+    <td>
+      <form class="form-inline" method="post" action="/admin/media/rename">
+        {{ csrfField }}
+        <input type="hidden" name="name" value="{{ .Name }}">
+        <input class="form-control" name="to" value="{{ .Name }}" required>
+        <button class="btn btn-default" type="submit">Rename</button>
+      </form>
+      <form class="form-inline" method="post" action="/admin/media/delete">
+        {{ csrfField }}
+        <input type="hidden" name="name" value="{{ .Name }}">
+        <button class="btn btn-danger" type="submit">Delete</button>
+      </form>
+    </td>
+    {{ end }}
+  </tr>
+  {{ end }}
+</table>
+{{ end }}`
+
+/**
+ * Records the changes of the articles whose links were updated by a rename
+ */
+func mediaRenamed(ctx *web.Context, conf *config.Config, changed []ArticleUsage) {
+	for _, a := range changed {
+		contentChanged(ctx, conf, a.Section, a.Slug, "Update")
+	}
+}
+
+/**
+ * Lists the images and videos of the content store in the admin area
+ */
+func handleAdminMedia(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	account, ok := checkAdminAuth(ctx, &conf, RoleAuthor)
+	if !ok {
+		return ""
+	}
+	files, err := getMediaFiles(&conf)
+	if err != nil {
+		ctx.Abort(500, "Could not read content")
+		return ""
+	}
+	response, err := renderAdmin(ctx, adminMediaBody, map[string]interface{}{
+		"Title": "Media", "Files": files, "CanEdit": account.Can(RoleEditor)})
+	if err != nil {
+		ctx.Abort(500, err.Error())
+		return ""
+	}
+	return response
+}
+
+/**
+ * Deletes or renames a media file from the admin area
+ */
+func handleAdminMediaAction(ctx *web.Context, action string) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	if _, ok := checkAdminAuth(ctx, &conf, RoleEditor); !ok {
+		return ""
+	}
+	if !checkCsrf(ctx) {
+		ctx.Abort(403, "Invalid or missing CSRF token.")
+		return ""
+	}
+	if action == "delete" {
+		err = deleteMedia(ctx.Params["name"], &conf)
+	} else {
+		var changed []ArticleUsage
+		changed, err = renameMedia(ctx.Params["name"], ctx.Params["to"], &conf)
+		mediaRenamed(ctx, &conf, changed)
+	}
+	switch {
+	case os.IsNotExist(err):
+		ctx.Abort(404, "File not found.")
+	case os.IsExist(err):
+		ctx.Abort(409, "File already exists.")
+	case err == errInvalidMediaName:
+		ctx.Abort(400, "Invalid file name.")
+	case err != nil:
+		ctx.Abort(500, "Could not change file")
+	default:
+		ctx.Redirect(303, "/admin/media")
+	}
+	return ""
+}
+
+/**
+ * Lists the images and videos of the content store, with the articles using
+ * them
+ */
+func handleApiMedia(ctx *web.Context) string {
+	conf, _, response, ok := getApiConfig(ctx, RoleAuthor)
+	if !ok {
+		return response
+	}
+	files, err := getMediaFiles(&conf)
+	if err != nil {
+		return apiError(ctx, 500, "Could not read content")
+	}
+	return apiResponse(ctx, 200, files)
+}
+
+/**
+ * Deletes a media file
+ */
+func handleApiDeleteMedia(ctx *web.Context, name string) string {
+	conf, _, response, ok := getApiConfig(ctx, RoleEditor)
+	if !ok {
+		return response
+	}
+	name, _ = url.PathUnescape(name)
+	if err := deleteMedia(name, &conf); os.IsNotExist(err) {
+		return apiError(ctx, 404, "File not found")
+	} else if err != nil {
+		return apiError(ctx, 500, "Could not delete file")
+	}
+	ctx.WriteHeader(204)
+	return ""
+}
+
+/**
+ * Renames a media file from a {"from": ..., "to": ...} body, returning the
+ * articles whose links were updated
+ */
+func handleApiRenameMedia(ctx *web.Context) string {
+	conf, _, response, ok := getApiConfig(ctx, RoleEditor)
+	if !ok {
+		return response
+	}
+	var input MediaRename
+	bs, err := ioutil.ReadAll(ctx.Request.Body)
+	if err != nil || json.Unmarshal(bs, &input) != nil {
+		return apiError(ctx, 400, "Invalid rename")
+	}
+	changed, err := renameMedia(input.From, input.To, &conf)
+	mediaRenamed(ctx, &conf, changed)
+	switch {
+	case os.IsNotExist(err):
+		return apiError(ctx, 404, "File not found")
+	case os.IsExist(err):
+		return apiError(ctx, 409, "File already exists")
+	case err == errInvalidMediaName:
+		return apiError(ctx, 400, "Invalid file name")
+	case err != nil:
+		return apiError(ctx, 500, "Could not rename file")
+	}
+	if changed == nil {
+		changed = []ArticleUsage{}
+	}
+	return apiResponse(ctx, 200, changed)
+}
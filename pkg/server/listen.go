@@ -0,0 +1,56 @@
+package server
+
+import (
+	"errors"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Prefix of the ServerIp config entries naming a unix domain socket
+const unixSocketPrefix = "unix:"
+
+/**
+ * Returns the listener of the ServerIp config entry: a TCP address, or the
+ * path of a unix domain socket prefixed with "unix:", e.g.
+ * "unix:/run/gosite.sock". Sockets get the permissions of SocketMode, an octal
+ * mode like "0660", and a socket left over by a previous run is replaced
+ */
+func Listen(conf *config.Config) (net.Listener, error) {
+	if !strings.HasPrefix(conf.ServerIp, unixSocketPrefix) {
+		return net.Listen("tcp", conf.ServerIp)
+	}
+	file := strings.TrimPrefix(conf.ServerIp, unixSocketPrefix)
+	if len(file) == 0 {
+		return nil, errors.New("No socket path set in the ServerIp config entry")
+	}
+	var mode uint64
+	if len(conf.SocketMode) > 0 {
+		var err error
+		if mode, err = strconv.ParseUint(conf.SocketMode, 8, 32); err != nil {
+			return nil, errors.New("Invalid SocketMode " + conf.SocketMode)
+		}
+	}
+	// Only sockets are removed, never a file the path points to by mistake
+	if fi, err := os.Lstat(file); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, errors.New(file + " exists and is not a socket")
+		}
+		if err = os.Remove(file); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", file)
+	if err != nil {
+		return nil, err
+	}
+	if mode > 0 {
+		if err = os.Chmod(file, os.FileMode(mode)); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+	return listener, nil
+}
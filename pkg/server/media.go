@@ -0,0 +1,87 @@
+package server
+
+import (
+	"bytes"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"github.com/rredpoppy/gosite/pkg/render"
+	"net/http"
+	"strconv"
+)
+
+/**
+ * Returns an image, or its thumbnail of the given width when it is not zero,
+ * in the most compact format the browser accepts, along with its MIME type.
+ * The image is returned as it is when no conversion is better
+ */
+func negotiateImage(ctx *web.Context, name string, width int, bs []byte, mime string, conf *config.Config) ([]byte, string) {
+	if !render.CanConvertImage(name, conf) {
+		return bs, mime
+	}
+	// Caches must keep a version per format
+	ctx.SetHeader("Vary", "Accept", true)
+	if t := render.GetDerivativeType(ctx.Request.Header.Get("Accept"), conf); len(t) > 0 {
+		if converted, err := render.GetDerivative(name, width, t, conf); err == nil {
+			return converted, t
+		}
+	}
+	return bs, mime
+}
+
+/**
+ * Serves the images and videos of the content folder, like the ones of the
+ * galleries
+ */
+func handleMedia(ctx *web.Context, name string) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	name, err = render.CleanContentName(name)
+	mime := render.GetImageType(name) + render.GetVideoType(name)
+	if err != nil || len(mime) == 0 {
+		return renderNotFound(ctx, &conf)
+	}
+	store := content.GetContentStore(&conf)
+	fi, err := store.Stat(name)
+	if err != nil {
+		return renderNotFound(ctx, &conf)
+	}
+	bs, err := store.ReadFile(name)
+	if err != nil {
+		return renderNotFound(ctx, &conf)
+	}
+	bs, mime = negotiateImage(ctx, name, 0, bs, mime, &conf)
+	// Range requests let browsers seek in videos
+	ctx.SetHeader("Content-Type", mime, true)
+	http.ServeContent(ctx, ctx.Request, name, fi.ModTime(), bytes.NewReader(bs))
+	return ""
+}
+
+/**
+ * Serves the thumbnails of the images of the content folder
+ */
+func handleThumbnail(ctx *web.Context, width string, name string) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	w, err := strconv.Atoi(width)
+	if err != nil {
+		return renderNotFound(ctx, &conf)
+	}
+	name, err = render.CleanContentName(name)
+	if err != nil {
+		return renderNotFound(ctx, &conf)
+	}
+	bs, mime, err := render.GetThumbnail(name, w, &conf)
+	if err != nil {
+		return renderNotFound(ctx, &conf)
+	}
+	bs, mime = negotiateImage(ctx, name, w, bs, mime, &conf)
+	ctx.SetHeader("Content-Type", mime, true)
+	return string(bs)
+}
@@ -0,0 +1,448 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/subtle"
+	"errors"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Function wrapping an HTTP handler with cross-cutting behavior
+type Middleware func(next http.Handler) http.Handler
+
+// Function building a middleware from its config entry
+type MiddlewareFactory func(conf config.MiddlewareConfig) (Middleware, error)
+
+// Middlewares available to the Middleware config entry, by name
+var middlewares = map[string]MiddlewareFactory{
+	"logging":  newLoggingMiddleware,
+	"recovery": newRecoveryMiddleware,
+	"gzip":     newGzipMiddleware,
+	"auth":     newAuthMiddleware,
+	"cache":    newCacheMiddleware,
+	"cors":     newCorsMiddleware,
+}
+
+// Logger of the built-in middlewares, the one of the site server once it is
+// set up
+var middlewareLogger *log.Logger
+
+/**
+ * Returns the logger of the built-in middlewares, or the standard logger when
+ * they are used without the site server
+ */
+func getMiddlewareLogger() *log.Logger {
+	if middlewareLogger == nil {
+		return log.Default()
+	}
+	return middlewareLogger
+}
+
+/**
+ * Registers a middleware under the given name, so it can be enabled from the
+ * Middleware config entry. Middlewares must be registered before the server
+ * starts
+ */
+func RegisterMiddleware(name string, factory MiddlewareFactory) {
+	middlewares[name] = factory
+}
+
+/**
+ * Wraps a handler with the middlewares listed in the config, the first one
+ * being the outermost
+ */
+func getMiddlewareChain(handler http.Handler, conf *config.Config) (http.Handler, error) {
+	for i := len(conf.Middleware) - 1; i >= 0; i-- {
+		entry := conf.Middleware[i]
+		factory, ok := middlewares[entry.Name]
+		if !ok {
+			return nil, errors.New("Unknown middleware " + entry.Name)
+		}
+		m, err := factory(entry)
+		if err != nil {
+			return nil, err
+		}
+		var routes []*regexp.Regexp
+		for _, route := range entry.Routes {
+			re, err := regexp.Compile(route)
+			if err != nil {
+				return nil, err
+			}
+			routes = append(routes, re)
+		}
+		handler = routeMiddleware(m, routes, handler)
+	}
+	return handler, nil
+}
+
+/**
+ * Applies a middleware to the requests whose path matches one of the routes
+ */
+func routeMiddleware(m Middleware, routes []*regexp.Regexp, next http.Handler) http.Handler {
+	wrapped := m(next)
+	if len(routes) == 0 {
+		return wrapped
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, re := range routes {
+			if re.MatchString(r.URL.Path) {
+				wrapped.ServeHTTP(w, r)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Response writer keeping track of the status sent
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = 200
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+/**
+ * Logs every request with the address of the client, its status and duration
+ */
+func newLoggingMiddleware(conf config.MiddlewareConfig) (Middleware, error) {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, r)
+			getMiddlewareLogger().Printf("[%s] %s %s %s %d %s", getRequestID(r), getClientAddr(r), r.Method, r.URL.RequestURI(),
+				sw.status, time.Since(start))
+		})
+	}, nil
+}
+
+/**
+ * Turns panics into 500 responses instead of dropping the connection
+ */
+func newRecoveryMiddleware(conf config.MiddlewareConfig) (Middleware, error) {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if err := recover(); err != nil {
+					getMiddlewareLogger().Printf("[%s] Panic serving %s: %v", getRequestID(r), r.URL.Path, err)
+					http.Error(w, "Internal server error\n\nReference: "+getRequestID(r), 500)
+				}
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// Response writer compressing the body it is given
+type gzipWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (w *gzipWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		h := w.Header()
+		if status != 204 && status != 304 && len(h.Get("Content-Encoding")) == 0 {
+			h.Set("Content-Encoding", "gzip")
+			h.Del("Content-Length")
+			w.gz = gzip.NewWriter(w.ResponseWriter)
+		}
+		w.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (w *gzipWriter) Write(b []byte) (int, error) {
+	// The type is sniffed from the uncompressed body
+	if !w.wroteHeader && len(w.Header().Get("Content-Type")) == 0 {
+		w.Header().Set("Content-Type", http.DetectContentType(b))
+	}
+	w.WriteHeader(200)
+	if w.gz == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.gz.Write(b)
+}
+
+/**
+ * Compresses responses for clients accepting gzip
+ */
+func newGzipMiddleware(conf config.MiddlewareConfig) (Middleware, error) {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") || r.Method == "HEAD" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			gw := &gzipWriter{ResponseWriter: w}
+			next.ServeHTTP(gw, r)
+			if gw.gz != nil {
+				gw.gz.Close()
+			}
+		})
+	}, nil
+}
+
+/**
+ * Protects routes with HTTP basic auth, using the User and Password of the
+ * middleware entry, or the admin credentials when not set
+ */
+func newAuthMiddleware(conf config.MiddlewareConfig) (Middleware, error) {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			expectedUser, expectedPassword := conf.User, conf.Password
+			if len(expectedPassword) == 0 {
+				site, err := config.Load()
+				if err != nil {
+					http.Error(w, "Configuration error.", 500)
+					return
+				}
+				expectedUser, expectedPassword = site.Admin.User, site.Admin.Password
+			}
+			user, password, ok := r.BasicAuth()
+			if !ok || len(expectedPassword) == 0 ||
+				subtle.ConstantTimeCompare([]byte(user), []byte(expectedUser)) != 1 ||
+				subtle.ConstantTimeCompare([]byte(password), []byte(expectedPassword)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Basic realm=\"gosite\"")
+				http.Error(w, "Unauthorized", 401)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
+
+// Number of responses the cache middleware keeps at most
+const cacheMaxEntries = 1000
+
+// Response stored by the cache middleware
+type cachedResponse struct {
+	header  http.Header
+	body    []byte
+	expires time.Time
+}
+
+// Response writer recording the response it is given. The header is recorded
+// as the handler sent it, before the writers it wraps, like the one of the
+// gzip middleware, change it for the body they write
+type recordingWriter struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (w *recordingWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+		w.header = w.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *recordingWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = 200
+		w.header = w.Header().Clone()
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+/**
+ * Returns true if a cors middleware entry lets the given origin call the
+ * site, so its responses carry CORS headers of their own
+ */
+func isCorsOrigin(origin string) bool {
+	site, err := config.Load()
+	if err != nil || len(origin) == 0 {
+		return false
+	}
+	for _, entry := range site.Middleware {
+		if entry.Name != "cors" {
+			continue
+		}
+		for _, allowed := range entry.Origins {
+			if allowed == "*" || strings.TrimSuffix(allowed, "/") == origin {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+/**
+ * Returns what a response kept by the cache middleware varies with: the
+ * format asked for by the Accept header and the image formats it accepts,
+ * whether the client takes gzip, and the origin of the cross-origin requests
+ * the cors middleware allows. Browsers sending their Accept headers in
+ * different words share responses, and so do the origins CORS is not enabled
+ * for
+ */
+func getCacheVariant(r *http.Request) string {
+	accept := r.Header.Get("Accept")
+	variant := []string{negotiateFormat(accept),
+		strconv.FormatBool(strings.Contains(r.Header.Get("Accept-Encoding"), "gzip"))}
+	if origin := r.Header.Get("Origin"); isCorsOrigin(origin) {
+		variant = append(variant, origin)
+	}
+	for _, t := range []string{"image/avif", "image/webp"} {
+		if strings.Contains(accept, t) {
+			variant = append(variant, t)
+		}
+	}
+	return strings.Join(variant, "\n")
+}
+
+/**
+ * Makes room for a response in a cache holding cacheMaxEntries of them:
+ * expired responses are dropped first, then random ones
+ */
+func evictCachedResponses(cache map[string]cachedResponse) {
+	if len(cache) < cacheMaxEntries {
+		return
+	}
+	now := time.Now()
+	for k, c := range cache {
+		if now.After(c.expires) {
+			delete(cache, k)
+		}
+	}
+	// Maps are iterated in a random order
+	for k := range cache {
+		if len(cache) < cacheMaxEntries {
+			break
+		}
+		delete(cache, k)
+	}
+}
+
+/**
+ * Keeps successful responses to anonymous GET requests in memory for MaxAge
+ * seconds, a minute by default, or until the content changes, up to
+ * cacheMaxEntries of them. Responses are kept by scheme, host and path, and
+ * vary with the Accept, Accept-Encoding and allowed Origin headers. Clients
+ * holding the ETag of a kept response get a 304
+ */
+func newCacheMiddleware(conf config.MiddlewareConfig) (Middleware, error) {
+	ttl := time.Duration(conf.MaxAge) * time.Second
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+	var mu sync.Mutex
+	cache := make(map[string]cachedResponse)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != "GET" || len(r.Header.Get("Authorization")) > 0 || len(r.Header.Get("Cookie")) > 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			scheme := "http"
+			if isSecureRequest(r) {
+				scheme = "https"
+			}
+			key := scheme + "://" + r.Host + r.URL.RequestURI() + "\n" + getCacheVariant(r) + "\n" + content.GetContentVersion()
+			mu.Lock()
+			cached, ok := cache[key]
+			mu.Unlock()
+			if ok && time.Now().Before(cached.expires) {
+				for name, values := range cached.header {
+					w.Header()[name] = values
+				}
+				w.Header().Set("X-Cache", "HIT")
+				if etag := cached.header.Get("ETag"); len(etag) > 0 && matchesETag(r.Header.Get("If-None-Match"), etag) {
+					w.Header().Del("Content-Type")
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+				w.Write(cached.body)
+				return
+			}
+			rw := &recordingWriter{ResponseWriter: w}
+			next.ServeHTTP(rw, r)
+			if rw.status == 200 && len(rw.header.Get("Set-Cookie")) == 0 {
+				header := rw.header
+				// Every request gets its own ID, hits included
+				header.Del(requestIDHeader)
+				mu.Lock()
+				evictCachedResponses(cache)
+				cache[key] = cachedResponse{header: header, body: rw.body.Bytes(), expires: time.Now().Add(ttl)}
+				mu.Unlock()
+			}
+		})
+	}, nil
+}
+
+/**
+ * Lets browser apps on the Origins of the entry, or on any site with "*",
+ * call the routes: cross-origin requests get the CORS headers allowing the
+ * Methods (GET, HEAD and POST by default) and the request Headers, and
+ * preflight requests are answered directly. Browsers may cache preflight
+ * answers for MaxAge seconds
+ */
+func newCorsMiddleware(conf config.MiddlewareConfig) (Middleware, error) {
+	if len(conf.Origins) == 0 {
+		return nil, errors.New("The cors middleware needs Origins")
+	}
+	methods := conf.Methods
+	if len(methods) == 0 {
+		methods = []string{"GET", "HEAD", "POST"}
+	}
+	any := false
+	origins := make(map[string]bool)
+	for _, origin := range conf.Origins {
+		any = any || origin == "*"
+		origins[strings.TrimSuffix(origin, "/")] = true
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if !any {
+				w.Header().Add("Vary", "Origin")
+			}
+			if len(origin) == 0 || !(any || origins[origin]) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if any {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if r.Method != "OPTIONS" || len(r.Header.Get("Access-Control-Request-Method")) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			if len(conf.Headers) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(conf.Headers, ", "))
+			}
+			if conf.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(conf.MaxAge))
+			}
+			w.WriteHeader(204)
+		})
+	}, nil
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"compress/gzip"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+/**
+ * Returns the cache middleware wrapping a handler which answers each request
+ * with the number of requests it served
+ */
+func newTestCache(t *testing.T) (http.Handler, *int) {
+	m, err := newCacheMiddleware(config.MiddlewareConfig{Name: "cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := 0
+	return m(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+		w.Write([]byte(strconv.Itoa(served)))
+	})), &served
+}
+
+func TestCacheKeepsHostsApart(t *testing.T) {
+	handler, served := newTestCache(t)
+	for _, url := range []string{"http://a.example.com/", "http://b.example.com/", "https://a.example.com/", "http://a.example.com/"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", url, nil))
+	}
+	if *served != 3 {
+		t.Errorf("served %d requests for 3 distinct hosts and schemes, want 3", *served)
+	}
+}
+
+func TestCacheSharesUnknownOrigins(t *testing.T) {
+	handler, served := newTestCache(t)
+	for _, origin := range []string{"", "https://one.example.com", "https://two.example.com"} {
+		r := httptest.NewRequest("GET", "/", nil)
+		if len(origin) > 0 {
+			r.Header.Set("Origin", origin)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	if *served != 1 {
+		t.Errorf("served %d requests from origins without CORS, want 1", *served)
+	}
+}
+
+func TestCacheIsBounded(t *testing.T) {
+	cache := make(map[string]cachedResponse)
+	for i := 0; i < cacheMaxEntries+10; i++ {
+		evictCachedResponses(cache)
+		cache[strconv.Itoa(i)] = cachedResponse{expires: time.Now().Add(time.Hour)}
+	}
+	if len(cache) > cacheMaxEntries {
+		t.Errorf("cache holds %d responses, want at most %d", len(cache), cacheMaxEntries)
+	}
+}
+
+func TestCacheBehindGzip(t *testing.T) {
+	zip, err := newGzipMiddleware(config.MiddlewareConfig{Name: "gzip"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, _ := newTestCache(t)
+	handler := zip(cache)
+	for i, want := range []string{"", "HIT"} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Header().Get("X-Cache") != want {
+			t.Errorf("request %d has X-Cache %q, want %q", i, w.Header().Get("X-Cache"), want)
+		}
+		gz, err := gzip.NewReader(w.Body)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if body, err := ioutil.ReadAll(gz); err != nil || string(body) != "1" {
+			t.Errorf("request %d decoded to %q with error %v, want \"1\"", i, body, err)
+		}
+	}
+}
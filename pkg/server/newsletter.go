@@ -0,0 +1,189 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lock guarding the subscribers file against concurrent writes
+var subscribersLock sync.Mutex
+
+/**
+ * Returns the signature of a confirmation link for the given address and
+ * expiry time
+ */
+func getSubscriptionToken(email string, expires int64, conf *config.Config) string {
+	mac := hmac.New(sha256.New, []byte(conf.Newsletter.Secret))
+	mac.Write([]byte(strings.ToLower(email) + "|" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/**
+ * Appends a confirmed address to the subscribers file, one address per line,
+ * unless it is already listed
+ */
+func storeSubscriber(email string, conf *config.Config) error {
+	subscribersLock.Lock()
+	defer subscribersLock.Unlock()
+	file := conf.Newsletter.File
+	if len(file) == 0 {
+		file = "subscribers.txt"
+	}
+	store, name := content.OpenDataStore(filepath.Dir(file)), filepath.Base(file)
+	bs, err := store.ReadFile(name)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, line := range strings.Split(string(bs), "\n") {
+		if strings.EqualFold(strings.Split(line, "\t")[0], email) {
+			return nil
+		}
+	}
+	return content.AppendFile(store, name, []byte(email+"\t"+time.Now().Format(time.RFC3339)+"\n"))
+}
+
+/**
+ * Posts a JSON payload to a newsletter provider API
+ */
+func postToProvider(endpoint string, payload interface{}, auth func(*http.Request)) error {
+	bs, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(bs))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	auth(req)
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return errors.New("Newsletter provider answered " + resp.Status)
+	}
+	return nil
+}
+
+/**
+ * Records a confirmed subscription with the configured provider
+ */
+func addSubscriber(email string, conf *config.Config) error {
+	n := conf.Newsletter
+	switch n.Provider {
+	case "buttondown":
+		return postToProvider("https://api.buttondown.email/v1/subscribers",
+			map[string]string{"email_address": email},
+			func(req *http.Request) {
+				req.Header.Set("Authorization", "Token "+n.ApiKey)
+			})
+	case "mailchimp":
+		// The data center is the suffix of the API key, as in "key-us6"
+		parts := strings.Split(n.ApiKey, "-")
+		endpoint := "https://" + parts[len(parts)-1] + ".api.mailchimp.com/3.0/lists/" +
+			url.PathEscape(n.ListId) + "/members"
+		return postToProvider(endpoint,
+			map[string]string{"email_address": email, "status": "subscribed"},
+			func(req *http.Request) {
+				req.SetBasicAuth("gosite", n.ApiKey)
+			})
+	}
+	return storeSubscriber(email, conf)
+}
+
+/**
+ * Handles subscription requests by mailing a confirmation link to the address
+ */
+func handleSubscribe(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	if len(conf.Newsletter.Provider) == 0 || len(conf.Newsletter.Secret) == 0 {
+		ctx.Abort(404, "Page not found.")
+		return ""
+	}
+	if !checkCsrf(ctx) {
+		return renderCsrfError(ctx, &conf)
+	}
+	address, err := mail.ParseAddress(strings.TrimSpace(ctx.Params["email"]))
+	if err != nil || len(ctx.Params["website"]) > 0 {
+		ctx.WriteHeader(400)
+		return renderMessage(ctx, &conf, "Newsletter", "Please enter a valid email address.", nil)
+	}
+
+	expires := time.Now().Add(48 * time.Hour).Unix()
+	link := getRootURL(ctx, &conf) + "/subscribe/confirm?" + url.Values{
+		"email":   {address.Address},
+		"expires": {strconv.FormatInt(expires, 10)},
+		"token":   {getSubscriptionToken(address.Address, expires, &conf)},
+	}.Encode()
+	subject := conf.Newsletter.Subject
+	if len(subject) == 0 {
+		subject = "Please confirm your subscription"
+	}
+	body := "Please confirm your subscription to " + conf.Site.Title +
+		" by following this link:\n\n" + link + "\n\nIf you did not subscribe, just ignore this message.\n"
+	if err = sendMail(&conf.Smtp, []string{address.Address}, subject, "", body); err != nil {
+		ctx.WriteHeader(500)
+		return renderMessage(ctx, &conf, "Newsletter", "Sorry, the confirmation mail could not be sent.", nil)
+	}
+	message := conf.Newsletter.SuccessMessage
+	if len(message) == 0 {
+		message = "Almost done! Please check your inbox to confirm your subscription."
+	}
+	return renderMessage(ctx, &conf, "Newsletter", message, nil)
+}
+
+/**
+ * Handles the confirmation links, recording the subscription when the link is
+ * valid and not expired
+ */
+func handleConfirmSubscription(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	if len(conf.Newsletter.Provider) == 0 || len(conf.Newsletter.Secret) == 0 {
+		ctx.Abort(404, "Page not found.")
+		return ""
+	}
+	email := ctx.Params["email"]
+	expires, err := strconv.ParseInt(ctx.Params["expires"], 10, 64)
+	token := getSubscriptionToken(email, expires, &conf)
+	if err != nil || time.Now().Unix() > expires ||
+		!hmac.Equal([]byte(token), []byte(ctx.Params["token"])) {
+		ctx.WriteHeader(400)
+		return renderMessage(ctx, &conf, "Newsletter", "This confirmation link is invalid or has expired.", nil)
+	}
+	if err = addSubscriber(email, &conf); err != nil {
+		ctx.WriteHeader(500)
+		return renderMessage(ctx, &conf, "Newsletter", "Sorry, your subscription could not be recorded.", nil)
+	}
+	message := conf.Newsletter.ConfirmMessage
+	if len(message) == 0 {
+		message = "Thank you, your subscription is confirmed."
+	}
+	return renderMessage(ctx, &conf, "Newsletter", message, nil)
+}
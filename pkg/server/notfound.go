@@ -0,0 +1,306 @@
+package server
+
+import (
+	"bufio"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"html"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Longest referrer kept in the not found log
+const maxReferrerLength = 500
+
+// Longest path kept in the not found log
+const maxNotFoundPathLength = 500
+
+// Number of paths tracked at most. The least hit ones make room for new ones
+const maxNotFoundEntries = 1000
+
+// Number of referrers tracked at most for a path
+const maxNotFoundReferrers = 20
+
+// Number of hits appended to the not found log before it is rewritten from
+// the tracked paths
+const maxNotFoundAppends = 10000
+
+// Struct representing a path answered with a 404, with the pages linking to
+// it
+type NotFoundEntry struct {
+	Path      string         `json:"path"`
+	Count     int            `json:"count"`
+	LastSeen  time.Time      `json:"lastSeen"`
+	Referrers map[string]int `json:"referrers"`
+}
+
+// Tracker of the requests answered with a 404. Hits are appended to a log
+// file holding only the time, the path and the referrer, and the number of
+// hits when the log was rewritten from the tracked paths
+type NotFoundTracker struct {
+	sync.Mutex
+	loaded   bool
+	appended int
+	entries  map[string]*NotFoundEntry
+}
+
+// Tracker shared by all requests
+var notFoundTracker = &NotFoundTracker{entries: make(map[string]*NotFoundEntry)}
+
+/**
+ * Returns the store holding the not found log, and the name of the log
+ * within it
+ */
+func getNotFoundFile(conf *config.Config) (content.Store, string) {
+	file := conf.NotFound.File
+	if len(file) == 0 {
+		file = "notfound.log"
+	}
+	return content.OpenDataStore(filepath.Dir(file)), filepath.Base(file)
+}
+
+/**
+ * Returns the first bytes of a string, up to the given length, without
+ * splitting a character
+ */
+func truncateString(s string, length int) string {
+	if len(s) <= length {
+		return s
+	}
+	for length > 0 && !utf8.RuneStart(s[length]) {
+		length--
+	}
+	return s[:length]
+}
+
+/**
+ * Counts hits in memory. Once maxNotFoundEntries paths are tracked, the least
+ * hit one, the oldest among equals, makes room for a new path. Must be called
+ * with the lock held
+ */
+func (t *NotFoundTracker) add(at time.Time, path string, referrer string, count int) {
+	entry, ok := t.entries[path]
+	if !ok {
+		if len(t.entries) >= maxNotFoundEntries {
+			var least *NotFoundEntry
+			for _, e := range t.entries {
+				if least == nil || e.Count < least.Count || (e.Count == least.Count && e.LastSeen.Before(least.LastSeen)) {
+					least = e
+				}
+			}
+			delete(t.entries, least.Path)
+		}
+		entry = &NotFoundEntry{Path: path, Referrers: make(map[string]int)}
+		t.entries[path] = entry
+	}
+	entry.Count += count
+	if at.After(entry.LastSeen) {
+		entry.LastSeen = at
+	}
+	if _, ok := entry.Referrers[referrer]; len(referrer) > 0 && (ok || len(entry.Referrers) < maxNotFoundReferrers) {
+		entry.Referrers[referrer] += count
+	}
+}
+
+/**
+ * Rewrites the not found log from the tracked paths, one line per referrer of
+ * each path with its number of hits, so the log does not outgrow them. Must
+ * be called with the lock held
+ */
+func (t *NotFoundTracker) compact(conf *config.Config) error {
+	var lines []string
+	for _, entry := range t.entries {
+		at := entry.LastSeen.Format(time.RFC3339)
+		rest := entry.Count
+		for referrer, count := range entry.Referrers {
+			lines = append(lines, at+"\t"+entry.Path+"\t"+referrer+"\t"+strconv.Itoa(count))
+			rest -= count
+		}
+		if rest > 0 {
+			lines = append(lines, at+"\t"+entry.Path+"\t\t"+strconv.Itoa(rest))
+		}
+	}
+	sort.Strings(lines)
+	store, name := getNotFoundFile(conf)
+	t.appended = 0
+	return store.WriteFile(name, []byte(strings.Join(lines, "\n")+"\n"))
+}
+
+/**
+ * Reads the not found log into memory, once. Must be called with the lock
+ * held
+ */
+func (t *NotFoundTracker) load(conf *config.Config) {
+	if t.loaded {
+		return
+	}
+	t.loaded = true
+	store, name := getNotFoundFile(conf)
+	f, err := store.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.Split(scanner.Text(), "\t")
+		if len(parts) != 3 && len(parts) != 4 {
+			continue
+		}
+		count := 1
+		if len(parts) == 4 {
+			if count, err = strconv.Atoi(parts[3]); err != nil || count <= 0 {
+				continue
+			}
+		}
+		at, _ := time.Parse(time.RFC3339, parts[0])
+		t.add(at, parts[1], parts[2], count)
+		t.appended++
+	}
+}
+
+/**
+ * Records a hit of a path answered with a 404. Paths and referrers are cut to
+ * their first 500 bytes, and the log is rewritten from the tracked paths
+ * every maxNotFoundAppends hits
+ */
+func (t *NotFoundTracker) Record(path string, referrer string, conf *config.Config) {
+	clean := func(s string) string {
+		return strings.Map(func(r rune) rune {
+			if r == '\t' || r == '\n' || r == '\r' {
+				return ' '
+			}
+			return r
+		}, s)
+	}
+	path = truncateString(clean(path), maxNotFoundPathLength)
+	referrer = truncateString(clean(referrer), maxReferrerLength)
+	t.Lock()
+	defer t.Unlock()
+	t.load(conf)
+	now := time.Now().UTC()
+	t.add(now, path, referrer, 1)
+	if t.appended++; t.appended >= maxNotFoundAppends {
+		t.compact(conf)
+		return
+	}
+	store, name := getNotFoundFile(conf)
+	content.AppendFile(store, name, []byte(now.Format(time.RFC3339)+"\t"+path+"\t"+referrer+"\n"))
+}
+
+/**
+ * Returns the paths answered with a 404, most hit first
+ */
+func (t *NotFoundTracker) Report(conf *config.Config) []NotFoundEntry {
+	t.Lock()
+	defer t.Unlock()
+	t.load(conf)
+	report := make([]NotFoundEntry, 0, len(t.entries))
+	for _, entry := range t.entries {
+		e := *entry
+		e.Referrers = make(map[string]int, len(entry.Referrers))
+		for referrer, count := range entry.Referrers {
+			e.Referrers[referrer] = count
+		}
+		report = append(report, e)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Count != report[j].Count {
+			return report[i].Count > report[j].Count
+		}
+		return report[i].Path < report[j].Path
+	})
+	return report
+}
+
+/**
+ * Wraps the site handler to record the GET requests answered with a 404,
+ * when NotFound.Enabled is set. Crawlers are recorded as well, since their
+ * hits point at broken links too
+ */
+func trackNotFound(next http.Handler, conf *config.Config) http.Handler {
+	if !conf.NotFound.Enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status == 404 && (r.Method == "GET" || r.Method == "HEAD") {
+			notFoundTracker.Record(r.URL.Path, r.Referer(), conf)
+		}
+	})
+}
+
+/**
+ * Returns the referrers of a path, most frequent first
+ */
+func getTopReferrers(entry NotFoundEntry) []string {
+	referrers := make([]string, 0, len(entry.Referrers))
+	for referrer := range entry.Referrers {
+		referrers = append(referrers, referrer)
+	}
+	sort.Slice(referrers, func(i, j int) bool {
+		if entry.Referrers[referrers[i]] != entry.Referrers[referrers[j]] {
+			return entry.Referrers[referrers[i]] > entry.Referrers[referrers[j]]
+		}
+		return referrers[i] < referrers[j]
+	})
+	return referrers
+}
+
+/**
+ * Report of the paths answered with a 404 and the pages linking to them, as
+ * JSON when asked for
+ */
+func handleNotFoundReport(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	if _, ok := checkAdminAuth(ctx, &conf, RoleAdmin); !ok {
+		return ""
+	}
+	report := notFoundTracker.Report(&conf)
+	if negotiateFormat(ctx.Request.Header.Get("Accept")) == "json" {
+		return apiResponse(ctx, 200, report)
+	}
+	rows := []string{"<!DOCTYPE html><html><head><meta charset=\"utf-8\"><title>Pages not found</title></head><body>",
+		"<h1>Pages not found</h1>"}
+	if len(report) == 0 {
+		rows = append(rows, "<p>No broken links recorded.</p>")
+	} else {
+		rows = append(rows, "<table><tr><th>Path</th><th>Hits</th><th>Last seen</th><th>Referrers</th></tr>")
+	}
+	for _, entry := range report {
+		var referrers []string
+		for _, referrer := range getTopReferrers(entry) {
+			referrers = append(referrers, html.EscapeString(referrer)+" ("+strconv.Itoa(entry.Referrers[referrer])+")")
+		}
+		rows = append(rows, "<tr><td>"+html.EscapeString(entry.Path)+"</td><td>"+strconv.Itoa(entry.Count)+"</td><td>"+
+			entry.LastSeen.Format("2006-01-02 15:04")+"</td><td>"+strings.Join(referrers, "<br>")+"</td></tr>")
+	}
+	if len(report) > 0 {
+		rows = append(rows, "</table>")
+	}
+	rows = append(rows, "</body></html>")
+	return strings.Join(rows, "\n")
+}
+
+/**
+ * Lists the paths answered with a 404 through the API
+ */
+func handleApiNotFound(ctx *web.Context) string {
+	conf, _, failure, ok := getApiConfig(ctx, RoleAdmin)
+	if !ok {
+		return failure
+	}
+	return apiResponse(ctx, 200, notFoundTracker.Report(&conf))
+}
@@ -0,0 +1,41 @@
+package server
+
+import (
+	"github.com/rredpoppy/gosite/pkg/config"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestNotFoundTrackerIsBounded(t *testing.T) {
+	conf := config.Default()
+	conf.NotFound.File = filepath.Join(t.TempDir(), "notfound.log")
+	tracker := &NotFoundTracker{entries: make(map[string]*NotFoundEntry)}
+	tracker.Record("/popular", "", &conf)
+	tracker.Record("/popular", "", &conf)
+	for i := 0; i < maxNotFoundEntries+10; i++ {
+		tracker.Record("/scan/"+strconv.Itoa(i), "https://example.com/"+strconv.Itoa(i), &conf)
+	}
+	tracker.Record("/"+strings.Repeat("é", maxNotFoundPathLength), "", &conf)
+	report := tracker.Report(&conf)
+	if len(report) > maxNotFoundEntries {
+		t.Errorf("tracker holds %d paths, want at most %d", len(report), maxNotFoundEntries)
+	}
+	if report[0].Path != "/popular" || report[0].Count != 2 {
+		t.Errorf("most hit path %s with %d hits, want /popular with 2", report[0].Path, report[0].Count)
+	}
+	for _, entry := range report {
+		if len(entry.Path) > maxNotFoundPathLength || !utf8.ValidString(entry.Path) {
+			t.Errorf("tracked path %q is not cut to %d bytes of characters", entry.Path, maxNotFoundPathLength)
+		}
+	}
+
+	// The log rewritten from the tracked paths holds the same counts
+	tracker.compact(&conf)
+	reloaded := &NotFoundTracker{entries: make(map[string]*NotFoundEntry)}
+	if again := reloaded.Report(&conf); len(again) != len(report) || again[0].Count != 2 {
+		t.Errorf("rewritten log holds %d paths, want %d", len(again), len(report))
+	}
+}
@@ -0,0 +1,378 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Client of the OAuth providers
+var oauthClient = &http.Client{Timeout: 10 * time.Second}
+
+// Struct representing the endpoints of an OAuth provider
+type oauthProvider struct {
+	Name     string
+	AuthURL  string `json:"authorization_endpoint"`
+	TokenURL string `json:"token_endpoint"`
+	UserURL  string `json:"userinfo_endpoint"`
+	Scope    string
+}
+
+// Struct representing a person logged in with OAuth
+type oauthUser struct {
+	Name          string
+	Emails        []string
+	Organizations []string
+}
+
+// Built-in OAuth providers
+var oauthProviders = map[string]oauthProvider{
+	"github": {Name: "GitHub", AuthURL: "https://github.com/login/oauth/authorize",
+		TokenURL: "https://github.com/login/oauth/access_token", UserURL: "https://api.github.com/user",
+		Scope: "read:user user:email read:org"},
+	"google": {Name: "Google", AuthURL: "https://accounts.google.com/o/oauth2/v2/auth",
+		TokenURL: "https://oauth2.googleapis.com/token", UserURL: "https://openidconnect.googleapis.com/v1/userinfo",
+		Scope: "openid email profile"},
+}
+
+// Endpoints of the OpenID Connect issuers, read once from their discovery
+// documents
+var oidcProviders = struct {
+	sync.Mutex
+	issuers map[string]oauthProvider
+}{issuers: make(map[string]oauthProvider)}
+
+/**
+ * Returns true if people can log in with OAuth, which needs a provider, a
+ * client and people allowed to log in
+ */
+func isOAuthEnabled(conf *config.Config) bool {
+	oauth := conf.Admin.OAuth
+	if len(oauth.Provider) == 0 || len(oauth.ClientId) == 0 {
+		return false
+	}
+	for _, user := range conf.Admin.Users {
+		if len(user.Email) > 0 && roleRanks[user.Role] > 0 {
+			return true
+		}
+	}
+	return len(oauth.Emails) > 0 || len(oauth.Organizations) > 0
+}
+
+/**
+ * Returns the name of the OAuth provider shown on the login form, or an empty
+ * string when OAuth is disabled
+ */
+func getOAuthName(conf *config.Config) string {
+	if !isOAuthEnabled(conf) {
+		return ""
+	}
+	if provider, ok := oauthProviders[conf.Admin.OAuth.Provider]; ok {
+		return provider.Name
+	}
+	return "single sign-on"
+}
+
+/**
+ * Requests a JSON document from an OAuth provider, with the access token
+ * when given
+ */
+func getOAuthJSON(link string, token string, v interface{}) error {
+	req, err := http.NewRequest("GET", link, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if len(token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := oauthClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return errors.New(link + " answered with HTTP status " + strconv.Itoa(resp.StatusCode))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+/**
+ * Returns the endpoints of the configured provider. OpenID Connect providers
+ * are discovered from the Issuer
+ */
+func getOAuthProvider(conf *config.Config) (oauthProvider, error) {
+	if provider, ok := oauthProviders[conf.Admin.OAuth.Provider]; ok {
+		return provider, nil
+	}
+	if conf.Admin.OAuth.Provider != "oidc" || len(conf.Admin.OAuth.Issuer) == 0 {
+		return oauthProvider{}, errors.New("Unknown OAuth provider " + conf.Admin.OAuth.Provider)
+	}
+	issuer := strings.TrimSuffix(conf.Admin.OAuth.Issuer, "/")
+	oidcProviders.Lock()
+	defer oidcProviders.Unlock()
+	if provider, ok := oidcProviders.issuers[issuer]; ok {
+		return provider, nil
+	}
+	var provider oauthProvider
+	if err := getOAuthJSON(issuer+"/.well-known/openid-configuration", "", &provider); err != nil {
+		return provider, err
+	}
+	if len(provider.AuthURL) == 0 || len(provider.TokenURL) == 0 || len(provider.UserURL) == 0 {
+		return provider, errors.New("Incomplete OpenID Connect configuration at " + issuer)
+	}
+	provider.Name = "single sign-on"
+	provider.Scope = "openid email profile"
+	oidcProviders.issuers[issuer] = provider
+	return provider, nil
+}
+
+/**
+ * Returns the link the provider sends people back to after they log in
+ */
+func getOAuthCallback(ctx *web.Context, conf *config.Config) string {
+	return getRootURL(ctx, conf) + "/admin/oauth/callback"
+}
+
+/**
+ * Exchanges the code given by the provider for an access token
+ */
+func getOAuthToken(ctx *web.Context, provider oauthProvider, code string, conf *config.Config) (string, error) {
+	req, err := http.NewRequest("POST", provider.TokenURL, strings.NewReader(url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {getOAuthCallback(ctx, conf)},
+		"client_id":     {conf.Admin.OAuth.ClientId},
+		"client_secret": {conf.Admin.OAuth.ClientSecret},
+	}.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := oauthClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var token struct {
+		AccessToken string `json:"access_token"`
+		Error       string `json:"error"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if len(token.AccessToken) == 0 {
+		return "", errors.New("No access token given: " + token.Error)
+	}
+	return token.AccessToken, nil
+}
+
+/**
+ * Returns the person logged in with an access token, with their verified
+ * email addresses and, on GitHub, the organizations they belong to
+ */
+func getOAuthUser(provider oauthProvider, token string, conf *config.Config) (oauthUser, error) {
+	var user oauthUser
+	if conf.Admin.OAuth.Provider != "github" {
+		var info struct {
+			Name          string `json:"name"`
+			Email         string `json:"email"`
+			EmailVerified bool   `json:"email_verified"`
+		}
+		if err := getOAuthJSON(provider.UserURL, token, &info); err != nil {
+			return user, err
+		}
+		user.Name = info.Name
+		if info.EmailVerified && len(info.Email) > 0 {
+			user.Emails = []string{info.Email}
+			if len(user.Name) == 0 {
+				user.Name = info.Email
+			}
+		}
+		return user, nil
+	}
+	var profile struct {
+		Login string `json:"login"`
+	}
+	if err := getOAuthJSON(provider.UserURL, token, &profile); err != nil {
+		return user, err
+	}
+	user.Name = profile.Login
+	var emails []struct {
+		Email    string `json:"email"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getOAuthJSON(provider.UserURL+"/emails", token, &emails); err != nil {
+		return user, err
+	}
+	for _, email := range emails {
+		if email.Verified {
+			user.Emails = append(user.Emails, email.Email)
+		}
+	}
+	if len(conf.Admin.OAuth.Organizations) > 0 {
+		var orgs []struct {
+			Login string `json:"login"`
+		}
+		if err := getOAuthJSON(provider.UserURL+"/orgs", token, &orgs); err != nil {
+			return user, err
+		}
+		for _, org := range orgs {
+			user.Organizations = append(user.Organizations, org.Login)
+		}
+	}
+	return user, nil
+}
+
+/**
+ * Returns the role of the people allowed to log in by OAuth.Emails or
+ * OAuth.Organizations, admin by default
+ */
+func getOAuthRole(conf *config.Config) string {
+	if roleRanks[conf.Admin.OAuth.Role] > 0 {
+		return conf.Admin.OAuth.Role
+	}
+	return RoleAdmin
+}
+
+/**
+ * Returns the account a person logs in with, and false when they may not log
+ * in. People whose verified address is the Email of one of the Admin.Users
+ * get that account, the others allowed get OAuth.Role under the first of
+ * their verified addresses matching
+ */
+func getOAuthAccount(user oauthUser, conf *config.Config) (Account, bool) {
+	for _, email := range user.Emails {
+		for _, account := range getAccounts(conf) {
+			if len(account.Email) > 0 && strings.EqualFold(email, account.Email) {
+				return Account{Name: account.Name, Email: account.Email, Role: account.Role}, true
+			}
+		}
+	}
+	if len(user.Emails) == 0 {
+		return Account{}, false
+	}
+	for _, email := range user.Emails {
+		for _, allowed := range conf.Admin.OAuth.Emails {
+			if strings.EqualFold(email, allowed) ||
+				(strings.HasPrefix(allowed, "@") && strings.HasSuffix(strings.ToLower(email), strings.ToLower(allowed))) {
+				return Account{Name: user.Name, Email: email, Role: getOAuthRole(conf)}, true
+			}
+		}
+	}
+	for _, org := range user.Organizations {
+		for _, allowed := range conf.Admin.OAuth.Organizations {
+			if strings.EqualFold(org, allowed) {
+				return Account{Name: user.Name, Email: user.Emails[0], Role: getOAuthRole(conf)}, true
+			}
+		}
+	}
+	return Account{}, false
+}
+
+/**
+ * Sends the administrator to the OAuth provider to log in, keeping a random
+ * state in the session to check the answer against
+ */
+func handleOAuthLogin(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	if !isOAuthEnabled(&conf) {
+		ctx.Abort(404, "Page not found.")
+		return ""
+	}
+	provider, err := getOAuthProvider(&conf)
+	if err != nil {
+		ctx.Abort(502, err.Error())
+		return ""
+	}
+	bs := make([]byte, 16)
+	if _, err = rand.Read(bs); err != nil {
+		ctx.Abort(500, "Could not start login")
+		return ""
+	}
+	state := hex.EncodeToString(bs)
+	session := GetSession(ctx, &conf)
+	session.Values["oauth_state"] = state
+	session.Values["oauth_next"] = getLoginRedirect(ctx.Params["next"])
+	if err = session.Save(ctx, &conf); err != nil {
+		ctx.Abort(500, "Could not open session")
+		return ""
+	}
+	ctx.Redirect(303, provider.AuthURL+"?"+url.Values{
+		"client_id":     {conf.Admin.OAuth.ClientId},
+		"redirect_uri":  {getOAuthCallback(ctx, &conf)},
+		"response_type": {"code"},
+		"scope":         {provider.Scope},
+		"state":         {state},
+	}.Encode())
+	return ""
+}
+
+/**
+ * Handles the answer of the OAuth provider, opening an admin session for the
+ * people allowed to log in
+ */
+func handleOAuthCallback(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	if !isOAuthEnabled(&conf) {
+		ctx.Abort(404, "Page not found.")
+		return ""
+	}
+	session := GetSession(ctx, &conf)
+	state, next := session.Values["oauth_state"], session.Values["oauth_next"]
+	delete(session.Values, "oauth_state")
+	delete(session.Values, "oauth_next")
+	if len(state) == 0 || !hmac.Equal([]byte(state), []byte(ctx.Params["state"])) {
+		ctx.Abort(403, "Invalid login state, please log in again.")
+		return ""
+	}
+	if len(ctx.Params["error"]) > 0 || len(ctx.Params["code"]) == 0 {
+		ctx.Abort(403, "Login cancelled.")
+		return ""
+	}
+	provider, err := getOAuthProvider(&conf)
+	if err != nil {
+		ctx.Abort(502, err.Error())
+		return ""
+	}
+	token, err := getOAuthToken(ctx, provider, ctx.Params["code"], &conf)
+	if err != nil {
+		ctx.Abort(502, "Could not log in: "+err.Error())
+		return ""
+	}
+	user, err := getOAuthUser(provider, token, &conf)
+	if err != nil {
+		ctx.Abort(502, "Could not log in: "+err.Error())
+		return ""
+	}
+	account, ok := getOAuthAccount(user, &conf)
+	if !ok {
+		ctx.Abort(403, "You are not allowed to administer this site.")
+		return ""
+	}
+	if err = openAccountSession(ctx, session, account, &conf); err != nil {
+		ctx.Abort(500, "Could not open session")
+		return ""
+	}
+	ctx.Redirect(303, getLoginRedirect(next))
+	return ""
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"github.com/rredpoppy/gosite/pkg/config"
+	"testing"
+)
+
+/**
+ * Returns a config letting people log in with GitHub through an address, a
+ * domain, an organization or the Email of an editor
+ */
+func getTestOAuthConfig() *config.Config {
+	conf := config.Config{}
+	conf.Admin.Users = []config.UserConfig{{Name: "ed", Email: "Ed@Example.com", Role: RoleEditor}}
+	conf.Admin.OAuth = config.OAuthConfig{Provider: "github", ClientId: "client", Role: RoleAuthor,
+		Emails: []string{"jane@example.net", "@example.org"}, Organizations: []string{"gosite"}}
+	return &conf
+}
+
+func TestOAuthAccount(t *testing.T) {
+	conf := getTestOAuthConfig()
+	for _, test := range []struct {
+		user        oauthUser
+		name, email string
+		role        string
+	}{
+		{oauthUser{Name: "jane", Emails: []string{"Jane@Example.net"}}, "jane", "Jane@Example.net", RoleAuthor},
+		{oauthUser{Name: "joe", Emails: []string{"joe@home.net", "joe@example.org"}}, "joe", "joe@example.org", RoleAuthor},
+		{oauthUser{Name: "sam", Emails: []string{"sam@home.net"}, Organizations: []string{"GoSite"}}, "sam", "sam@home.net", RoleAuthor},
+		{oauthUser{Name: "eddie", Emails: []string{"ed@example.com"}}, "ed", "Ed@Example.com", RoleEditor},
+		{oauthUser{Name: "eve", Emails: []string{"eve@notexample.org"}}, "", "", ""},
+		{oauthUser{Name: "eve", Emails: []string{"eve@example.org.evil.com"}}, "", "", ""},
+		{oauthUser{Name: "eve", Emails: []string{"jane@example.net.evil.com"}}, "", "", ""},
+		{oauthUser{Name: "eve", Emails: []string{"eve@home.net"}, Organizations: []string{"other"}}, "", "", ""},
+		{oauthUser{Name: "eve", Organizations: []string{"gosite"}}, "", "", ""},
+	} {
+		account, ok := getOAuthAccount(test.user, conf)
+		if ok != (len(test.role) > 0) || account.Name != test.name || account.Email != test.email || account.Role != test.role {
+			t.Errorf("%v logged in as %v %v, want %q %q %q", test.user, account, ok, test.name, test.email, test.role)
+		}
+	}
+}
+
+func TestOAuthEnabled(t *testing.T) {
+	conf := getTestOAuthConfig()
+	if !isOAuthEnabled(conf) || getOAuthName(conf) != "GitHub" {
+		t.Fatal("OAuth disabled by a complete config")
+	}
+	conf.Admin.OAuth.Emails, conf.Admin.OAuth.Organizations = nil, nil
+	if !isOAuthEnabled(conf) {
+		t.Error("OAuth disabled while a user may log in through their Email")
+	}
+	conf.Admin.Users = nil
+	if isOAuthEnabled(conf) || len(getOAuthName(conf)) > 0 {
+		t.Error("OAuth enabled while nobody may log in")
+	}
+	conf = getTestOAuthConfig()
+	conf.Admin.OAuth.ClientId = ""
+	if isOAuthEnabled(conf) {
+		t.Error("OAuth enabled without a client")
+	}
+	conf = getTestOAuthConfig()
+	conf.Admin.OAuth.Role = "owner"
+	if getOAuthRole(conf) != RoleAdmin {
+		t.Errorf("unknown role gave %q, want the admin role", getOAuthRole(conf))
+	}
+}
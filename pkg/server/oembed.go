@@ -0,0 +1,113 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"github.com/russross/blackfriday"
+	"html"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Struct representing an oEmbed response, as described at https://oembed.com
+type OEmbed struct {
+	Type         string `json:"type"`
+	Version      string `json:"version"`
+	Title        string `json:"title,omitempty"`
+	AuthorName   string `json:"author_name,omitempty"`
+	ProviderName string `json:"provider_name"`
+	ProviderUrl  string `json:"provider_url"`
+	ThumbnailUrl string `json:"thumbnail_url,omitempty"`
+	Html         string `json:"html"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+}
+
+/**
+ * Returns the value of a numeric query parameter, or the fallback if it is
+ * missing or invalid
+ */
+func getIntParam(ctx *web.Context, name string, fallback int) int {
+	v, err := strconv.Atoi(ctx.Params[name])
+	if err != nil || v <= 0 {
+		return fallback
+	}
+	return v
+}
+
+/**
+ * oEmbed provider endpoint. Returns a rich embed for the page given in the url
+ * query parameter
+ */
+func handleOEmbed(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	if format := ctx.Params["format"]; len(format) > 0 && format != "json" {
+		ctx.Abort(501, "Only the json format is supported")
+		return ""
+	}
+	// Links are those of the BaseURL site config entry, or of the request host
+	root := getRootURL(ctx, &conf)
+	base, _ := url.Parse(root)
+	u, err := url.Parse(ctx.Params["url"])
+	if err != nil || (len(u.Host) > 0 && u.Host != ctx.Request.Host && u.Host != base.Host) {
+		ctx.Abort(404, "Page not found.")
+		return ""
+	}
+	if len(u.Host) > 0 && u.Host == base.Host && len(base.Path) > 0 {
+		u.Path = strings.TrimPrefix(u.Path, strings.TrimSuffix(base.Path, "/"))
+	}
+	section, slug, err := content.ResolvePermalink(u.Path, &conf)
+	if err != nil {
+		ctx.Abort(404, "Page not found.")
+		return ""
+	}
+	page, err := content.GetPublishedPage(section, slug, &conf)
+	if err != nil {
+		ctx.Abort(404, "Page not found.")
+		return ""
+	}
+	page = content.MaskProtected(page)
+
+	link := root + content.GetArticleLink(section, slug, &conf)
+	title := content.GetPageTitle(page)
+	author := page.Meta.Get("author")
+	if len(author) == 0 {
+		author = conf.Site.Author
+	}
+	thumbnail := page.Meta.Get("image")
+	if strings.HasPrefix(thumbnail, "/") {
+		thumbnail = root + thumbnail
+	}
+	excerpt := string(blackfriday.MarkdownCommon([]byte(content.GetSummary(page.Body))))
+	providerName := conf.Site.Title
+	if len(providerName) == 0 {
+		providerName = ctx.Request.Host
+	}
+	embed := OEmbed{
+		Type:         "rich",
+		Version:      "1.0",
+		Title:        title,
+		AuthorName:   author,
+		ProviderName: providerName,
+		ProviderUrl:  root + "/",
+		ThumbnailUrl: thumbnail,
+		Html: "<blockquote class=\"gosite-embed\"><h3><a href=\"" + html.EscapeString(link) + "\">" +
+			html.EscapeString(title) + "</a></h3>" + excerpt + "</blockquote>",
+		Width:  getIntParam(ctx, "maxwidth", 600),
+		Height: getIntParam(ctx, "maxheight", 400),
+	}
+	bs, err := json.Marshal(embed)
+	if err != nil {
+		ctx.Abort(500, "Could not encode embed")
+		return ""
+	}
+	ctx.ContentType("json")
+	return string(bs)
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Most URLs IndexNow takes in a single request
+const indexNowBatch = 10000
+
+// Client of the search engine pings, so an unreachable engine does not hold
+// up builds and edits for long
+var pingClient = &http.Client{Timeout: 10 * time.Second}
+
+// Struct representing an IndexNow submission
+type indexNowRequest struct {
+	Host        string   `json:"host"`
+	Key         string   `json:"key"`
+	KeyLocation string   `json:"keyLocation"`
+	UrlList     []string `json:"urlList"`
+}
+
+/**
+ * Returns the link of the sitemap given to the ping endpoints
+ */
+func getSitemapLink(conf *config.Config) string {
+	if len(conf.Ping.Sitemap) > 0 {
+		return conf.Ping.Sitemap
+	}
+	return content.GetSiteURL(conf) + "/sitemap.xml"
+}
+
+/**
+ * Returns the link of the key file proving IndexNow submissions come from the
+ * site
+ */
+func getIndexNowKeyLink(conf *config.Config) string {
+	return content.GetSiteURL(conf) + "/" + conf.Ping.IndexNowKey + ".txt"
+}
+
+/**
+ * Submits changed URLs to IndexNow, in batches
+ */
+func submitIndexNow(urls []string, conf *config.Config) error {
+	endpoint := conf.Ping.IndexNowEndpoint
+	if len(endpoint) == 0 {
+		endpoint = "https://api.indexnow.org/indexnow"
+	}
+	base, err := url.Parse(conf.Site.BaseURL)
+	if err != nil {
+		return err
+	}
+	for start := 0; start < len(urls); start += indexNowBatch {
+		end := start + indexNowBatch
+		if end > len(urls) {
+			end = len(urls)
+		}
+		bs, err := json.Marshal(indexNowRequest{Host: base.Host, Key: conf.Ping.IndexNowKey,
+			KeyLocation: getIndexNowKeyLink(conf), UrlList: urls[start:end]})
+		if err != nil {
+			return err
+		}
+		resp, err := pingClient.Post(endpoint, "application/json; charset=utf-8", bytes.NewReader(bs))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 400 {
+			return errors.New("IndexNow answered with HTTP status " + strconv.Itoa(resp.StatusCode))
+		}
+	}
+	return nil
+}
+
+/**
+ * Tells search engines the given URLs of the site changed, when Ping.Enabled
+ * is set: each of the Ping.Endpoints is requested with the {sitemap}
+ * placeholder replaced by the link of the sitemap, and the URLs are submitted
+ * to IndexNow when an IndexNowKey is set. Site.BaseURL must be set, since
+ * search engines need absolute URLs
+ */
+func PingSearchEngines(urls []string, conf *config.Config) error {
+	if !conf.Ping.Enabled || len(conf.Site.BaseURL) == 0 || len(urls) == 0 {
+		return nil
+	}
+	var failed []string
+	sitemap := url.QueryEscape(getSitemapLink(conf))
+	for _, endpoint := range conf.Ping.Endpoints {
+		resp, err := pingClient.Get(strings.Replace(endpoint, "{sitemap}", sitemap, -1))
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode >= 400 {
+				err = errors.New("HTTP status " + strconv.Itoa(resp.StatusCode))
+			}
+		}
+		if err != nil {
+			failed = append(failed, endpoint+": "+err.Error())
+		}
+	}
+	if len(conf.Ping.IndexNowKey) > 0 {
+		if err := submitIndexNow(urls, conf); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New("Could not ping search engines: " + strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+/**
+ * Pings the search engines in the background about an article changed
+ * through the admin area or the API. Drafts are left out, while deleted
+ * articles are submitted so search engines drop them
+ */
+func pingArticle(ctx *web.Context, conf *config.Config, section string, slug string, action string) {
+	if !conf.Ping.Enabled {
+		return
+	}
+	if _, err := content.GetPublishedPage(section, slug, conf); err != nil && action != "Delete" {
+		return
+	}
+	link := content.GetSiteURL(conf) + content.GetArticleLink(section, slug, conf)
+	go func() {
+		if err := PingSearchEngines([]string{link}, conf); err != nil {
+			logRequestError(ctx, err.Error())
+		}
+	}()
+}
+
+/**
+ * Serves the IndexNow key file
+ */
+func handleIndexNowKey(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	ctx.SetHeader("Content-Type", "text/plain; charset=utf-8", true)
+	return conf.Ping.IndexNowKey
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"bytes"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"github.com/rredpoppy/gosite/pkg/render"
+	"net/http"
+	"strings"
+)
+
+/**
+ * Wraps the site handler to serve it under the PathPrefix config entry: the
+ * prefix is taken off the path of the requests before routing, the prefix
+ * alone redirects to the homepage, and other paths are not found
+ */
+func stripPathPrefix(next http.Handler, conf *config.Config) http.Handler {
+	prefix := content.GetPathPrefix(conf)
+	if len(prefix) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == prefix {
+			target := prefix + "/"
+			if len(r.URL.RawQuery) > 0 {
+				target += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, target, 301)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, prefix+"/") {
+			http.NotFound(w, r)
+			return
+		}
+		r = r.Clone(r.Context())
+		r.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+		r.URL.RawPath = strings.TrimPrefix(r.URL.RawPath, prefix)
+		next.ServeHTTP(w, r)
+	})
+}
+
+/**
+ * Returns a path of the site under a prefix. Links to other hosts are left as
+ * they are; the site itself never links to paths under the prefix
+ */
+func addPathPrefix(link string, prefix string) string {
+	if !strings.HasPrefix(link, "/") || strings.HasPrefix(link, "//") {
+		return link
+	}
+	return prefix + link
+}
+
+// Response writer adding a prefix to the links of the pages and redirects it
+// is given. Pages are held until the handler is done, to be rewritten at once
+type prefixWriter struct {
+	http.ResponseWriter
+	prefix      string
+	status      int
+	wroteHeader bool
+	page        *bytes.Buffer
+}
+
+func (w *prefixWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	h := w.Header()
+	if location := h.Get("Location"); len(location) > 0 {
+		h.Set("Location", addPathPrefix(location, w.prefix))
+	}
+	contentType := h.Get("Content-Type")
+	if len(contentType) == 0 || strings.HasPrefix(contentType, "text/html") {
+		h.Del("Content-Length")
+		w.status, w.page = status, new(bytes.Buffer)
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *prefixWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+	if w.page != nil {
+		return w.page.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+/**
+ * Sends the page held by the writer, its links rewritten, once the handler
+ * is done with it
+ */
+func (w *prefixWriter) finish() {
+	if w.page == nil {
+		return
+	}
+	body := w.page.Bytes()
+	h := w.Header()
+	if len(h.Get("Content-Type")) == 0 && len(body) > 0 {
+		h.Set("Content-Type", http.DetectContentType(body))
+	}
+	if strings.HasPrefix(h.Get("Content-Type"), "text/html") {
+		body = render.RewriteLinks(body, func(link string) string {
+			return addPathPrefix(link, w.prefix)
+		})
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(body)
+}
+
+/**
+ * Wraps the routes of the site to add the PathPrefix config entry to the
+ * links of the pages, whether they come from the templates or the content,
+ * and to the redirects
+ */
+func prefixLinks(next http.Handler, conf *config.Config) http.Handler {
+	prefix := content.GetPathPrefix(conf)
+	if len(prefix) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pw := &prefixWriter{ResponseWriter: w, prefix: prefix}
+		next.ServeHTTP(pw, r)
+		if !pw.wroteHeader {
+			pw.WriteHeader(200)
+		}
+		pw.finish()
+	})
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/**
+ * Returns the signature of a preview link for the given article and expiry
+ * time
+ */
+func getPreviewToken(section string, slug string, expires int64, conf *config.Config) string {
+	mac := hmac.New(sha256.New, []byte(conf.Admin.PreviewSecret))
+	mac.Write([]byte(section + "/" + slug + "|" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/**
+ * Returns a shareable link previewing an article, drafts and scheduled
+ * articles included, until it expires after Admin.PreviewHours (72 by
+ * default). Returns an empty string while no Admin.PreviewSecret is set
+ */
+func GetPreviewLink(section string, slug string, conf *config.Config) string {
+	if len(conf.Admin.PreviewSecret) == 0 {
+		return ""
+	}
+	hours := conf.Admin.PreviewHours
+	if hours <= 0 {
+		hours = 72
+	}
+	expires := time.Now().Add(time.Duration(hours) * time.Hour).Unix()
+	return "/preview/" + content.GetSectionSlug(section) + "/" + slug + "?" + url.Values{
+		"expires": {strconv.FormatInt(expires, 10)},
+		"token":   {getPreviewToken(section, slug, expires, conf)},
+	}.Encode()
+}
+
+/**
+ * Marks a response as private, so previews are neither cached nor indexed
+ */
+func setPreviewHeaders(ctx *web.Context) {
+	ctx.SetHeader("Cache-Control", "private, no-store", true)
+	ctx.SetHeader("X-Robots-Tag", "noindex, nofollow", true)
+}
+
+/**
+ * Loads the config of the preview environment, which mirrors the site under
+ * /preview with the drafts included. Only editors may browse it
+ */
+func getPreviewConfig(ctx *web.Context) (config.Config, bool) {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return conf, false
+	}
+	if _, ok := checkAdminAuth(ctx, &conf, RoleEditor); !ok {
+		return conf, false
+	}
+	conf.Drafts = true
+	setPreviewHeaders(ctx)
+	return conf, true
+}
+
+/**
+ * Handles the sections of the preview environment
+ */
+func handlePreviewSection(ctx *web.Context, section string) string {
+	conf, ok := getPreviewConfig(ctx)
+	if !ok {
+		return ""
+	}
+	return routeSection(ctx, &conf, section)
+}
+
+/**
+ * Handles the listing pages of the preview environment
+ */
+func handlePreviewPaginatedSection(ctx *web.Context, section string, page string) string {
+	conf, ok := getPreviewConfig(ctx)
+	if !ok {
+		return ""
+	}
+	return routePaginatedSection(ctx, &conf, section, page)
+}
+
+/**
+ * Handles the permalinks of the preview environment
+ */
+func handlePreviewPermalink(ctx *web.Context, path string) string {
+	conf, ok := getPreviewConfig(ctx)
+	if !ok {
+		return ""
+	}
+	return routePermalink(ctx, &conf, path)
+}
+
+/**
+ * Returns the session key granting access to the preview of an article
+ */
+func getPreviewKey(section string, slug string) string {
+	return "preview:" + section + "/" + slug
+}
+
+/**
+ * Returns true if the session of the request was granted access to the
+ * preview of an article by a preview link, still valid
+ */
+func hasPreviewAccess(ctx *web.Context, conf *config.Config, section string, slug string) bool {
+	parts := strings.SplitN(GetSession(ctx, conf).Values[getPreviewKey(section, slug)], ".", 2)
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	return err == nil && len(parts) == 2 && time.Now().Unix() <= expires &&
+		hmac.Equal([]byte(getPreviewToken(section, slug, expires, conf)), []byte(parts[1]))
+}
+
+/**
+ * Handles the articles of the preview environment, and the shareable preview
+ * links. Valid links grant the session access to a single article without a
+ * login until they expire, and send the visitor to the preview without the
+ * token in the address
+ */
+func handlePreview(ctx *web.Context, section string, slug string) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	found := content.FindSection(section, &conf)
+	if len(ctx.Params["token"]) > 0 {
+		if len(conf.Admin.PreviewSecret) == 0 {
+			return renderNotFound(ctx, &conf)
+		}
+		expires, err := strconv.ParseInt(ctx.Params["expires"], 10, 64)
+		token := getPreviewToken(found, slug, expires, &conf)
+		if err != nil || time.Now().Unix() > expires ||
+			!hmac.Equal([]byte(token), []byte(ctx.Params["token"])) {
+			ctx.WriteHeader(403)
+			return renderMessage(ctx, &conf, "Preview", "This preview link is invalid or has expired.", nil)
+		}
+		session := GetSession(ctx, &conf)
+		session.Values[getPreviewKey(found, slug)] = strconv.FormatInt(expires, 10) + "." + token
+		if err = session.Save(ctx, &conf); err != nil {
+			ctx.Abort(500, "Could not open session")
+			return ""
+		}
+		setPreviewHeaders(ctx)
+		ctx.Redirect(303, ctx.Request.URL.Path)
+		return ""
+	}
+	if len(conf.Admin.PreviewSecret) > 0 && hasPreviewAccess(ctx, &conf, found, slug) {
+		// Drafts and scheduled articles are read past the publication gate
+		p, err := content.GetPage(found, slug, &conf)
+		if err != nil {
+			return renderNotFound(ctx, &conf)
+		}
+		setPreviewHeaders(ctx)
+		return renderArticle(ctx, &conf, found, slug, content.RunContentLoaded(found, slug, p), true)
+	}
+	conf, ok := getPreviewConfig(ctx)
+	if !ok {
+		return ""
+	}
+	return routePage(ctx, &conf, section, slug)
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"github.com/rredpoppy/gosite/pkg/config"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestScheduledArticlesOnlyShowInPreview(t *testing.T) {
+	site := newTestSite(t, func(conf *config.Config) {
+		conf.Admin.User, conf.Admin.Password = "admin", "secret"
+	}, map[string]string{
+		"content/blog/today.md":    "---\ntitle: Today\n---\n# Today\n\nPublished.\n",
+		"content/blog/tomorrow.md": "---\ntitle: Tomorrow\ndate: " + tomorrow() + "\n---\n# Tomorrow\n\nScheduled.\n",
+	})
+	if w := getTestPage(site, "/blog/tomorrow", "text/html", "", ""); w.Code != 404 {
+		t.Errorf("scheduled article served with status %d, want 404", w.Code)
+	}
+	if w := getTestPage(site, "/", "application/json", "", ""); !strings.Contains(w.Body.String(), "today") ||
+		strings.Contains(w.Body.String(), "tomorrow") {
+		t.Errorf("scheduled article listed on the homepage: %d %s", w.Code, w.Body.String())
+	}
+	if w := getTestPage(site, "/blog/today", "text/html", "", ""); w.Code != 200 {
+		t.Errorf("published article served with status %d, want 200", w.Code)
+	}
+	if w := getTestPage(site, "/preview/blog/tomorrow", "text/html", "admin", "secret"); w.Code != 200 {
+		t.Errorf("scheduled article previewed with status %d, want 200", w.Code)
+	}
+	if w := getTestPage(site, "/preview/", "application/json", "admin", "secret"); !strings.Contains(w.Body.String(), "tomorrow") {
+		t.Errorf("scheduled article missing from the preview listing: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestPreviewLinksShowScheduledArticles(t *testing.T) {
+	setup := func(conf *config.Config) {
+		conf.Admin.PreviewSecret = "preview-secret"
+		conf.Session.Secret = "session-secret"
+	}
+	site := newTestSite(t, setup, map[string]string{
+		"content/blog/tomorrow.md": "---\ntitle: Tomorrow\ndate: " + tomorrow() + "\n---\n# Tomorrow\n\nScheduled.\n",
+	})
+	conf := config.Default()
+	setup(&conf)
+	link := GetPreviewLink("blog", "tomorrow", &conf)
+	w := getTestPage(site, link, "text/html", "", "")
+	if w.Code != 303 || len(w.Result().Cookies()) == 0 {
+		t.Fatalf("preview link answered with status %d and no session, want a 303 with one", w.Code)
+	}
+	r := httptest.NewRequest("GET", "/preview/blog/tomorrow", nil)
+	for _, cookie := range w.Result().Cookies() {
+		r.AddCookie(cookie)
+	}
+	w = httptest.NewRecorder()
+	site.ServeHTTP(w, r)
+	if w.Code != 200 {
+		t.Errorf("scheduled article previewed by link with status %d, want 200", w.Code)
+	}
+	if w := getTestPage(site, "/preview/blog/tomorrow", "text/html", "", ""); w.Code == 200 {
+		t.Error("scheduled article previewed without the link")
+	}
+	if w := getTestPage(site, "/blog/tomorrow", "text/html", "", ""); w.Code != 404 {
+		t.Errorf("scheduled article served with status %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"net/http/pprof"
+)
+
+/**
+ * Serves the pprof profiles of the running server to the administrators, when
+ * it is started with -profile. The index lists the profiles, which are
+ * downloaded for go tool pprof, e.g. /debug/pprof/profile?seconds=30 for the
+ * CPU and /debug/pprof/heap for the memory
+ */
+func handleProfile(ctx *web.Context, name string) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	if _, ok := checkAdminAuth(ctx, &conf, RoleAdmin); !ok {
+		return ""
+	}
+	ctx.SetHeader("Cache-Control", "no-store", true)
+	switch name {
+	case "":
+		pprof.Index(ctx.ResponseWriter, ctx.Request)
+	case "cmdline":
+		pprof.Cmdline(ctx.ResponseWriter, ctx.Request)
+	case "profile":
+		pprof.Profile(ctx.ResponseWriter, ctx.Request)
+	case "symbol":
+		pprof.Symbol(ctx.ResponseWriter, ctx.Request)
+	case "trace":
+		pprof.Trace(ctx.ResponseWriter, ctx.Request)
+	default:
+		pprof.Handler(name).ServeHTTP(ctx.ResponseWriter, ctx.Request)
+	}
+	return ""
+}
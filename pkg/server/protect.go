@@ -0,0 +1,86 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/subtle"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+)
+
+/**
+ * Returns the session key unlocking an article
+ */
+func getUnlockKey(section string, page string) string {
+	return "unlock:" + section + "/" + page
+}
+
+/**
+ * Returns the signature kept by the sessions unlocking an article. It covers
+ * the password of the article, so changing the password locks the article
+ * again
+ */
+func getUnlockSignature(section string, page string, password string, conf *config.Config) string {
+	return getSessionMac("unlock|"+section+"/"+page+"|"+password, conf)
+}
+
+/**
+ * Returns true if the session of the request unlocks the article
+ */
+func isUnlocked(ctx *web.Context, conf *config.Config, section string, page string, p content.Page) bool {
+	signature := GetSession(ctx, conf).Values[getUnlockKey(section, page)]
+	return hmac.Equal([]byte(signature), []byte(getUnlockSignature(section, page, p.Meta.Get("password"), conf)))
+}
+
+/**
+ * Renders the password prompt of a protected article
+ */
+func renderPasswordPrompt(ctx *web.Context, conf *config.Config, section string, page string, p content.Page) string {
+	if negotiateFormat(ctx.Request.Header.Get("Accept")) == "json" {
+		return apiError(ctx, 403, "Password required")
+	}
+	extra := map[string]interface{}{
+		"passwordForm":   true,
+		"passwordAction": "/unlock/" + content.GetSectionSlug(section) + "/" + page,
+		"passwordWrong":  ctx.Request.Method == "POST",
+	}
+	addCsrfToken(ctx, extra)
+	return renderMessage(ctx, conf, content.GetPageTitle(p), "This article is protected by a password.", extra)
+}
+
+/**
+ * Handles the password prompt of the protected articles, unlocking the
+ * article for the session when the password is right
+ */
+func handleUnlock(ctx *web.Context, section string, page string) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	section = content.FindSection(section, &conf)
+	p, err := content.GetPublishedPage(section, page, &conf)
+	if err != nil {
+		return renderNotFound(ctx, &conf)
+	}
+	link := content.GetArticleLink(section, page, &conf)
+	if !p.IsProtected() {
+		ctx.Redirect(303, link)
+		return ""
+	}
+	if !checkCsrf(ctx) {
+		return renderCsrfError(ctx, &conf)
+	}
+	if subtle.ConstantTimeCompare([]byte(ctx.Params["password"]), []byte(p.Meta.Get("password"))) != 1 {
+		ctx.WriteHeader(403)
+		return renderPasswordPrompt(ctx, &conf, section, page, p)
+	}
+	session := GetSession(ctx, &conf)
+	session.Values[getUnlockKey(section, page)] = getUnlockSignature(section, page, p.Meta.Get("password"), &conf)
+	if err = session.Save(ctx, &conf); err != nil {
+		ctx.Abort(500, "Could not open session")
+		return ""
+	}
+	ctx.Redirect(303, link)
+	return ""
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"net"
+	"net/http"
+	"strings"
+)
+
+/**
+ * Returns the networks of the Proxy.Trusted config entry, given as IP
+ * addresses or CIDR ranges. Invalid entries are ignored
+ */
+func getTrustedProxies(conf *config.Config) []*net.IPNet {
+	var nets []*net.IPNet
+	for _, entry := range conf.Proxy.Trusted {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil {
+				bits := 8 * len(ip)
+				if ip.To4() != nil {
+					ip, bits = ip.To4(), 32
+				}
+				nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			}
+			continue
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			nets = append(nets, network)
+		}
+	}
+	return nets
+}
+
+/**
+ * Returns true if an address belongs to one of the trusted networks
+ */
+func isTrustedAddr(addr string, nets []*net.IPNet) bool {
+	ip := net.ParseIP(strings.TrimSpace(addr))
+	if ip == nil {
+		return false
+	}
+	for _, network := range nets {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Returns the address of the client of a request sent through trusted
+ * proxies: the last address of X-Forwarded-For not belonging to a trusted
+ * proxy, since clients may send the header themselves with any address
+ */
+func getForwardedClient(header string, nets []*net.IPNet) string {
+	var addrs []string
+	for _, addr := range strings.Split(header, ",") {
+		if addr = strings.TrimSpace(addr); len(addr) > 0 {
+			addrs = append(addrs, addr)
+		}
+	}
+	for i := len(addrs) - 1; i >= 0; i-- {
+		if !isTrustedAddr(addrs[i], nets) || i == 0 {
+			if net.ParseIP(addrs[i]) == nil {
+				return ""
+			}
+			return addrs[i]
+		}
+	}
+	return ""
+}
+
+/**
+ * Returns the first value of a comma separated forwarding header
+ */
+func getFirstForwarded(header string) string {
+	return strings.TrimSpace(strings.SplitN(header, ",", 2)[0])
+}
+
+/**
+ * Returns the address of the client of a request, without its port
+ */
+func getClientAddr(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// Key of the request context holding the scheme a trusted proxy was asked
+// for, in lower case
+type forwardedProtoKey struct{}
+
+/**
+ * Returns true if a request was sent over HTTPS, directly or to a trusted
+ * proxy. The scheme of the request line is not trusted, since any client can
+ * send an absolute URL
+ */
+func isSecureRequest(r *http.Request) bool {
+	proto, _ := r.Context().Value(forwardedProtoKey{}).(string)
+	return r.TLS != nil || proto == "https"
+}
+
+/**
+ * Wraps the site handler to honor the X-Forwarded-For, X-Forwarded-Proto and
+ * X-Forwarded-Host headers of requests coming from the proxies of the
+ * Proxy.Trusted config entry, or through a unix domain socket. The address of
+ * the client then replaces the one of the proxy, and links, redirects and
+ * cookies follow the scheme and host the client asked for. Headers of other
+ * requests are ignored, as anybody can send them
+ */
+func addProxyHeaders(next http.Handler, conf *config.Config) http.Handler {
+	nets := getTrustedProxies(conf)
+	if len(nets) == 0 && !strings.HasPrefix(conf.ServerIp, unixSocketPrefix) {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer := getClientAddr(r)
+		// Peers of unix domain sockets have no address, and can only be local
+		// proxies allowed to connect to the socket
+		if net.ParseIP(peer) != nil && !isTrustedAddr(peer, nets) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		ctx := r.Context()
+		if proto := strings.ToLower(getFirstForwarded(r.Header.Get("X-Forwarded-Proto"))); proto == "http" || proto == "https" {
+			ctx = context.WithValue(ctx, forwardedProtoKey{}, proto)
+		}
+		r = r.Clone(ctx)
+		if client := getForwardedClient(r.Header.Get("X-Forwarded-For"), nets); len(client) > 0 {
+			r.RemoteAddr = client
+		}
+		if host := getFirstForwarded(r.Header.Get("X-Forwarded-Host")); len(host) > 0 {
+			r.Host = host
+		}
+		next.ServeHTTP(w, r)
+	})
+}
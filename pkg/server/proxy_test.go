@@ -0,0 +1,52 @@
+package server
+
+import (
+	"github.com/rredpoppy/gosite/pkg/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+/**
+ * Sends a request through the proxy handling of a site trusting 10.0.0.0/8,
+ * and returns the request the site handler got
+ */
+func getProxiedRequest(remote string, target string, header map[string]string) *http.Request {
+	conf := config.Default()
+	conf.Proxy.Trusted = []string{"10.0.0.0/8"}
+	var got *http.Request
+	handler := addProxyHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+	}), &conf)
+	r := httptest.NewRequest("GET", target, nil)
+	// The request line scheme comes over a plain connection
+	r.TLS = nil
+	r.RemoteAddr = remote + ":4321"
+	for name, value := range header {
+		r.Header.Set(name, value)
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	return got
+}
+
+func TestProxyHeadersOfTrustedProxies(t *testing.T) {
+	header := map[string]string{"X-Forwarded-For": "203.0.113.7", "X-Forwarded-Proto": "https",
+		"X-Forwarded-Host": "www.example.org"}
+	r := getProxiedRequest("10.1.2.3", "/", header)
+	if !isSecureRequest(r) || r.Host != "www.example.org" || r.RemoteAddr != "203.0.113.7" {
+		t.Errorf("trusted proxy request has secure %v, host %s, client %s", isSecureRequest(r), r.Host, r.RemoteAddr)
+	}
+	r = getProxiedRequest("198.51.100.1", "/", header)
+	if isSecureRequest(r) || r.Host == "www.example.org" || getClientAddr(r) != "198.51.100.1" {
+		t.Errorf("untrusted client request has secure %v, host %s, client %s", isSecureRequest(r), r.Host, r.RemoteAddr)
+	}
+}
+
+func TestAbsoluteRequestLineIsNotSecure(t *testing.T) {
+	if r := getProxiedRequest("198.51.100.1", "https://example.com/", nil); isSecureRequest(r) {
+		t.Error("request line scheme of a direct client taken for HTTPS")
+	}
+	if r := getProxiedRequest("10.1.2.3", "https://example.com/", nil); isSecureRequest(r) {
+		t.Error("request line scheme of a proxy taken for HTTPS without X-Forwarded-Proto")
+	}
+}
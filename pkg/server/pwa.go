@@ -0,0 +1,167 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"strings"
+)
+
+// Struct representing the web app manifest of the site
+type WebManifest struct {
+	Name            string         `json:"name"`
+	ShortName       string         `json:"short_name"`
+	Description     string         `json:"description,omitempty"`
+	StartUrl        string         `json:"start_url"`
+	Scope           string         `json:"scope"`
+	Display         string         `json:"display"`
+	ThemeColor      string         `json:"theme_color,omitempty"`
+	BackgroundColor string         `json:"background_color,omitempty"`
+	Icons           []ManifestIcon `json:"icons"`
+}
+
+// Struct representing an icon of the web app manifest
+type ManifestIcon struct {
+	Src   string `json:"src"`
+	Sizes string `json:"sizes,omitempty"`
+	Type  string `json:"type,omitempty"`
+}
+
+// Service worker of the site. Pages come from the network first, so readers
+// get their latest version, and from the cache when offline; other files
+// come from the cache first. The admin area, the APIs and the previews are
+// left alone
+const serviceWorker = `var CACHE = "gosite-{version}";
+var PRECACHE = {precache};
+
+self.addEventListener("install", function (event) {
+  event.waitUntil(caches.open(CACHE).then(function (cache) {
+    return cache.addAll(PRECACHE);
+  }).then(function () {
+    return self.skipWaiting();
+  }));
+});
+
+self.addEventListener("activate", function (event) {
+  event.waitUntil(caches.keys().then(function (keys) {
+    return Promise.all(keys.filter(function (key) {
+      return key.indexOf("gosite-") === 0 && key !== CACHE;
+    }).map(function (key) {
+      return caches.delete(key);
+    }));
+  }).then(function () {
+    return self.clients.claim();
+  }));
+});
+
+function store(request, response) {
+  if (response.ok) {
+    var copy = response.clone();
+    caches.open(CACHE).then(function (cache) {
+      cache.put(request, copy);
+    });
+  }
+  return response;
+}
+
+self.addEventListener("fetch", function (event) {
+  var request = event.request;
+  var url = new URL(request.url);
+  if (request.method !== "GET" || url.origin !== self.location.origin ||
+      /^\/(admin|api|preview|graphql)(\/|$)/.test(url.pathname)) {
+    return;
+  }
+  if (request.mode === "navigate") {
+    event.respondWith(fetch(request).then(function (response) {
+      return store(request, response);
+    }).catch(function () {
+      return caches.match(request).then(function (cached) {
+        return cached || caches.match("/");
+      });
+    }));
+    return;
+  }
+  event.respondWith(caches.match(request).then(function (cached) {
+    return cached || fetch(request).then(function (response) {
+      return store(request, response);
+    });
+  }));
+});
+`
+
+/**
+ * Returns the files the service worker caches when installed: the homepage,
+ * the Pwa.Precache files and the links of the newest articles
+ */
+func getPrecachedFiles(conf *config.Config) []string {
+	files := append([]string{"/"}, conf.Pwa.Precache...)
+	count := conf.Pwa.RecentArticles
+	if count <= 0 {
+		return files
+	}
+	articles, err := getFeedArticles("", conf)
+	if err != nil {
+		return files
+	}
+	for i, a := range articles {
+		if i == count {
+			break
+		}
+		if !(content.Page{Meta: a.Item.Meta}).IsProtected() {
+			files = append(files, content.GetArticleLink(a.Section, a.Item.Slug, conf))
+		}
+	}
+	return files
+}
+
+/**
+ * Serves the web app manifest of the site
+ */
+func handleWebManifest(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	manifest := WebManifest{Name: conf.Pwa.Name, ShortName: conf.Pwa.ShortName,
+		Description: conf.Site.Description, StartUrl: "/", Scope: "/", Display: "standalone",
+		ThemeColor: conf.Pwa.ThemeColor, BackgroundColor: conf.Pwa.BackgroundColor, Icons: []ManifestIcon{}}
+	if len(manifest.Name) == 0 {
+		manifest.Name = conf.Site.Title
+	}
+	if len(manifest.ShortName) == 0 {
+		manifest.ShortName = manifest.Name
+	}
+	for _, icon := range conf.Pwa.Icons {
+		manifest.Icons = append(manifest.Icons, ManifestIcon{Src: icon.Src, Sizes: icon.Sizes, Type: icon.Type})
+	}
+	bs, err := json.MarshalIndent(manifest, "", "    ")
+	if err != nil {
+		ctx.Abort(500, "Could not encode manifest")
+		return ""
+	}
+	ctx.SetHeader("Content-Type", "application/manifest+json", true)
+	return string(bs)
+}
+
+/**
+ * Serves the service worker of the site. Its cache is named after the files
+ * it caches, so a new article or asset installs a new version
+ */
+func handleServiceWorker(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	files, err := json.Marshal(getPrecachedFiles(&conf))
+	if err != nil {
+		ctx.Abort(500, "Could not encode service worker")
+		return ""
+	}
+	ctx.SetHeader("Content-Type", "application/javascript; charset=utf-8", true)
+	// Browsers must always check for a new version
+	ctx.SetHeader("Cache-Control", "no-cache", true)
+	return strings.NewReplacer("{version}", content.Hash(files)[:12], "{precache}", string(files)).Replace(serviceWorker)
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"math/rand"
+	"time"
+)
+
+/**
+ * Redirects to a random published article, of the section given by the
+ * section parameter, as a folder or a slug, or of any section
+ */
+func handleRandom(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	articles, err := getGqlArticles(&conf)
+	if err != nil {
+		ctx.Abort(501, "Could not load articles")
+		return ""
+	}
+	if section := ctx.Params["section"]; len(section) > 0 {
+		section = content.FindSection(section, &conf)
+		var scoped []gqlArticle
+		for _, a := range articles {
+			if a.Section == section {
+				scoped = append(scoped, a)
+			}
+		}
+		articles = scoped
+	}
+	if len(articles) == 0 {
+		return renderNotFound(ctx, &conf)
+	}
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	// Every request must pick again
+	ctx.SetHeader("Cache-Control", "no-store", true)
+	ctx.Redirect(302, articles[random.Intn(len(articles))].Link)
+	return ""
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/**
+ * Returns the status of a redirect, 301 unless configured otherwise
+ */
+func getRedirectStatus(redirect config.RedirectConfig) int {
+	if redirect.Status >= 300 && redirect.Status < 400 {
+		return redirect.Status
+	}
+	return 301
+}
+
+/**
+ * Returns the handler of a redirect of the Redirects config entry
+ */
+func getRedirectHandler(redirect config.RedirectConfig) func(ctx *web.Context) string {
+	return func(ctx *web.Context) string {
+		ctx.Redirect(getRedirectStatus(redirect), redirect.To)
+		return ""
+	}
+}
+
+/**
+ * Returns the redirects of the site: the Redirects config entry and, with a
+ * custom Permalink, the default links of the published articles redirected
+ * to their permalink
+ */
+func getSiteRedirects(conf *config.Config) []config.RedirectConfig {
+	redirects := append([]config.RedirectConfig{}, conf.Redirects...)
+	if len(conf.Permalink) == 0 || conf.Permalink == content.DefaultPermalink {
+		return redirects
+	}
+	articles, _ := getGqlArticles(conf)
+	for _, a := range articles {
+		link := "/" + content.GetSectionSlug(a.Section) + "/" + a.Slug
+		if link != a.Link {
+			redirects = append(redirects, config.RedirectConfig{From: link, To: a.Link, Status: 301})
+		}
+	}
+	return redirects
+}
+
+/**
+ * Serves the _redirects file of Netlify, mirroring the redirects of the site
+ * in static exports
+ */
+func handleNetlifyRedirects(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	var lines []string
+	for _, r := range getSiteRedirects(&conf) {
+		lines = append(lines, r.From+" "+r.To+" "+strconv.Itoa(getRedirectStatus(r)))
+	}
+	ctx.SetHeader("Content-Type", "text/plain; charset=utf-8", true)
+	return strings.Join(lines, "\n") + "\n"
+}
+
+/**
+ * Serves the _headers file of Netlify, sending the security headers with
+ * every file of static exports, and the caching hints of the CacheControl
+ * config entry with the static assets and the feeds. Pages are left to the
+ * caching of Netlify, which is cleared on every deployment
+ */
+func handleNetlifyHeaders(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	security := getSecurityHeaders(&conf)
+	var names []string
+	for name := range security {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := []string{"/*"}
+	for _, name := range names {
+		lines = append(lines, "  "+name+": "+security[name])
+	}
+	cached := func(paths []string, seconds int) {
+		if seconds <= 0 {
+			return
+		}
+		for _, p := range paths {
+			lines = append(lines, p, "  Cache-Control: public, max-age="+strconv.Itoa(seconds))
+		}
+	}
+	assets := []string{"/media/*", "/thumbs/*"}
+	if entries, err := content.GetSiteStore("static", "static").ReadDir(""); err == nil {
+		for _, entry := range entries {
+			if entry.IsDir() {
+				assets = append(assets, "/"+entry.Name()+"/*")
+			} else {
+				assets = append(assets, "/"+entry.Name())
+			}
+		}
+	}
+	cached(assets, conf.CacheControl.Static)
+	cached([]string{"/feed.xml", "/*/feed.xml"}, conf.CacheControl.Feeds)
+	if conf.Pwa.Enabled {
+		lines = append(lines, "/sw.js", "  Cache-Control: no-cache")
+	}
+	ctx.SetHeader("Content-Type", "text/plain; charset=utf-8", true)
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/hoisie/web"
+	"html"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Header carrying the ID of a request, set on the request for the handlers
+// and on the response for the client
+const requestIDHeader = "X-Request-Id"
+
+/**
+ * Returns a new random request ID, short enough to be read out by visitors
+ * reporting an error
+ */
+func newRequestID() string {
+	bs := make([]byte, 6)
+	if _, err := rand.Read(bs); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(bs)
+}
+
+/**
+ * Returns the ID of a request, empty for requests not served by the site
+ * handler
+ */
+func getRequestID(r *http.Request) string {
+	return r.Header.Get(requestIDHeader)
+}
+
+/**
+ * Logs a message of a handler, prefixed with the ID of the request so it can
+ * be matched with the error page the visitor saw
+ */
+func logRequestError(ctx *web.Context, v ...interface{}) {
+	if ctx.Server == nil || ctx.Server.Logger == nil {
+		return
+	}
+	ctx.Server.Logger.Println(append([]interface{}{"[" + getRequestID(ctx.Request) + "]"}, v...)...)
+}
+
+/**
+ * Wraps the site handler to give every request a new ID, sent back in the
+ * X-Request-Id header. An ID sent by the client is replaced, as anybody can
+ * send one
+ */
+func addRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := newRequestID()
+		r.Header.Set(requestIDHeader, id)
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Response writer holding the bodies of server errors, to add the ID of the
+// request to them once the handler is done
+type referenceWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	body        *bytes.Buffer
+}
+
+func (w *referenceWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	if status >= 500 && !strings.Contains(w.Header().Get("Content-Type"), "json") {
+		w.status, w.body = status, new(bytes.Buffer)
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *referenceWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(200)
+	}
+	if w.body != nil {
+		return w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+/**
+ * Sends the server error held by the writer with the reference of the
+ * request: a line of text added to plain error messages, a paragraph closing
+ * the body of error pages
+ */
+func (w *referenceWriter) finish(r *http.Request) {
+	if w.body == nil {
+		return
+	}
+	id := getRequestID(r)
+	body := w.body.String()
+	message := ""
+	h := w.Header()
+	if len(h.Get("Content-Type")) == 0 && len(body) > 0 {
+		h.Set("Content-Type", http.DetectContentType([]byte(body)))
+	}
+	if strings.Contains(h.Get("Content-Type"), "html") {
+		reference := "<p class=\"reference\">Reference: " + html.EscapeString(id) + "</p>"
+		if i := strings.LastIndex(body, "</body>"); i >= 0 {
+			body = body[:i] + reference + body[i:]
+		} else {
+			body += reference
+		}
+	} else {
+		message = strings.TrimSpace(strings.SplitN(body, "\n", 2)[0])
+		body = strings.TrimRight(body, "\n") + "\n\nReference: " + id + "\n"
+	}
+	getMiddlewareLogger().Print(strings.TrimSpace("[" + id + "] " + r.Method + " " + r.URL.RequestURI() + " " +
+		strconv.Itoa(w.status) + " " + message))
+	// The length may have been set by the handler after the status
+	h.Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write([]byte(body))
+}
+
+/**
+ * Wraps the site handler to add the ID of the request to server errors, so
+ * visitors reporting one can quote its reference, which is logged along with
+ * the error
+ */
+func addErrorReferences(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &referenceWriter{ResponseWriter: w}
+		next.ServeHTTP(rw, r)
+		rw.finish(r)
+	})
+}
@@ -0,0 +1,22 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorReferencesWithoutServer(t *testing.T) {
+	previous := middlewareLogger
+	middlewareLogger = nil
+	t.Cleanup(func() { middlewareLogger = previous })
+	handler := addRequestID(addErrorReferences(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "Broken", 500)
+	})))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != 500 || !strings.Contains(w.Body.String(), "Reference: ") {
+		t.Errorf("error answered %d with %q, want a 500 with its reference", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,517 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Struct representing a saved version of an article. Ids are the time of the
+// revision in nanoseconds, or the commit hash when read from Git
+type Revision struct {
+	Id      string    `json:"id"`
+	Created time.Time `json:"created"`
+	Author  string    `json:"author"`
+	// Markdown file of the article, front matter included
+	Content string `json:"content,omitempty"`
+}
+
+// Struct representing the changes between two revisions of an article
+type RevisionDiff struct {
+	From  string     `json:"from"`
+	To    string     `json:"to"`
+	Lines []DiffLine `json:"lines"`
+}
+
+// Valid revision ids
+var revisionPattern = regexp.MustCompile("^([0-9]+|[0-9a-f]{40})$")
+
+/**
+ * Returns true if the history of the articles is read from the Git
+ * repository holding the content folder rather than kept by the site
+ */
+func isGitHistory(conf *config.Config) bool {
+	return conf.Git.Enabled && len(content.GetContentFolder(conf, "")) > 0
+}
+
+/**
+ * Returns true if the history of the articles is available
+ */
+func hasRevisions(conf *config.Config) bool {
+	return conf.Revisions.Enabled || isGitHistory(conf)
+}
+
+/**
+ * Returns the store holding the revisions, in a folder per article
+ */
+func getRevisionStore(conf *config.Config) content.Store {
+	folder := conf.Revisions.Folder
+	if len(folder) == 0 {
+		folder = "revisions"
+	}
+	return content.OpenDataStore(folder)
+}
+
+/**
+ * Returns the revisions of an article, newest first, without their content
+ */
+func getRevisions(section string, slug string, conf *config.Config) ([]Revision, error) {
+	revisions := []Revision{}
+	if isGitHistory(conf) {
+		name := content.GetStoredName(conf, content.GetArticleName(section, slug))
+		out, err := runGit(content.GetContentFolder(conf, name), "log", "--diff-filter=ACMRT", "--format=%H%x09%at%x09%an",
+			"--", filepath.FromSlash(name))
+		if err != nil {
+			return revisions, err
+		}
+		for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+			parts := strings.SplitN(line, "\t", 3)
+			if len(parts) != 3 {
+				continue
+			}
+			seconds, _ := strconv.ParseInt(parts[1], 10, 64)
+			revisions = append(revisions, Revision{Id: parts[0], Created: time.Unix(seconds, 0), Author: parts[2]})
+		}
+		return revisions, nil
+	}
+	store := getRevisionStore(conf)
+	files, err := store.ReadDir(section + "/" + slug)
+	if err != nil && !os.IsNotExist(err) {
+		return revisions, err
+	}
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		r, err := readRevision(store, section, slug, strings.TrimSuffix(fi.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+		r.Content = ""
+		revisions = append(revisions, r)
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Created.After(revisions[j].Created)
+	})
+	return revisions, nil
+}
+
+/**
+ * Reads a revision kept by the site
+ */
+func readRevision(store content.Store, section string, slug string, id string) (Revision, error) {
+	var r Revision
+	bs, err := store.ReadFile(section + "/" + slug + "/" + id + ".json")
+	if err != nil {
+		return r, err
+	}
+	err = json.Unmarshal(bs, &r)
+	return r, err
+}
+
+/**
+ * Returns a revision of an article with its content
+ */
+func getRevision(section string, slug string, id string, conf *config.Config) (Revision, error) {
+	if !revisionPattern.MatchString(id) {
+		return Revision{}, os.ErrNotExist
+	}
+	if !isGitHistory(conf) {
+		return readRevision(getRevisionStore(conf), section, slug, id)
+	}
+	revisions, err := getRevisions(section, slug, conf)
+	if err != nil {
+		return Revision{}, err
+	}
+	for _, r := range revisions {
+		if r.Id != id {
+			continue
+		}
+		// Paths starting with ./ are relative to the content folder
+		name := content.GetStoredName(conf, content.GetArticleName(section, slug))
+		r.Content, err = runGit(content.GetContentFolder(conf, name), "show", id+":./"+name)
+		return r, err
+	}
+	return Revision{}, os.ErrNotExist
+}
+
+/**
+ * Returns the markdown file of a revision of an article, "current" standing
+ * for the article as it is now
+ */
+func getRevisionContent(section string, slug string, id string, conf *config.Config) (string, error) {
+	if id == "current" {
+		bs, err := content.GetContentStore(conf).ReadFile(content.GetArticleName(section, slug))
+		return string(bs), err
+	}
+	r, err := getRevision(section, slug, id, conf)
+	return r.Content, err
+}
+
+/**
+ * Returns the diff between two revisions of an article, named by the from
+ * and to parameters of the request. The current version is compared by
+ * default, to the revision before the latest one
+ */
+func getRevisionDiff(ctx *web.Context, section string, slug string, conf *config.Config) (RevisionDiff, error) {
+	diff := RevisionDiff{From: ctx.Params["from"], To: ctx.Params["to"]}
+	if len(diff.To) == 0 {
+		diff.To = "current"
+	}
+	if len(diff.From) == 0 {
+		revisions, err := getRevisions(section, slug, conf)
+		if err != nil {
+			return diff, err
+		}
+		if len(revisions) == 0 {
+			return diff, os.ErrNotExist
+		}
+		diff.From = revisions[0].Id
+		if len(revisions) > 1 {
+			diff.From = revisions[1].Id
+		}
+	}
+	from, err := getRevisionContent(section, slug, diff.From, conf)
+	if err != nil {
+		return diff, err
+	}
+	to, err := getRevisionContent(section, slug, diff.To, conf)
+	if err != nil {
+		return diff, err
+	}
+	diff.Lines = diffLines(from, to)
+	return diff, nil
+}
+
+/**
+ * Keeps the current version of an article as a revision, attributed to the
+ * account of the request, unless it is the same as the latest one. Only the
+ * Revisions.Keep latest revisions are kept when set. Does nothing when
+ * revisions are disabled or read from Git, whose commits are the revisions
+ */
+func saveRevision(ctx *web.Context, conf *config.Config, section string, slug string) error {
+	if !conf.Revisions.Enabled || isGitHistory(conf) {
+		return nil
+	}
+	bs, err := content.GetContentStore(conf).ReadFile(content.GetArticleName(section, slug))
+	if err != nil {
+		return err
+	}
+	revisions, err := getRevisions(section, slug, conf)
+	if err != nil {
+		return err
+	}
+	store := getRevisionStore(conf)
+	if len(revisions) > 0 {
+		if latest, err := readRevision(store, section, slug, revisions[0].Id); err == nil && latest.Content == string(bs) {
+			return nil
+		}
+	}
+	author := "api"
+	if account, ok := getRequestAccount(ctx, conf); ok {
+		author = account.Name
+	}
+	now := time.Now()
+	r := Revision{Id: strconv.FormatInt(now.UnixNano(), 10), Created: now, Author: author, Content: string(bs)}
+	data, err := json.MarshalIndent(r, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err = store.WriteFile(section+"/"+slug+"/"+r.Id+".json", data); err != nil {
+		return err
+	}
+	revisions = append([]Revision{r}, revisions...)
+	for i := conf.Revisions.Keep; conf.Revisions.Keep > 0 && i < len(revisions); i++ {
+		store.Remove(section + "/" + slug + "/" + revisions[i].Id + ".json")
+	}
+	return nil
+}
+
+/**
+ * Saves a revision as the current version of an article, restricted to what
+ * the account may save. Returns the restored page
+ */
+func restoreRevision(account Account, section string, slug string, id string, conf *config.Config) (content.Page, error) {
+	r, err := getRevision(section, slug, id, conf)
+	if err != nil {
+		return content.Page{}, err
+	}
+	meta, body := content.ParseFrontMatter(r.Content)
+	p := account.RestrictPage(content.Page{Meta: meta, Body: body})
+	return p, content.SavePage(section, slug, p, conf)
+}
+
+// History of an article, linking to its revisions
+const adminHistoryBody = `{{ define "body" }}
+<p><a href="/admin/edit/{{ .Section }}/{{ .Slug }}">Back to the editor</a></p>
+{{ if not .Revisions }}<p>No revisions yet.</p>{{ end }}
+<form method="get" action="/admin/history/{{ .Section }}/{{ .Slug }}/diff">
+<table class="table">
+  <tr>
+    <th>Revision</th><th>Author</th><th>From</th><th>To</th>
+  </tr>
+  <tr>
+    <td>Current version</td><td></td><td></td>
+    <td><input type="radio" name="to" value="current" checked></td>
+  </tr>
+  {{ range $i, $r := .Revisions }}
+  <tr>
+    <td><a href="/admin/history/{{ $.Section }}/{{ $.Slug }}/{{ .Id }}">{{ .Created.Format "2006-01-02 15:04:05" }}</a></td>
+    <td>{{ .Author }}</td>
+    <td><input type="radio" name="from" value="{{ .Id }}"{{ if eq $i 1 }} checked{{ end }}></td>
+    <td><input type="radio" name="to" value="{{ .Id }}"></td>
+  </tr>
+  {{ end }}
+</table>
+{{ if .Revisions }}<button class="btn btn-default" type="submit">Compare</button>{{ end }}
+</form>
+{{ end }}`
+
+// Changes between two revisions of an article
+const adminDiffBody = `{{ define "body" }}
+<p><a href="/admin/history/{{ .Section }}/{{ .Slug }}">Back to the history</a></p>
+<p>Changes from {{ .Diff.From }} to {{ .Diff.To }}</p>
+<pre>{{ range .Diff.Lines }}{{ if eq .Op "+" }}<ins style="background: #dfd; text-decoration: none">+ {{ .Text }}</ins>{{ else if eq .Op "-" }}<del style="background: #fdd; text-decoration: none">- {{ .Text }}</del>{{ else }}  {{ .Text }}{{ end }}
+{{ end }}</pre>
+{{ if ne .Diff.From "current" }}
+<form method="post" action="/admin/history/{{ .Section }}/{{ .Slug }}/{{ .Diff.From }}">
+  {{ csrfField }}
+  <button class="btn btn-primary" type="submit">Restore {{ .Diff.From }}</button>
+</form>
+{{ end }}
+{{ end }}`
+
+// Revision of an article, with the form restoring it
+const adminRevisionBody = `{{ define "body" }}
+<p><a href="/admin/history/{{ .Section }}/{{ .Slug }}">Back to the history</a></p>
+<p>{{ .Revision.Created.Format "2006-01-02 15:04:05" }} by {{ .Revision.Author }}</p>
+<form method="post" action="/admin/history/{{ .Section }}/{{ .Slug }}/{{ .Revision.Id }}">
+  {{ csrfField }}
+  <button class="btn btn-primary" type="submit">Restore this revision</button>
+  <a class="btn btn-default" href="/admin/history/{{ .Section }}/{{ .Slug }}/diff?from={{ .Revision.Id }}&amp;to=current">Compare with the current version</a>
+</form>
+<pre>{{ .Revision.Content }}</pre>
+{{ end }}`
+
+/**
+ * Returns true if the account may see the history of an article and restore
+ * it. Deleted articles may only be restored by editors
+ */
+func canRestore(account Account, section string, slug string, conf *config.Config) bool {
+	if p, err := content.GetPage(section, slug, conf); err == nil {
+		return account.CanEdit(p)
+	}
+	return account.Can(RoleEditor)
+}
+
+/**
+ * Lists the revisions of an article in the admin area
+ */
+func handleAdminHistory(ctx *web.Context, section string, slug string) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	account, ok := checkAdminAuth(ctx, &conf, RoleAuthor)
+	if !ok {
+		return ""
+	}
+	if !hasRevisions(&conf) {
+		ctx.Abort(404, "Revisions are disabled.")
+		return ""
+	}
+	if !canRestore(account, section, slug, &conf) {
+		ctx.Abort(403, "You are not allowed to edit this article.")
+		return ""
+	}
+	revisions, err := getRevisions(section, slug, &conf)
+	if err != nil {
+		ctx.Abort(500, "Could not read revisions")
+		return ""
+	}
+	response, err := renderAdmin(ctx, adminHistoryBody, map[string]interface{}{
+		"Title": "History of " + slug, "Section": section, "Slug": slug, "Revisions": revisions})
+	if err != nil {
+		ctx.Abort(500, err.Error())
+		return ""
+	}
+	return response
+}
+
+/**
+ * Shows a revision of an article in the admin area, and restores it when
+ * posted to
+ */
+func handleAdminRevision(ctx *web.Context, section string, slug string, id string) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	account, ok := checkAdminAuth(ctx, &conf, RoleAuthor)
+	if !ok {
+		return ""
+	}
+	if !hasRevisions(&conf) {
+		ctx.Abort(404, "Revisions are disabled.")
+		return ""
+	}
+	if !canRestore(account, section, slug, &conf) {
+		ctx.Abort(403, "You are not allowed to edit this article.")
+		return ""
+	}
+	if ctx.Request.Method == "POST" {
+		if !checkCsrf(ctx) {
+			ctx.Abort(403, "Invalid or missing CSRF token.")
+			return ""
+		}
+		if _, err = restoreRevision(account, section, slug, id, &conf); os.IsNotExist(err) {
+			ctx.Abort(404, "Revision not found.")
+			return ""
+		} else if err != nil {
+			ctx.Abort(500, "Could not restore revision")
+			return ""
+		}
+		contentChanged(ctx, &conf, section, slug, "Restore")
+		ctx.Redirect(303, "/admin/edit/"+section+"/"+slug+"?saved=1")
+		return ""
+	}
+	r, err := getRevision(section, slug, id, &conf)
+	if err != nil {
+		ctx.Abort(404, "Revision not found.")
+		return ""
+	}
+	response, err := renderAdmin(ctx, adminRevisionBody, map[string]interface{}{
+		"Title": "Revision of " + slug, "Section": section, "Slug": slug, "Revision": r})
+	if err != nil {
+		ctx.Abort(500, err.Error())
+		return ""
+	}
+	return response
+}
+
+/**
+ * Shows the changes between two revisions of an article in the admin area
+ */
+func handleAdminDiff(ctx *web.Context, section string, slug string) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	account, ok := checkAdminAuth(ctx, &conf, RoleAuthor)
+	if !ok {
+		return ""
+	}
+	if !hasRevisions(&conf) {
+		ctx.Abort(404, "Revisions are disabled.")
+		return ""
+	}
+	if !canRestore(account, section, slug, &conf) {
+		ctx.Abort(403, "You are not allowed to edit this article.")
+		return ""
+	}
+	diff, err := getRevisionDiff(ctx, section, slug, &conf)
+	if err != nil {
+		ctx.Abort(404, "Revision not found.")
+		return ""
+	}
+	response, err := renderAdmin(ctx, adminDiffBody, map[string]interface{}{
+		"Title": "Changes of " + slug, "Section": section, "Slug": slug, "Diff": diff})
+	if err != nil {
+		ctx.Abort(500, err.Error())
+		return ""
+	}
+	return response
+}
+
+/**
+ * Loads the config and checks that the request may see the history of an
+ * article, writing the error response otherwise
+ */
+func getApiRevisionConfig(ctx *web.Context, section string, slug string) (config.Config, Account, string, bool) {
+	conf, account, response, ok := getApiConfig(ctx, RoleAuthor)
+	if !ok {
+		return conf, account, response, false
+	}
+	if !hasRevisions(&conf) {
+		return conf, account, apiError(ctx, 404, "Revisions are disabled"), false
+	}
+	if !canRestore(account, section, slug, &conf) {
+		return conf, account, apiError(ctx, 403, "Forbidden"), false
+	}
+	return conf, account, "", true
+}
+
+/**
+ * Lists the revisions of an article, newest first
+ */
+func handleApiRevisions(ctx *web.Context, section string, slug string) string {
+	conf, _, response, ok := getApiRevisionConfig(ctx, section, slug)
+	if !ok {
+		return response
+	}
+	revisions, err := getRevisions(section, slug, &conf)
+	if err != nil {
+		return apiError(ctx, 500, "Could not read revisions")
+	}
+	return apiResponse(ctx, 200, revisions)
+}
+
+/**
+ * Returns a revision of an article with its content
+ */
+func handleApiGetRevision(ctx *web.Context, section string, slug string, id string) string {
+	conf, _, response, ok := getApiRevisionConfig(ctx, section, slug)
+	if !ok {
+		return response
+	}
+	r, err := getRevision(section, slug, id, &conf)
+	if err != nil {
+		return apiError(ctx, 404, "Revision not found")
+	}
+	return apiResponse(ctx, 200, r)
+}
+
+/**
+ * Returns the changes between two revisions of an article, named by the from
+ * and to query parameters
+ */
+func handleApiRevisionDiff(ctx *web.Context, section string, slug string) string {
+	conf, _, response, ok := getApiRevisionConfig(ctx, section, slug)
+	if !ok {
+		return response
+	}
+	diff, err := getRevisionDiff(ctx, section, slug, &conf)
+	if err != nil {
+		return apiError(ctx, 404, "Revision not found")
+	}
+	return apiResponse(ctx, 200, diff)
+}
+
+/**
+ * Restores a revision of an article, returning the restored article
+ */
+func handleApiRestoreRevision(ctx *web.Context, section string, slug string, id string) string {
+	conf, account, response, ok := getApiRevisionConfig(ctx, section, slug)
+	if !ok {
+		return response
+	}
+	if _, err := restoreRevision(account, section, slug, id, &conf); os.IsNotExist(err) {
+		return apiError(ctx, 404, "Revision not found")
+	} else if err != nil {
+		return apiError(ctx, 500, "Could not restore revision")
+	}
+	contentChanged(ctx, &conf, section, slug, "Restore")
+	article, _ := getApiArticle(section, slug, true, &conf)
+	return apiResponse(ctx, 200, article)
+}
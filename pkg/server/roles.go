@@ -0,0 +1,204 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/subtle"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"strings"
+)
+
+// Roles of the people using the admin area and the content API. Authors
+// write drafts of their own, editors edit and publish every article and
+// moderate comments, administrators also see the reports of the site
+const (
+	RoleAuthor = "author"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+// Rank of the roles, each one allowed what the lower ones are
+var roleRanks = map[string]int{RoleAuthor: 1, RoleEditor: 2, RoleAdmin: 3}
+
+// Struct representing the person behind a request to the admin area or the
+// content API
+type Account struct {
+	Name, Email, Role string
+}
+
+/**
+ * Returns true if the account has the given role, or a higher one
+ */
+func (a Account) Can(role string) bool {
+	return roleRanks[a.Role] > 0 && roleRanks[a.Role] >= roleRanks[role]
+}
+
+/**
+ * Returns true if the account may change an article. Authors may only change
+ * their own drafts, named after them by the author front matter key
+ */
+func (a Account) CanEdit(p content.Page) bool {
+	if a.Can(RoleEditor) {
+		return true
+	}
+	return a.Can(RoleAuthor) && p.IsDraft() && strings.EqualFold(p.Meta.Get("author"), a.Name)
+}
+
+/**
+ * Returns a page saved by the account. Pages saved by authors stay drafts
+ * named after them, so they can only be published by an editor
+ */
+func (a Account) RestrictPage(p content.Page) content.Page {
+	if a.Can(RoleEditor) {
+		return p
+	}
+	meta := make(content.FrontMatter)
+	for key, value := range p.Meta {
+		meta[key] = value
+	}
+	meta["draft"] = "true"
+	meta["author"] = a.Name
+	return content.Page{Meta: meta, Body: p.Body}
+}
+
+/**
+ * Returns the accounts of the admin area: the administrator of the Admin
+ * config entry, the scripts using its ApiToken, named "api", and the Users
+ * having a known role
+ */
+func getAccounts(conf *config.Config) []config.UserConfig {
+	var accounts []config.UserConfig
+	if len(conf.Admin.Password) > 0 {
+		accounts = append(accounts, config.UserConfig{Name: conf.Admin.User, Password: conf.Admin.Password, Role: RoleAdmin})
+	}
+	if len(conf.Admin.ApiToken) > 0 {
+		accounts = append(accounts, config.UserConfig{Name: "api", Token: conf.Admin.ApiToken, Role: RoleAdmin})
+	}
+	for _, user := range conf.Admin.Users {
+		if roleRanks[user.Role] > 0 && len(user.Name) > 0 {
+			accounts = append(accounts, user)
+		}
+	}
+	return accounts
+}
+
+/**
+ * Returns true if some people log in with a password
+ */
+func hasPasswordLogin(conf *config.Config) bool {
+	for _, account := range getAccounts(conf) {
+		if len(account.Password) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Returns true if people can log in, with a password or OAuth
+ */
+func isAdminEnabled(conf *config.Config) bool {
+	return hasPasswordLogin(conf) || isOAuthEnabled(conf)
+}
+
+/**
+ * Returns the account with the given name and password
+ */
+func findPasswordAccount(name string, password string, conf *config.Config) (Account, bool) {
+	for _, account := range getAccounts(conf) {
+		if len(account.Password) > 0 && subtle.ConstantTimeCompare([]byte(name), []byte(account.Name)) == 1 &&
+			subtle.ConstantTimeCompare([]byte(password), []byte(account.Password)) == 1 {
+			return Account{Name: account.Name, Email: account.Email, Role: account.Role}, true
+		}
+	}
+	return Account{}, false
+}
+
+/**
+ * Returns the account of the scripts sending the given token
+ */
+func findTokenAccount(token string, conf *config.Config) (Account, bool) {
+	for _, account := range getAccounts(conf) {
+		if len(account.Token) > 0 && subtle.ConstantTimeCompare([]byte(token), []byte(account.Token)) == 1 {
+			return Account{Name: account.Name, Email: account.Email, Role: account.Role}, true
+		}
+	}
+	return Account{}, false
+}
+
+/**
+ * Returns the signature kept by the sessions of an account. It covers the
+ * role and the password of the account and the people allowed to log in with
+ * OAuth, so changing them logs the account out
+ */
+func getAccountMac(account Account, conf *config.Config) string {
+	password := ""
+	for _, a := range getAccounts(conf) {
+		if a.Name == account.Name && strings.EqualFold(a.Email, account.Email) {
+			password = a.Password
+			break
+		}
+	}
+	return getSessionMac("admin|"+account.Name+"|"+account.Email+"|"+account.Role+"|"+password+"|"+
+		strings.Join(conf.Admin.OAuth.Emails, ",")+"|"+strings.Join(conf.Admin.OAuth.Organizations, ","), conf)
+}
+
+/**
+ * Logs an account in with the session of the request
+ */
+func openAccountSession(ctx *web.Context, session Session, account Account, conf *config.Config) error {
+	session.Values["admin"] = getAccountMac(account, conf)
+	session.Values["user"] = account.Name
+	session.Values["email"] = account.Email
+	return session.Save(ctx, conf)
+}
+
+/**
+ * Returns the account logged in with a session. Its role is read from the
+ * config again, people only known through OAuth getting OAuth.Role
+ */
+func getSessionAccount(session Session, conf *config.Config) (Account, bool) {
+	if !isAdminEnabled(conf) || len(session.Values["admin"]) == 0 {
+		return Account{}, false
+	}
+	name, email := session.Values["user"], session.Values["email"]
+	account, found := Account{}, false
+	for _, a := range getAccounts(conf) {
+		if a.Name == name && strings.EqualFold(a.Email, email) {
+			account, found = Account{Name: a.Name, Email: a.Email, Role: a.Role}, true
+			break
+		}
+	}
+	if !found && len(email) > 0 && isOAuthEnabled(conf) {
+		account = Account{Name: name, Email: email, Role: getOAuthRole(conf)}
+	}
+	if len(account.Role) == 0 || !hmac.Equal([]byte(session.Values["admin"]), []byte(getAccountMac(account, conf))) {
+		return Account{}, false
+	}
+	return account, true
+}
+
+/**
+ * Returns the account whose credentials the request sends: a token as bearer
+ * token or a name and password with HTTP basic auth
+ */
+func getCredentialsAccount(ctx *web.Context, conf *config.Config) (Account, bool) {
+	if token := ctx.Request.Header.Get("Authorization"); strings.HasPrefix(token, "Bearer ") {
+		return findTokenAccount(strings.TrimPrefix(token, "Bearer "), conf)
+	}
+	if name, password, err := ctx.GetBasicAuth(); err == nil {
+		return findPasswordAccount(name, password, conf)
+	}
+	return Account{}, false
+}
+
+/**
+ * Returns the account behind a request, logged in or sending its credentials
+ */
+func getRequestAccount(ctx *web.Context, conf *config.Config) (Account, bool) {
+	if account, ok := getSessionAccount(GetSession(ctx, conf), conf); ok {
+		return account, true
+	}
+	return getCredentialsAccount(ctx, conf)
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"net/http/httptest"
+	"testing"
+)
+
+/**
+ * Returns a config with an administrator, a script, an editor and an author
+ */
+func getTestRolesConfig() *config.Config {
+	conf := config.Config{}
+	conf.Session.Secret = "session-secret"
+	conf.Admin = config.AdminConfig{User: "admin", Password: "secret", ApiToken: "api-token", Users: []config.UserConfig{
+		{Name: "ed", Password: "ed-secret", Token: "ed-token", Role: RoleEditor},
+		{Name: "ann", Password: "ann-secret", Role: RoleAuthor},
+		{Name: "bob", Password: "bob-secret", Role: "owner"},
+	}}
+	return &conf
+}
+
+func TestAccountCan(t *testing.T) {
+	for _, test := range []struct {
+		role, wanted string
+		allowed      bool
+	}{
+		{RoleAdmin, RoleAdmin, true},
+		{RoleAdmin, RoleAuthor, true},
+		{RoleEditor, RoleEditor, true},
+		{RoleEditor, RoleAdmin, false},
+		{RoleAuthor, RoleAuthor, true},
+		{RoleAuthor, RoleEditor, false},
+		{"owner", RoleAuthor, false},
+		{"", RoleAuthor, false},
+	} {
+		if (Account{Role: test.role}).Can(test.wanted) != test.allowed {
+			t.Errorf("%q allowed what %q may do: %v, want %v", test.role, test.wanted, !test.allowed, test.allowed)
+		}
+	}
+}
+
+func TestAccountCanEdit(t *testing.T) {
+	author := Account{Name: "ann", Role: RoleAuthor}
+	own := content.Page{Meta: content.FrontMatter{"draft": "true", "author": "Ann"}}
+	published := content.Page{Meta: content.FrontMatter{"author": "ann"}}
+	other := content.Page{Meta: content.FrontMatter{"draft": "true", "author": "ed"}}
+	if !author.CanEdit(own) {
+		t.Error("author cannot edit their own draft")
+	}
+	if author.CanEdit(published) || author.CanEdit(other) {
+		t.Error("author can edit a published article or the draft of someone else")
+	}
+	if !(Account{Name: "ed", Role: RoleEditor}).CanEdit(published) {
+		t.Error("editor cannot edit a published article")
+	}
+	page := author.RestrictPage(content.Page{Meta: content.FrontMatter{"author": "ed"}, Body: "Body"})
+	if !page.IsDraft() || page.Meta.Get("author") != "ann" || page.Body != "Body" {
+		t.Errorf("page saved by an author kept as %v", page.Meta)
+	}
+}
+
+func TestCredentialsAccount(t *testing.T) {
+	conf := getTestRolesConfig()
+	for _, test := range []struct {
+		user, password, token string
+		name, role            string
+	}{
+		{user: "admin", password: "secret", name: "admin", role: RoleAdmin},
+		{user: "ed", password: "ed-secret", name: "ed", role: RoleEditor},
+		{user: "ann", password: "ann-secret", name: "ann", role: RoleAuthor},
+		{token: "api-token", name: "api", role: RoleAdmin},
+		{token: "ed-token", name: "ed", role: RoleEditor},
+		{user: "ann", password: "secret"},
+		{user: "bob", password: "bob-secret"},
+		{user: "ed", password: "ed-token"},
+		{token: "ed-secret"},
+		{token: ""},
+	} {
+		r := httptest.NewRequest("GET", "/api/articles", nil)
+		if len(test.user) > 0 {
+			r.SetBasicAuth(test.user, test.password)
+		} else {
+			r.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		ctx, _ := newTestContext(r)
+		account, ok := getCredentialsAccount(ctx, conf)
+		if ok != (len(test.role) > 0) || account.Name != test.name || account.Role != test.role {
+			t.Errorf("credentials %q %q %q gave %v %v, want %q %q", test.user, test.password, test.token,
+				account, ok, test.name, test.role)
+		}
+	}
+}
+
+func TestSessionAccount(t *testing.T) {
+	conf := getTestRolesConfig()
+	ctx, _ := newTestContext(httptest.NewRequest("GET", "/admin", nil))
+	session := GetSession(ctx, conf)
+	if err := openAccountSession(ctx, session, Account{Name: "ed", Role: RoleEditor}, conf); err != nil {
+		t.Fatal(err)
+	}
+	if account, ok := getSessionAccount(session, conf); !ok || account.Role != RoleEditor {
+		t.Fatalf("session gave %v %v, want the editor", account, ok)
+	}
+	conf.Admin.Users[0].Role = RoleAdmin
+	if account, ok := getSessionAccount(session, conf); ok {
+		t.Errorf("session kept %v after the role changed", account)
+	}
+	conf = getTestRolesConfig()
+	conf.Admin.Users[0].Password = "new-secret"
+	if account, ok := getSessionAccount(session, conf); ok {
+		t.Errorf("session kept %v after the password changed", account)
+	}
+	conf = getTestRolesConfig()
+	session.Values["user"] = "admin"
+	if account, ok := getSessionAccount(session, conf); ok {
+		t.Errorf("session renamed to %v", account)
+	}
+}
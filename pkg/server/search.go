@@ -0,0 +1,361 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"html"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Struct representing an article found by a search, with its relevance
+type SearchResult struct {
+	Section string  `json:"section"`
+	Slug    string  `json:"slug"`
+	Title   string  `json:"title"`
+	Url     string  `json:"url"`
+	Summary string  `json:"summary"`
+	Score   float64 `json:"score"`
+}
+
+// Struct representing the words of an article, by field, with the number of
+// times each word appears
+type searchDocument struct {
+	Article           gqlArticle
+	Title, Tags, Body map[string]int
+}
+
+// Weights of a word matching a query term exactly, as a prefix and with a
+// typo
+const (
+	exactMatch  = 1.0
+	prefixMatch = 0.75
+	fuzzyMatch  = 0.5
+)
+
+/**
+ * Returns the words of a text, in lower case and without their diacritics
+ */
+func getSearchTokens(text string) []string {
+	return strings.FieldsFunc(content.Slugify(text), func(r rune) bool {
+		return r == '-'
+	})
+}
+
+/**
+ * Returns the number of times each word of a text appears in it
+ */
+func countSearchTokens(text string) map[string]int {
+	counts := make(map[string]int)
+	for _, token := range getSearchTokens(text) {
+		counts[token]++
+	}
+	return counts
+}
+
+/**
+ * Returns the edit distance between two words, the number of letters to
+ * insert, delete, replace or swap with the next one to turn one into the
+ * other. Returns max+1 as soon as the distance is known to be larger than max
+ */
+func editDistance(a string, b string, max int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > max || -d > max {
+		return max + 1
+	}
+	// Rows of the distances between the prefixes of a and those of b
+	before, previous, current := make([]int, len(rb)+1), make([]int, len(rb)+1), make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		lowest := current[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(minInt(previous[j]+1, current[j-1]+1), previous[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				current[j] = minInt(current[j], before[j-2]+1)
+			}
+			lowest = minInt(lowest, current[j])
+		}
+		if lowest > max {
+			return max + 1
+		}
+		before, previous, current = previous, current, before
+	}
+	return previous[len(rb)]
+}
+
+/**
+ * Returns the smallest of two integers
+ */
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+/**
+ * Returns the typos tolerated in a query term: none for short words, one up
+ * to seven letters and two for longer words
+ */
+func getTypoTolerance(term string) int {
+	switch n := len([]rune(term)); {
+	case n < 4:
+		return 0
+	case n < 8:
+		return 1
+	}
+	return 2
+}
+
+/**
+ * Returns how well a query term matches the words of a field, from 0 for no
+ * match to 1 for an exact one, along with the number of times the best
+ * matching word appears
+ */
+func matchSearchTerm(term string, words map[string]int, fuzzy bool) (float64, int) {
+	if n, ok := words[term]; ok {
+		return exactMatch, n
+	}
+	best, count := 0.0, 0
+	tolerance := getTypoTolerance(term)
+	for word, n := range words {
+		weight := 0.0
+		if len(term) >= 2 && strings.HasPrefix(word, term) {
+			weight = prefixMatch
+		} else if fuzzy && tolerance > 0 && editDistance(term, word, tolerance) <= tolerance {
+			weight = fuzzyMatch
+		}
+		if weight > best || (weight == best && weight > 0 && n > count) {
+			best, count = weight, n
+		}
+	}
+	return best, count
+}
+
+/**
+ * Returns the weight of a field of the articles in search scores, its
+ * Search config value or the given default
+ */
+func getSearchWeight(weight float64, fallback float64) float64 {
+	if weight > 0 {
+		return weight
+	}
+	return fallback
+}
+
+/**
+ * Returns the score of an article for a query, 0 when a term of the query
+ * matches none of its words. Matches in the title weigh more than matches
+ * in the tags, which weigh more than matches in the body, where words
+ * appearing often weigh more
+ */
+func scoreSearchDocument(doc searchDocument, terms []string, conf *config.Config) float64 {
+	titleWeight := getSearchWeight(conf.Search.TitleWeight, 3)
+	tagWeight := getSearchWeight(conf.Search.TagWeight, 2)
+	bodyWeight := getSearchWeight(conf.Search.BodyWeight, 1)
+	score := 0.0
+	for _, term := range terms {
+		title, _ := matchSearchTerm(term, doc.Title, conf.Search.Fuzzy)
+		tags, _ := matchSearchTerm(term, doc.Tags, conf.Search.Fuzzy)
+		body, count := matchSearchTerm(term, doc.Body, conf.Search.Fuzzy)
+		termScore := titleWeight*title + tagWeight*tags
+		if count > 0 {
+			termScore += bodyWeight * body * (1 + math.Log(float64(count)))
+		}
+		if termScore == 0 {
+			return 0
+		}
+		score += termScore
+	}
+	return score
+}
+
+// Number of characters of a query searched at most
+const searchMaxQuery = 200
+
+// Number of distinct words of a query searched at most
+const searchMaxTerms = 10
+
+/**
+ * Returns the published articles matching a query, most relevant first and
+ * newest first among equally relevant ones. Words of the query match words
+ * of the articles starting with them and, with Search.Fuzzy, words a typo
+ * away. Protected articles are only found by their title. Only the first
+ * searchMaxTerms words of the first searchMaxQuery characters are searched,
+ * since every word is compared with every word of the articles
+ */
+func searchArticles(query string, conf *config.Config) ([]SearchResult, error) {
+	results := []SearchResult{}
+	if runes := []rune(query); len(runes) > searchMaxQuery {
+		query = string(runes[:searchMaxQuery])
+	}
+	var terms []string
+	seen := make(map[string]bool)
+	for _, term := range getSearchTokens(query) {
+		if len(terms) == searchMaxTerms {
+			break
+		}
+		if !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+	if len(terms) == 0 {
+		return results, nil
+	}
+	articles, err := getGqlArticles(conf)
+	if err != nil {
+		return results, err
+	}
+	type scored struct {
+		article gqlArticle
+		score   float64
+	}
+	var found []scored
+	for _, a := range articles {
+		doc := searchDocument{Article: a, Title: countSearchTokens(content.GetPageTitle(a.Page)),
+			Tags: countSearchTokens(strings.Join(a.Page.Meta.List("tags"), " ")), Body: map[string]int{}}
+		// The body of protected articles is masked, and left out of the search
+		if !a.Protected {
+			doc.Body = countSearchTokens(a.Page.Body)
+		}
+		if score := scoreSearchDocument(doc, terms, conf); score > 0 {
+			found = append(found, scored{a, score})
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].score != found[j].score {
+			return found[i].score > found[j].score
+		}
+		return found[i].article.Date.After(found[j].article.Date)
+	})
+	max := conf.Search.Results
+	if max <= 0 {
+		max = 20
+	}
+	for i, f := range found {
+		if i == max {
+			break
+		}
+		results = append(results, SearchResult{Section: f.article.Section, Slug: f.article.Slug,
+			Title: content.GetPageTitle(f.article.Page), Url: f.article.Link,
+			Summary: content.GetPageDescription(f.article.Page), Score: math.Round(f.score*100) / 100})
+	}
+	return results, nil
+}
+
+/**
+ * Handles the search page, /search?q=..., answering with the results in JSON
+ * when asked for
+ */
+func handleSearch(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	query := strings.TrimSpace(ctx.Params["q"])
+	results, err := searchArticles(query, &conf)
+	if err != nil {
+		ctx.Abort(501, "Could not load articles")
+		return ""
+	}
+	ctx.SetHeader("Vary", "Accept", true)
+	if negotiateFormat(ctx.Request.Header.Get("Accept")) == "json" {
+		return apiResponse(ctx, 200, results)
+	}
+	message := "<form class=\"search-form\" method=\"get\" action=\"/search\">" +
+		"<input class=\"form-control\" type=\"search\" name=\"q\" value=\"" + html.EscapeString(query) + "\" placeholder=\"Search\">" +
+		"</form>\n\n"
+	if len(query) > 0 && len(results) == 0 {
+		message += "No article matches your search."
+	}
+	for _, r := range results {
+		message += "- [" + r.Title + "](" + r.Url + ") " + r.Summary + "\n"
+	}
+	canonical := getRootURL(ctx, &conf) + "/search"
+	if len(query) > 0 {
+		canonical += "?q=" + url.QueryEscape(query)
+	}
+	return renderMessage(ctx, &conf, "Search", message, map[string]interface{}{
+		"canonical": canonical, "search": query, "searchResults": results})
+}
+
+// Struct representing an article of the client-side search index. Words are
+// the distinct words of its title, tags and body, separated by spaces
+type SearchIndexEntry struct {
+	Title   string    `json:"title"`
+	Url     string    `json:"url"`
+	Section string    `json:"section"`
+	Date    time.Time `json:"date"`
+	Tags    []string  `json:"tags"`
+	Summary string    `json:"summary"`
+	Words   string    `json:"words"`
+}
+
+/**
+ * Returns the distinct words of texts, in the order they first appear
+ */
+func getDistinctTokens(texts ...string) []string {
+	var words []string
+	seen := make(map[string]bool)
+	for _, text := range texts {
+		for _, token := range getSearchTokens(text) {
+			if !seen[token] {
+				seen[token] = true
+				words = append(words, token)
+			}
+		}
+	}
+	return words
+}
+
+/**
+ * Serves the search index of the published articles, newest first, for
+ * themes searching in the browser. Protected articles are indexed by their
+ * title only
+ */
+func handleSearchIndex(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		return apiError(ctx, 500, "Configuration error")
+	}
+	articles, err := getGqlArticles(&conf)
+	if err != nil {
+		return apiError(ctx, 500, "Could not load articles")
+	}
+	sort.SliceStable(articles, func(i, j int) bool {
+		return articles[i].Date.After(articles[j].Date)
+	})
+	index := []SearchIndexEntry{}
+	for _, a := range articles {
+		entry := SearchIndexEntry{Title: content.GetPageTitle(a.Page), Url: a.Link, Section: a.Section,
+			Date: a.Date, Tags: append([]string{}, a.Page.Meta.List("tags")...),
+			Summary: content.GetPageDescription(a.Page)}
+		body := a.Page.Body
+		// The body of protected articles is masked, and left out of the index
+		if a.Protected {
+			body = ""
+		}
+		entry.Words = strings.Join(getDistinctTokens(entry.Title, strings.Join(entry.Tags, " "), body), " ")
+		index = append(index, entry)
+	}
+	bs, err := json.Marshal(index)
+	if err != nil {
+		return apiError(ctx, 500, "Could not encode index")
+	}
+	ctx.SetHeader("Content-Type", "application/json; charset=utf-8", true)
+	return string(bs)
+}
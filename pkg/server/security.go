@@ -0,0 +1,59 @@
+package server
+
+import (
+	"github.com/rredpoppy/gosite/pkg/config"
+	"net/http"
+	"strings"
+)
+
+// Headers sent with every response unless configured otherwise
+var defaultSecurityHeaders = map[string]string{
+	"Content-Security-Policy":   "frame-ancestors 'self'; object-src 'none'; base-uri 'self'",
+	"Strict-Transport-Security": "max-age=31536000",
+	"X-Content-Type-Options":    "nosniff",
+	"Referrer-Policy":           "strict-origin-when-cross-origin",
+	"X-Frame-Options":           "SAMEORIGIN",
+}
+
+/**
+ * Returns the security headers of the site: the configured value of each
+ * header, its default value when not set, and none when set to "off"
+ */
+func getSecurityHeaders(conf *config.Config) map[string]string {
+	configured := map[string]string{
+		"Content-Security-Policy":   conf.Security.ContentSecurityPolicy,
+		"Strict-Transport-Security": conf.Security.StrictTransportSecurity,
+		"X-Content-Type-Options":    conf.Security.ContentTypeOptions,
+		"Referrer-Policy":           conf.Security.ReferrerPolicy,
+		"X-Frame-Options":           conf.Security.FrameOptions,
+	}
+	headers := make(map[string]string)
+	for name, value := range configured {
+		if len(value) == 0 {
+			value = defaultSecurityHeaders[name]
+		}
+		if value != "off" {
+			headers[name] = value
+		}
+	}
+	return headers
+}
+
+/**
+ * Wraps the site handler to send the security headers with every response.
+ * HSTS is only sent over HTTPS, or when the site is served over HTTPS behind
+ * a proxy. Handlers may still replace any of the headers
+ */
+func addSecurityHeaders(next http.Handler, conf *config.Config) http.Handler {
+	headers := getSecurityHeaders(conf)
+	https := strings.HasPrefix(conf.Site.BaseURL, "https://")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for name, value := range headers {
+			if name == "Strict-Transport-Security" && !isSecureRequest(r) && !https {
+				continue
+			}
+			w.Header().Set(name, value)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,645 @@
+package server
+
+import (
+	"errors"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"github.com/rredpoppy/gosite/pkg/render"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Struct representing the JSON representation of a page
+type PageData struct {
+	Section   string              `json:"section"`
+	Slug      string              `json:"slug"`
+	Meta      content.FrontMatter `json:"meta"`
+	Canonical string              `json:"canonical"`
+	Markdown  string              `json:"markdown"`
+	Content   string              `json:"content"`
+}
+
+// Struct representing an article in the JSON representation of a listing
+type ListingItemData struct {
+	Slug    string              `json:"slug"`
+	Title   string              `json:"title"`
+	Url     string              `json:"url"`
+	Meta    content.FrontMatter `json:"meta"`
+	Summary string              `json:"summary"`
+}
+
+// Struct representing the JSON representation of a listing page
+type ListingData struct {
+	Section  string            `json:"section"`
+	Title    string            `json:"title"`
+	Intro    string            `json:"intro,omitempty"`
+	Articles []ListingItemData `json:"articles"`
+	Page     int               `json:"page"`
+	Pages    int               `json:"pages"`
+	Total    int               `json:"total"`
+	Prev     string            `json:"prev,omitempty"`
+	Next     string            `json:"next,omitempty"`
+}
+
+/**
+ * Returns the JSON representation of a listing page, with rendered HTML
+ */
+func getListingData(listing content.Listing, title string) ListingData {
+	data := ListingData{Section: listing.Section, Title: title,
+		Articles: []ListingItemData{}, Page: listing.Page,
+		Pages: listing.Pages, Total: listing.Total}
+	if len(listing.Intro) > 0 {
+		data.Intro = render.Markdown(listing.Section, "", listing.Intro)
+	}
+	for _, item := range listing.Items {
+		data.Articles = append(data.Articles, ListingItemData{Slug: item.Slug,
+			Title: item.Title, Url: item.Link, Meta: item.Meta,
+			Summary: render.PageMarkdown(listing.Section, item.Slug, content.Page{Meta: item.Meta, Body: item.Summary})})
+	}
+	if listing.Page > 1 {
+		data.Prev = listing.GetPageLink(listing.Page - 1)
+	}
+	if listing.Page < listing.Pages && listing.ShowListing {
+		data.Next = listing.GetPageLink(listing.Page + 1)
+	}
+	return data
+}
+
+/**
+ * Returns a template context holding the values shared by every page, to
+ * which handlers add their own
+ */
+func getTemplateContext(ctx *web.Context, conf *config.Config, menu content.Menu) map[string]interface{} {
+	tplContext := map[string]interface{}{"site": conf.Site, "menu": menu,
+		"contactFields": conf.Contact.Fields,
+		"newsletter":    len(conf.Newsletter.Provider) > 0 && len(conf.Newsletter.Secret) > 0,
+		"analytics":     render.GetAnalyticsSnippet(conf),
+		"popular":       getPopularPosts(conf),
+		"tagCloud":      getTagCloud(conf)}
+	addFeedLinks(tplContext, ctx, conf, "")
+	// Previews are not part of the installed site
+	if conf.Pwa.Enabled && !conf.Drafts {
+		tplContext["pwa"] = conf.Pwa
+	}
+	if hasForms(conf) {
+		addCsrfToken(ctx, tplContext)
+	}
+	return tplContext
+}
+
+/**
+ * Checks that the request comes from an account having the given role,
+ * logged in through /admin/login or sending its credentials with HTTP basic
+ * auth. Sends browsers to the login form when nobody is logged in, and
+ * returns false; administration is disabled while neither a password nor
+ * OAuth is configured
+ */
+func checkAdminAuth(ctx *web.Context, conf *config.Config, role string) (Account, bool) {
+	if !isAdminEnabled(conf) {
+		ctx.Abort(403, "Administration is disabled.")
+		return Account{}, false
+	}
+	account, ok := getRequestAccount(ctx, conf)
+	if ok && account.Can(role) {
+		return account, true
+	}
+	if ok {
+		ctx.Abort(403, "You are not allowed to do this.")
+		return account, false
+	}
+	if ctx.Request.Method == "GET" && negotiateFormat(ctx.Request.Header.Get("Accept")) == "html" {
+		ctx.Redirect(303, "/admin/login?"+url.Values{"next": {ctx.Request.URL.RequestURI()}}.Encode())
+		return account, false
+	}
+	ctx.SetHeader("WWW-Authenticate", "Basic realm=\"gosite\"", true)
+	ctx.Abort(401, "Unauthorized")
+	return account, false
+}
+
+/**
+ * Returns the absolute URL of the current request, on the BaseURL site config
+ * entry when set, so links given to search engines and social networks point
+ * to the public site whatever host the request was sent to
+ */
+func getRequestURL(ctx *web.Context, conf *config.Config) string {
+	return getRootURL(ctx, conf) + ctx.Request.URL.Path
+}
+
+/**
+ * Returns an error if the BaseURL site config entry is set to anything but an
+ * absolute http or https link, which would end up in every absolute link
+ */
+func checkBaseURL(conf *config.Config) error {
+	if len(conf.Site.BaseURL) == 0 {
+		return nil
+	}
+	u, err := url.Parse(conf.Site.BaseURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return errors.New("Site.BaseURL must be an absolute http or https link, e.g. https://example.com")
+	}
+	return nil
+}
+
+/**
+ * Returns the absolute URL of the site root, without a trailing slash. The
+ * BaseURL site config entry is used when set, otherwise the request host,
+ * followed by the PathPrefix the site is served under
+ */
+func getRootURL(ctx *web.Context, conf *config.Config) string {
+	if len(conf.Site.BaseURL) > 0 {
+		return content.GetSiteURL(conf)
+	}
+	scheme := "http"
+	if isSecureRequest(ctx.Request) {
+		scheme = "https"
+	}
+	return scheme + "://" + ctx.Request.Host + content.GetPathPrefix(conf)
+}
+
+/**
+ * Returns the response format preferred by the Accept header: "html", "json"
+ * or "markdown". Unknown or missing headers default to "html"
+ */
+func negotiateFormat(accept string) string {
+	formats := map[string]string{
+		"text/html":             "html",
+		"application/xhtml+xml": "html",
+		"*/*":                   "html",
+		"text/*":                "html",
+		"application/json":      "json",
+		"text/markdown":         "markdown",
+		"text/x-markdown":       "markdown",
+	}
+	format, best := "html", 0.0
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		f, ok := formats[strings.ToLower(strings.TrimSpace(params[0]))]
+		if !ok {
+			continue
+		}
+		q := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > best {
+			format, best = f, q
+		}
+	}
+	return format
+}
+
+/*
+ * Page handler, displays the requested page from a template and from Md files
+ */
+func handlePage(ctx *web.Context, section string, page string) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	return routePage(ctx, &conf, section, page)
+}
+
+/**
+ * Serves an article at its permalink, redirecting other links to it
+ */
+func routePage(ctx *web.Context, conf *config.Config, section string, page string) string {
+	section = content.FindSection(section, conf)
+	output, err := content.GetPublishedPage(section, page, conf)
+	if err != nil {
+		return renderNotFound(ctx, conf)
+	}
+	// Articles are served at their permalink only
+	if len(section) > 0 {
+		if link := content.GetArticleLink(section, page, conf); link != ctx.Request.URL.Path {
+			ctx.Redirect(301, link)
+			return ""
+		}
+	}
+	if output.IsProtected() && !conf.Drafts && !isUnlocked(ctx, conf, section, page, output) {
+		return renderPasswordPrompt(ctx, conf, section, page, output)
+	}
+	return renderArticle(ctx, conf, section, page, output, conf.Drafts)
+}
+
+/**
+ * Renders an article with the site template, or as JSON or markdown when
+ * asked for. Previews of unpublished articles neither count views nor take
+ * comments, and point search engines at the article permalink
+ */
+func renderArticle(ctx *web.Context, conf *config.Config, section string, page string, output content.Page, preview bool) string {
+	engine := render.GetEngine(conf)
+	menu, err := content.GetMenu(conf)
+	if err != nil {
+		ctx.Abort(501, "Could not load menu")
+		return ""
+	}
+	// Unlocked articles are for the visitor alone
+	if output.IsProtected() && !preview {
+		ctx.SetHeader("Cache-Control", "private, no-store", true)
+	} else if !preview {
+		setCacheHeaders(ctx.Header(), conf.CacheControl.Articles)
+	}
+	output = content.HidePassword(output)
+	body := render.PageMarkdown(section, page, output)
+	// Cross-posted articles point search engines at the original publication
+	canonical := output.Meta.Get("canonical")
+	if len(canonical) == 0 && preview {
+		canonical = getRootURL(ctx, conf) + content.GetArticleLink(section, page, conf)
+	} else if len(canonical) == 0 {
+		canonical = getRequestURL(ctx, conf)
+	}
+	robots := output.Robots()
+	if len(robots) > 0 {
+		ctx.SetHeader("X-Robots-Tag", robots, true)
+	}
+	ctx.SetHeader("Vary", "Accept", true)
+	switch negotiateFormat(ctx.Request.Header.Get("Accept")) {
+	case "json":
+		return apiResponse(ctx, 200, PageData{Section: section, Slug: page,
+			Meta: output.Meta, Canonical: canonical,
+			Markdown: output.Body, Content: body})
+	case "markdown":
+		ctx.SetHeader("Content-Type", "text/markdown; charset=utf-8", true)
+		return output.Body
+	}
+	current := menu.GetCurrent(section, conf)
+	if len(section) == 0 {
+		current.Title = content.GetPageTitle(output)
+	}
+	tplContext := getTemplateContext(ctx, conf, menu)
+	if conf.Views.Enabled && len(section) > 0 && !preview {
+		if !isBot(ctx.Request.UserAgent()) {
+			viewCounter.Record(section+"/"+page, conf)
+		}
+		tplContext["views"] = viewCounter.Count(section+"/"+page, conf)
+	}
+	tplContext["content"] = body
+	tplContext["meta"] = output.Meta
+	if updated := content.GetArticleUpdated(output); !updated.IsZero() {
+		tplContext["updated"] = updated
+	}
+	tplContext["description"] = content.GetPageDescription(output)
+	tplContext["keywords"] = strings.Join(output.Meta.List("keywords"), ", ")
+	tplContext["robots"] = robots
+	tplContext["headExtra"] = render.GetHeadExtra(output, conf)
+	tplContext["contactForm"] = output.Meta.Bool("contact")
+	addAuthorProfile(tplContext, output, conf)
+	tplContext["comments"] = render.GetCommentsEmbed(output, canonical, section+"/"+page, conf)
+	if conf.Comments.Provider == "native" && len(section) > 0 && !preview &&
+		!(output.Meta.Has("comments") && !output.Meta.Bool("comments")) {
+		tplContext["nativeComments"] = true
+		tplContext["commentList"], _ = getComments(section, page, true, conf)
+		tplContext["commentAction"] = "/" + section + "/" + page + "/comments"
+		tplContext["commentPending"] = ctx.Params["comment"] == "pending"
+	}
+	tplContext["currentMenu"] = current
+	tplContext["isHome"] = current.Link == content.GetLinkPrefix(conf)+"/"
+	tplContext["canonical"] = canonical
+	addFeedLinks(tplContext, ctx, conf, section)
+	tplContext["preview"] = preview
+	tplContext["amphtml"] = getAmpLink(ctx, conf, section, page, output, preview)
+	tplContext["oembed"] = "/oembed?url=" + url.QueryEscape(getRequestURL(ctx, conf))
+	root := getRootURL(ctx, conf)
+	og := render.GetArticleOpenGraph(output, canonical, root, conf)
+	tplContext["openGraph"] = og
+	tplContext["socialMeta"] = og.Html()
+	trail := []render.Breadcrumb{{Name: conf.Site.Title, Url: root + "/"}}
+	if len(section) > 0 {
+		trail = append(trail, render.Breadcrumb{Name: current.Title, Url: root + current.Link})
+	}
+	trail = append(trail, render.Breadcrumb{Name: og.Title, Url: getRequestURL(ctx, conf)})
+	tplContext["structuredData"] = render.GetStructuredData(
+		render.GetArticleSchema(output, og, conf), render.GetBreadcrumbList(trail))
+	response, err := engine.Render("template.html", tplContext)
+	if err != nil {
+		ctx.Abort(501, "")
+		return err.Error()
+	}
+	return servePage(ctx, response)
+}
+
+/**
+ * Handles request for a page of a section listing
+ */
+func handlePaginatedSection(ctx *web.Context, section string, page string) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	return routePaginatedSection(ctx, &conf, section, page)
+}
+
+/**
+ * Serves a page of a section listing. The first page is only served at the
+ * section URL, and other page numbers only in their canonical form, so each
+ * listing page has a single URL
+ */
+func routePaginatedSection(ctx *web.Context, conf *config.Config, section string, page string) string {
+	listing := content.Listing{Section: section, Prefix: content.GetLinkPrefix(conf)}
+	p, err := strconv.Atoi(page)
+	if err != nil || p <= 1 {
+		ctx.Redirect(301, listing.GetPageLink(1))
+		return ""
+	}
+	if strconv.Itoa(p) != page {
+		ctx.Redirect(301, listing.GetPageLink(p))
+		return ""
+	}
+	return renderSection(ctx, conf, section, p)
+}
+
+/**
+ * Renders a page of a section listing
+ */
+func renderSection(ctx *web.Context, conf *config.Config, section string, p int) string {
+	section = content.FindSection(section, conf)
+	engine := render.GetEngine(conf)
+	var body, output string
+	menu, err := content.GetMenu(conf)
+	if err != nil {
+		ctx.Abort(501, "Could not load menu")
+		return ""
+	}
+	current := menu.GetCurrent(section, conf)
+	ctx.SetHeader("Vary", "Accept", true)
+	if negotiateFormat(ctx.Request.Header.Get("Accept")) == "json" {
+		listing, err := content.GetListing(section, p, conf)
+		if err != nil {
+			return apiError(ctx, 404, "Page not found")
+		}
+		return apiResponse(ctx, 200, getListingData(listing, current.Title))
+	}
+	output, err = content.GetAbstracts(section, p, conf)
+	if err != nil {
+		return renderNotFound(ctx, conf)
+	}
+	if !conf.Drafts {
+		setCacheHeaders(ctx.Header(), conf.CacheControl.Listings)
+	}
+	body = render.Markdown(section, "", output)
+	tplContext := getTemplateContext(ctx, conf, menu)
+	tplContext["content"] = body
+	tplContext["currentMenu"] = current
+	tplContext["isHome"] = current.Link == content.GetLinkPrefix(conf)+"/"
+	tplContext["canonical"] = getRequestURL(ctx, conf)
+	tplContext["description"] = conf.Site.Description
+	addFeedLinks(tplContext, ctx, conf, section)
+	og := render.GetListingOpenGraph(conf.Site.Title+" - "+current.Title, getRequestURL(ctx, conf), conf)
+	tplContext["openGraph"] = og
+	tplContext["socialMeta"] = og.Html()
+	root := getRootURL(ctx, conf)
+	trail := []render.Breadcrumb{{Name: conf.Site.Title, Url: root + "/"}}
+	if current.Link != content.GetLinkPrefix(conf)+"/" {
+		trail = append(trail, render.Breadcrumb{Name: current.Title, Url: root + current.Link})
+	}
+	tplContext["structuredData"] = render.GetStructuredData(
+		render.GetWebSiteSchema(root, conf), render.GetBreadcrumbList(trail))
+	response, err := engine.Render("template.html", tplContext)
+	if err != nil {
+		ctx.Abort(501, "")
+		return err.Error()
+	}
+	return servePage(ctx, response)
+}
+
+/**
+ * Renders a markdown message, like the outcome of a form submission, with the
+ * site template. Extra values are added to the template context
+ */
+func renderMessage(ctx *web.Context, conf *config.Config, title string, message string, extra map[string]interface{}) string {
+	menu, err := content.GetMenu(conf)
+	if err != nil {
+		ctx.Abort(501, "Could not load menu")
+		return ""
+	}
+	tplContext := getTemplateContext(ctx, conf, menu)
+	tplContext["content"] = render.Markdown("", "", message)
+	tplContext["currentMenu"] = config.MenuItem{Title: title, Link: ctx.Request.URL.Path}
+	tplContext["isHome"] = false
+	for key, value := range extra {
+		tplContext[key] = value
+	}
+	response, err := render.GetEngine(conf).Render("template.html", tplContext)
+	if err != nil {
+		ctx.Abort(501, "")
+		return err.Error()
+	}
+	return servePage(ctx, response)
+}
+
+/**
+ * Handles the permalinks of the articles when the Permalink config entry is
+ * not the default one
+ */
+func handlePermalink(ctx *web.Context, path string) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	return routePermalink(ctx, &conf, path)
+}
+
+/**
+ * Serves the article a permalink points to. Paths which are not the
+ * permalink of an article are served as section listings and pages
+ */
+func routePermalink(ctx *web.Context, conf *config.Config, path string) string {
+	if section, slug, err := content.ResolvePermalink(path, conf); err == nil {
+		return routePage(ctx, conf, section, slug)
+	}
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) == 1 {
+		return routeSection(ctx, conf, parts[0])
+	}
+	if len(parts) == 2 {
+		if _, err := strconv.Atoi(parts[1]); err == nil {
+			return routePaginatedSection(ctx, conf, parts[0], parts[1])
+		}
+		return routePage(ctx, conf, parts[0], parts[1])
+	}
+	return renderNotFound(ctx, conf)
+}
+
+/**
+ * Renders the page shown for missing pages and out of range listing pages,
+ * with a 404 status
+ */
+func renderNotFound(ctx *web.Context, conf *config.Config) string {
+	ctx.WriteHeader(404)
+	return renderMessage(ctx, conf, "Page not found", "Sorry, the page you are looking for does not exist.", nil)
+}
+
+// Wrapper for handling paginated section when no section is given
+func handleSection(ctx *web.Context, section string) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	return routeSection(ctx, &conf, section)
+}
+
+/**
+ * Serves the first page of a section listing. The homepage shows either
+ * content/index.md or the home section
+ */
+func routeSection(ctx *web.Context, conf *config.Config, section string) string {
+	if len(section) == 0 {
+		menu, err := content.GetMenu(conf)
+		if err != nil {
+			ctx.Abort(501, "Could not load menu")
+			return ""
+		}
+		home := content.GetHomeSection(menu, conf)
+		if len(home) == 0 {
+			if content.HasHomePage(conf) {
+				return routePage(ctx, conf, "", "index")
+			}
+			ctx.Abort(404, "Page not found.")
+			return ""
+		}
+		return renderSection(ctx, conf, home, 1)
+	}
+	return renderSection(ctx, conf, section, 1)
+}
+
+/**
+ * Returns the handler serving a site: the routes of the site wrapped in the
+ * middlewares of the config. Programs embedding gosite can serve it with
+ * net/http, or mount it under their own router
+ */
+func New(conf *config.Config) (http.Handler, error) {
+	if err := content.CheckPermalink(conf); err != nil {
+		return nil, err
+	}
+	if err := checkBaseURL(conf); err != nil {
+		return nil, err
+	}
+	server := web.NewServer()
+	for _, redirect := range conf.Redirects {
+		server.Get(regexp.QuoteMeta(redirect.From), getRedirectHandler(redirect))
+	}
+	if config.Profile {
+		server.Get(`/debug/pprof/([a-z0-9_]*)`, handleProfile)
+	}
+	server.Get("/oembed", handleOEmbed)
+	server.Post("/contact", handleContact)
+	server.Post("/subscribe", handleSubscribe)
+	server.Get("/feed.xml", handleFeed)
+	server.Get("/search", handleSearch)
+	server.Get("/popular", handlePopular)
+	server.Get("/random", handleRandom)
+	if conf.Search.ClientIndex {
+		server.Get(`/search-index\.json`, handleSearchIndex)
+	}
+	if conf.Export.Netlify {
+		server.Get("/_redirects", handleNetlifyRedirects)
+		server.Get("/_headers", handleNetlifyHeaders)
+	}
+	if conf.Sitemap.Enabled {
+		server.Get(`/sitemap\.xml`, handleSitemap)
+		server.Get(`/sitemap-([0-9]+)\.xml`, handleSitemapPart)
+	}
+	if conf.Pwa.Enabled {
+		server.Get(`/manifest\.webmanifest`, handleWebManifest)
+		server.Get(`/sw\.js`, handleServiceWorker)
+	}
+	if len(conf.Ping.IndexNowKey) > 0 {
+		server.Get("/"+regexp.QuoteMeta(conf.Ping.IndexNowKey)+`\.txt`, handleIndexNowKey)
+	}
+	server.Get(`/([\pL\pN_-]+)/feed.xml`, handleSectionFeed)
+	server.Get(`/authors/([\pL\pN-]+)`, handleAuthor)
+	server.Get("/media/(.+)", handleMedia)
+	server.Get("/thumbs/([0-9]+)/(.+)", handleThumbnail)
+	server.Post(`/unlock/([\pL\pN_-]+)/(\pL[\pL\pN-]*)`, handleUnlock)
+	server.Get("/subscribe/confirm", handleConfirmSubscription)
+	server.Get("/admin/login", handleAdminLogin)
+	server.Post("/admin/login", handleAdminLogin)
+	server.Post("/admin/logout", handleAdminLogout)
+	server.Get("/admin/oauth", handleOAuthLogin)
+	server.Get("/admin/oauth/callback", handleOAuthCallback)
+	server.Get("/admin", handleAdmin)
+	server.Post("/admin/new", handleAdminNew)
+	server.Get(`/admin/edit/([\pL\pN_-]+)/(_?\pL[\pL\pN-]*)`, handleAdminEdit)
+	server.Post(`/admin/edit/([\pL\pN_-]+)/(_?\pL[\pL\pN-]*)`, handleAdminSave)
+	server.Get(`/admin/history/([\pL\pN_-]+)/(_?\pL[\pL\pN-]*)`, handleAdminHistory)
+	server.Get(`/admin/history/([\pL\pN_-]+)/(_?\pL[\pL\pN-]*)/diff`, handleAdminDiff)
+	server.Get(`/admin/history/([\pL\pN_-]+)/(_?\pL[\pL\pN-]*)/([0-9a-f]+)`, handleAdminRevision)
+	server.Post(`/admin/history/([\pL\pN_-]+)/(_?\pL[\pL\pN-]*)/([0-9a-f]+)`, handleAdminRevision)
+	server.Post(`/admin/delete/([\pL\pN_-]+)/(_?\pL[\pL\pN-]*)`, handleAdminDelete)
+	server.Get("/admin/trash", handleAdminTrash)
+	server.Post(`/admin/upload/([\pL\pN_-]+)`, handleAdminUpload)
+	server.Get("/admin/media", handleAdminMedia)
+	server.Post("/admin/media/(delete|rename)", handleAdminMediaAction)
+	server.Post("/admin/trash/([0-9]+)/(restore|purge)", handleAdminTrashAction)
+	server.Get("/admin/comments", handleCommentQueue)
+	server.Get("/admin/notfound", handleNotFoundReport)
+	server.Get("/api/v1/articles", handleApiPublishedArticles)
+	server.Get("/api/v1/sections", handleApiSections)
+	server.Get("/api/v1/notfound", handleApiNotFound)
+	server.Get(`/api/v1/sections/([\pL\pN_-]+)/articles`, handleApiArticles)
+	server.Post(`/api/v1/sections/([\pL\pN_-]+)/articles`, handleApiCreateArticle)
+	server.Get(`/api/v1/sections/([\pL\pN_-]+)/articles/(_?\pL[\pL\pN-]*)`, handleApiGetArticle)
+	server.Put(`/api/v1/sections/([\pL\pN_-]+)/articles/(_?\pL[\pL\pN-]*)`, handleApiPutArticle)
+	server.Delete(`/api/v1/sections/([\pL\pN_-]+)/articles/(_?\pL[\pL\pN-]*)`, handleApiDeleteArticle)
+	server.Get(`/api/v1/sections/([\pL\pN_-]+)/articles/(_?\pL[\pL\pN-]*)/revisions`, handleApiRevisions)
+	server.Get(`/api/v1/sections/([\pL\pN_-]+)/articles/(_?\pL[\pL\pN-]*)/revisions/diff`, handleApiRevisionDiff)
+	server.Get(`/api/v1/sections/([\pL\pN_-]+)/articles/(_?\pL[\pL\pN-]*)/revisions/([0-9a-f]+)`, handleApiGetRevision)
+	server.Post(`/api/v1/sections/([\pL\pN_-]+)/articles/(_?\pL[\pL\pN-]*)/revisions/([0-9a-f]+)/restore`, handleApiRestoreRevision)
+	server.Post(`/api/v1/sections/([\pL\pN_-]+)/media`, handleApiUpload)
+	server.Get("/api/v1/media", handleApiMedia)
+	server.Post("/api/v1/media/rename", handleApiRenameMedia)
+	server.Delete("/api/v1/media/(.+)", handleApiDeleteMedia)
+	server.Get("/api/v1/trash", handleApiTrash)
+	server.Post("/api/v1/trash/([0-9]+)/restore", handleApiRestoreTrash)
+	server.Delete("/api/v1/trash/([0-9]+)", handleApiPurgeTrash)
+	server.Get("/graphql", handleGraphql)
+	server.Post("/graphql", handleGraphql)
+	server.Get(`/api/content/([\pL\pN_-]+)`, handleContentListing)
+	server.Get(`/api/content/([\pL\pN_-]+)/(\pL[\pL\pN-]*)`, handleContentPage)
+	server.Get(`/([\pL\pN_-]+)/page/([0-9]+)\.json`, handleListingCards)
+	server.Post(`/admin/comments/([\pL\pN_-]+)/(\pL[\pL\pN-]*)/([0-9a-f]+)/(approve|delete)`, handleModerateComment)
+	server.Post(`/([\pL\pN_-]+)/(\pL[\pL\pN-]*)/comments`, handlePostComment)
+	if config.Embedded != nil {
+		// Static files are served by the web package from the static folder on
+		// disk when present, and from the binary otherwise
+		static, _ := fs.Sub(config.Embedded, "static")
+		server.Handler("/(css|js|fonts|img)/.*", "GET", http.FileServer(http.FS(static)))
+	}
+	// The preview environment mirrors the site routes under /preview
+	if len(conf.Permalink) > 0 && conf.Permalink != content.DefaultPermalink {
+		server.Get("/preview"+content.GetPermalinkRoute(conf), handlePreviewPermalink)
+	}
+	server.Get(`/preview(?:/([\pL\pN_-]*))?`, handlePreviewSection)
+	server.Get(`/preview/([\pL\pN_-]+)/([0-9]+)`, handlePreviewPaginatedSection)
+	server.Get(`/preview/([\pL\pN_-]+)/(_?\pL[\pL\pN-]*)`, handlePreview)
+	if len(conf.Permalink) > 0 && conf.Permalink != content.DefaultPermalink {
+		server.Get(content.GetPermalinkRoute(conf), handlePermalink)
+	}
+	if conf.Amp.Enabled {
+		server.Get(content.GetPermalinkRoute(conf)+"/amp", handleAmp)
+	}
+	server.Get(`/([\pL\pN_-]*)`, handleSection)
+	server.Get(`/([\pL\pN_-]+)/([0-9]+)`, handlePaginatedSection)
+	server.Get(`/([\pL\pN_-]+)/(\pL[\pL\pN-]*)`, handlePage)
+	middlewareLogger = server.Logger
+	handler, err := getMiddlewareChain(addETags(addErrorReferences(trackNotFound(prefixLinks(server, conf), conf))), conf)
+	if err != nil {
+		return nil, err
+	}
+	handler = addSecurityHeaders(addCacheHeaders(handler, conf), conf)
+	return addProxyHeaders(stripPathPrefix(addRequestID(handler), conf), conf), nil
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/**
+ * Writes a site to a temporary folder and returns the handler serving it. The
+ * config starts from the defaults, with the content and template folders of
+ * the site, and may be changed by setup. Files are named relative to the site
+ * folder, and a bare template.html is added when the site has none
+ */
+func newTestSite(t *testing.T, setup func(conf *config.Config), files map[string]string) http.Handler {
+	dir := t.TempDir()
+	conf := config.Default()
+	conf.ContentFolder = filepath.Join(dir, "content")
+	conf.TemplateFolder = filepath.Join(dir, "template")
+	conf.Middleware = nil
+	if setup != nil {
+		setup(&conf)
+	}
+	if _, ok := files["template/template.html"]; !ok {
+		files["template/template.html"] = "{{ content }}"
+	}
+	bs, err := json.Marshal(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files["config.json"] = string(bs)
+	for name, data := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err = os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	previous := config.File
+	config.File = filepath.Join(dir, "config.json")
+	t.Cleanup(func() { config.File = previous })
+	handler, err := New(&conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+/**
+ * Sends a GET request accepting the given type to a test site, with HTTP
+ * basic auth when a user is given, and returns the response
+ */
+func getTestPage(handler http.Handler, path string, accept string, user string, password string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", path, nil)
+	r.Header.Set("Accept", accept)
+	if len(user) > 0 {
+		r.SetBasicAuth(user, password)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w
+}
+
+// Front matter date of the day after the test runs
+func tomorrow() string {
+	return time.Now().AddDate(0, 0, 1).Format("2006-01-02")
+}
+
+/**
+ * Sends a GET request for JSON to a test site as the admin account, decodes
+ * the response into value when it succeeds and returns its status
+ */
+func getTestJSON(t *testing.T, handler http.Handler, path string, value interface{}) int {
+	w := getTestPage(handler, path, "application/json", "admin", "secret")
+	if w.Code == 200 {
+		if err := json.Unmarshal(w.Body.Bytes(), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code
+}
+
+/**
+ * Returns the context handlers get for a request, recording their response
+ */
+func newTestContext(r *http.Request) (*web.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	r.ParseForm()
+	params := make(map[string]string)
+	for name, values := range r.Form {
+		params[name] = values[0]
+	}
+	return &web.Context{Request: r, Params: params, ResponseWriter: w}, w
+}
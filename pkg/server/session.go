@@ -0,0 +1,124 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Name of the session cookie
+const sessionCookie = "gosite_session"
+
+// Struct representing the session of a visitor, kept in a signed cookie
+type Session struct {
+	Values  map[string]string `json:"v"`
+	Expires int64             `json:"e"`
+}
+
+// Secret signing the sessions while no Session.Secret is configured, so
+// sessions only last until the server restarts
+var processSecret struct {
+	sync.Once
+	key []byte
+}
+
+/**
+ * Returns the key signing the sessions
+ */
+func getSessionSecret(conf *config.Config) []byte {
+	if len(conf.Session.Secret) > 0 {
+		return []byte(conf.Session.Secret)
+	}
+	processSecret.Do(func() {
+		processSecret.key = make([]byte, 32)
+		rand.Read(processSecret.key)
+	})
+	return processSecret.key
+}
+
+/**
+ * Returns the time sessions last after they were last changed, Session.Hours
+ * or a week by default
+ */
+func getSessionDuration(conf *config.Config) time.Duration {
+	hours := conf.Session.Hours
+	if hours <= 0 {
+		hours = 168
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+/**
+ * Returns the signature of a value with the session secret. Sessions keep
+ * signatures of the credentials they were opened with rather than the
+ * credentials themselves, so changing a password closes them
+ */
+func getSessionMac(value string, conf *config.Config) string {
+	mac := hmac.New(sha256.New, getSessionSecret(conf))
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+/**
+ * Returns the session of the request, or an empty session when its cookie is
+ * missing, tampered with or expired
+ */
+func GetSession(ctx *web.Context, conf *config.Config) Session {
+	session := Session{Values: make(map[string]string)}
+	cookie, err := ctx.Request.Cookie(sessionCookie)
+	if err != nil {
+		return session
+	}
+	parts := strings.SplitN(cookie.Value, ".", 2)
+	if len(parts) != 2 || !hmac.Equal([]byte(getSessionMac(parts[0], conf)), []byte(parts[1])) {
+		return session
+	}
+	bs, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return session
+	}
+	var stored Session
+	if json.Unmarshal(bs, &stored) != nil || time.Now().Unix() > stored.Expires || stored.Values == nil {
+		return session
+	}
+	return stored
+}
+
+/**
+ * Sends the session back to the visitor, extending it by the session
+ * duration. Empty sessions delete the cookie
+ */
+func (s Session) Save(ctx *web.Context, conf *config.Config) error {
+	cookie := &http.Cookie{
+		Name:     sessionCookie,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   isSecureRequest(ctx.Request),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if len(s.Values) == 0 {
+		cookie.MaxAge = -1
+		http.SetCookie(ctx, cookie)
+		return nil
+	}
+	expires := time.Now().Add(getSessionDuration(conf))
+	s.Expires = expires.Unix()
+	bs, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(bs)
+	cookie.Value = payload + "." + getSessionMac(payload, conf)
+	cookie.Expires = expires
+	http.SetCookie(ctx, cookie)
+	return nil
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+/**
+ * Returns the session a request holding the given session cookie gets
+ */
+func getTestSession(value string, conf *config.Config) Session {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookie, Value: value})
+	ctx, _ := newTestContext(r)
+	return GetSession(ctx, conf)
+}
+
+/**
+ * Returns the value of the session cookie set by a response
+ */
+func getSessionCookie(w *httptest.ResponseRecorder) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == sessionCookie {
+			return cookie
+		}
+	}
+	return nil
+}
+
+func TestSessionSigning(t *testing.T) {
+	conf := config.Default()
+	conf.Session.Secret = "session-secret"
+	ctx, w := newTestContext(httptest.NewRequest("GET", "/", nil))
+	if err := (Session{Values: map[string]string{"user": "admin"}}).Save(ctx, &conf); err != nil {
+		t.Fatal(err)
+	}
+	cookie := getSessionCookie(w)
+	if cookie == nil || !cookie.HttpOnly {
+		t.Fatalf("session saved as %v, want an HttpOnly cookie", cookie)
+	}
+	if session := getTestSession(cookie.Value, &conf); session.Values["user"] != "admin" {
+		t.Errorf("saved session read back as %v", session.Values)
+	}
+
+	// A visitor rewriting the values cannot sign them
+	parts := strings.SplitN(cookie.Value, ".", 2)
+	forged := base64.RawURLEncoding.EncodeToString([]byte(`{"v":{"user":"root"},"e":9999999999}`))
+	for _, value := range []string{forged + "." + parts[1], parts[0] + ".00", parts[0], ""} {
+		if session := getTestSession(value, &conf); len(session.Values) > 0 {
+			t.Errorf("tampered session %q read as %v", value, session.Values)
+		}
+	}
+	other := conf
+	other.Session.Secret = "other-secret"
+	if session := getTestSession(cookie.Value, &other); len(session.Values) > 0 {
+		t.Error("session read with another secret")
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	conf := config.Default()
+	conf.Session.Secret = "session-secret"
+	bs, _ := json.Marshal(Session{Values: map[string]string{"user": "admin"}, Expires: time.Now().Add(-time.Minute).Unix()})
+	payload := base64.RawURLEncoding.EncodeToString(bs)
+	if session := getTestSession(payload+"."+getSessionMac(payload, &conf), &conf); len(session.Values) > 0 {
+		t.Errorf("expired session read as %v", session.Values)
+	}
+
+	// Empty sessions delete the cookie
+	ctx, w := newTestContext(httptest.NewRequest("GET", "/", nil))
+	(Session{}).Save(ctx, &conf)
+	if cookie := getSessionCookie(w); cookie == nil || cookie.MaxAge >= 0 {
+		t.Errorf("empty session saved as %v, want the cookie deleted", cookie)
+	}
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"encoding/xml"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Namespace of the sitemap protocol
+const sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+// Most links a sitemap may hold
+const maxSitemapUrls = 50000
+
+// Struct representing a link of a sitemap
+type sitemapUrl struct {
+	XMLName xml.Name `xml:"url"`
+	Loc     string   `xml:"loc"`
+	LastMod string   `xml:"lastmod,omitempty"`
+}
+
+// Struct representing a sitemap of a sitemap index
+type sitemapEntry struct {
+	XMLName xml.Name `xml:"sitemap"`
+	Loc     string   `xml:"loc"`
+	LastMod string   `xml:"lastmod,omitempty"`
+}
+
+// Struct representing a page of the site listed in the sitemap, with the
+// last time it changed
+type sitemapPage struct {
+	Link     string
+	Modified time.Time
+}
+
+/**
+ * Returns the pages listed in the sitemap: the homepage, the listing of the
+ * sections and the published articles, newest first, but for the articles
+ * kept out of search engines. Articles changed when their "updated" front
+ * matter key says so, or else when their file did. Listings change with their
+ * newest article, and the homepage with the newest of all
+ */
+func getSitemapPages(conf *config.Config) ([]sitemapPage, error) {
+	articles, err := getGqlArticles(conf)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(articles, func(i, j int) bool {
+		return articles[i].Date.After(articles[j].Date)
+	})
+	home := sitemapPage{Link: "/"}
+	var sections, pages []sitemapPage
+	index := make(map[string]int)
+	for _, a := range articles {
+		if a.Page.IsNoIndex() {
+			continue
+		}
+		modified := a.Modified
+		if updated := content.GetArticleUpdated(a.Page); !updated.IsZero() {
+			modified = updated
+		}
+		if modified.After(home.Modified) {
+			home.Modified = modified
+		}
+		i, ok := index[a.Section]
+		if !ok {
+			i = len(sections)
+			index[a.Section] = i
+			sections = append(sections, sitemapPage{
+				Link: content.Listing{Section: a.Section, Prefix: content.GetLinkPrefix(conf)}.GetPageLink(1)})
+		}
+		if modified.After(sections[i].Modified) {
+			sections[i].Modified = modified
+		}
+		pages = append(pages, sitemapPage{Link: a.Link, Modified: modified})
+	}
+	return append(append([]sitemapPage{home}, sections...), pages...), nil
+}
+
+/**
+ * Returns the most links a sitemap of the site holds, the Sitemap.MaxUrls
+ * config value within the limit of the protocol
+ */
+func getSitemapSize(conf *config.Config) int {
+	if conf.Sitemap.MaxUrls <= 0 || conf.Sitemap.MaxUrls > maxSitemapUrls {
+		return maxSitemapUrls
+	}
+	return conf.Sitemap.MaxUrls
+}
+
+/**
+ * Returns a time in the format of the sitemaps, empty when unknown
+ */
+func formatSitemapTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+/**
+ * Writes a sitemap or sitemap index, one element at a time, so large sites
+ * are not held in memory as a whole
+ */
+func writeSitemap(w io.Writer, root string, count int, element func(i int) interface{}) error {
+	if _, err := io.WriteString(w, xml.Header+"<"+root+" xmlns=\""+sitemapNamespace+"\">\n"); err != nil {
+		return err
+	}
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("  ", "  ")
+	for i := 0; i < count; i++ {
+		if err := encoder.Encode(element(i)); err != nil {
+			return err
+		}
+	}
+	if err := encoder.Flush(); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n</"+root+">\n")
+	return err
+}
+
+/**
+ * Serves the sitemap of the site. Past Sitemap.MaxUrls links, it is a sitemap
+ * index of the /sitemap-<n>.xml files, holding the links in order
+ */
+func handleSitemap(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	pages, err := getSitemapPages(&conf)
+	if err != nil {
+		ctx.Abort(501, "Could not load articles")
+		return ""
+	}
+	root, size := getRootURL(ctx, &conf), getSitemapSize(&conf)
+	ctx.SetHeader("Content-Type", "application/xml; charset=utf-8", true)
+	if len(pages) <= size {
+		writeSitemap(ctx, "urlset", len(pages), func(i int) interface{} {
+			return sitemapUrl{Loc: root + pages[i].Link, LastMod: formatSitemapTime(pages[i].Modified)}
+		})
+		return ""
+	}
+	parts := (len(pages) + size - 1) / size
+	writeSitemap(ctx, "sitemapindex", parts, func(i int) interface{} {
+		var modified time.Time
+		for _, p := range pages[i*size : minInt((i+1)*size, len(pages))] {
+			if p.Modified.After(modified) {
+				modified = p.Modified
+			}
+		}
+		return sitemapEntry{Loc: root + "/sitemap-" + strconv.Itoa(i+1) + ".xml", LastMod: formatSitemapTime(modified)}
+	})
+	return ""
+}
+
+/**
+ * Serves a sitemap of the sitemap index, missing when the site fits in a
+ * single sitemap
+ */
+func handleSitemapPart(ctx *web.Context, part string) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	pages, err := getSitemapPages(&conf)
+	if err != nil {
+		ctx.Abort(501, "Could not load articles")
+		return ""
+	}
+	size := getSitemapSize(&conf)
+	n, err := strconv.Atoi(part)
+	if err != nil || n < 1 || len(pages) <= size || (n-1)*size >= len(pages) {
+		return renderNotFound(ctx, &conf)
+	}
+	root := getRootURL(ctx, &conf)
+	pages = pages[(n-1)*size : minInt(n*size, len(pages))]
+	ctx.SetHeader("Content-Type", "application/xml; charset=utf-8", true)
+	writeSitemap(ctx, "urlset", len(pages), func(i int) interface{} {
+		return sitemapUrl{Loc: root + pages[i].Link, LastMod: formatSitemapTime(pages[i].Modified)}
+	})
+	return ""
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Struct representing a tag of the tag cloud, with the number of published
+// articles having it and its Weight, from 1 for the least used tags to the
+// TagCloud.Buckets for the most used ones
+type TagCloudItem struct {
+	Tag, Slug     string
+	Count, Weight int
+}
+
+/**
+ * Returns the tags of the published articles
+ */
+func getPublishedTags(conf *config.Config) [][]string {
+	var tags [][]string
+	if content.SiteIndex != nil {
+		published, _ := content.SiteIndex.Published()
+		for _, a := range published {
+			tags = append(tags, a.Tags)
+		}
+		return tags
+	}
+	articles, _ := getGqlArticles(conf)
+	for _, a := range articles {
+		tags = append(tags, a.Page.Meta.List("tags"))
+	}
+	return tags
+}
+
+/**
+ * Returns the tag cloud of the site: the TagCloud.Max most used tags, all of
+ * them when zero, sorted by name. Tags differing only by case are counted
+ * together, under their first spelling. Weights grow with the logarithm of
+ * the counts, so a few popular tags do not flatten the others
+ */
+func getTagCloud(conf *config.Config) []TagCloudItem {
+	cloud := []TagCloudItem{}
+	index := make(map[string]int)
+	for _, tags := range getPublishedTags(conf) {
+		seen := make(map[string]bool)
+		for _, tag := range tags {
+			tag = strings.TrimSpace(tag)
+			key := strings.ToLower(tag)
+			if len(tag) == 0 || seen[key] {
+				continue
+			}
+			seen[key] = true
+			if i, ok := index[key]; ok {
+				cloud[i].Count++
+			} else {
+				index[key] = len(cloud)
+				cloud = append(cloud, TagCloudItem{Tag: tag, Slug: content.Slugify(tag), Count: 1})
+			}
+		}
+	}
+	sort.SliceStable(cloud, func(i, j int) bool {
+		return cloud[i].Count > cloud[j].Count
+	})
+	if conf.TagCloud.Max > 0 && len(cloud) > conf.TagCloud.Max {
+		cloud = cloud[:conf.TagCloud.Max]
+	}
+	buckets := conf.TagCloud.Buckets
+	if buckets <= 0 {
+		buckets = 5
+	}
+	if len(cloud) > 0 {
+		low, high := math.Log(float64(cloud[len(cloud)-1].Count)), math.Log(float64(cloud[0].Count))
+		for i := range cloud {
+			cloud[i].Weight = 1
+			if high > low {
+				cloud[i].Weight += int(math.Round((math.Log(float64(cloud[i].Count)) - low) / (high - low) * float64(buckets-1)))
+			}
+		}
+	}
+	sort.SliceStable(cloud, func(i, j int) bool {
+		return strings.ToLower(cloud[i].Tag) < strings.ToLower(cloud[j].Tag)
+	})
+	return cloud
+}
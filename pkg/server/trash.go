@@ -0,0 +1,316 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Struct representing a deleted article kept in the trash
+type TrashItem struct {
+	Id        string    `json:"id"`
+	Section   string    `json:"section"`
+	Slug      string    `json:"slug"`
+	Title     string    `json:"title"`
+	Deleted   time.Time `json:"deleted"`
+	DeletedBy string    `json:"deletedBy"`
+	// Markdown file of the article, front matter included
+	Content string `json:"content,omitempty"`
+}
+
+// Valid trash item ids
+var trashPattern = regexp.MustCompile("^[0-9]+$")
+
+/**
+ * Returns the store holding the trash, a JSON file per deleted article
+ */
+func getTrashStore(conf *config.Config) content.Store {
+	folder := conf.Trash.Folder
+	if len(folder) == 0 {
+		folder = "trash"
+	}
+	return content.OpenDataStore(folder)
+}
+
+/**
+ * Returns the deleted articles, most recently deleted first, without their
+ * content. Items older than Trash.Days are purged on the way
+ */
+func getTrash(conf *config.Config) ([]TrashItem, error) {
+	items := []TrashItem{}
+	store := getTrashStore(conf)
+	files, err := store.ReadDir("")
+	if err != nil && !os.IsNotExist(err) {
+		return items, err
+	}
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), ".json") {
+			continue
+		}
+		item, err := getTrashItem(strings.TrimSuffix(fi.Name(), ".json"), conf)
+		if err != nil {
+			continue
+		}
+		if conf.Trash.Days > 0 && time.Since(item.Deleted) > time.Duration(conf.Trash.Days)*24*time.Hour {
+			store.Remove(fi.Name())
+			continue
+		}
+		item.Content = ""
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Deleted.After(items[j].Deleted)
+	})
+	return items, nil
+}
+
+/**
+ * Returns a deleted article with its content
+ */
+func getTrashItem(id string, conf *config.Config) (TrashItem, error) {
+	var item TrashItem
+	if !trashPattern.MatchString(id) {
+		return item, os.ErrNotExist
+	}
+	bs, err := getTrashStore(conf).ReadFile(id + ".json")
+	if err != nil {
+		return item, err
+	}
+	err = json.Unmarshal(bs, &item)
+	return item, err
+}
+
+/**
+ * Moves an article to the trash, noting who deleted it and when
+ */
+func trashArticle(ctx *web.Context, conf *config.Config, section string, slug string) error {
+	store := content.GetContentStore(conf)
+	name := content.GetArticleName(section, slug)
+	bs, err := store.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	meta, body := content.ParseFrontMatter(string(bs))
+	now := time.Now()
+	item := TrashItem{Id: strconv.FormatInt(now.UnixNano(), 10), Section: section, Slug: slug,
+		Title: content.GetPageTitle(content.Page{Meta: meta, Body: body}), Deleted: now, DeletedBy: "api",
+		Content: string(bs)}
+	if account, ok := getRequestAccount(ctx, conf); ok {
+		item.DeletedBy = account.Name
+	}
+	data, err := json.MarshalIndent(item, "", "    ")
+	if err != nil {
+		return err
+	}
+	if err = getTrashStore(conf).WriteFile(item.Id+".json", data); err != nil {
+		return err
+	}
+	return store.Remove(name)
+}
+
+/**
+ * Puts a deleted article back where it was. Fails with os.ErrExist when an
+ * article was created there since
+ */
+func restoreTrashItem(id string, conf *config.Config) (TrashItem, error) {
+	item, err := getTrashItem(id, conf)
+	if err != nil {
+		return item, err
+	}
+	store := content.GetContentStore(conf)
+	name := content.GetArticleName(item.Section, item.Slug)
+	if _, err = store.Stat(name); err == nil {
+		return item, os.ErrExist
+	}
+	if err = store.WriteFile(name, []byte(item.Content)); err != nil {
+		return item, err
+	}
+	return item, getTrashStore(conf).Remove(id + ".json")
+}
+
+/**
+ * Deletes an article from the trash for good
+ */
+func purgeTrashItem(id string, conf *config.Config) error {
+	if !trashPattern.MatchString(id) {
+		return os.ErrNotExist
+	}
+	return getTrashStore(conf).Remove(id + ".json")
+}
+
+// Trash of the admin area
+const adminTrashBody = `{{ define "body" }}
+{{ if not .Items }}<p>The trash is empty.</p>{{ end }}
+<table class="table">
+  {{ range .Items }}
+  <tr>
+    <td>{{ .Section }}/{{ .Slug }}</td>
+    <td>{{ .Title }}</td>
+    <td>{{ .Deleted.Format "2006-01-02 15:04" }} by {{ .DeletedBy }}</td>
+    <td>
+      <form class="form-inline" method="post" action="/admin/trash/{{ .Id }}/restore">
+        {{ csrfField }}
+        <button class="btn btn-default" type="submit">Restore</button>
+      </form>
+    </td>
+    <td>
+      <form class="form-inline" method="post" action="/admin/trash/{{ .Id }}/purge">
+        {{ csrfField }}
+        <button class="btn btn-danger" type="submit">Delete for good</button>
+      </form>
+    </td>
+  </tr>
+  {{ end }}
+</table>
+{{ end }}`
+
+/**
+ * Moves an article to the trash from the admin editor
+ */
+func handleAdminDelete(ctx *web.Context, section string, slug string) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	if _, ok := checkAdminAuth(ctx, &conf, RoleEditor); !ok {
+		return ""
+	}
+	if !checkCsrf(ctx) {
+		ctx.Abort(403, "Invalid or missing CSRF token.")
+		return ""
+	}
+	if err = trashArticle(ctx, &conf, section, slug); os.IsNotExist(err) {
+		ctx.Abort(404, "Page not found.")
+		return ""
+	} else if err != nil {
+		ctx.Abort(500, "Could not delete article")
+		return ""
+	}
+	contentChanged(ctx, &conf, section, slug, "Delete")
+	ctx.Redirect(303, "/admin/trash")
+	return ""
+}
+
+/**
+ * Lists the deleted articles in the admin area
+ */
+func handleAdminTrash(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	if _, ok := checkAdminAuth(ctx, &conf, RoleEditor); !ok {
+		return ""
+	}
+	items, err := getTrash(&conf)
+	if err != nil {
+		ctx.Abort(500, "Could not read the trash")
+		return ""
+	}
+	response, err := renderAdmin(ctx, adminTrashBody, map[string]interface{}{"Title": "Trash", "Items": items})
+	if err != nil {
+		ctx.Abort(500, err.Error())
+		return ""
+	}
+	return response
+}
+
+/**
+ * Restores or purges a deleted article from the admin area
+ */
+func handleAdminTrashAction(ctx *web.Context, id string, action string) string {
+	conf, err := config.Load()
+	if err != nil {
+		ctx.Abort(500, "Configuration error.")
+		return ""
+	}
+	if _, ok := checkAdminAuth(ctx, &conf, RoleEditor); !ok {
+		return ""
+	}
+	if !checkCsrf(ctx) {
+		ctx.Abort(403, "Invalid or missing CSRF token.")
+		return ""
+	}
+	if action == "purge" {
+		if err = purgeTrashItem(id, &conf); err != nil {
+			ctx.Abort(404, "Not found in the trash.")
+			return ""
+		}
+		ctx.Redirect(303, "/admin/trash")
+		return ""
+	}
+	item, err := restoreTrashItem(id, &conf)
+	if os.IsExist(err) {
+		ctx.Abort(409, "Article already exists.")
+		return ""
+	} else if os.IsNotExist(err) {
+		ctx.Abort(404, "Not found in the trash.")
+		return ""
+	} else if err != nil {
+		ctx.Abort(500, "Could not restore article")
+		return ""
+	}
+	contentChanged(ctx, &conf, item.Section, item.Slug, "Restore")
+	ctx.Redirect(303, "/admin/edit/"+item.Section+"/"+item.Slug)
+	return ""
+}
+
+/**
+ * Lists the deleted articles, most recently deleted first
+ */
+func handleApiTrash(ctx *web.Context) string {
+	conf, _, response, ok := getApiConfig(ctx, RoleEditor)
+	if !ok {
+		return response
+	}
+	items, err := getTrash(&conf)
+	if err != nil {
+		return apiError(ctx, 500, "Could not read the trash")
+	}
+	return apiResponse(ctx, 200, items)
+}
+
+/**
+ * Puts a deleted article back where it was, returning the article
+ */
+func handleApiRestoreTrash(ctx *web.Context, id string) string {
+	conf, _, response, ok := getApiConfig(ctx, RoleEditor)
+	if !ok {
+		return response
+	}
+	item, err := restoreTrashItem(id, &conf)
+	if os.IsExist(err) {
+		return apiError(ctx, 409, "Article already exists")
+	} else if os.IsNotExist(err) {
+		return apiError(ctx, 404, "Not found in the trash")
+	} else if err != nil {
+		return apiError(ctx, 500, "Could not restore article")
+	}
+	contentChanged(ctx, &conf, item.Section, item.Slug, "Restore")
+	article, _ := getApiArticle(item.Section, item.Slug, true, &conf)
+	return apiResponse(ctx, 200, article)
+}
+
+/**
+ * Deletes an article from the trash for good
+ */
+func handleApiPurgeTrash(ctx *web.Context, id string) string {
+	conf, _, response, ok := getApiConfig(ctx, RoleEditor)
+	if !ok {
+		return response
+	}
+	if err := purgeTrashItem(id, &conf); err != nil {
+		return apiError(ctx, 404, "Not found in the trash")
+	}
+	ctx.WriteHeader(204)
+	return ""
+}
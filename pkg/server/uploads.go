@@ -0,0 +1,180 @@
+package server
+
+import (
+	"errors"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"github.com/rredpoppy/gosite/pkg/render"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Struct representing an uploaded image, with the markdown showing it
+type Upload struct {
+	Name     string `json:"name"`
+	Url      string `json:"url"`
+	Markdown string `json:"markdown"`
+}
+
+// Error of a rejected upload, with the status answering it
+type UploadError struct {
+	Status  int
+	message string
+}
+
+// Error function for uploads, returns the error message
+func (e UploadError) Error() string {
+	return e.message
+}
+
+/**
+ * Returns the largest upload accepted, in bytes: Uploads.MaxSize kilobytes,
+ * 5 MB by default
+ */
+func getUploadMaxSize(conf *config.Config) int64 {
+	if conf.Uploads.MaxSize > 0 {
+		return int64(conf.Uploads.MaxSize) * 1024
+	}
+	return 5 * 1024 * 1024
+}
+
+/**
+ * Returns true if files with the given extension may be uploaded: images the
+ * site serves, restricted to the Uploads.Types extensions when set
+ */
+func isUploadType(ext string, conf *config.Config) bool {
+	if len(render.GetImageType(ext)) == 0 {
+		return false
+	}
+	return len(conf.Uploads.Types) == 0 || hasExtension(conf.Uploads.Types, ext)
+}
+
+/**
+ * Returns true if an extension is in a list of extensions, with or without
+ * their dot
+ */
+func hasExtension(list []string, ext string) bool {
+	for _, t := range list {
+		if strings.EqualFold(strings.TrimPrefix(t, "."), strings.TrimPrefix(ext, ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Stores the image posted as the file field of a multipart request in the
+ * Uploads.Folder of a section, named after the uploaded file. Names already
+ * taken get a number, and images lose their metadata when Uploads.StripMetadata
+ * is set. The alt field of the request is the alternative text of the markdown
+ * snippet
+ */
+func saveUpload(ctx *web.Context, section string, conf *config.Config) (Upload, error) {
+	var upload Upload
+	store := content.GetContentStore(conf)
+	if fi, err := store.Stat(section); err != nil || !fi.IsDir() {
+		return upload, UploadError{404, "Section not found"}
+	}
+	max := getUploadMaxSize(conf)
+	// The form holds a few small fields next to the file
+	ctx.Request.Body = http.MaxBytesReader(ctx, ctx.Request.Body, max+64*1024)
+	if err := ctx.Request.ParseMultipartForm(max); err != nil {
+		return upload, UploadError{413, "File too large or invalid form"}
+	}
+	f, header, err := ctx.Request.FormFile("file")
+	if err != nil {
+		return upload, UploadError{400, "Missing file"}
+	}
+	defer f.Close()
+	bs, err := ioutil.ReadAll(f)
+	if err != nil {
+		return upload, err
+	}
+	if int64(len(bs)) > max {
+		return upload, UploadError{413, "File too large"}
+	}
+	ext := strings.ToLower(path.Ext(header.Filename))
+	// The content must be what the extension says, so nothing else gets
+	// served as an image
+	if !isUploadType(ext, conf) || http.DetectContentType(bs) != render.GetImageType(ext) {
+		return upload, UploadError{415, "Unsupported file type"}
+	}
+	// Phone photos tell where they were taken, so their metadata goes
+	if conf.Uploads.StripMetadata && !hasExtension(conf.Uploads.KeepMetadata, ext) {
+		if bs, err = render.SanitizeImage(bs, ext, conf); err != nil {
+			return upload, UploadError{415, "Invalid image"}
+		}
+	}
+	base := content.Slugify(strings.TrimSuffix(path.Base(header.Filename), path.Ext(header.Filename)))
+	if len(base) == 0 {
+		base = "image"
+	}
+	folder := conf.Uploads.Folder
+	if len(folder) == 0 {
+		folder = "images"
+	}
+	folder = section + "/" + strings.Trim(path.Clean("/"+folder), "/")
+	name := folder + "/" + base + ext
+	for i := 2; ; i++ {
+		if _, err := store.Stat(name); err != nil {
+			break
+		}
+		name = folder + "/" + base + "-" + strconv.Itoa(i) + ext
+	}
+	if err = store.WriteFile(name, bs); err != nil {
+		return upload, err
+	}
+	alt := strings.NewReplacer("[", "", "]", "", "\n", " ").Replace(ctx.Request.FormValue("alt"))
+	upload = Upload{Name: name, Url: "/media/" + name}
+	upload.Markdown = "![" + alt + "](" + upload.Url + ")"
+	return upload, nil
+}
+
+/**
+ * Answers an upload request with the upload or its error, in JSON
+ */
+func sendUpload(ctx *web.Context, upload Upload, err error) string {
+	var rejected UploadError
+	if errors.As(err, &rejected) {
+		return apiError(ctx, rejected.Status, rejected.Error())
+	} else if err != nil {
+		return apiError(ctx, 500, "Could not save file")
+	}
+	ctx.SetHeader("Location", upload.Url, true)
+	return apiResponse(ctx, 201, upload)
+}
+
+/**
+ * Uploads an image to a section through the content API
+ */
+func handleApiUpload(ctx *web.Context, section string) string {
+	conf, _, response, ok := getApiConfig(ctx, RoleAuthor)
+	if !ok {
+		return response
+	}
+	upload, err := saveUpload(ctx, section, &conf)
+	return sendUpload(ctx, upload, err)
+}
+
+/**
+ * Uploads an image from the admin editor, which sends its CSRF token as the
+ * X-CSRF-Token header
+ */
+func handleAdminUpload(ctx *web.Context, section string) string {
+	conf, err := config.Load()
+	if err != nil {
+		return apiError(ctx, 500, "Configuration error")
+	}
+	if _, ok := checkAdminAuth(ctx, &conf, RoleAuthor); !ok {
+		return ""
+	}
+	if !checkCsrf(ctx) {
+		return apiError(ctx, 403, "Invalid or missing CSRF token")
+	}
+	upload, err := saveUpload(ctx, section, &conf)
+	return sendUpload(ctx, upload, err)
+}
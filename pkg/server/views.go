@@ -0,0 +1,202 @@
+package server
+
+import (
+	"bufio"
+	"github.com/hoisie/web"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Struct representing an article ranked by its number of views
+type PopularPost struct {
+	Title string `json:"title"`
+	Link  string `json:"link"`
+	Views int    `json:"views"`
+}
+
+// Counter of article views. Views are appended to a log file holding only
+// the time and the article, so no visitor data is ever stored
+type ViewCounter struct {
+	sync.Mutex
+	loaded bool
+	counts map[string]int
+	// Views of each article by day, counted in days since the Unix epoch
+	daily map[string]map[int64]int
+}
+
+// Counter shared by all requests
+var viewCounter = &ViewCounter{counts: make(map[string]int), daily: make(map[string]map[int64]int)}
+
+/**
+ * Returns the day of a time, in days since the Unix epoch
+ */
+func getViewDay(t time.Time) int64 {
+	return t.Unix() / 86400
+}
+
+/**
+ * Adds a view of an article on a day. Must be called with the lock held
+ */
+func (c *ViewCounter) add(key string, day int64) {
+	c.counts[key]++
+	if c.daily[key] == nil {
+		c.daily[key] = make(map[int64]int)
+	}
+	c.daily[key][day]++
+}
+
+/**
+ * Returns the store holding the views log, and the name of the log within it
+ */
+func getViewsFile(conf *config.Config) (content.Store, string) {
+	file := conf.Views.File
+	if len(file) == 0 {
+		file = "views.log"
+	}
+	return content.OpenDataStore(filepath.Dir(file)), filepath.Base(file)
+}
+
+/**
+ * Reads the views log into memory, once. Must be called with the lock held
+ */
+func (c *ViewCounter) load(conf *config.Config) {
+	if c.loaded {
+		return
+	}
+	c.loaded = true
+	store, name := getViewsFile(conf)
+	f, err := store.Open(name)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.Split(scanner.Text(), "\t")
+		if len(parts) == 2 {
+			t, _ := time.Parse(time.RFC3339, parts[0])
+			c.add(parts[1], getViewDay(t))
+		}
+	}
+}
+
+/**
+ * Records a view of the article identified by its "section/page" key
+ */
+func (c *ViewCounter) Record(key string, conf *config.Config) {
+	c.Lock()
+	defer c.Unlock()
+	c.load(conf)
+	now := time.Now().UTC()
+	c.add(key, getViewDay(now))
+	store, name := getViewsFile(conf)
+	content.AppendFile(store, name, []byte(now.Format(time.RFC3339)+"\t"+key+"\n"))
+}
+
+/**
+ * Returns the number of views of an article
+ */
+func (c *ViewCounter) Count(key string, conf *config.Config) int {
+	c.Lock()
+	defer c.Unlock()
+	c.load(conf)
+	return c.counts[key]
+}
+
+/**
+ * Returns the article keys ordered by descending number of views over the
+ * last given days, today included, along with those numbers. All the views
+ * count when days is zero
+ */
+func (c *ViewCounter) Ranking(days int, conf *config.Config) ([]string, map[string]int) {
+	c.Lock()
+	defer c.Unlock()
+	c.load(conf)
+	counts := make(map[string]int)
+	since := getViewDay(time.Now()) - int64(days) + 1
+	for key, count := range c.counts {
+		if days > 0 {
+			count = 0
+			for day, n := range c.daily[key] {
+				if day >= since {
+					count += n
+				}
+			}
+		}
+		if count > 0 {
+			counts[key] = count
+		}
+	}
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	return keys, counts
+}
+
+/**
+ * Returns true if the request comes from a crawler rather than a reader
+ */
+func isBot(userAgent string) bool {
+	ua := strings.ToLower(userAgent)
+	for _, marker := range []string{"bot", "spider", "crawl", "slurp", "preview"} {
+		if strings.Contains(ua, marker) {
+			return true
+		}
+	}
+	return len(ua) == 0
+}
+
+/**
+ * Returns the most viewed articles that still exist, most viewed first,
+ * counting the views of the last Views.PopularDays days, or all of them
+ */
+func getPopularPosts(conf *config.Config) []PopularPost {
+	popular := []PopularPost{}
+	if !conf.Views.Enabled {
+		return popular
+	}
+	count := conf.Views.PopularCount
+	if count == 0 {
+		count = 5
+	}
+	keys, views := viewCounter.Ranking(conf.Views.PopularDays, conf)
+	for _, key := range keys {
+		if len(popular) >= count {
+			break
+		}
+		parts := strings.SplitN(key, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		page, err := content.GetPublishedPage(parts[0], parts[1], conf)
+		if err != nil {
+			continue
+		}
+		popular = append(popular, PopularPost{Title: content.GetPageTitle(page),
+			Link: content.GetArticleLink(parts[0], parts[1], conf), Views: views[key]})
+	}
+	return popular
+}
+
+/**
+ * Serves the most viewed articles in JSON, for widgets
+ */
+func handlePopular(ctx *web.Context) string {
+	conf, err := config.Load()
+	if err != nil {
+		return apiError(ctx, 500, "Configuration error")
+	}
+	return apiResponse(ctx, 200, getPopularPosts(&conf))
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"errors"
+	"github.com/rredpoppy/gosite/pkg/config"
+	"github.com/rredpoppy/gosite/pkg/content"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// User agent of the warm-up requests. It reads as a bot so they are not
+// counted as views
+const warmUpUserAgent = "gosite-warmup (bot)"
+
+// Accept header of the warm-up requests, the one browsers send for pages, so
+// the responses kept are the ones their visitors get
+const warmUpAccept = "text/html,application/xhtml+xml,image/avif,image/webp,*/*"
+
+// Error returned when warming up would render pages nothing keeps
+var ErrNoWarmUpCache = errors.New("Warmup skipped: no cache middleware is enabled")
+
+// Response writer dropping the pages rendered to warm the caches up
+type warmUpWriter struct {
+	header http.Header
+	status int
+}
+
+func (w *warmUpWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *warmUpWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *warmUpWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = 200
+	}
+	return len(b), nil
+}
+
+/**
+ * Returns the paths rendered at startup: the homepage, the first page of the
+ * listing of each section of the menu and the Warmup.Articles newest
+ * articles, 10 by default
+ */
+func getWarmUpPaths(conf *config.Config) ([]string, error) {
+	paths := []string{"/"}
+	menu, err := content.GetMenu(conf)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range menu {
+		if len(item.Section) > 0 && item.Link != "/" {
+			paths = append(paths, item.Link)
+		}
+	}
+	articles, err := getGqlArticles(conf)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(articles, func(i, j int) bool {
+		return articles[i].Date.After(articles[j].Date)
+	})
+	count := conf.Warmup.Articles
+	if count <= 0 {
+		count = 10
+	}
+	for i := 0; i < count && i < len(articles); i++ {
+		paths = append(paths, articles[i].Link)
+	}
+	return paths, nil
+}
+
+/**
+ * Returns the scheme and host the warm-up pages are asked for on: those of
+ * Site.BaseURL, or the address the server listens on when it is unset
+ */
+func getWarmUpHost(conf *config.Config) (string, string) {
+	if base, err := url.Parse(conf.Site.BaseURL); err == nil && len(base.Host) > 0 {
+		return base.Scheme, base.Host
+	}
+	host := conf.ServerIp
+	if strings.HasPrefix(host, unixSocketPrefix) {
+		host = "localhost"
+	} else if strings.HasPrefix(host, ":") {
+		host = "localhost" + host
+	}
+	return "http", host
+}
+
+/**
+ * Returns true if the cache middleware keeps the responses of the site
+ */
+func hasCacheMiddleware(conf *config.Config) bool {
+	for _, entry := range conf.Middleware {
+		if entry.Name == "cache" {
+			return true
+		}
+	}
+	return false
+}
+
+/**
+ * Renders the pages the first visitors are most likely to ask for with the
+ * site handler, as a browser would ask for them, so the menu, the templates,
+ * the diagrams and the cache middleware are ready before they come. Pages
+ * are asked for on the host of Site.BaseURL, since pages link to the host
+ * they are served on, or on the listen address without one. Returns
+ * ErrNoWarmUpCache without the cache middleware, and the number of pages
+ * rendered otherwise
+ */
+func WarmUp(handler http.Handler, conf *config.Config) (int, error) {
+	if !hasCacheMiddleware(conf) {
+		return 0, ErrNoWarmUpCache
+	}
+	scheme, host := getWarmUpHost(conf)
+	paths, err := getWarmUpPaths(conf)
+	if err != nil {
+		return 0, err
+	}
+	rendered := 0
+	for _, p := range paths {
+		req, err := http.NewRequest("GET", scheme+"://"+host+content.GetPathPrefix(conf)+p, nil)
+		if err != nil {
+			return rendered, err
+		}
+		req.Header.Set("Accept", warmUpAccept)
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set("User-Agent", warmUpUserAgent)
+		w := &warmUpWriter{header: make(http.Header)}
+		handler.ServeHTTP(w, req)
+		if w.status == 200 {
+			rendered++
+		}
+	}
+	return rendered, nil
+}
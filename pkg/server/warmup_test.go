@@ -0,0 +1,32 @@
+package server
+
+import (
+	"github.com/rredpoppy/gosite/pkg/config"
+	"testing"
+)
+
+func TestWarmUpNeedsCacheMiddleware(t *testing.T) {
+	conf := config.Default()
+	conf.Middleware = nil
+	if _, err := WarmUp(nil, &conf); err != ErrNoWarmUpCache {
+		t.Errorf("warm-up without a cache returned %v, want ErrNoWarmUpCache", err)
+	}
+}
+
+func TestWarmUpUsesListenAddress(t *testing.T) {
+	setup := func(conf *config.Config) {
+		conf.Site.BaseURL = ""
+		conf.Middleware = []config.MiddlewareConfig{{Name: "cache"}}
+	}
+	site := newTestSite(t, setup, map[string]string{
+		"content/blog/hello.md": "---\ntitle: Hello\n---\n# Hello\n\nWorld.\n",
+	})
+	conf := config.Default()
+	setup(&conf)
+	if scheme, host := getWarmUpHost(&conf); scheme != "http" || host != "localhost:8080" {
+		t.Errorf("warm-up host is %s://%s, want http://localhost:8080", scheme, host)
+	}
+	if rendered, err := WarmUp(site, &conf); err != nil || rendered == 0 {
+		t.Errorf("warm-up rendered %d pages with error %v", rendered, err)
+	}
+}